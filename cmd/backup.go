@@ -0,0 +1,74 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/zlepper/welp/internal/app/welp"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"os"
+)
+
+var (
+	backupOutput string
+	backupUrl    string
+	backupToken  string
+	// Leaves out the uploaded files, which is required when they are stored in S3
+	backupDatabaseOnly bool
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Makes a backup of all the data",
+	Long: `Makes a tar.gz backup of the database files and the uploaded files, with a manifest
+with the checksums of all the files.
+
+To backup a running instance, pass its address with --url, and the token of an admin
+with --token or the WELP_TOKEN environment variable. The instance makes a consistent
+snapshot, by pausing changes while the backup is made.
+Without --url, the backup is made from the data on the local disk, which should only
+be done while welp is stopped.
+
+Files stored in S3 can't be part of the backup, so back them up separately and pass
+--databaseOnly. The backup fails without it, so the files aren't left out by accident.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		token := backupToken
+		if token == "" {
+			token = os.Getenv("WELP_TOKEN")
+		}
+
+		welp.Backup(getBindWebArgs(), backupOutput, backupUrl, token, models.BackupOptions{
+			DatabaseOnly: backupDatabaseOnly,
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+
+	f := backupCmd.Flags()
+	f.StringVarP(&backupOutput, "output", "o", "", "The file to write the backup to. Defaults to welp-backup-<time>.tar.gz")
+	f.StringVar(&backupUrl, "url", "", "The address of a running welp instance to download the backup from, e.g. https://feedback.example.com")
+	f.StringVar(&backupToken, "token", "", "The login token of an admin, used with --url")
+	f.BoolVar(&backupDatabaseOnly, "databaseOnly", false, "Only backup the database files. Required when the uploaded files are stored in S3")
+}
@@ -0,0 +1,410 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	envPrefix  = "WELP_"
+	secretMask = "********"
+)
+
+// The flags that are masked when the config is printed
+var secretFlags = map[string]bool{
+	"sendGridApiKey": true,
+	"s3AccessKey":    true,
+	"s3SecretKey":    true,
+	"metricsToken":   true,
+}
+
+// The flags that are about how welp is started, rather than how it runs
+var ignoredConfigFlags = map[string]bool{
+	"config": true,
+	"help":   true,
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Shows the configuration of welp",
+	Long: `Every flag of welp can also be set in a config file, or with an environment variable
+named WELP_ followed by the name of the flag in upper snake case, e.g. WELP_STORAGE_FOLDER_PATH.
+Flags take precedence over environment variables, which take precedence over the config file.
+
+The config file can be yaml, toml or json, and uses the names of the flags as keys. It is
+read from --config, or from $HOME/.welp.yaml, $HOME/.welp.toml or $HOME/.welp.json.`,
+}
+
+var configPrintCmd = &cobra.Command{
+	Use:   "print",
+	Short: "Prints the effective configuration, with secrets masked",
+	Long: `Prints the configuration welp would run with, after the config file, the environment
+variables and the flags have been combined, as yaml that can be used as a config file.
+Secrets, like the SendGrid api key, are masked.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Print(getEffectiveConfig())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configPrintCmd)
+}
+
+// Calls the function with each of the flags of welp itself, sorted by name
+func visitConfigFlags(fn func(flag *pflag.Flag)) {
+	flags := map[string]*pflag.Flag{}
+	add := func(flag *pflag.Flag) {
+		if !ignoredConfigFlags[flag.Name] {
+			flags[flag.Name] = flag
+		}
+	}
+	rootCmd.PersistentFlags().VisitAll(add)
+	rootCmd.Flags().VisitAll(add)
+
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fn(flags[name])
+	}
+}
+
+// Gets the environment variable a flag can be set with, e.g. WELP_S3_ACCESS_KEY for s3AccessKey
+func getEnvName(flagName string) string {
+	var name strings.Builder
+	name.WriteString(envPrefix)
+
+	var previous rune
+	for i, r := range flagName {
+		if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(previous) || unicode.IsDigit(previous)) {
+			name.WriteRune('_')
+		}
+		name.WriteRune(unicode.ToUpper(r))
+		previous = r
+	}
+
+	return name.String()
+}
+
+// Reads the config file, and sets the flags that weren't given on the command line
+// from the environment variables and the config file
+func loadConfig() error {
+	if cfgFile != "" {
+		ext := strings.TrimPrefix(filepath.Ext(cfgFile), ".")
+		if ext != "yaml" && ext != "yml" && ext != "toml" && ext != "json" {
+			return fmt.Errorf("the config file '%s' should be a .yaml, .toml or .json file", cfgFile)
+		}
+		viper.SetConfigFile(cfgFile)
+	} else {
+		home, err := homedir.Dir()
+		if err != nil {
+			return err
+		}
+
+		// Search config in home directory with name ".welp" (without extension).
+		viper.AddConfigPath(home)
+		viper.SetConfigName(".welp")
+	}
+
+	err := readConfigFile()
+	if err != nil {
+		return err
+	}
+
+	// Written to stderr, so it isn't mixed into what commands write to stdout
+	if configFile := viper.ConfigFileUsed(); configFile != "" {
+		fmt.Fprintln(os.Stderr, "Using config file:", configFile)
+	}
+
+	visitConfigFlags(func(flag *pflag.Flag) {
+		if flag.Changed {
+			commandLineFlags[flag.Name] = true
+		}
+	})
+
+	err = applyConfig()
+	if err != nil {
+		return err
+	}
+
+	visitConfigFlags(func(flag *pflag.Flag) {
+		startupValues[flag.Name] = flag.Value.String()
+	})
+
+	return nil
+}
+
+// The flags that were given on the command line, which the config can't change
+var commandLineFlags = map[string]bool{}
+
+// The values of the flags when welp was started, so a reload can tell what has changed
+var startupValues = map[string]string{}
+
+// Reads the config file and the environment variables again, while welp is running.
+// Returns the new args, and the flags that now have another value than when welp was started
+func reloadConfig() (models.BindWebArgs, []string, error) {
+	err := readConfigFile()
+	if err == nil {
+		err = applyConfig()
+	}
+	if err != nil {
+		return models.BindWebArgs{}, nil, err
+	}
+
+	args := getBindWebArgs()
+	err = validateConfig(args)
+	if err != nil {
+		return models.BindWebArgs{}, nil, err
+	}
+
+	changed := make([]string, 0)
+	visitConfigFlags(func(flag *pflag.Flag) {
+		if flag.Value.String() != startupValues[flag.Name] {
+			changed = append(changed, flag.Name)
+		}
+	})
+
+	return args, changed, nil
+}
+
+func readConfigFile() error {
+	err := viper.ReadInConfig()
+	if _, notFound := err.(viper.ConfigFileNotFoundError); notFound && cfgFile == "" {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the config file: %v", err)
+	}
+
+	return nil
+}
+
+// Sets all the flags that weren't given on the command line from the environment variables
+// and the config file, or to their default if they are in neither
+func applyConfig() error {
+	var err error
+	known := map[string]bool{}
+	visitConfigFlags(func(flag *pflag.Flag) {
+		known[strings.ToLower(flag.Name)] = true
+		if err == nil && !commandLineFlags[flag.Name] {
+			err = applyFlagConfig(flag)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// The keys of sections, like s3.bucket, are never known, as the settings are flat
+	for _, key := range viper.AllKeys() {
+		if !known[key] {
+			return fmt.Errorf("unknown setting '%s' in the config file '%s'", key, viper.ConfigFileUsed())
+		}
+	}
+
+	return nil
+}
+
+func applyFlagConfig(flag *pflag.Flag) error {
+	envName := getEnvName(flag.Name)
+	err := viper.BindEnv(flag.Name, envName)
+	if err != nil {
+		return err
+	}
+
+	// The setting might have been removed since the config was last read
+	err = setFlag(flag, flag.DefValue)
+	if err != nil {
+		return err
+	}
+	flag.Changed = false
+
+	if !viper.IsSet(flag.Name) {
+		return nil
+	}
+
+	source := fmt.Sprintf("the config file '%s'", viper.ConfigFileUsed())
+	if os.Getenv(envName) != "" {
+		source = envName
+	}
+
+	value, err := getConfigValue(viper.Get(flag.Name))
+	if err == nil {
+		err = setFlag(flag, value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value for %s from %s: %v", flag.Name, source, err)
+	}
+
+	// Lets the validation tell the flags that were set apart from the defaults
+	flag.Changed = true
+
+	return nil
+}
+
+// Sets the value of the flag. Lists replace the current value, instead of being added to it
+func setFlag(flag *pflag.Flag, value string) error {
+	slice, ok := flag.Value.(pflag.SliceValue)
+	if !ok {
+		return flag.Value.Set(value)
+	}
+
+	// The default of a list is written as [a,b]
+	values, err := readCsv(strings.TrimSuffix(strings.TrimPrefix(value, "["), "]"))
+	if err != nil {
+		return err
+	}
+	return slice.Replace(values)
+}
+
+// Converts a value from the config file to how it would be written as a flag
+func getConfigValue(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case []interface{}:
+		values := make([]string, len(value))
+		for i, v := range value {
+			values[i] = fmt.Sprint(v)
+		}
+		return joinCsv(values)
+	case []string:
+		return joinCsv(value)
+	case map[string]interface{}, map[interface{}]interface{}:
+		return "", errors.New("expected a single value or a list, not a section")
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
+
+func joinCsv(values []string) (string, error) {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	err := writer.Write(values)
+	writer.Flush()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(buffer.String(), "\n"), nil
+}
+
+// Gets the configuration as yaml, with secrets masked
+func getEffectiveConfig() string {
+	var config strings.Builder
+
+	visitConfigFlags(func(flag *pflag.Flag) {
+		config.WriteString(flag.Name)
+		config.WriteString(":")
+
+		switch {
+		case secretFlags[flag.Name]:
+			value := ""
+			if flag.Value.String() != "" {
+				value = secretMask
+			}
+			config.WriteString(" " + strconv.Quote(value))
+		case flag.Value.Type() == "stringSlice":
+			values := flag.Value.(pflag.SliceValue).GetSlice()
+			if len(values) == 0 {
+				config.WriteString(" []")
+			}
+			for _, value := range values {
+				config.WriteString("\n  - " + strconv.Quote(value))
+			}
+		case flag.Value.Type() == "bool" || flag.Value.Type() == "int" || flag.Value.Type() == "float64":
+			config.WriteString(" " + flag.Value.String())
+		default:
+			config.WriteString(" " + strconv.Quote(flag.Value.String()))
+		}
+
+		config.WriteString("\n")
+	})
+
+	return config.String()
+}
+
+func readCsv(value string) ([]string, error) {
+	if value == "" {
+		return []string{}, nil
+	}
+	return csv.NewReader(strings.NewReader(value)).Read()
+}
+
+// Checks that the combined configuration makes sense, and returns all the problems at once
+func validateConfig(args models.BindWebArgs) error {
+	problems := make([]string, 0)
+	check := func(ok bool, format string, a ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, a...))
+		}
+	}
+
+	flags := rootCmd.Flags()
+	check(!args.UseHttps || !flags.Changed("port"), "useHttps always uses port 80 and 443, so port can't be set with it")
+	check(args.Port > 0 && args.Port < 65536, "port should be between 1 and 65535, was %d", args.Port)
+	check(args.Metrics.Address == "" || args.Metrics.Address != ":"+strconv.Itoa(args.Port), "metricsAddress should be another address than the port welp listens on")
+	check(args.ShutdownTimeout >= 0, "shutdownTimeout can't be negative")
+	check(args.SaveInterval > 0, "saveInterval should be more than 0")
+	check(args.TokenDuration > 0, "tokenDuration should be more than 0")
+	check(args.Tracing.SampleRatio >= 0 && args.Tracing.SampleRatio <= 1, "traceSampleRatio should be between 0 and 1, was %g", args.Tracing.SampleRatio)
+
+	check(args.UploadLimits.MaxFiles >= 0, "maxFilesPerFeedback can't be negative")
+	check(args.UploadLimits.MaxFileSize == 0 || args.UploadLimits.MaxRequestSize == 0 || args.UploadLimits.MaxFileSize <= args.UploadLimits.MaxRequestSize,
+		"maxFileSize (%s) can't be larger than maxRequestSize (%s)", args.UploadLimits.MaxFileSize, args.UploadLimits.MaxRequestSize)
+
+	check(args.SpamMinSubmitTime >= 0, "spamMinSubmitTime can't be negative")
+	check(args.SpamProofOfWorkDifficulty >= 0 && args.SpamProofOfWorkDifficulty <= 255, "spamProofOfWorkDifficulty should be between 0 and 255, was %d", args.SpamProofOfWorkDifficulty)
+	check(args.SpamThreshold >= 0 && args.SpamThreshold <= 1, "spamThreshold should be between 0 and 1, was %g", args.SpamThreshold)
+
+	check(args.Retention.AfterCreation >= 0, "retentionDays can't be negative")
+	check(args.Retention.AfterResolution >= 0, "retentionDaysAfterResolution can't be negative")
+	check(args.Retention.Interval > 0, "retentionInterval should be more than 0")
+	check(args.Consistency.Interval >= 0, "fsckInterval can't be negative")
+	check(args.Consistency.GracePeriod >= 0, "fsckGracePeriod can't be negative")
+
+	check(args.S3.Bucket == "" || args.S3.PartSize >= 5*models.MegaByte, "s3PartSize should be at least 5MB, as S3 doesn't accept smaller parts")
+	check(args.S3.SecretKey == "" || args.S3.AccessKey != "", "s3SecretKey is set, but s3AccessKey isn't")
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+}
@@ -0,0 +1,286 @@
+package cmd
+
+import (
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type configSources struct {
+	// The content of the yaml config file
+	file  string
+	env   map[string]string
+	flags map[string]string
+}
+
+// Loads the config like welp does when it's started, from a clean slate
+func loadTestConfig(t *testing.T, sources configSources) (string, error) {
+	folder, err := ioutil.TempDir("", "welp-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfgFile = filepath.Join(folder, ".welp.yaml")
+	writeTestConfig(t, sources.file)
+
+	viper.Reset()
+	commandLineFlags = map[string]bool{}
+	startupValues = map[string]string{}
+	visitConfigFlags(func(flag *pflag.Flag) {
+		err := setFlag(flag, flag.DefValue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		flag.Changed = false
+	})
+
+	for name, value := range sources.env {
+		os.Setenv(name, value)
+	}
+	visitConfigFlags(func(flag *pflag.Flag) {
+		value, ok := sources.flags[flag.Name]
+		if !ok {
+			return
+		}
+		err := setFlag(flag, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		flag.Changed = true
+	})
+
+	return folder, loadConfig()
+}
+
+func writeTestConfig(t *testing.T, content string) {
+	err := ioutil.WriteFile(cfgFile, []byte(content), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func cleanupTestConfig(folder string, sources configSources) {
+	os.RemoveAll(folder)
+	for name := range sources.env {
+		os.Unsetenv(name)
+	}
+}
+
+func TestGetEnvName(t *testing.T) {
+	tests := []struct {
+		flag     string
+		expected string
+	}{
+		{"port", "WELP_PORT"},
+		{"useHttps", "WELP_USE_HTTPS"},
+		{"storageFolderPath", "WELP_STORAGE_FOLDER_PATH"},
+		{"sendGridApiKey", "WELP_SEND_GRID_API_KEY"},
+		{"s3AccessKey", "WELP_S3_ACCESS_KEY"},
+		{"s3PartSize", "WELP_S3_PART_SIZE"},
+	}
+
+	for _, test := range tests {
+		if actual := getEnvName(test.flag); actual != test.expected {
+			t.Errorf("expected %s to be set with %s, got %s", test.flag, test.expected, actual)
+		}
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name        string
+		sources     configSources
+		port        int
+		storagePath string
+	}{
+		{
+			name:        "defaults",
+			port:        8080,
+			storagePath: "storage",
+		},
+		{
+			name:        "config file",
+			sources:     configSources{file: "port: 9000\nstorageFolderPath: from-file\n"},
+			port:        9000,
+			storagePath: "from-file",
+		},
+		{
+			name: "environment over config file",
+			sources: configSources{
+				file: "port: 9000\nstorageFolderPath: from-file\n",
+				env:  map[string]string{"WELP_PORT": "9001"},
+			},
+			port:        9001,
+			storagePath: "from-file",
+		},
+		{
+			name: "flag over environment and config file",
+			sources: configSources{
+				file:  "port: 9000\nstorageFolderPath: from-file\n",
+				env:   map[string]string{"WELP_PORT": "9001", "WELP_STORAGE_FOLDER_PATH": "from-env"},
+				flags: map[string]string{"port": "9002"},
+			},
+			port:        9002,
+			storagePath: "from-env",
+		},
+		{
+			name:        "keys are case insensitive",
+			sources:     configSources{file: "PORT: 9000\nstoragefolderpath: from-file\n"},
+			port:        9000,
+			storagePath: "from-file",
+		},
+	}
+
+	for _, test := range tests {
+		folder, err := loadTestConfig(t, test.sources)
+		cleanupTestConfig(folder, test.sources)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		args := getBindWebArgs()
+		if args.Port != test.port || args.FolderPath != test.storagePath {
+			t.Errorf("%s: expected port %d and storage %s, got %d and %s", test.name, test.port, test.storagePath, args.Port, args.FolderPath)
+		}
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		sources  configSources
+		expected string
+	}{
+		{
+			name:     "unknown key",
+			sources:  configSources{file: "port: 9000\nunknownSetting: 1\n"},
+			expected: "unknown setting 'unknownsetting'",
+		},
+		{
+			name:     "unknown section",
+			sources:  configSources{file: "s3:\n  bucket: feedback\n"},
+			expected: "unknown setting 's3.bucket'",
+		},
+		{
+			name:     "section for a setting",
+			sources:  configSources{file: "port:\n  http: 9000\n"},
+			expected: "expected a single value or a list, not a section",
+		},
+		{
+			name:     "invalid value in the config file",
+			sources:  configSources{file: "port: http\n"},
+			expected: "invalid value for port from the config file",
+		},
+		{
+			name:     "invalid value in the environment",
+			sources:  configSources{env: map[string]string{"WELP_PORT": "http"}},
+			expected: "invalid value for port from WELP_PORT",
+		},
+	}
+
+	for _, test := range tests {
+		folder, err := loadTestConfig(t, test.sources)
+		cleanupTestConfig(folder, test.sources)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: expected an error with '%s', got %v", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestConfigListValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		sources  configSources
+		expected []string
+	}{
+		{
+			name:     "default",
+			expected: []string{"*"},
+		},
+		{
+			name:     "yaml list",
+			sources:  configSources{file: "allowedOrigins:\n  - https://a.example\n  - https://b.example\n"},
+			expected: []string{"https://a.example", "https://b.example"},
+		},
+		{
+			name:     "comma separated in the config file",
+			sources:  configSources{file: "allowedOrigins: https://a.example,https://b.example\n"},
+			expected: []string{"https://a.example", "https://b.example"},
+		},
+		{
+			name:     "empty yaml list",
+			sources:  configSources{file: "allowedOrigins: []\n"},
+			expected: []string{},
+		},
+		{
+			name:     "yaml list with a comma in a value",
+			sources:  configSources{file: "allowedOrigins:\n  - \"a,b\"\n  - c\n"},
+			expected: []string{"a,b", "c"},
+		},
+		{
+			name:     "comma separated in the environment",
+			sources:  configSources{env: map[string]string{"WELP_ALLOWED_ORIGINS": "https://a.example,https://b.example"}},
+			expected: []string{"https://a.example", "https://b.example"},
+		},
+		{
+			name: "environment replaces the config file",
+			sources: configSources{
+				file: "allowedOrigins:\n  - https://a.example\n",
+				env:  map[string]string{"WELP_ALLOWED_ORIGINS": "https://b.example"},
+			},
+			expected: []string{"https://b.example"},
+		},
+	}
+
+	for _, test := range tests {
+		folder, err := loadTestConfig(t, test.sources)
+		cleanupTestConfig(folder, test.sources)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if actual := getBindWebArgs().AllowedOrigins; !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	sources := configSources{
+		file:  "port: 9000\nallowedOrigins:\n  - https://a.example\nstorageFolderPath: from-file\n",
+		flags: map[string]string{"storageFolderPath": "from-flag"},
+	}
+	folder, err := loadTestConfig(t, sources)
+	defer cleanupTestConfig(folder, sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The port is removed, so it should go back to the default
+	writeTestConfig(t, "allowedOrigins:\n  - https://b.example\nstorageFolderPath: changed\n")
+	args, changed, err := reloadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(changed)
+	if !reflect.DeepEqual(changed, []string{"allowedOrigins", "port"}) {
+		t.Errorf("expected allowedOrigins and port to have changed, got %v", changed)
+	}
+	if args.Port != 8080 {
+		t.Errorf("expected the removed port to be the default again, got %d", args.Port)
+	}
+	if !reflect.DeepEqual(args.AllowedOrigins, []string{"https://b.example"}) {
+		t.Errorf("expected the new allowed origins, got %v", args.AllowedOrigins)
+	}
+	if args.FolderPath != "from-flag" {
+		t.Errorf("expected the flag from the command line to be kept, got %s", args.FolderPath)
+	}
+}
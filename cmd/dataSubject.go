@@ -0,0 +1,86 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/zlepper/welp/internal/app/welp"
+)
+
+var (
+	dataSubjectContactAddress string
+	dataSubjectOutput         string
+)
+
+var dataSubjectCmd = &cobra.Command{
+	Use:   "data-subject",
+	Short: "Finds, exports, erases or anonymises all feedback from a contact address",
+	Long: `Handles requests from the people who has sent feedback, about the data welp has about them.
+All feedback with the contact address given by --contactAddress is included, ignoring case.
+Every export, erasure and anonymisation is recorded in the data subject log.
+
+Stop welp before erasing or anonymising, as it could overwrite the changes.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		welp.RunDataSubjectCommand(getBindWebArgs(), welp.FindDataSubjectCommand, dataSubjectContactAddress, "")
+	},
+}
+
+var dataSubjectExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports all feedback from the contact address, with the attachments, as a zip file",
+	Run: func(cmd *cobra.Command, args []string) {
+		welp.RunDataSubjectCommand(getBindWebArgs(), welp.ExportDataSubjectCommand, dataSubjectContactAddress, dataSubjectOutput)
+	},
+}
+
+var dataSubjectEraseCmd = &cobra.Command{
+	Use:   "erase",
+	Short: "Deletes all feedback from the contact address permanently, with the attachments",
+	Run: func(cmd *cobra.Command, args []string) {
+		welp.RunDataSubjectCommand(getBindWebArgs(), welp.EraseDataSubjectCommand, dataSubjectContactAddress, "")
+	},
+}
+
+var dataSubjectAnonymiseCmd = &cobra.Command{
+	Use:   "anonymise",
+	Short: "Anonymises all feedback from the contact address",
+	Run: func(cmd *cobra.Command, args []string) {
+		welp.RunDataSubjectCommand(getBindWebArgs(), welp.AnonymiseDataSubjectCommand, dataSubjectContactAddress, "")
+	},
+}
+
+var dataSubjectLogCmd = &cobra.Command{
+	Use:   "log",
+	Short: "Prints the log of data subject actions",
+	Run: func(cmd *cobra.Command, args []string) {
+		welp.RunDataSubjectCommand(getBindWebArgs(), welp.DataSubjectLogCommand, "", "")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dataSubjectCmd)
+	dataSubjectCmd.AddCommand(dataSubjectExportCmd, dataSubjectEraseCmd, dataSubjectAnonymiseCmd, dataSubjectLogCmd)
+
+	dataSubjectCmd.PersistentFlags().StringVar(&dataSubjectContactAddress, "contactAddress", "", "The contact address of the data subject")
+	dataSubjectExportCmd.Flags().StringVarP(&dataSubjectOutput, "output", "o", "", "The file to write the zip file to. If not set, it is written to stdout")
+}
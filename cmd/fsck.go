@@ -0,0 +1,58 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/zlepper/welp/internal/app/welp"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"time"
+)
+
+var fsckOptions = models.ConsistencyOptions{
+	GracePeriod: time.Hour,
+}
+
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Checks that the stored files match the feedback",
+	Long: `Cross references the feedback with the stored files, and reports files that are
+missing, files no feedback refers to (orphaned), and files that don't have the size
+the feedback says they have.
+
+Without flags nothing is changed. Pass --repair to remove missing files from the
+feedback and correct the sizes, and --collectGarbage to delete orphaned files.
+Thumbnails belong to the file they were made from.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		welp.Fsck(getBindWebArgs(), fsckOptions)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fsckCmd)
+
+	f := fsckCmd.Flags()
+	f.BoolVar(&fsckOptions.Repair, "repair", false, "Remove missing files from the feedback, and correct sizes that don't match")
+	f.BoolVar(&fsckOptions.CollectGarbage, "collectGarbage", false, "Delete stored files no feedback refers to")
+	f.DurationVar(&fsckOptions.GracePeriod, "gracePeriod", fsckOptions.GracePeriod, "Only delete orphaned files older than this, in case welp is running and they are being uploaded")
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/zlepper/welp/internal/app/welp"
+	"github.com/zlepper/welp/internal/pkg/models"
+)
+
+var importArgs = welp.ImportArgs{
+	Mapping: models.DefaultImportMapping,
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Imports feedback from a csv or json file",
+	Long: `Imports feedback from a csv or json export of another tool. The columns (csv) or
+fields (json) the values are read from can be changed with the column flags. Fields in
+nested json objects are separated by dots, e.g. --contactColumn from.email.
+
+Attachments can be urls, or paths relative to --attachmentFolder. More than one
+attachment in a csv column is separated by ";".
+
+The ids and timestamps of the feedback are kept. Feedback without an id gets one made
+from its content, so running the same import again skips the feedback that was
+already imported. No emails are sent about imported feedback.
+Rows that can't be imported are listed in the report, while the rest are imported.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		importArgs.Filename = args[0]
+
+		welp.Import(getBindWebArgs(), importArgs)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+
+	f := importCmd.Flags()
+	f.StringVar(&importArgs.Format, "format", "", "The format of the file, either csv or json. Defaults to the extension of the file")
+	f.StringVar(&importArgs.Mapping.Id, "idColumn", importArgs.Mapping.Id, "The column the id is read from")
+	f.StringVar(&importArgs.Mapping.Message, "messageColumn", importArgs.Mapping.Message, "The column the message is read from")
+	f.StringVar(&importArgs.Mapping.ContactAddress, "contactColumn", importArgs.Mapping.ContactAddress, "The column the contact address is read from")
+	f.StringVar(&importArgs.Mapping.Created, "createdColumn", importArgs.Mapping.Created, "The column the time the feedback was created is read from")
+	f.StringVar(&importArgs.Mapping.Attachments, "attachmentsColumn", importArgs.Mapping.Attachments, "The column the attachment urls or paths are read from")
+	f.StringVar(&importArgs.TimeFormat, "timeFormat", "", "The layout of the created times, as in Go's time.Parse, e.g. 2006-01-02 15:04. Defaults to trying the most common formats")
+	f.StringVar(&importArgs.AttachmentFolder, "attachmentFolder", "", "The folder relative attachment paths are read from. Defaults to the folder of the imported file")
+	f.StringVar(&importArgs.ReportFile, "report", "", "A file to write the report to as json, in addition to printing it")
+}
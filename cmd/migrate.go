@@ -0,0 +1,63 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/zlepper/welp/internal/app/welp"
+)
+
+var migrateArgs welp.MigrateArgs
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Moves all the data to other storages",
+	Long: `Copies the feedback, users, secrets and attached files from one set of storages
+to another, and verifies every copy with a checksum and the counts afterwards.
+
+The database is given as flatfile:<folder>. Files are given as local:<folder>
+or s3:<bucket>[/<prefix>], using the S3 connection flags. The source defaults
+to the storages welp is configured with, so e.g. moving the files to S3 is:
+
+  welp migrate --toFiles s3:my-bucket
+
+If the migration is interrupted, running the same command again resumes it.
+Files that were already copied are only verified. The thumbnails of the files
+are copied too, and any that fail are made again when first requested.
+
+Stop welp first, and start it with the new storages afterwards.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		welp.Migrate(getBindWebArgs(), migrateArgs)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+
+	f := migrateCmd.Flags()
+	f.StringVar(&migrateArgs.From, "from", "", "The database to migrate from, e.g. flatfile:./db. Defaults to --databaseFolderPath")
+	f.StringVar(&migrateArgs.To, "to", "", "The database to migrate to, e.g. flatfile:/mnt/new/db. If empty, the database isn't migrated")
+	f.StringVar(&migrateArgs.FromFiles, "fromFiles", "", "The file storage to migrate from, e.g. local:./storage. Defaults to the configured file storage")
+	f.StringVar(&migrateArgs.ToFiles, "toFiles", "", "The file storage to migrate to, e.g. s3:my-bucket/welp. If empty, the files stay where they are")
+	f.StringVar(&migrateArgs.StateFile, "state", "welp-migrate-state.json", "The file the progress is kept in, so an interrupted migration can be resumed")
+}
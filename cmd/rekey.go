@@ -0,0 +1,50 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/zlepper/welp/internal/app/welp"
+)
+
+var newMasterKeyFile string
+
+var rekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Rotates the master key used for encryption",
+	Long: `Makes all database files and stored files readable with a new master key.
+Only the data keys are encrypted again, so this is fast even with many files.
+Files that are not encrypted yet are encrypted with the new key, so this is also
+how existing data is encrypted when encryption is first enabled.
+
+Stop welp before running this, and start it with the new key afterwards.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		welp.Rekey(getBindWebArgs(), newMasterKeyFile)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rekeyCmd)
+
+	rekeyCmd.Flags().StringVar(&newMasterKeyFile, "newMasterKeyFile", "", "The file with the new master key. If not set, the key is read from the WELP_NEW_MASTER_KEY environment variable.")
+}
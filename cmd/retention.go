@@ -0,0 +1,52 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/zlepper/welp/internal/app/welp"
+)
+
+var retentionCommandDryRun bool
+
+var retentionCmd = &cobra.Command{
+	Use:   "retention",
+	Short: "Purges the feedback the retention policy says should no longer be kept",
+	Long: `Enforces the retention policy from --retentionDays, --retentionDaysAfterResolution
+and --retentionAction once, and prints a report of the purged feedback.
+Use --dryRun to see what would be purged, without changing anything.
+
+The server enforces the policy by itself, so this is mostly useful for
+checking a policy before enabling it.
+
+Stop welp before running this without --dryRun, as it could overwrite the changes.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		welp.EnforceRetention(getBindWebArgs(), retentionCommandDryRun)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(retentionCmd)
+
+	retentionCmd.Flags().BoolVar(&retentionCommandDryRun, "dryRun", false, "Only print what would be purged, without changing anything")
+}
@@ -26,9 +26,7 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 	"github.com/zlepper/welp/internal/app/welp"
 	"github.com/zlepper/welp/internal/pkg/models"
 	"time"
@@ -39,6 +37,9 @@ var (
 	storageFolderPath      string
 	useHttps               bool
 	port                   int
+	shutdownTimeout        time.Duration
+	metricsOptions         models.MetricsOptions
+	tracingOptions         models.TracingOptions
 	tokenDuration          time.Duration
 	saveInterval           time.Duration
 	databaseFolderPath     string
@@ -46,6 +47,47 @@ var (
 	emailSenderAddress     string
 	sendGridApiKey         string
 	certificateCacheFolder string
+	allowedOrigins         []string
+
+	spamHoneypot              bool
+	spamMinSubmitTime         time.Duration
+	spamProofOfWorkDifficulty int
+	spamThreshold             float64
+
+	trustedProxies           []string
+	submissionRateLimit      = models.RateLimitPolicy{Requests: 10, Period: time.Minute}
+	totalSubmissionRateLimit models.RateLimitPolicy
+	loginRateLimit           = models.RateLimitPolicy{Requests: 5, Period: time.Minute}
+	apiRateLimit             = models.RateLimitPolicy{Requests: 300, Period: time.Minute}
+
+	maxFilesPerFeedback int
+	maxFileSize         = 25 * models.MegaByte
+	maxRequestSize      = 100 * models.MegaByte
+	allowedContentTypes []string
+	stripImageMetadata  bool
+
+	deduplicateFiles bool
+	masterKeyFile    string
+
+	retentionDays                int
+	retentionDaysAfterResolution int
+	retentionAction              = models.RetentionDelete
+	retentionInterval            time.Duration
+	retentionDryRun              bool
+
+	fsckInterval       time.Duration
+	fsckRepair         bool
+	fsckCollectGarbage bool
+	fsckGracePeriod    time.Duration
+
+	s3Options = models.S3Options{
+		PartSize: 16 * models.MegaByte,
+	}
+
+	loggingOptions = models.LoggingOptions{
+		Level:  models.LogInfo,
+		Format: models.LogJson,
+	}
 )
 
 const (
@@ -60,20 +102,71 @@ var rootCmd = &cobra.Command{
 	Short: "A simple server for getting feedback from clients",
 	Long: `A very simple server that can help implementing a feedback flow
 so clients can easily provide feedback. `,
-	Run: func(cmd *cobra.Command, args []string) {
-		welp.BindWeb(models.BindWebArgs{
-			FolderPath:             storageFolderPath,
-			UseHttps:               useHttps,
-			Port:                   port,
-			TokenDuration:          tokenDuration,
-			SaveInterval:           saveInterval,
-			DatabaseFolderName:     databaseFolderPath,
-			EmailSenderName:        emailSenderName,
-			EmailSenderAddress:     emailSenderAddress,
-			SendGridApiKey:         sendGridApiKey,
-			CertificateCacheFolder: certificateCacheFolder,
-		})
-	},
+}
+
+func runServer(cmd *cobra.Command, args []string) {
+	webArgs := getBindWebArgs()
+	webArgs.ReloadConfig = reloadConfig
+	welp.BindWeb(webArgs)
+}
+
+// Gets the args for the welp app, from the flags
+func getBindWebArgs() models.BindWebArgs {
+	return models.BindWebArgs{
+		FolderPath: storageFolderPath,
+		UploadLimits: models.UploadLimits{
+			MaxFiles:            maxFilesPerFeedback,
+			MaxFileSize:         maxFileSize,
+			MaxRequestSize:      maxRequestSize,
+			AllowedContentTypes: allowedContentTypes,
+		},
+		StripImageMetadata:     stripImageMetadata,
+		S3:                     s3Options,
+		DeduplicateFiles:       deduplicateFiles,
+		MasterKeyFile:          masterKeyFile,
+		UseHttps:               useHttps,
+		Port:                   port,
+		ShutdownTimeout:        shutdownTimeout,
+		Metrics:                metricsOptions,
+		Logging:                loggingOptions,
+		Tracing:                tracingOptions,
+		TokenDuration:          tokenDuration,
+		SaveInterval:           saveInterval,
+		DatabaseFolderName:     databaseFolderPath,
+		EmailSenderName:        emailSenderName,
+		EmailSenderAddress:     emailSenderAddress,
+		SendGridApiKey:         sendGridApiKey,
+		CertificateCacheFolder: certificateCacheFolder,
+		AllowedOrigins:         allowedOrigins,
+
+		SpamHoneypot:              spamHoneypot,
+		SpamMinSubmitTime:         spamMinSubmitTime,
+		SpamProofOfWorkDifficulty: spamProofOfWorkDifficulty,
+		SpamThreshold:             spamThreshold,
+
+		TrustedProxies:           trustedProxies,
+		SubmissionRateLimit:      submissionRateLimit,
+		TotalSubmissionRateLimit: totalSubmissionRateLimit,
+		LoginRateLimit:           loginRateLimit,
+		ApiRateLimit:             apiRateLimit,
+
+		Retention: models.RetentionPolicy{
+			AfterCreation:   time.Duration(retentionDays) * day,
+			AfterResolution: time.Duration(retentionDaysAfterResolution) * day,
+			Action:          retentionAction,
+			Interval:        retentionInterval,
+			DryRun:          retentionDryRun,
+		},
+
+		Consistency: models.ConsistencyPolicy{
+			ConsistencyOptions: models.ConsistencyOptions{
+				Repair:         fsckRepair,
+				CollectGarbage: fsckCollectGarbage,
+				GracePeriod:    fsckGracePeriod,
+			},
+			Interval: fsckInterval,
+		},
+	}
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -86,57 +179,107 @@ func Execute() {
 }
 
 func init() {
+	// Set here instead of in rootCmd, as reloading the config refers back to the flags of rootCmd
+	rootCmd.Run = runServer
+
 	cobra.OnInitialize(initConfig)
 
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.welp.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "A yaml, toml or json file with the settings of welp, using the flag names as keys. Defaults to $HOME/.welp.yaml, $HOME/.welp.toml or $HOME/.welp.json. Every flag can also be set with an environment variable, e.g. WELP_STORAGE_FOLDER_PATH.")
+
+	// The storage options are shared with the commands that works on the stored data
+	pf := rootCmd.PersistentFlags()
+	pf.StringVar(&storageFolderPath, "storageFolderPath", "storage", "Sets the folder welp should storage uploaded files to")
+
+	pf.Var(&loggingOptions.Level, "logLevel", "The least important log entries that are written. Either debug, info, warn or error.")
+	pf.Var(&loggingOptions.Format, "logFormat", "How log entries are written. Either text, for people to read, or json, for log collectors.")
+
+	// Flatfile storage options
+	pf.DurationVar(&saveInterval, "saveInterval", 5*time.Second, "How often the flatFile storage should save changes (such as new feedback, or user changes). Lower values provides better guarantee that data doesn't get lost, but will decrease performance. ")
+	pf.StringVar(&databaseFolderPath, "databaseFolderPath", "db", "The folder to put database files in.")
+
+	pf.BoolVar(&deduplicateFiles, "deduplicateFiles", false, "Store files by the sha256 hash of their content, so files with the same content are only stored once. Files stored before this was enabled are kept as they are.")
+
+	pf.StringVar(&masterKeyFile, "masterKeyFile", "", "A file with the master key used to encrypt stored files and database files, as 32 random bytes encoded as base64. If not set, the key is read from the WELP_MASTER_KEY environment variable. If there is no key, nothing is encrypted.")
+
+	// Retention options
+	pf.IntVar(&retentionDays, "retentionDays", 0, "Purge feedback this many days after it was created. 0 keeps feedback forever.")
+	pf.IntVar(&retentionDaysAfterResolution, "retentionDaysAfterResolution", 0, "Purge feedback this many days after it was resolved by archiving it. 0 keeps resolved feedback forever.")
+	pf.Var(&retentionAction, "retentionAction", "What happens to feedback when it is purged. Either delete, which deletes the feedback and its attachments, or anonymise, which removes the contact address, the attachments and email addresses in the message.")
+
+	// S3 storage options
+	pf.StringVar(&s3Options.Bucket, "s3Bucket", "", "The S3 bucket to store uploaded files in. If set, files are stored in S3 instead of --storageFolderPath.")
+	pf.StringVar(&s3Options.Prefix, "s3Prefix", "", "Put in front of the name of all files stored in S3, e.g. welp/")
+	pf.StringVar(&s3Options.Endpoint, "s3Endpoint", "s3.amazonaws.com", "The host of the S3 api. Set this to use an S3 compatible storage, like MinIO.")
+	pf.StringVar(&s3Options.Region, "s3Region", "", "The region of the S3 bucket.")
+	pf.StringVar(&s3Options.AccessKey, "s3AccessKey", "", "The access key for S3. If not set, the credentials are found in the environment, the aws credentials file or the instance metadata.")
+	pf.StringVar(&s3Options.SecretKey, "s3SecretKey", "", "The secret key for S3.")
+	pf.BoolVar(&s3Options.PathStyle, "s3PathStyle", false, "Put the bucket name in the path instead of the host name. Most self hosted S3 compatible storages needs this.")
+	pf.BoolVar(&s3Options.Insecure, "s3Insecure", false, "Use http instead of https to talk to S3.")
+	pf.Var(&s3Options.PartSize, "s3PartSize", "The size of each part when uploading files to S3. One part is kept in memory per upload.")
+	pf.DurationVar(&s3Options.PresignDuration, "s3PresignDuration", 0, "If set, downloads are redirected to presigned S3 urls that are valid this long, instead of going through welp.")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	f := rootCmd.Flags()
-	f.StringVar(&storageFolderPath, "storageFolderPath", "storage", "Sets the folder welp should storage uploaded files to")
+
+	// Upload options
+	f.IntVar(&maxFilesPerFeedback, "maxFilesPerFeedback", 10, "The maximum number of files that can be attached to a single feedback. 0 means no limit.")
+	f.Var(&maxFileSize, "maxFileSize", "The maximum size of a single attached file, e.g. 25MB. 0 means no limit.")
+	f.Var(&maxRequestSize, "maxRequestSize", "The maximum size of an entire feedback submission, including all files, e.g. 100MB. 0 means no limit.")
+	f.StringSliceVar(&allowedContentTypes, "allowedContentTypes", []string{"image/*", "video/*", "audio/*", "text/plain", "application/pdf", "application/zip", "application/x-gzip"}, "The content types that can be attached to feedback. Wildcards like image/* are supported. The content type is detected from the file content, not the filename. Pass an empty value to allow everything.")
+	f.BoolVar(&stripImageMetadata, "stripImageMetadata", true, "Remove metadata, like exif and gps locations, from uploaded jpeg, png and webp images before they are saved.")
 
 	f.BoolVar(&useHttps, "useHttps", false, "Enable to automatically use https everywhere. Will add http -> https redirect, and enable HSTS. Not compatible with the --port flag")
 	f.IntVar(&port, "port", 8080, "Sets the port to host welp on")
+	f.DurationVar(&shutdownTimeout, "shutdownTimeout", 5*time.Second, "How long requests in progress, like uploads, get to finish when welp is stopped, before they are cut off.")
+	f.StringVar(&metricsOptions.Address, "metricsAddress", "", "Serve the prometheus metrics on a separate address, like :9090, instead of on /metrics of the normal port.")
+	f.StringVar(&metricsOptions.Token, "metricsToken", "", "If set, the metrics are only served to scrapers that send it as a bearer token.")
+	f.StringVar(&tracingOptions.Exporter, "traceExporter", "", "Where the traces of the requests are sent. Either stdout, file:<path> or otlp:<url of an OpenTelemetry collector>, e.g. otlp:http://localhost:4318. Empty disables tracing.")
+	f.Float64Var(&tracingOptions.SampleRatio, "traceSampleRatio", 1, "The fraction of the traces started by welp that are recorded, between 0 and 1. Traces continued from a caller are recorded if the caller recorded them.")
 	f.StringVar(&certificateCacheFolder, "certificateCacheFolder", ".cache", "The folder where certificates fetched for https will be cached.")
 
-	f.DurationVar(&tokenDuration, "tokenDuration", year, "How long a login token should be valid. Shorter times is probably more secure, but longer times makes it easier for users. Defaults to a year.")
+	f.StringSliceVar(&allowedOrigins, "allowedOrigins", []string{"*"}, "The origins (e.g. https://example.com) that are allowed to submit feedback and embed the feedback form in a frame. Pass \"*\" to allow every origin.")
 
-	// Flatfile storage options
-	f.DurationVar(&saveInterval, "saveInterval", 5*time.Second, "How often the flatFile storage should save changes (such as new feedback, or user changes). Lower values provides better guarantee that data doesn't get lost, but will decrease performance. ")
-	f.StringVar(&databaseFolderPath, "databaseFolderPath", "db", "The folder to put database files in.")
+	f.DurationVar(&retentionInterval, "retentionInterval", time.Hour, "How often the retention policy is enforced.")
+	f.BoolVar(&retentionDryRun, "retentionDryRun", false, "Only log what the retention policy would purge, without changing anything.")
+
+	f.DurationVar(&fsckInterval, "fsckInterval", day, "How often the stored files are checked against the feedback, and issues are logged. 0 disables the check.")
+	f.BoolVar(&fsckRepair, "fsckRepair", false, "Let the periodic check remove missing files from the feedback, and correct sizes that don't match.")
+	f.BoolVar(&fsckCollectGarbage, "fsckCollectGarbage", false, "Let the periodic check delete stored files no feedback refers to.")
+	f.DurationVar(&fsckGracePeriod, "fsckGracePeriod", time.Hour, "Orphaned files are only deleted when they are older than this, so uploads in progress are kept.")
+
+	f.DurationVar(&tokenDuration, "tokenDuration", year, "How long a login token should be valid. Shorter times is probably more secure, but longer times makes it easier for users. Defaults to a year.")
 
 	// Email options
 	f.StringVar(&emailSenderName, "emailSenderName", "no-reply", "The name that should appear on emails being sent from the system")
 	f.StringVar(&emailSenderAddress, "emailSenderAddress", "noreply@noreply.com", "The email address that emails should be sent from. Also used for reply address if people respond to emails.")
 	f.StringVar(&sendGridApiKey, "sendGridApiKey", "", "An api key for sendGrid (https://sendgrid.com/). If provided, sendGrid will be used for sending emails.")
+
+	// Spam options
+	f.BoolVar(&spamHoneypot, "spamHoneypot", true, "Put feedback in quarantine if the hidden honeypot field in the feedback form has been filled out.")
+	f.DurationVar(&spamMinSubmitTime, "spamMinSubmitTime", 3*time.Second, "Feedback submitted faster than this after the form was loaded is rejected. 0 disables the check.")
+	f.IntVar(&spamProofOfWorkDifficulty, "spamProofOfWorkDifficulty", 16, "How many leading zero bits the proof of work clients has to solve before submitting feedback should have. Each extra bit doubles the work. 0 disables the proof of work.")
+	f.Float64Var(&spamThreshold, "spamThreshold", 0.9, "Feedback the spam classifier thinks is spam with at least this probability is put in quarantine. 0 disables the classifier.")
+
+	// Rate limit options
+	f.StringSliceVar(&trustedProxies, "trustedProxies", []string{}, "Ip addresses or cidr ranges of reverse proxies that are trusted to set the X-Forwarded-For and X-Forwarded-Proto headers. Leave empty if welp isn't behind a proxy.")
+	f.Var(&submissionRateLimit, "submissionRateLimit", "How often a single client can submit feedback, as <requests>/<period>. 0 disables the limit.")
+	f.Var(&totalSubmissionRateLimit, "totalSubmissionRateLimit", "How often feedback can be submitted in total across all clients, as <requests>/<period>. 0 disables the limit.")
+	f.Var(&loginRateLimit, "loginRateLimit", "How often a single client can attempt to login, as <requests>/<period>. 0 disables the limit.")
+	f.Var(&apiRateLimit, "apiRateLimit", "How often a single user can call apis that require authentication, as <requests>/<period>. Downloading files and thumbnails doesn't count. 0 disables the limit.")
 }
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
-	if cfgFile != "" {
-		// Use config file from the flag.
-		viper.SetConfigFile(cfgFile)
-	} else {
-		// Find home directory.
-		home, err := homedir.Dir()
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-
-		// Search config in home directory with name ".welp" (without extension).
-		viper.AddConfigPath(home)
-		viper.SetConfigName(".welp")
+	err := loadConfig()
+	if err == nil {
+		err = validateConfig(getBindWebArgs())
 	}
-
-	viper.AutomaticEnv() // read in environment variables that match
-
-	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-
 }
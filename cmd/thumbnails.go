@@ -0,0 +1,47 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/zlepper/welp/internal/app/welp"
+)
+
+var forceThumbnails bool
+
+var thumbnailsCmd = &cobra.Command{
+	Use:   "thumbnails",
+	Short: "Generates thumbnails for image attachments",
+	Long: `Generates the thumbnails for all image attachments that doesn't have them yet.
+Only needed for attachments uploaded before welp made thumbnails, as new uploads
+gets their thumbnails right away.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		welp.BackfillThumbnails(getBindWebArgs(), forceThumbnails)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(thumbnailsCmd)
+
+	thumbnailsCmd.Flags().BoolVar(&forceThumbnails, "force", false, "Generate the thumbnails again, even if they already exist")
+}
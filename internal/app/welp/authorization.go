@@ -25,6 +25,7 @@ package welp
 import (
 	"github.com/labstack/echo"
 	"github.com/zlepper/welp/internal/pkg/consts"
+	"github.com/zlepper/welp/internal/pkg/metrics"
 	"github.com/zlepper/welp/internal/pkg/models"
 	"github.com/zlepper/welp/internal/pkg/webapi"
 	"net/http"
@@ -36,6 +37,8 @@ type AuthorizationApiArgs struct {
 	AuthService   models.AuthorizationService
 	LoginDuration time.Duration
 	JwtMiddleware echo.MiddlewareFunc
+	// Limits how often a client can attempt to login
+	RateLimitMiddleware echo.MiddlewareFunc
 }
 
 type authorizationApiServer struct {
@@ -49,7 +52,7 @@ func bindAuthorizationApi(g *echo.Group, args AuthorizationApiArgs) {
 	}
 
 	g.GET("/login", authApiServer.loginGetHandler)
-	g.POST("/login", authApiServer.loginPostHandler)
+	g.POST("/login", authApiServer.loginPostHandler, args.RateLimitMiddleware)
 	g.GET("/logout", authApiServer.logoutGetHandler)
 }
 
@@ -81,6 +84,7 @@ func (s *authorizationApiServer) loginPostHandler(c echo.Context) error {
 
 	token, err := s.AuthService.Login(webapi.GetContext(c.Request()), request.Email, request.Password)
 	if err != nil {
+		metrics.LoginFailed()
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
@@ -0,0 +1,154 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package welp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/zlepper/welp/internal/app/welp/internal"
+	"github.com/zlepper/welp/internal/pkg/backup"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Makes a backup and writes it to output, or to a file in the current folder named after the time if output is empty.
+// If url is set, the backup is downloaded from the welp instance running there, using the token to login.
+// Otherwise the backup is made from the data on the local disk
+func Backup(args models.BindWebArgs, output, url, token string, options models.BackupOptions) {
+	logger := internal.NewLogger(args)
+
+	if output == "" {
+		output = getBackupFilename(time.Now())
+	}
+
+	var err error
+	if url != "" {
+		err = downloadBackup(url, token, output, options)
+	} else {
+		err = writeLocalBackup(args, logger, output, options)
+	}
+	if err != nil {
+		os.Remove(output)
+		logger.Fatalf("Failed to make a backup: %v", err)
+		return
+	}
+
+	logger.Infof("The backup has been written to '%s'", output)
+}
+
+func writeLocalBackup(args models.BindWebArgs, logger models.Logger, output string, options models.BackupOptions) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer internal.WaitForServices()
+	defer cancel()
+
+	loadedServices, err := internal.GetServices(ctx, args, logger)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = loadedServices.BackupService.Backup(ctx, file, options)
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+func downloadBackup(url, token, output string, options models.BackupOptions) error {
+	if token == "" {
+		return errors.New("a token is required to download a backup, either from --token or WELP_TOKEN")
+	}
+
+	backupUrl := strings.TrimSuffix(url, "/") + "/admin/backup"
+	if options.DatabaseOnly {
+		backupUrl += "?databaseOnly=true"
+	}
+
+	request, err := http.NewRequest(http.MethodGet, backupUrl, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		// Welp explains why, e.g. that the files are stored in S3
+		body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 4096))
+		return fmt.Errorf("welp responded with %s: %s", response.Status, strings.TrimSpace(string(body)))
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, response.Body)
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// Restores the backup in the input file. Welp must not be running meanwhile
+func Restore(args models.BindWebArgs, input string) {
+	logger := internal.NewLogger(args)
+
+	file, err := os.Open(input)
+	if err != nil {
+		logger.Fatal(err)
+		return
+	}
+	defer file.Close()
+
+	// The storages are not loaded, as they would save their data on top of the restored data
+	manifest, err := backup.Restore(file, backup.RestoreArgs{
+		DatabaseFolder: args.DatabaseFolderName,
+		StorageFolder:  internal.GetLocalStorageFolder(args),
+		Logger:         logger,
+	})
+	if err != nil {
+		logger.Fatalf("Failed to restore the backup: %v", err)
+		return
+	}
+
+	logger.Infof("Restored the backup from %s", manifest.Created.Format(time.RFC3339))
+}
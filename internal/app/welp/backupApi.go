@@ -0,0 +1,87 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package welp
+
+import (
+	"github.com/labstack/echo"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/webapi"
+	"net/http"
+	"time"
+)
+
+type bindBackupApiArgs struct {
+	Logger        models.Logger
+	BackupService models.BackupService
+	JwtMiddleware echo.MiddlewareFunc
+	// Only lets admins through
+	AdminMiddleware echo.MiddlewareFunc
+}
+
+func bindBackupApi(e *echo.Group, args bindBackupApiArgs) {
+	server := &backupServer{
+		bindBackupApiArgs: args,
+	}
+
+	e.GET("/admin/backup", server.backupHandler, args.JwtMiddleware, args.AdminMiddleware)
+}
+
+type backupServer struct {
+	bindBackupApiArgs
+}
+
+// Streams a backup of all the data, so nothing has to be buffered.
+// Pass databaseOnly to leave out the uploaded files, which is required when they are stored in S3
+func (s *backupServer) backupHandler(c echo.Context) error {
+	filename := getBackupFilename(time.Now())
+	options := models.BackupOptions{
+		DatabaseOnly: c.QueryParam("databaseOnly") != "",
+	}
+
+	// The response starts with the first part of the backup, so it can still fail with an error before that
+	header := c.Response().Header()
+	header.Set(webapi.HeaderContentType, "application/gzip")
+	header.Set(webapi.HeaderContentDisposition, getContentDisposition("attachment", filename))
+	header.Set(webapi.HeaderCacheControl, "no-store")
+
+	_, err := s.BackupService.Backup(webapi.GetContext(c.Request()), c.Response(), options)
+	if err != nil && !c.Response().Committed {
+		header.Del(webapi.HeaderContentType)
+		header.Del(webapi.HeaderContentDisposition)
+		if err == models.ErrBackupFilesNotLocal {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		return err
+	}
+	if err != nil {
+		// The response has already started, so all that can be done is to leave the backup broken.
+		// The missing manifest makes sure it is never restored
+		s.Logger.WithContext(webapi.GetContext(c.Request())).Errorf("Failed to make a backup: %v", err)
+	}
+
+	return nil
+}
+
+func getBackupFilename(now time.Time) string {
+	return "welp-backup-" + now.Format("20060102-150405") + ".tar.gz"
+}
@@ -24,9 +24,10 @@ package welp
 
 import (
 	"github.com/labstack/echo"
+	"github.com/zlepper/welp/internal/pkg/export"
 	"github.com/zlepper/welp/internal/pkg/models"
 	"github.com/zlepper/welp/internal/pkg/webapi"
-	"net/http"
+	"io"
 )
 
 type baseApi struct {
@@ -37,16 +38,56 @@ func (b *baseApi) respond(c echo.Context, code int, response interface{}, templa
 	responseType := webapi.GetResponseType(c.Request())
 
 	switch responseType {
-	case webapi.MIMEJSON:
-	default:
-		return c.JSON(http.StatusCreated, response)
 	case webapi.MIMEXML:
-		return c.XML(http.StatusCreated, response)
+		return c.XML(code, response)
 	case webapi.MIMEHTML:
-		return c.Render(http.StatusCreated, templateName, response)
+		return c.Render(code, templateName, response)
+	case webapi.MIMECSV, webapi.MIMENDJSON, webapi.MIMEMarkdown:
+		list, ok := response.(feedbackList)
+		if !ok {
+			// Only feedback lists can be exported
+			return c.JSON(code, response)
+		}
+		return b.exportFeedback(c, code, responseType, list.GetFeedback())
+	default:
+		return c.JSON(code, response)
+	}
+}
+
+// Responses that contains a list of feedback, which can be exported as csv, ndjson and markdown
+type feedbackList interface {
+	GetFeedback() []models.Feedback
+}
+
+// How each of the export formats are written
+var feedbackExportFormats = map[string]struct {
+	contentType string
+	extension   string
+	newWriter   func(writer io.Writer) export.FeedbackWriter
+}{
+	webapi.MIMECSV:      {"text/csv; charset=utf-8", ".csv", export.NewCSVWriter},
+	webapi.MIMENDJSON:   {"application/x-ndjson; charset=utf-8", ".ndjson", export.NewNDJSONWriter},
+	webapi.MIMEMarkdown: {"text/markdown; charset=utf-8", ".md", export.NewMarkdownWriter},
+}
+
+// Writes the feedback one row at a time, straight to the response
+func (b *baseApi) exportFeedback(c echo.Context, code int, responseType string, feedback []models.Feedback) error {
+	format := feedbackExportFormats[responseType]
+
+	header := c.Response().Header()
+	header.Set(webapi.HeaderContentType, format.contentType)
+	header.Set(webapi.HeaderContentDisposition, getContentDisposition("attachment", "feedback"+format.extension))
+	c.Response().WriteHeader(code)
+
+	writer := format.newWriter(c.Response())
+	for _, f := range feedback {
+		err := writer.Write(f)
+		if err != nil {
+			return err
+		}
 	}
 
-	return nil
+	return writer.Close()
 }
 
 type authState struct {
@@ -0,0 +1,121 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package welp
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/zlepper/welp/internal/app/welp/internal"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"io"
+	"os"
+)
+
+// Who is logged as having done the data subject actions from the command line
+const commandLinePerformer = "command line"
+
+// The data subject actions that can be done from the command line
+type DataSubjectCommand int
+
+const (
+	// Lists the feedback with the contact address
+	FindDataSubjectCommand DataSubjectCommand = iota
+	// Exports the feedback and attachments as a zip file
+	ExportDataSubjectCommand
+	// Deletes the feedback and attachments permanently
+	EraseDataSubjectCommand
+	// Anonymises the feedback
+	AnonymiseDataSubjectCommand
+	// Prints the log of data subject actions
+	DataSubjectLogCommand
+)
+
+// Runs a data subject action from the command line. The export is written to output,
+// or to stdout if output is empty. Everything else is printed as json
+func RunDataSubjectCommand(args models.BindWebArgs, command DataSubjectCommand, contactAddress, output string) {
+	// Stdout is used for the result
+	logger := internal.NewLoggerWithOutput(args, os.Stderr)
+
+	if command != DataSubjectLogCommand && models.NormalizeContactAddress(contactAddress) == "" {
+		logger.Fatal("--contactAddress is required")
+		return
+	}
+
+	err := runDataSubjectCommand(args, logger, command, contactAddress, output)
+	if err != nil {
+		logger.Fatal(err)
+	}
+}
+
+// Runs the command, and waits for the changes to be saved before returning
+func runDataSubjectCommand(args models.BindWebArgs, logger models.Logger, command DataSubjectCommand, contactAddress, output string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer internal.WaitForServices()
+	defer cancel()
+
+	loadedServices, err := internal.GetServices(ctx, args, logger)
+	if err != nil {
+		return err
+	}
+	service := loadedServices.DataSubjectService
+
+	var result interface{}
+	switch command {
+	case FindDataSubjectCommand:
+		result, err = service.FindFeedback(ctx, contactAddress)
+	case ExportDataSubjectCommand:
+		result, err = exportDataSubject(ctx, service, contactAddress, output)
+	case EraseDataSubjectCommand:
+		result, err = service.Erase(ctx, contactAddress, commandLinePerformer)
+	case AnonymiseDataSubjectCommand:
+		result, err = service.Anonymise(ctx, contactAddress, commandLinePerformer)
+	case DataSubjectLogCommand:
+		result, err = service.GetLog(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The export itself went to stdout, so the log entry can't be printed there as well
+	if command == ExportDataSubjectCommand && output == "" {
+		return nil
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
+
+func exportDataSubject(ctx context.Context, service models.DataSubjectService, contactAddress, output string) (models.DataSubjectLogEntry, error) {
+	var writer io.Writer = os.Stdout
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return models.DataSubjectLogEntry{}, err
+		}
+		defer file.Close()
+		writer = file
+	}
+
+	return service.Export(ctx, contactAddress, commandLinePerformer, writer)
+}
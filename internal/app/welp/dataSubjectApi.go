@@ -0,0 +1,169 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package welp
+
+import (
+	"github.com/labstack/echo"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/webapi"
+	"net/http"
+	"time"
+)
+
+type bindDataSubjectApiArgs struct {
+	Logger             models.Logger
+	DataSubjectService models.DataSubjectService
+	JwtMiddleware      echo.MiddlewareFunc
+	// Only lets admins through
+	AdminMiddleware echo.MiddlewareFunc
+}
+
+func bindDataSubjectApi(e *echo.Group, args bindDataSubjectApiArgs) {
+	server := &dataSubjectServer{
+		bindDataSubjectApiArgs: args,
+	}
+
+	group := e.Group("/admin/data-subject", args.JwtMiddleware, args.AdminMiddleware)
+
+	// Everything that takes the contact address is a POST, so the address isn't
+	// put in the url, where it would end up in the access logs
+	group.POST("", server.findFeedbackHandler)
+	group.POST("/export", server.exportHandler)
+	group.POST("/erase", server.eraseHandler)
+	group.POST("/anonymise", server.anonymiseHandler)
+	group.GET("/log", server.getLogHandler)
+}
+
+type dataSubjectServer struct {
+	bindDataSubjectApiArgs
+	baseApi
+}
+
+type dataSubjectRequest struct {
+	// The contact address of the data subject
+	ContactAddress string `json:"contactAddress" form:"contactAddress"`
+}
+
+type dataSubjectFeedbackResponse struct {
+	Feedback []models.Feedback `json:"feedback" xml:"feedback>feedback"`
+}
+
+type dataSubjectLogResponse struct {
+	Entries []models.DataSubjectLogEntry `json:"entries" xml:"entries>entry"`
+}
+
+func (s *dataSubjectServer) getContactAddress(c echo.Context) (string, error) {
+	var request dataSubjectRequest
+	err := c.Bind(&request)
+	if err != nil {
+		return "", err
+	}
+
+	if models.NormalizeContactAddress(request.ContactAddress) == "" {
+		return "", echo.NewHTTPError(http.StatusBadRequest, models.ErrContactAddressRequired.Error())
+	}
+
+	return request.ContactAddress, nil
+}
+
+func (s *dataSubjectServer) findFeedbackHandler(c echo.Context) error {
+	contactAddress, err := s.getContactAddress(c)
+	if err != nil {
+		return err
+	}
+
+	feedback, err := s.DataSubjectService.FindFeedback(webapi.GetContext(c.Request()), contactAddress)
+	if err != nil {
+		return err
+	}
+
+	return s.respondWithData(c, dataSubjectFeedbackResponse{Feedback: feedback})
+}
+
+func (s *dataSubjectServer) exportHandler(c echo.Context) error {
+	contactAddress, err := s.getContactAddress(c)
+	if err != nil {
+		return err
+	}
+
+	filename := "feedback-export-" + time.Now().Format("2006-01-02") + ".zip"
+
+	header := c.Response().Header()
+	header.Set(webapi.HeaderContentType, "application/zip")
+	header.Set(webapi.HeaderContentDisposition, getContentDisposition("attachment", filename))
+	header.Set(webapi.HeaderCacheControl, "no-store")
+	c.Response().WriteHeader(http.StatusOK)
+
+	_, err = s.DataSubjectService.Export(webapi.GetContext(c.Request()), contactAddress, s.getAuthState(c).User.Email, c.Response())
+	if err != nil {
+		// The response has already started, so all that can be done is to leave the zip file broken
+		s.Logger.WithContext(webapi.GetContext(c.Request())).Errorf("Failed to export the data of a data subject: %v", err)
+	}
+
+	return nil
+}
+
+func (s *dataSubjectServer) eraseHandler(c echo.Context) error {
+	contactAddress, err := s.getContactAddress(c)
+	if err != nil {
+		return err
+	}
+
+	entry, err := s.DataSubjectService.Erase(webapi.GetContext(c.Request()), contactAddress, s.getAuthState(c).User.Email)
+	if err != nil {
+		return err
+	}
+
+	return s.respondWithData(c, entry)
+}
+
+func (s *dataSubjectServer) anonymiseHandler(c echo.Context) error {
+	contactAddress, err := s.getContactAddress(c)
+	if err != nil {
+		return err
+	}
+
+	entry, err := s.DataSubjectService.Anonymise(webapi.GetContext(c.Request()), contactAddress, s.getAuthState(c).User.Email)
+	if err != nil {
+		return err
+	}
+
+	return s.respondWithData(c, entry)
+}
+
+func (s *dataSubjectServer) getLogHandler(c echo.Context) error {
+	entries, err := s.DataSubjectService.GetLog(webapi.GetContext(c.Request()))
+	if err != nil {
+		return err
+	}
+
+	return s.respondWithData(c, dataSubjectLogResponse{Entries: entries})
+}
+
+// There are no pages for data subjects, so json is returned unless xml is explicitly requested
+func (s *dataSubjectServer) respondWithData(c echo.Context, response interface{}) error {
+	if webapi.GetResponseType(c.Request()) == webapi.MIMEXML {
+		return c.XML(http.StatusOK, response)
+	}
+	return c.JSON(http.StatusOK, response)
+}
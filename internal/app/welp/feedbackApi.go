@@ -24,13 +24,18 @@ package welp
 
 import (
 	"context"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/labstack/echo"
+	"github.com/zlepper/welp/internal/pkg/metrics"
 	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/spam"
+	"github.com/zlepper/welp/internal/pkg/tracing"
 	"github.com/zlepper/welp/internal/pkg/webapi"
 	"mime/multipart"
 	"net/http"
 	"path"
+	"strings"
 )
 
 type feedbackServer struct {
@@ -39,11 +44,26 @@ type feedbackServer struct {
 }
 
 type bindFeedbackApiArgs struct {
-	Logger          models.Logger
-	FeedbackService models.FeedbackService
-	FileStorage     models.FileStorage
-	EmailService    models.EmailService
-	JwtMiddleware   echo.MiddlewareFunc
+	Logger           models.Logger
+	FeedbackService  models.FeedbackService
+	FileStorage      models.FileStorage
+	ThumbnailService models.ThumbnailService
+	// Cleans the files before they are saved
+	FileSanitizer models.FileSanitizer
+	UploadLimits  models.UploadLimits
+	EmailService  models.EmailService
+	SpamFilter    models.SpamFilter
+	// Issues the challenges the feedback form has to include
+	SpamChallengeService models.SpamChallengeService
+	JwtMiddleware        echo.MiddlewareFunc
+	// Only lets admins through
+	AdminMiddleware echo.MiddlewareFunc
+	// Rejects submissions from origins that are not allowed
+	AllowedOriginMiddleware echo.MiddlewareFunc
+	// Limits how often feedback can be submitted
+	SubmissionRateLimitMiddleware echo.MiddlewareFunc
+	// Restricts which origins can show the embed page in a frame
+	FrameAncestorsMiddleware echo.MiddlewareFunc
 }
 
 func bindFeedbackApi(e *echo.Group, args bindFeedbackApiArgs) {
@@ -52,9 +72,25 @@ func bindFeedbackApi(e *echo.Group, args bindFeedbackApiArgs) {
 		bindFeedbackApiArgs: args,
 	}
 
-	e.POST("/", server.createFeedbackEntryHandler)
-	e.GET("/embed", server.getFeedbackEmbedHandler)
+	e.POST("/", server.createFeedbackEntryHandler, args.AllowedOriginMiddleware, args.SubmissionRateLimitMiddleware)
+	e.GET("/embed", server.getFeedbackEmbedHandler, args.FrameAncestorsMiddleware)
+	e.GET("/challenge", server.getSpamChallengeHandler, args.AllowedOriginMiddleware)
 	e.GET("/", server.getFeedbackListHandler, args.JwtMiddleware)
+
+	e.GET("/quarantine", server.getQuarantinedFeedbackHandler, args.JwtMiddleware, args.AdminMiddleware)
+	e.POST("/feedback/:id/spam", server.markAsSpamHandler, args.JwtMiddleware, args.AdminMiddleware)
+	e.POST("/feedback/:id/not-spam", server.markAsNotSpamHandler, args.JwtMiddleware, args.AdminMiddleware)
+
+	e.GET("/archive", server.getArchivedFeedbackHandler, args.JwtMiddleware)
+	e.POST("/feedback/:id/archive", server.archiveHandler, args.JwtMiddleware)
+	e.POST("/feedback/:id/unarchive", server.unarchiveHandler, args.JwtMiddleware)
+
+	e.GET("/trash", server.getTrashedFeedbackHandler, args.JwtMiddleware, args.AdminMiddleware)
+	e.POST("/feedback/:id/trash", server.moveToTrashHandler, args.JwtMiddleware, args.AdminMiddleware)
+	e.POST("/feedback/:id/restore", server.restoreHandler, args.JwtMiddleware, args.AdminMiddleware)
+	e.DELETE("/feedback/:id", server.deleteFeedbackHandler, args.JwtMiddleware, args.AdminMiddleware)
+	// Html forms can't send DELETE requests
+	e.POST("/feedback/:id/delete", server.deleteFeedbackHandler, args.JwtMiddleware, args.AdminMiddleware)
 }
 
 type createFeedbackRequest struct {
@@ -65,11 +101,35 @@ type createFeedbackRequest struct {
 	ContactAddress string `json:"contactAddress" form:"contactAddress"`
 }
 
+// How much of a multipart form is kept in memory, the rest is buffered on disk
+const multipartMemory = 8 << 20
+
 func (s *feedbackServer) createFeedbackEntryHandler(c echo.Context) error {
 	ctx := webapi.GetContext(c.Request())
 
+	var body *webapi.LimitedBody
+	if s.UploadLimits.MaxRequestSize > 0 {
+		body = webapi.LimitRequestBody(c.Request(), int64(s.UploadLimits.MaxRequestSize))
+	}
+
+	// Parse the form before binding, so echo doesn't parse it with its own defaults
+	_, parseSpan := tracing.StartSpan(ctx, "parseMultipartForm")
+	err := c.Request().ParseMultipartForm(multipartMemory)
+	parseSpan.EndWithError(err)
+	if err != nil {
+		if body != nil && body.Exceeded() {
+			return s.respond(c, http.StatusRequestEntityTooLarge, uploadRejectedResponse{
+				Message: webapi.ErrRequestTooLarge.Error(),
+				Errors: []uploadError{
+					{Error: fmt.Sprintf("the request is larger than the maximum of %s", s.UploadLimits.MaxRequestSize)},
+				},
+			}, "upload-rejected")
+		}
+		return err
+	}
+
 	var request createFeedbackRequest
-	err := c.Bind(&request)
+	err = c.Bind(&request)
 	if err != nil {
 		return err
 	}
@@ -79,9 +139,35 @@ func (s *feedbackServer) createFeedbackEntryHandler(c echo.Context) error {
 		return err
 	}
 
-	// Read all the files and save them to storage
+	// Check for spam before anything is saved, so bots can't fill up the storage
+	spamResult, err := s.SpamFilter.Check(ctx, models.SpamCheckArgs{
+		Message:        request.Message,
+		ContactAddress: request.ContactAddress,
+		Form:           form.Value,
+	})
+	if err != nil {
+		return err
+	}
+
+	if spamResult.Verdict == models.RejectedSpam {
+		return echo.NewHTTPError(http.StatusBadRequest, models.ErrSpamRejected.Error())
+	}
+
 	files := form.File["files"]
 
+	uploadErrors, err := validateUploads(s.UploadLimits, files)
+	if err != nil {
+		return err
+	}
+
+	if len(uploadErrors) > 0 {
+		return s.respond(c, http.StatusBadRequest, uploadRejectedResponse{
+			Message: "invalid attachments",
+			Errors:  uploadErrors,
+		}, "upload-rejected")
+	}
+
+	// Read all the files and save them to storage
 	savedFiles := make([]models.File, 0)
 
 	for _, file := range files {
@@ -90,26 +176,60 @@ func (s *feedbackServer) createFeedbackEntryHandler(c echo.Context) error {
 		}
 
 		created, err := s.saveMultipartFile(ctx, file)
+		if err == models.ErrImageNotSanitized {
+			s.deleteFiles(ctx, savedFiles)
+			return s.respond(c, http.StatusBadRequest, uploadRejectedResponse{
+				Message: "invalid attachments",
+				Errors:  []uploadError{{File: file.Filename, Error: err.Error()}},
+			}, "upload-rejected")
+		}
 		if err != nil {
+			s.deleteFiles(ctx, savedFiles)
 			return err
 		}
 
 		savedFiles = append(savedFiles, created)
 	}
 
-	feedback, err := s.FeedbackService.CreateFeedback(ctx, request.Message, request.ContactAddress, savedFiles)
+	var feedback models.Feedback
+	if spamResult.Verdict == models.SuspectedSpam {
+		// Don't let the sender know, so they don't try to work around the filter
+		feedback, err = s.FeedbackService.CreateQuarantinedFeedback(ctx, request.Message, request.ContactAddress, savedFiles, spamResult.Reason)
+	} else {
+		feedback, err = s.FeedbackService.CreateFeedback(ctx, request.Message, request.ContactAddress, savedFiles)
+	}
 	if err != nil {
+		s.deleteFiles(ctx, savedFiles)
 		return err
 	}
 
 	return s.respond(c, http.StatusCreated, feedback, "feedback-created")
 }
 
+// Removes files that was saved for feedback that never got created
+func (s *feedbackServer) deleteFiles(ctx context.Context, files []models.File) {
+	for _, file := range files {
+		err := s.FileStorage.DeleteFile(ctx, file.Id)
+		if err != nil {
+			s.Logger.WithContext(ctx).Errorf("Failed to delete file '%s': %v", file.Id, err)
+		}
+
+		err = s.ThumbnailService.DeleteThumbnails(ctx, file)
+		if err != nil {
+			s.Logger.WithContext(ctx).Errorf("Failed to delete thumbnails of file '%s': %v", file.Id, err)
+		}
+	}
+}
+
 func (s *feedbackServer) sendNewFeedbackEmail(ctx context.Context, feedback models.Feedback) {
 
 }
 
 func (s *feedbackServer) saveMultipartFile(ctx context.Context, file *multipart.FileHeader) (createdFile models.File, err error) {
+	ctx, span := tracing.StartSpan(ctx, "saveMultipartFile")
+	defer func() {
+		span.EndWithError(err)
+	}()
 
 	src, err := file.Open()
 	if err != nil {
@@ -117,6 +237,9 @@ func (s *feedbackServer) saveMultipartFile(ctx context.Context, file *multipart.
 	}
 	defer src.Close()
 	contentType, reader, err := webapi.DetectContentType(src)
+	if err != nil {
+		return createdFile, err
+	}
 
 	id, err := uuid.NewRandom()
 	if err != nil {
@@ -127,20 +250,82 @@ func (s *feedbackServer) saveMultipartFile(ctx context.Context, file *multipart.
 
 	filename := id.String() + ext
 
+	reader, sanitized, err := s.FileSanitizer.Sanitize(ctx, contentType, reader)
+	if err != nil {
+		return createdFile, err
+	}
+
 	size, err := s.FileStorage.SaveFile(ctx, filename, reader)
 	if err != nil {
 		return createdFile, err
 	}
+	metrics.AttachmentSaved(size)
+	span.SetAttribute("file.size", size)
+	span.SetAttribute("file.contentType", contentType)
+
+	createdFile = models.File{
+		Id:           filename,
+		Size:         size,
+		ContentType:  contentType,
+		OriginalName: getOriginalFilename(file.Filename),
+		Sanitized:    sanitized,
+	}
+
+	if createdFile.IsImage() {
+		// The thumbnails can also be made when they are first requested,
+		// so failing here isn't a reason to reject the feedback
+		err = s.ThumbnailService.GenerateThumbnails(ctx, createdFile)
+		if err != nil && err != models.ErrThumbnailNotSupported {
+			s.Logger.WithContext(ctx).Warnf("Failed to generate thumbnails for '%s': %v", createdFile.Id, err)
+		}
+	}
+
+	return createdFile, nil
+}
+
+// Strips any path from the uploaded filename, as some browsers sends the full path
+func getOriginalFilename(filename string) string {
+	filename = path.Base(strings.Replace(filename, "\\", "/", -1))
+	if filename == "." || filename == "/" {
+		return ""
+	}
+	return filename
+}
 
-	return models.File{
-		Id:          filename,
-		Size:        size,
-		ContentType: contentType,
-	}, nil
+type feedbackEmbedResponse struct {
+	Challenge     models.SpamChallenge
+	HoneypotField string
 }
 
 func (s *feedbackServer) getFeedbackEmbedHandler(c echo.Context) error {
-	return c.Render(http.StatusOK, "embed", nil)
+	challenge, err := s.SpamChallengeService.NewChallenge(webapi.GetContext(c.Request()))
+	if err != nil {
+		return err
+	}
+
+	// The challenge is only valid once, so the page should never be cached
+	c.Response().Header().Set(webapi.HeaderCacheControl, "no-store")
+
+	return c.Render(http.StatusOK, "embed", feedbackEmbedResponse{
+		Challenge:     challenge,
+		HoneypotField: spam.HoneypotField,
+	})
+}
+
+// Gets a challenge for clients that implement their own feedback form
+func (s *feedbackServer) getSpamChallengeHandler(c echo.Context) error {
+	challenge, err := s.SpamChallengeService.NewChallenge(webapi.GetContext(c.Request()))
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set(webapi.HeaderCacheControl, "no-store")
+
+	// There is no page for the challenge, so json is returned unless xml is explicitly requested
+	if webapi.GetResponseType(c.Request()) == webapi.MIMEXML {
+		return c.XML(http.StatusOK, challenge)
+	}
+	return c.JSON(http.StatusOK, challenge)
 }
 
 func (s *feedbackServer) getAllFeedbackHandler(c echo.Context) error {
@@ -155,6 +340,16 @@ func (s *feedbackServer) getAllFeedbackHandler(c echo.Context) error {
 type feedbackResponse struct {
 	Feedback  []models.Feedback
 	AuthState authState
+	// True if the feedback is the quarantined feedback
+	Quarantine bool
+	// True if the feedback is the archived feedback
+	Archive bool
+	// True if the feedback is the feedback in the trash
+	Trash bool
+}
+
+func (r feedbackResponse) GetFeedback() []models.Feedback {
+	return r.Feedback
 }
 
 func (s *feedbackServer) getFeedbackListHandler(c echo.Context) error {
@@ -172,3 +367,120 @@ func (s *feedbackServer) getFeedbackListHandler(c echo.Context) error {
 
 	return s.respond(c, http.StatusOK, response, "feedback-list")
 }
+
+func (s *feedbackServer) getQuarantinedFeedbackHandler(c echo.Context) error {
+	ctx := webapi.GetContext(c.Request())
+
+	feedback, err := s.FeedbackService.GetQuarantinedFeedback(ctx)
+	if err != nil {
+		return err
+	}
+
+	response := feedbackResponse{
+		Feedback:   feedback,
+		AuthState:  s.getAuthState(c),
+		Quarantine: true,
+	}
+
+	return s.respond(c, http.StatusOK, response, "feedback-list")
+}
+
+func (s *feedbackServer) getArchivedFeedbackHandler(c echo.Context) error {
+	ctx := webapi.GetContext(c.Request())
+
+	feedback, err := s.FeedbackService.GetArchivedFeedback(ctx)
+	if err != nil {
+		return err
+	}
+
+	response := feedbackResponse{
+		Feedback:  feedback,
+		AuthState: s.getAuthState(c),
+		Archive:   true,
+	}
+
+	return s.respond(c, http.StatusOK, response, "feedback-list")
+}
+
+func (s *feedbackServer) getTrashedFeedbackHandler(c echo.Context) error {
+	ctx := webapi.GetContext(c.Request())
+
+	feedback, err := s.FeedbackService.GetTrashedFeedback(ctx)
+	if err != nil {
+		return err
+	}
+
+	response := feedbackResponse{
+		Feedback:  feedback,
+		AuthState: s.getAuthState(c),
+		Trash:     true,
+	}
+
+	return s.respond(c, http.StatusOK, response, "feedback-list")
+}
+
+func (s *feedbackServer) markAsSpamHandler(c echo.Context) error {
+	feedback, err := s.FeedbackService.MarkAsSpam(webapi.GetContext(c.Request()), c.Param("id"))
+	return s.respondToFeedbackChange(c, feedback, err, "/")
+}
+
+func (s *feedbackServer) markAsNotSpamHandler(c echo.Context) error {
+	feedback, err := s.FeedbackService.MarkAsNotSpam(webapi.GetContext(c.Request()), c.Param("id"))
+	return s.respondToFeedbackChange(c, feedback, err, "/quarantine")
+}
+
+func (s *feedbackServer) archiveHandler(c echo.Context) error {
+	feedback, err := s.FeedbackService.Archive(webapi.GetContext(c.Request()), c.Param("id"))
+	return s.respondToFeedbackChange(c, feedback, err, "/")
+}
+
+func (s *feedbackServer) unarchiveHandler(c echo.Context) error {
+	feedback, err := s.FeedbackService.Unarchive(webapi.GetContext(c.Request()), c.Param("id"))
+	return s.respondToFeedbackChange(c, feedback, err, "/archive")
+}
+
+func (s *feedbackServer) moveToTrashHandler(c echo.Context) error {
+	feedback, err := s.FeedbackService.MoveToTrash(webapi.GetContext(c.Request()), c.Param("id"))
+	return s.respondToFeedbackChange(c, feedback, err, "/")
+}
+
+func (s *feedbackServer) restoreHandler(c echo.Context) error {
+	feedback, err := s.FeedbackService.Restore(webapi.GetContext(c.Request()), c.Param("id"))
+	return s.respondToFeedbackChange(c, feedback, err, "/trash")
+}
+
+func (s *feedbackServer) deleteFeedbackHandler(c echo.Context) error {
+	err := s.FeedbackService.DeleteFeedback(webapi.GetContext(c.Request()), c.Param("id"))
+	if err != nil {
+		if err == models.ErrFeedbackNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		if err == models.ErrFeedbackNotTrashed {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		return err
+	}
+
+	if webapi.GetResponseType(c.Request()) == webapi.MIMEHTML {
+		return c.Redirect(http.StatusSeeOther, "/trash")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (s *feedbackServer) respondToFeedbackChange(c echo.Context, feedback models.Feedback, err error, returnUrl string) error {
+	if err != nil {
+		if err == models.ErrFeedbackNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return err
+	}
+
+	switch webapi.GetResponseType(c.Request()) {
+	case webapi.MIMEJSON:
+		return c.JSON(http.StatusOK, feedback)
+	case webapi.MIMEXML:
+		return c.XML(http.StatusOK, feedback)
+	default:
+		return c.Redirect(http.StatusSeeOther, returnUrl)
+	}
+}
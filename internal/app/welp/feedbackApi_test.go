@@ -0,0 +1,105 @@
+package welp
+
+import (
+	"context"
+	"github.com/labstack/echo"
+	"github.com/zlepper/welp/internal/app/welp/internal"
+	"github.com/zlepper/welp/internal/pkg/logging"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type staticSecretService []byte
+
+func (s staticSecretService) GetSigningSecret(ctx context.Context) ([]byte, error) {
+	return s, nil
+}
+
+func (s staticSecretService) SetSigningSecret(ctx context.Context, secret []byte) error {
+	return nil
+}
+
+func passThrough(next echo.HandlerFunc) echo.HandlerFunc {
+	return next
+}
+
+// Gets a server with the feedback api, where only the origin and the login are checked
+func newFeedbackApiTestServer() *echo.Echo {
+	logger := logging.NewLogger(logging.LoggerArgs{})
+
+	e := echo.New()
+	bindFeedbackApi(e.Group(""), bindFeedbackApiArgs{
+		Logger: logger,
+		JwtMiddleware: internal.ChainMiddleware(
+			internal.SameOriginMiddleware(nil, logger),
+			internal.GetJWTMiddlware(staticSecretService("secret"), logger),
+		),
+		AdminMiddleware:               passThrough,
+		AllowedOriginMiddleware:       passThrough,
+		SubmissionRateLimitMiddleware: passThrough,
+		FrameAncestorsMiddleware:      passThrough,
+	})
+	return e
+}
+
+func TestFeedbackChangesRequireLogin(t *testing.T) {
+	e := newFeedbackApiTestServer()
+
+	tests := []struct {
+		accept   string
+		expected int
+	}{
+		{echo.MIMEApplicationJSON, http.StatusUnauthorized},
+		{echo.MIMEApplicationXML, http.StatusUnauthorized},
+		{"text/csv", http.StatusUnauthorized},
+		{echo.MIMETextHTML, http.StatusSeeOther},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodPost, "/feedback/abc/archive", nil)
+		request.Header.Set(echo.HeaderAccept, test.accept)
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, request)
+
+		if recorder.Code != test.expected {
+			t.Errorf("expected %d for an anonymous request accepting %s, got %d", test.expected, test.accept, recorder.Code)
+		}
+	}
+}
+
+func TestFeedbackChangesRequireSameOrigin(t *testing.T) {
+	e := newFeedbackApiTestServer()
+
+	tests := []struct {
+		method   string
+		origin   string
+		referer  string
+		expected int
+	}{
+		// Rejected before the login is looked at
+		{http.MethodPost, "https://evil.example", "", http.StatusForbidden},
+		{http.MethodPost, "", "https://evil.example/page", http.StatusForbidden},
+		{http.MethodPost, "http://welp.example", "", http.StatusUnauthorized},
+		{http.MethodPost, "", "", http.StatusUnauthorized},
+		// Reading isn't a change, so other origins get to the login
+		{http.MethodGet, "https://evil.example", "", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		target := "/feedback/abc/archive"
+		if test.method == http.MethodGet {
+			target = "/archive"
+		}
+		request := httptest.NewRequest(test.method, "http://welp.example"+target, nil)
+		request.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+		request.Header.Set(echo.HeaderOrigin, test.origin)
+		request.Header.Set("Referer", test.referer)
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, request)
+
+		if recorder.Code != test.expected {
+			t.Errorf("expected %d for a %s from origin '%s' and referer '%s', got %d", test.expected, test.method, test.origin, test.referer, recorder.Code)
+		}
+	}
+}
@@ -28,14 +28,17 @@ import (
 	"github.com/zlepper/welp/internal/pkg/webapi"
 	"io"
 	"math"
+	"mime"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type filesApiArgs struct {
 	models.Logger
 	models.FeedbackDataStorage
 	models.FileStorage
+	models.ThumbnailService
 	JwtMiddleware echo.MiddlewareFunc
 }
 
@@ -47,6 +50,7 @@ func bindFilesApi(e *echo.Group, args filesApiArgs) {
 	}
 
 	e.GET("/files/:id", server.getFileHandler, args.JwtMiddleware)
+	e.GET("/files/:id/thumb", server.getThumbnailHandler, args.JwtMiddleware)
 }
 
 type filesApiServer struct {
@@ -63,6 +67,31 @@ func (s *filesApiServer) getFileHandler(c echo.Context) error {
 
 	id := c.Param("id")
 
+	file, err := s.FeedbackDataStorage.GetFile(ctx, id)
+	if err != nil {
+		if err == models.ErrFileNotFound {
+			return s.respond(c, http.StatusNotFound, getFileNotFound{Message: err.Error()}, "file-not-found")
+		}
+		return err
+	}
+
+	disposition := "attachment"
+	if file.CanDisplayInline() && c.QueryParam("download") == "" {
+		disposition = "inline"
+	}
+	disposition = getContentDisposition(disposition, file.GetName())
+
+	// Let the storage serve the file directly, if it can
+	if provider, ok := s.FileStorage.(models.DownloadUrlProvider); ok {
+		downloadUrl, err := provider.GetDownloadUrl(ctx, id, file.ContentType, disposition)
+		if err == nil {
+			return c.Redirect(http.StatusTemporaryRedirect, downloadUrl)
+		}
+		if err != models.ErrDownloadUrlNotSupported {
+			return err
+		}
+	}
+
 	reader, err := s.FileStorage.LoadFile(ctx, id)
 	if err != nil {
 		if err == models.ErrFileNotFound {
@@ -72,8 +101,80 @@ func (s *filesApiServer) getFileHandler(c echo.Context) error {
 	}
 	defer reader.Close()
 
-	c.Response().Header().Set(webapi.HeaderCacheControl, "public, max-age="+strconv.Itoa(math.MaxInt32))
+	c.Response().Header().Set(webapi.HeaderContentDisposition, disposition)
+
+	return serveFile(c, file.Id, file.ContentType, file.Size, reader)
+}
+
+type getThumbnailBadRequest struct {
+	Message string `json:"message" xml:"message"`
+}
+
+func (s *filesApiServer) getThumbnailHandler(c echo.Context) error {
+	ctx := webapi.GetContext(c.Request())
+
+	size, err := models.ParseThumbnailSize(c.QueryParam("size"))
+	if err != nil {
+		return s.respond(c, http.StatusBadRequest, getThumbnailBadRequest{Message: err.Error()}, "bad-request")
+	}
+
+	file, err := s.FeedbackDataStorage.GetFile(ctx, c.Param("id"))
+	if err != nil {
+		if err == models.ErrFileNotFound {
+			return s.respond(c, http.StatusNotFound, getFileNotFound{Message: err.Error()}, "file-not-found")
+		}
+		return err
+	}
+
+	thumbnail, reader, err := s.ThumbnailService.LoadThumbnail(ctx, file, size)
+	if err != nil {
+		if err == models.ErrFileNotFound || err == models.ErrThumbnailNotSupported {
+			return s.respond(c, http.StatusNotFound, getFileNotFound{Message: err.Error()}, "file-not-found")
+		}
+		return err
+	}
+	defer reader.Close()
+
+	c.Response().Header().Set(webapi.HeaderContentDisposition, "inline")
 
-	_, err = io.Copy(c.Response(), reader)
+	return serveFile(c, thumbnail.Id, thumbnail.ContentType, 0, reader)
+}
+
+// Writes the file to the response. The size is only used if it's
+// larger than 0 and the reader can't seek
+func serveFile(c echo.Context, id, contentType string, size int64, reader io.Reader) error {
+	header := c.Response().Header()
+	// Files never changes once uploaded, so the id is good enough as an etag
+	header.Set(webapi.HeaderETag, strconv.Quote(id))
+	header.Set(webapi.HeaderCacheControl, "private, max-age="+strconv.Itoa(math.MaxInt32))
+	header.Set(webapi.HeaderContentType, contentType)
+	header.Set(webapi.HeaderContentTypeOptions, "nosniff")
+
+	// If the storage allows seeking, ServeContent takes care of range requests,
+	// If-None-Match and Content-Length
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		http.ServeContent(c.Response(), c.Request(), "", time.Time{}, seeker)
+		return nil
+	}
+
+	if match := c.Request().Header.Get(webapi.HeaderIfNoneMatch); match != "" && match == header.Get(webapi.HeaderETag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	if size > 0 {
+		header.Set(webapi.HeaderContentLength, strconv.FormatInt(size, 10))
+	}
+	c.Response().WriteHeader(http.StatusOK)
+
+	_, err := io.Copy(c.Response(), reader)
 	return err
 }
+
+func getContentDisposition(disposition, filename string) string {
+	value := mime.FormatMediaType(disposition, map[string]string{"filename": filename})
+	// FormatMediaType gives up on names it can't encode, so just leave the name out
+	if value == "" {
+		return disposition
+	}
+	return value
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package welp
+
+import (
+	"context"
+	"fmt"
+	"github.com/zlepper/welp/internal/app/welp/internal"
+	"github.com/zlepper/welp/internal/pkg/models"
+)
+
+// Checks that the stored files match the feedback, and prints the issues that were found
+func Fsck(args models.BindWebArgs, options models.ConsistencyOptions) {
+	logger := internal.NewLogger(args)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer internal.WaitForServices()
+	defer cancel()
+
+	loadedServices, err := internal.GetServices(ctx, args, logger)
+	if err != nil {
+		logger.Fatal(err)
+		return
+	}
+
+	report, err := loadedServices.ConsistencyService.Check(ctx, options)
+	if err != nil {
+		logger.Fatal(err)
+		return
+	}
+
+	printConsistencyReport(report)
+}
+
+func printConsistencyReport(report models.ConsistencyReport) {
+	fixed := 0
+	for _, issue := range report.Issues {
+		status := ""
+		switch {
+		case issue.Fixed:
+			fixed++
+			status = " (fixed)"
+		case issue.Error != "":
+			status = " (failed: " + issue.Error + ")"
+		}
+		fmt.Println(issue.Describe() + status)
+	}
+
+	if len(report.Issues) > 0 {
+		fmt.Println()
+	}
+
+	fmt.Printf("Checked %d feedback entries and %d stored files. Found %d issues, fixed %d\n", report.Feedback, report.Files, len(report.Issues), fixed)
+}
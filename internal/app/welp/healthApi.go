@@ -0,0 +1,86 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package welp
+
+import (
+	"github.com/labstack/echo"
+	"github.com/zlepper/welp/internal/pkg/consts"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/webapi"
+	"net/http"
+	"runtime"
+)
+
+type bindHealthApiArgs struct {
+	Logger        models.Logger
+	HealthService models.HealthService
+}
+
+// The health endpoints are used by load balancers and orchestrators, so they don't require authentication
+func bindHealthApi(e *echo.Group, args bindHealthApiArgs) {
+	server := &healthServer{
+		bindHealthApiArgs: args,
+	}
+
+	e.GET("/healthz", server.healthHandler)
+	e.GET("/readyz", server.readyHandler)
+	e.GET("/version", server.versionHandler)
+}
+
+type healthServer struct {
+	bindHealthApiArgs
+}
+
+// Only tells that the process is alive and answering requests
+func (s *healthServer) healthHandler(c echo.Context) error {
+	c.Response().Header().Set(webapi.HeaderCacheControl, "no-store")
+	return c.JSON(http.StatusOK, map[string]models.HealthStatus{
+		"status": models.HealthOk,
+	})
+}
+
+func (s *healthServer) readyHandler(c echo.Context) error {
+	report := s.HealthService.CheckReadiness(webapi.GetContext(c.Request()))
+
+	status := http.StatusOK
+	if report.Status == models.HealthFailing {
+		status = http.StatusServiceUnavailable
+	}
+
+	c.Response().Header().Set(webapi.HeaderCacheControl, "no-store")
+	return c.JSON(status, report)
+}
+
+func (s *healthServer) versionHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, getVersionInfo())
+}
+
+func getVersionInfo() models.VersionInfo {
+	return models.VersionInfo{
+		Version:   consts.Version,
+		Commit:    consts.Commit,
+		BuildDate: consts.BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
@@ -23,26 +23,69 @@
 package welp
 
 import (
+	"context"
 	"github.com/labstack/echo"
 	"github.com/zlepper/welp/internal/pkg/models"
 	"golang.org/x/crypto/acme/autocert"
+	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 )
 
-func host(args models.BindWebArgs, e *echo.Echo) {
+// Hosts welp until it gets SIGINT or SIGTERM. New connections are then refused,
+// and the requests in progress get ShutdownTimeout to finish before they are cut off.
+// The metrics server is only hosted if it isn't nil.
+// Returns an error if a server couldn't be started
+func host(args models.BindWebArgs, e *echo.Echo, metricsServer *echo.Echo, logger models.Logger) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
 
-	if args.UseHttps {
-		hostHttps(e, args)
-	} else {
-		hostHttp(e, args)
+	serverErrors := make(chan error, 2)
+	go func() {
+		if args.UseHttps {
+			logger.Info("Welp is listening on :443 with https")
+			serverErrors <- hostHttps(e, args)
+		} else {
+			logger.Infof("Welp is listening on :%d", args.Port)
+			serverErrors <- hostHttp(e, args)
+		}
+	}()
+
+	if metricsServer != nil {
+		go func() {
+			logger.Infof("Metrics are served on %s", args.Metrics.Address)
+			serverErrors <- metricsServer.Start(args.Metrics.Address)
+		}()
+		defer metricsServer.Close()
+	}
+
+	select {
+	case err := <-serverErrors:
+		e.Close()
+		return err
+	case sig := <-signals:
+		logger.Infof("Received %s, shutting down", sig)
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), args.ShutdownTimeout)
+	defer cancel()
+
+	err := e.Shutdown(ctx)
+	if err != nil {
+		logger.Warnf("Not all requests finished within %s, cutting them off: %v", args.ShutdownTimeout, err)
+		e.Close()
+	}
+
+	return nil
 }
 
-func hostHttps(e *echo.Echo, args models.BindWebArgs) {
+func hostHttps(e *echo.Echo, args models.BindWebArgs) error {
 	e.AutoTLSManager.Cache = autocert.DirCache(args.CertificateCacheFolder)
-	e.Logger.Fatal(e.StartAutoTLS(":443"))
+	return e.StartAutoTLS(":443")
 }
 
-func hostHttp(e *echo.Echo, args models.BindWebArgs) {
-	e.Logger.Fatal(e.Start(":" + strconv.Itoa(args.Port)))
+func hostHttp(e *echo.Echo, args models.BindWebArgs) error {
+	return e.Start(":" + strconv.Itoa(args.Port))
 }
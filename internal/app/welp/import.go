@@ -0,0 +1,124 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package welp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/zlepper/welp/internal/app/welp/internal"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+type ImportArgs struct {
+	// The file to import
+	Filename string
+	// Either csv or json. If empty, it's found from the extension of the file
+	Format  string
+	Mapping models.ImportMapping
+	// The layout of the created times. If empty, the most common layouts are tried
+	TimeFormat string
+	// The folder relative attachment paths are read from. Defaults to the folder of the imported file
+	AttachmentFolder string
+	// If not empty, the report is also written to this file as json
+	ReportFile string
+}
+
+// Imports feedback from a file, and prints a report of what was imported
+func Import(args models.BindWebArgs, importArgs ImportArgs) {
+	logger := internal.NewLogger(args)
+
+	format, err := getImportFormat(importArgs.Format, importArgs.Filename)
+	if err != nil {
+		logger.Fatal(err)
+		return
+	}
+
+	attachmentFolder := importArgs.AttachmentFolder
+	if attachmentFolder == "" {
+		attachmentFolder = filepath.Dir(importArgs.Filename)
+	}
+
+	file, err := os.Open(importArgs.Filename)
+	if err != nil {
+		logger.Fatal(err)
+		return
+	}
+	defer file.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer internal.WaitForServices()
+	defer cancel()
+
+	loadedServices, err := internal.GetServices(ctx, args, logger)
+	if err != nil {
+		logger.Fatal(err)
+		return
+	}
+
+	// Whoever runs the command already has access to the files and network of the machine
+	report, err := loadedServices.ImportService.Import(ctx, file, models.ImportOptions{
+		Format:               format,
+		Mapping:              importArgs.Mapping,
+		TimeFormat:           importArgs.TimeFormat,
+		AttachmentFolder:     attachmentFolder,
+		AllowPrivateNetworks: true,
+		UploadLimits:         args.UploadLimits,
+	})
+	if err != nil {
+		logger.Errorf("The import was stopped: %v", err)
+	}
+
+	printImportReport(report)
+
+	if importArgs.ReportFile != "" {
+		content, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			logger.Error(err)
+			return
+		}
+
+		err = ioutil.WriteFile(importArgs.ReportFile, content, 0644)
+		if err != nil {
+			logger.Error(err)
+		}
+	}
+}
+
+func printImportReport(report models.ImportReport) {
+	if len(report.Rejected) > 0 {
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "ROW\tID\tREASON")
+		for _, rejection := range report.Rejected {
+			fmt.Fprintf(writer, "%d\t%s\t%s\n", rejection.Row, rejection.Id, rejection.Reason)
+		}
+		writer.Flush()
+		fmt.Println()
+	}
+
+	fmt.Printf("%d feedback entries were imported, %d already existed and %d were rejected\n", report.Imported, report.Duplicates, len(report.Rejected))
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package welp
+
+import (
+	"github.com/labstack/echo"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/webapi"
+	"net/http"
+	"path"
+	"strings"
+)
+
+type bindImportApiArgs struct {
+	Logger        models.Logger
+	ImportService models.ImportService
+	UploadLimits  models.UploadLimits
+	JwtMiddleware echo.MiddlewareFunc
+	// Only lets admins through
+	AdminMiddleware echo.MiddlewareFunc
+}
+
+func bindImportApi(e *echo.Group, args bindImportApiArgs) {
+	server := &importServer{
+		bindImportApiArgs: args,
+	}
+
+	e.POST("/admin/import", server.importHandler, args.JwtMiddleware, args.AdminMiddleware)
+}
+
+type importServer struct {
+	bindImportApiArgs
+}
+
+type importRequest struct {
+	// Either csv or json. If empty, it's found from the extension of the uploaded file
+	Format            string `form:"format"`
+	IdColumn          string `form:"idColumn"`
+	MessageColumn     string `form:"messageColumn"`
+	ContactColumn     string `form:"contactColumn"`
+	CreatedColumn     string `form:"createdColumn"`
+	AttachmentsColumn string `form:"attachmentsColumn"`
+	TimeFormat        string `form:"timeFormat"`
+}
+
+// Imports the uploaded file. Attachments can only be downloaded from public urls,
+// as paths on the server, and internal services, shouldn't be reachable from the api
+func (s *importServer) importHandler(c echo.Context) error {
+	var request importRequest
+	err := c.Bind(&request)
+	if err != nil {
+		return err
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "the file to import is required")
+	}
+
+	format, err := getImportFormat(request.Format, fileHeader.Filename)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	mapping := models.DefaultImportMapping
+	setIfNotEmpty(&mapping.Id, request.IdColumn)
+	setIfNotEmpty(&mapping.Message, request.MessageColumn)
+	setIfNotEmpty(&mapping.ContactAddress, request.ContactColumn)
+	setIfNotEmpty(&mapping.Created, request.CreatedColumn)
+	setIfNotEmpty(&mapping.Attachments, request.AttachmentsColumn)
+
+	report, err := s.ImportService.Import(webapi.GetContext(c.Request()), file, models.ImportOptions{
+		Format:       format,
+		Mapping:      mapping,
+		TimeFormat:   request.TimeFormat,
+		UploadLimits: s.UploadLimits,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	// There are no pages for imports, so json is returned unless xml is explicitly requested
+	if webapi.GetResponseType(c.Request()) == webapi.MIMEXML {
+		return c.XML(http.StatusOK, report)
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// Gets the format of the imported file. If no format is given, it's found from the extension of the file
+func getImportFormat(format, filename string) (models.ImportFormat, error) {
+	if format == "" {
+		switch strings.ToLower(path.Ext(filename)) {
+		case ".json", ".ndjson", ".jsonl":
+			format = string(models.ImportJSON)
+		default:
+			format = string(models.ImportCSV)
+		}
+	}
+
+	return models.ParseImportFormat(format)
+}
+
+func setIfNotEmpty(target *string, value string) {
+	if value != "" {
+		*target = value
+	}
+}
@@ -55,17 +55,20 @@ func GetJWTMiddlware(secretService models.SecretService, logger models.Logger) e
 			err = getTokenDataFromRequest(c.Request(), signingSecret, &user)
 			if err != nil {
 
-				logger.Infof("Authorization required: %v", err)
+				logger.WithContext(webapi.GetContext(c.Request())).Infof("Authorization required: %v", err)
 
 				responseType := webapi.GetResponseType(c.Request())
 
 				returnUrl := c.Request().URL.EscapedPath()
 
-				logger.Debugf("Returning to: %s", returnUrl)
+				logger.WithContext(webapi.GetContext(c.Request())).Debugf("Returning to: %s", returnUrl)
 
+				// Every case has to return, so requests without a valid token never reach next
 				switch responseType {
 				case webapi.MIMEJSON:
+					return c.JSON(http.StatusUnauthorized, UnauthorizedResponse{Message: ErrAuthorizationRequired.Error()})
 				case webapi.MIMEXML:
+					return c.XML(http.StatusUnauthorized, UnauthorizedResponse{Message: ErrAuthorizationRequired.Error()})
 				default:
 					return echo.ErrUnauthorized
 				case webapi.MIMEHTML:
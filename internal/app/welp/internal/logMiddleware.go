@@ -0,0 +1,85 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package internal
+
+import (
+	"github.com/google/uuid"
+	"github.com/labstack/echo"
+	"github.com/zlepper/welp/internal/pkg/logging"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/webapi"
+	"net"
+	"regexp"
+	"time"
+)
+
+// Request ids from clients and proxies are only used if they are this simple,
+// so they can't be used to put anything odd in the log
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Gives every request an id, which is put in the context so the services log it too.
+// The id from the X-Request-ID header is used if it is set, e.g. by a proxy
+func RequestIdMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			requestId := c.Request().Header.Get(echo.HeaderXRequestID)
+			if !validRequestId.MatchString(requestId) {
+				requestId = uuid.New().String()
+			}
+
+			c.Response().Header().Set(echo.HeaderXRequestID, requestId)
+			request := c.Request()
+			c.SetRequest(request.WithContext(logging.WithRequestId(request.Context(), requestId)))
+
+			return next(c)
+		}
+	}
+}
+
+// Logs every request when it is done. Requests that failed with a server error are logged as errors.
+// The client ip is only taken from X-Forwarded-For when the request came through one of the trusted proxies
+func AccessLogMiddleware(trustedProxies []*net.IPNet, logger models.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+			status := getResponseStatus(c, err)
+
+			request := c.Request()
+			entry := logger.WithContext(request.Context()).
+				WithField("method", request.Method).
+				WithField("uri", request.RequestURI).
+				WithField("status", status).
+				WithField("duration", time.Since(start).String()).
+				WithField("remoteIp", webapi.GetClientIP(request, trustedProxies))
+
+			if status >= 500 {
+				entry.Errorf("Request failed: %v", err)
+			} else {
+				entry.Info("Request handled")
+			}
+
+			return err
+		}
+	}
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package internal
+
+import (
+	"github.com/zlepper/welp/internal/pkg/encryption"
+	"github.com/zlepper/welp/internal/pkg/logging"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"io"
+	"os"
+)
+
+// Creates the logger for the server and the commands, which logs to stdout.
+// The secrets from the configuration are always redacted, even where they don't look like secrets
+func NewLogger(args models.BindWebArgs) models.Logger {
+	return NewLoggerWithOutput(args, os.Stdout)
+}
+
+// Creates a logger like NewLogger, that logs to output instead
+func NewLoggerWithOutput(args models.BindWebArgs, output io.Writer) models.Logger {
+	return logging.NewLogger(logging.LoggerArgs{
+		Level:   args.Logging.Level,
+		Format:  args.Logging.Format,
+		Output:  output,
+		Secrets: getSecrets(args),
+	})
+}
+
+// Redacts the secrets of a reloaded configuration too, like a rotated api key.
+// The secrets of the earlier configuration are still redacted
+func AddConfigSecrets(logger models.Logger, args models.BindWebArgs) {
+	logging.AddSecrets(logger, getSecrets(args)...)
+}
+
+func getSecrets(args models.BindWebArgs) []string {
+	return []string{
+		args.SendGridApiKey,
+		args.S3.AccessKey,
+		args.S3.SecretKey,
+		args.Metrics.Token,
+		os.Getenv(encryption.MasterKeyEnvironmentVariable),
+	}
+}
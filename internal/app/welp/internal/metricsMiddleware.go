@@ -0,0 +1,66 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package internal
+
+import (
+	"github.com/labstack/echo"
+	"github.com/zlepper/welp/internal/pkg/metrics"
+	"net/http"
+	"time"
+)
+
+// Requests that didn't match any route are counted together, so random paths don't create new series
+const unmatchedRoute = "unmatched"
+
+// Counts the requests and how long they take, by the route they matched
+func MetricsMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+
+			status := getResponseStatus(c, err)
+
+			route := c.Path()
+			if route == "" || status == http.StatusNotFound {
+				route = unmatchedRoute
+			}
+
+			metrics.ObserveRequest(c.Request().Method, route, status, time.Since(start))
+			return err
+		}
+	}
+}
+
+// Gets the status code of the response. If the handler failed, the error handler hasn't
+// written the response yet, so the status has to come from the error
+func getResponseStatus(c echo.Context, err error) int {
+	if err == nil {
+		return c.Response().Status
+	}
+
+	if httpError, ok := err.(*echo.HTTPError); ok {
+		return httpError.Code
+	}
+	return http.StatusInternalServerError
+}
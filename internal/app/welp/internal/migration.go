@@ -0,0 +1,128 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"strings"
+)
+
+var (
+	ErrDeduplicationNeedsDatabase = errors.New("with --deduplicateFiles, the database and the files have to be migrated together, as the database keeps track of the blobs")
+)
+
+// Gets the storages a migration reads from or writes to. The data driver is "flatfile:<folder>",
+// and the file driver is either "local:<folder>" or "s3:<bucket>[/<prefix>]". S3 uses the connection
+// settings from the flags. An empty driver gives no storages for that part
+func GetMigrationStorages(ctx context.Context, args models.BindWebArgs, dataDriver, fileDriver string, logger models.Logger) (models.MigrationStorages, error) {
+	var storages models.MigrationStorages
+
+	encryptor, err := getEncryptor(args, logger)
+	if err != nil {
+		return storages, err
+	}
+
+	if dataDriver != "" {
+		kind, location := splitDriver(dataDriver)
+		if kind != "flatfile" || location == "" {
+			return storages, fmt.Errorf("unknown data driver '%s', it should be flatfile:<folder>", dataDriver)
+		}
+		args.DatabaseFolderName = location
+
+		storages.FeedbackDataStorage, err = getDataStorage(ctx, args, logger, encryptor)
+		if err != nil {
+			return storages, err
+		}
+
+		storages.AuthorizationDataStorage, err = getAuthenticationDataStorage(ctx, args, logger, encryptor)
+		if err != nil {
+			return storages, err
+		}
+
+		storages.SecretService, err = getSecretService(args, logger, encryptor)
+		if err != nil {
+			return storages, err
+		}
+
+		storages.SpamDataStorage, err = getSpamDataStorage(ctx, args, logger, encryptor)
+		if err != nil {
+			return storages, err
+		}
+
+		storages.DataSubjectLogStorage, err = getDataSubjectLogStorage(ctx, args, logger, encryptor)
+		if err != nil {
+			return storages, err
+		}
+	}
+
+	if fileDriver != "" {
+		if args.DeduplicateFiles && dataDriver == "" {
+			return storages, ErrDeduplicationNeedsDatabase
+		}
+
+		kind, location := splitDriver(fileDriver)
+		switch {
+		case kind == "local" && location != "":
+			args.FolderPath = location
+			args.S3.Bucket = ""
+		case kind == "s3" && location != "":
+			parts := strings.SplitN(location, "/", 2)
+			args.S3.Bucket = parts[0]
+			args.S3.Prefix = ""
+			if len(parts) == 2 {
+				args.S3.Prefix = parts[1]
+			}
+		default:
+			return storages, fmt.Errorf("unknown file driver '%s', it should be local:<folder> or s3:<bucket>[/<prefix>]", fileDriver)
+		}
+
+		storages.FileStorage, err = getFileStorage(ctx, args, logger, encryptor)
+		if err != nil {
+			return storages, err
+		}
+	}
+
+	return storages, nil
+}
+
+// Gets the driver the storages are configured to use by the flags
+func GetConfiguredDrivers(args models.BindWebArgs) (dataDriver, fileDriver string) {
+	dataDriver = "flatfile:" + args.DatabaseFolderName
+
+	if args.S3.Bucket != "" {
+		return dataDriver, "s3:" + strings.TrimSuffix(args.S3.Bucket+"/"+args.S3.Prefix, "/")
+	}
+
+	return dataDriver, "local:" + args.FolderPath
+}
+
+func splitDriver(driver string) (kind, location string) {
+	parts := strings.SplitN(driver, ":", 2)
+	if len(parts) != 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package internal
+
+import (
+	"errors"
+	"github.com/labstack/echo"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/webapi"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrOriginNotAllowed = errors.New("origin not allowed")
+)
+
+const allowAllOrigins = "*"
+
+// Only allows requests that either comes from the same origin as welp itself,
+// or from one of the allowed origins.
+// Requests without an origin (e.g. from api clients) are always allowed.
+func AllowedOriginMiddleware(allowedOrigins []string, trustedProxies []*net.IPNet, logger models.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			origin := getRequestOrigin(c.Request())
+
+			if origin == "" || isSameOrigin(c.Request(), origin, trustedProxies) || isOriginAllowed(allowedOrigins, origin) {
+				return next(c)
+			}
+
+			logger.WithContext(webapi.GetContext(c.Request())).Warnf("Rejected cross-origin request to '%s' from '%s' (%s)", c.Request().URL.Path, origin, webapi.GetClientIP(c.Request(), trustedProxies))
+
+			return echo.NewHTTPError(http.StatusForbidden, ErrOriginNotAllowed.Error())
+		}
+	}
+}
+
+// Only allows requests that change something if they come from the same origin as welp itself,
+// so other sites can't use the login cookie of an admin to e.g. delete feedback.
+// Requests without an origin (e.g. from api clients) are always allowed.
+func SameOriginMiddleware(trustedProxies []*net.IPNet, logger models.Logger) echo.MiddlewareFunc {
+	sameOrigin := AllowedOriginMiddleware(nil, trustedProxies, logger)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		checked := sameOrigin(next)
+		return func(c echo.Context) error {
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return next(c)
+			default:
+				return checked(c)
+			}
+		}
+	}
+}
+
+// Tells the browser which origins are allowed to show the response in a frame
+func FrameAncestorsMiddleware(allowedOrigins []string) echo.MiddlewareFunc {
+	policy := getFrameAncestorsPolicy(allowedOrigins)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Response().Header().Set(webapi.HeaderContentSecurityPolicy, policy)
+			return next(c)
+		}
+	}
+}
+
+func getFrameAncestorsPolicy(allowedOrigins []string) string {
+	sources := []string{"'self'"}
+	for _, origin := range allowedOrigins {
+		if origin == allowAllOrigins {
+			return "frame-ancestors *"
+		}
+		sources = append(sources, normalizeOrigin(origin))
+	}
+
+	return "frame-ancestors " + strings.Join(sources, " ")
+}
+
+func isOriginAllowed(allowedOrigins []string, origin string) bool {
+	origin = normalizeOrigin(origin)
+	for _, allowed := range allowedOrigins {
+		if allowed == allowAllOrigins || normalizeOrigin(allowed) == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// The scheme has to match too, so e.g. http://welp.example isn't the same origin as a welp served on https
+func isSameOrigin(r *http.Request, origin string, trustedProxies []*net.IPNet) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Scheme, webapi.GetScheme(r, trustedProxies)) && strings.EqualFold(u.Host, r.Host)
+}
+
+// Gets the origin the request was made from.
+// Falls back to the referer, as some browsers doesn't send
+// the Origin header on same-origin form posts
+func getRequestOrigin(r *http.Request) string {
+	origin := r.Header.Get(echo.HeaderOrigin)
+	if origin != "" && origin != "null" {
+		return origin
+	}
+
+	referer := r.Referer()
+	if referer == "" {
+		return origin
+	}
+
+	u, err := url.Parse(referer)
+	if err != nil || u.Host == "" {
+		return origin
+	}
+
+	return u.Scheme + "://" + u.Host
+}
+
+func normalizeOrigin(origin string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), "/")
+}
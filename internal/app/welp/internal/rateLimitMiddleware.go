@@ -0,0 +1,115 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package internal
+
+import (
+	"github.com/labstack/echo"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/webapi"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Decides which bucket a request should take from
+type RateLimitKeyFunc func(c echo.Context) string
+
+// Puts every client in its own bucket, based on their ip address
+func ClientIPKey(trustedProxies []*net.IPNet) RateLimitKeyFunc {
+	return func(c echo.Context) string {
+		return "ip:" + webapi.GetClientIP(c.Request(), trustedProxies)
+	}
+}
+
+// Puts every authenticated user in their own bucket.
+// Falls back to the ip address if the user isn't authenticated
+func UserKey(trustedProxies []*net.IPNet) RateLimitKeyFunc {
+	clientIPKey := ClientIPKey(trustedProxies)
+	return func(c echo.Context) string {
+		if user, ok := c.Get("user").(models.TokenUser); ok && user.Email != "" {
+			return "user:" + user.Email
+		}
+		return clientIPKey(c)
+	}
+}
+
+// Puts every request in the same bucket
+func GlobalKey(c echo.Context) string {
+	return "global"
+}
+
+type RateLimitedResponse struct {
+	Message string `json:"message" xml:"message"`
+	// How many seconds the client should wait before trying again
+	RetryAfter int `json:"retryAfter" xml:"retryAfter"`
+}
+
+func RateLimitMiddleware(limiter models.RateLimiter, keyFunc RateLimitKeyFunc, logger models.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := keyFunc(c)
+
+			allowed, retryAfter := limiter.Allow(key)
+			if allowed {
+				return next(c)
+			}
+
+			logger.WithContext(webapi.GetContext(c.Request())).Infof("Rate limited request to '%s' from '%s'", c.Request().URL.Path, key)
+
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			c.Response().Header().Set(webapi.HeaderRetryAfter, strconv.Itoa(seconds))
+
+			response := RateLimitedResponse{
+				Message:    http.StatusText(http.StatusTooManyRequests),
+				RetryAfter: seconds,
+			}
+
+			switch webapi.GetResponseType(c.Request()) {
+			case webapi.MIMEJSON:
+				return c.JSON(http.StatusTooManyRequests, response)
+			case webapi.MIMEXML:
+				return c.XML(http.StatusTooManyRequests, response)
+			default:
+				return c.Render(http.StatusTooManyRequests, "rate-limited", rateLimitedPage{
+					RetryAfter: time.Duration(seconds) * time.Second,
+				})
+			}
+		}
+	}
+}
+
+type rateLimitedPage struct {
+	RetryAfter time.Duration
+}
+
+// Combines several middlewares into one, the first being the outermost
+func ChainMiddleware(middlewares ...echo.MiddlewareFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
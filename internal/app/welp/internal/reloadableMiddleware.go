@@ -0,0 +1,53 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package internal
+
+import (
+	"github.com/labstack/echo"
+	"sync/atomic"
+)
+
+// A middleware that can be replaced while welp runs, e.g. when the allowed origins are reloaded.
+// Requests that have already started finish with the middleware they started with
+type ReloadableMiddleware struct {
+	current atomic.Value
+}
+
+func NewReloadableMiddleware(middleware echo.MiddlewareFunc) *ReloadableMiddleware {
+	m := &ReloadableMiddleware{}
+	m.Set(middleware)
+	return m
+}
+
+func (m *ReloadableMiddleware) Set(middleware echo.MiddlewareFunc) {
+	m.current.Store(middleware)
+}
+
+// Gets a middleware that runs the current middleware
+func (m *ReloadableMiddleware) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			return m.current.Load().(echo.MiddlewareFunc)(next)(c)
+		}
+	}
+}
@@ -25,6 +25,7 @@ package internal
 import (
 	"github.com/labstack/echo"
 	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/webapi"
 )
 
 func RequiresRoleMiddleware(role string, logger models.Logger) echo.MiddlewareFunc {
@@ -32,7 +33,7 @@ func RequiresRoleMiddleware(role string, logger models.Logger) echo.MiddlewareFu
 		return func(c echo.Context) error {
 			user, ok := c.Get("user").(models.TokenUser)
 			if !ok {
-				logger.Debugf("Failed to cast user to models.TokenUser")
+				logger.WithContext(webapi.GetContext(c.Request())).Debugf("Failed to cast user to models.TokenUser")
 				return ErrAuthorizationRequired
 			}
 
@@ -40,7 +41,7 @@ func RequiresRoleMiddleware(role string, logger models.Logger) echo.MiddlewareFu
 				return next(c)
 			}
 
-			logger.Debugf("User didn't have '%s' role", role)
+			logger.WithContext(webapi.GetContext(c.Request())).Debugf("User didn't have '%s' role", role)
 			return ErrAuthorizationRequired
 		}
 	}
@@ -24,11 +24,27 @@ package internal
 
 import (
 	"context"
+	"github.com/zlepper/welp/internal/pkg/backup"
+	"github.com/zlepper/welp/internal/pkg/dedup"
 	"github.com/zlepper/welp/internal/pkg/email"
+	"github.com/zlepper/welp/internal/pkg/encryption"
 	"github.com/zlepper/welp/internal/pkg/flatfile"
+	"github.com/zlepper/welp/internal/pkg/importer"
 	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/s3"
+	"github.com/zlepper/welp/internal/pkg/sanitize"
 	"github.com/zlepper/welp/internal/pkg/services"
+	"github.com/zlepper/welp/internal/pkg/spam"
+	"github.com/zlepper/welp/internal/pkg/thumbnail"
 	"path"
+	"time"
+)
+
+const (
+	// How long a spam challenge is valid after being issued
+	spamChallengeMaxAge = 24 * time.Hour
+	// How many emails and other background jobs can be running before welp is no longer ready
+	maxPendingBackgroundWork = 100
 )
 
 type dataLayerType int
@@ -44,38 +60,56 @@ type loadedServices struct {
 	models.SecretService
 	models.AuthorizationService
 	models.AuthorizationDataStorage
-	models.EmailService
+	*services.ReloadableEmailService
 	models.FeedbackService
+	models.SpamFilter
+	models.SpamChallengeService
+	models.ThumbnailService
+	models.FileSanitizer
+	models.RetentionService
+	models.DataSubjectService
+	models.BackupService
+	models.ImportService
+	models.ConsistencyService
+	models.HealthService
 }
 
-func GetServices(args models.BindWebArgs, logger models.Logger) (*loadedServices, error) {
+// Gets all the services. Some of the services work in the background, like saving changes,
+// until the context is cancelled
+func GetServices(ctx context.Context, args models.BindWebArgs, logger models.Logger) (*loadedServices, error) {
+
+	encryptor, err := getEncryptor(args, logger)
+	if err != nil {
+		return nil, err
+	}
 
-	fileStorage, err := getFileStorage(args, logger)
+	fileStorage, err := getFileStorage(ctx, args, logger, encryptor)
 	if err != nil {
 		return nil, err
 	}
 
-	feedbackDataStorage, err := getDataStorage(args, logger)
+	feedbackDataStorage, err := getDataStorage(ctx, args, logger, encryptor)
 	if err != nil {
 		return nil, err
 	}
 
-	secretService, err := getSecretService(args, logger)
+	secretService, err := getSecretService(args, logger, encryptor)
 	if err != nil {
 		return nil, err
 	}
 
-	emailService, err := getEmailService(args, logger)
+	emailBackend, err := GetEmailService(args, logger)
 	if err != nil {
 		return nil, err
 	}
+	emailService := services.NewReloadableEmailService(emailBackend, GetEmailSender(args))
 
 	tokenService, err := getTokenService(args, logger, secretService)
 	if err != nil {
 		return nil, err
 	}
 
-	authenticationDataStorage, err := getAuthenticationDataStorage(args, logger)
+	authenticationDataStorage, err := getAuthenticationDataStorage(ctx, args, logger, encryptor)
 	if err != nil {
 		return nil, err
 	}
@@ -85,7 +119,40 @@ func GetServices(args models.BindWebArgs, logger models.Logger) (*loadedServices
 		return nil, err
 	}
 
-	feedbackService, err := getFeedbackService(args, logger, emailService, feedbackDataStorage, authenticationDataStorage)
+	spamDataStorage, err := getSpamDataStorage(ctx, args, logger, encryptor)
+	if err != nil {
+		return nil, err
+	}
+
+	spamClassifier := spam.NewBayesClassifier(spam.BayesClassifierArgs{
+		DataStorage: spamDataStorage,
+		Logger:      logger,
+		Threshold:   args.SpamThreshold,
+	})
+
+	formTokenFilter := spam.NewFormTokenFilter(spam.FormTokenFilterArgs{
+		SecretService: secretService,
+		Logger:        logger,
+		MinSubmitTime: args.SpamMinSubmitTime,
+		MaxAge:        spamChallengeMaxAge,
+		Difficulty:    args.SpamProofOfWorkDifficulty,
+	})
+
+	spamFilter := getSpamFilter(args, logger, formTokenFilter, spamClassifier)
+
+	dataSubjectLogStorage, err := getDataSubjectLogStorage(ctx, args, logger, encryptor)
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnailService := thumbnail.NewThumbnailService(thumbnail.ThumbnailServiceArgs{
+		FileStorage: fileStorage,
+		Logger:      logger,
+	})
+
+	fileSanitizer := getFileSanitizer(args, logger)
+
+	feedbackService, err := getFeedbackService(args, logger, emailService, feedbackDataStorage, authenticationDataStorage, spamClassifier, fileStorage, thumbnailService)
 	if err != nil {
 		return nil, err
 	}
@@ -96,12 +163,83 @@ func GetServices(args models.BindWebArgs, logger models.Logger) (*loadedServices
 		SecretService:            secretService,
 		AuthorizationService:     authenticationService,
 		AuthorizationDataStorage: authenticationDataStorage,
-		EmailService:             emailService,
+		ReloadableEmailService:   emailService,
 		FeedbackService:          feedbackService,
+		SpamFilter:               spamFilter,
+		SpamChallengeService:     formTokenFilter,
+		ThumbnailService:         thumbnailService,
+		FileSanitizer:            fileSanitizer,
+		RetentionService: services.NewRetentionService(services.RetentionServiceArgs{
+			DataStorage:     feedbackDataStorage,
+			FeedbackService: feedbackService,
+			Policy:          args.Retention,
+			Logger:          logger,
+		}),
+		DataSubjectService: services.NewDataSubjectService(services.DataSubjectServiceArgs{
+			DataStorage:     feedbackDataStorage,
+			FeedbackService: feedbackService,
+			FileStorage:     fileStorage,
+			LogStorage:      dataSubjectLogStorage,
+			Logger:          logger,
+		}),
+		BackupService: backup.NewBackupService(backup.BackupServiceArgs{
+			DatabaseFolder: args.DatabaseFolderName,
+			StorageFolder:  GetLocalStorageFolder(args),
+			Logger:         logger,
+		}),
+		ConsistencyService: services.NewConsistencyService(services.ConsistencyServiceArgs{
+			DataStorage: feedbackDataStorage,
+			FileStorage: fileStorage,
+			Policy:      args.Consistency,
+			Logger:      logger,
+		}),
+		ImportService: importer.NewImportService(importer.ImportServiceArgs{
+			DataStorage:      feedbackDataStorage,
+			FileStorage:      fileStorage,
+			FileSanitizer:    fileSanitizer,
+			ThumbnailService: thumbnailService,
+			Logger:           logger,
+		}),
+		HealthService: services.NewHealthService(services.HealthServiceArgs{
+			DatabaseFolder: args.DatabaseFolderName,
+			StorageFolder:  GetLocalStorageFolder(args),
+			SaveError:      flatfile.GetSaveError,
+			EmailConfigured: func() bool {
+				return email.IsSending(emailService.GetEmailService())
+			},
+			MaxPendingWork: maxPendingBackgroundWork,
+			Logger:         logger,
+		}),
 	}, nil
 
 }
 
+// Starts the work the services do on a schedule, which runs until the context is cancelled
+func (s *loadedServices) StartSchedules(ctx context.Context) {
+	services.RunScheduleInBackground(func() {
+		s.RetentionService.RunSchedule(ctx)
+	})
+	services.RunScheduleInBackground(func() {
+		s.ConsistencyService.RunSchedule(ctx)
+	})
+}
+
+// Gets the folder the uploaded files are stored in on the local disk,
+// or an empty string if they are stored somewhere else
+func GetLocalStorageFolder(args models.BindWebArgs) string {
+	if args.S3.Bucket != "" {
+		return ""
+	}
+	return args.FolderPath
+}
+
+// Waits for the background work of the services to finish, and for them to save their changes,
+// after the context given to GetServices has been cancelled
+func WaitForServices() {
+	services.WaitForBackgroundWork()
+	flatfile.WaitForSaveCycles()
+}
+
 func detectDataLayerType(args models.BindWebArgs) dataLayerType {
 	if args.DatabaseFolderName != "" {
 		return flatFileDataLayer
@@ -109,29 +247,92 @@ func detectDataLayerType(args models.BindWebArgs) dataLayerType {
 	return unknown
 }
 
-func getFileStorage(args models.BindWebArgs, logger models.Logger) (models.FileStorage, error) {
+func getEncryptor(args models.BindWebArgs, logger models.Logger) (models.Encryptor, error) {
+	masterKey, err := encryption.LoadMasterKey(args.MasterKeyFile, encryption.MasterKeyEnvironmentVariable)
+	if err != nil {
+		logger.Errorf("Failed to load the master key: %v", err)
+		return nil, err
+	}
+
+	if masterKey == nil {
+		return encryption.NewPlaintextEncryptor(), nil
+	}
+
+	return encryption.NewEnvelopeEncryptor(masterKey)
+}
+
+func getFileStorage(ctx context.Context, args models.BindWebArgs, logger models.Logger, encryptor models.Encryptor) (models.FileStorage, error) {
+	storage, err := getBlobStorage(ctx, args, logger, encryptor)
+	if err != nil {
+		return nil, err
+	}
+
+	if !args.DeduplicateFiles {
+		return storage, nil
+	}
+
+	references, err := flatfile.NewBlobReferenceStorage(ctx, flatfile.BlobReferenceStorageArgs{
+		Logger:       logger,
+		Filename:     path.Join(args.DatabaseFolderName, "blobs.json"),
+		SaveInterval: args.SaveInterval,
+		Encryptor:    encryptor,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dedup.NewFileStorage(dedup.FileStorageArgs{
+		Storage:    storage,
+		References: references,
+		Logger:     logger,
+	}), nil
+}
+
+// Gets the storage the content of the files is actually stored in
+func getBlobStorage(ctx context.Context, args models.BindWebArgs, logger models.Logger, encryptor models.Encryptor) (models.FileStorage, error) {
+	if args.S3.Bucket != "" {
+		return s3.NewFileStorage(ctx, s3.FileStorageArgs{
+			S3Options: args.S3,
+			Logger:    logger,
+		})
+	}
+
 	return flatfile.NewFileStorage(flatfile.FileStorageArgs{
 		Logger:     logger,
 		FolderPath: args.FolderPath,
+		Encryptor:  encryptor,
 	})
 }
 
-func getDataStorage(args models.BindWebArgs, logger models.Logger) (models.FeedbackDataStorage, error) {
-	return flatfile.NewFeedbackDataStorage(context.Background(), flatfile.DataStorageArgs{
+func getFileSanitizer(args models.BindWebArgs, logger models.Logger) models.FileSanitizer {
+	if args.StripImageMetadata {
+		return sanitize.NewMetadataStripper(sanitize.MetadataStripperArgs{
+			Logger: logger,
+		})
+	}
+
+	return sanitize.NewNoOpSanitizer()
+}
+
+func getDataStorage(ctx context.Context, args models.BindWebArgs, logger models.Logger, encryptor models.Encryptor) (models.FeedbackDataStorage, error) {
+	return flatfile.NewFeedbackDataStorage(ctx, flatfile.DataStorageArgs{
 		Logger:       logger,
 		SaveInterval: args.SaveInterval,
 		Filename:     path.Join(args.DatabaseFolderName, "feedback.json"),
+		Encryptor:    encryptor,
 	})
 }
 
-func getSecretService(args models.BindWebArgs, logger models.Logger) (models.SecretService, error) {
+func getSecretService(args models.BindWebArgs, logger models.Logger, encryptor models.Encryptor) (models.SecretService, error) {
 	return flatfile.NewSecretStorage(flatfile.SecretStorageArgs{
-		Logger:   logger,
-		Filename: path.Join(args.DatabaseFolderName, "secrets.json"),
+		Logger:    logger,
+		Filename:  path.Join(args.DatabaseFolderName, "secrets.json"),
+		Encryptor: encryptor,
 	})
 }
 
-func getEmailService(args models.BindWebArgs, logger models.Logger) (models.EmailService, error) {
+// Gets the service emails are sent with. Also used when the SendGrid api key is reloaded
+func GetEmailService(args models.BindWebArgs, logger models.Logger) (models.EmailService, error) {
 	if args.SendGridApiKey != "" {
 		return email.NewSendGridEmailService(email.SendGridEmailServiceArgs{
 			ApiKey: args.SendGridApiKey,
@@ -139,7 +340,9 @@ func getEmailService(args models.BindWebArgs, logger models.Logger) (models.Emai
 		})
 	}
 
-	return email.NewNoOpEmailService(), nil
+	return email.NewNoOpEmailService(email.NoOpEmailServiceArgs{
+		Logger: logger,
+	}), nil
 }
 
 func getTokenService(args models.BindWebArgs, logger models.Logger, secretService models.SecretService) (models.TokenService, error) {
@@ -149,36 +352,81 @@ func getTokenService(args models.BindWebArgs, logger models.Logger, secretServic
 	})
 }
 
-func getAuthenticationDataStorage(args models.BindWebArgs, logger models.Logger) (models.AuthorizationDataStorage, error) {
-	return flatfile.NewAuthorizationDataStorage(context.Background(), flatfile.AuthorizationDataStorageArgs{
+func getAuthenticationDataStorage(ctx context.Context, args models.BindWebArgs, logger models.Logger, encryptor models.Encryptor) (models.AuthorizationDataStorage, error) {
+	return flatfile.NewAuthorizationDataStorage(ctx, flatfile.AuthorizationDataStorageArgs{
 		Logger:       logger,
 		Filename:     path.Join(args.DatabaseFolderName, "authentication.json"),
 		SaveInterval: args.SaveInterval,
+		Encryptor:    encryptor,
 	})
 }
 
-func getAuthenticationService(args models.BindWebArgs, logger models.Logger, emailService models.EmailService, tokenService models.TokenService, dataStorage models.AuthorizationDataStorage) (models.AuthorizationService, error) {
+// Gets who emails are sent from. Also used when the sender is reloaded
+func GetEmailSender(args models.BindWebArgs) services.EmailSender {
+	return services.EmailSender{
+		FromEmail:    args.EmailSenderAddress,
+		FromName:     args.EmailSenderName,
+		ReplyToEmail: args.EmailSenderAddress,
+		ReplyToName:  args.EmailSenderName,
+	}
+}
+
+func getAuthenticationService(args models.BindWebArgs, logger models.Logger, emailService *services.ReloadableEmailService, tokenService models.TokenService, dataStorage models.AuthorizationDataStorage) (models.AuthorizationService, error) {
 	return services.NewAuthorizationService(services.AuthorizationServiceArgs{
 		Logger:        logger,
 		EmailService:  emailService,
 		TokenDuration: args.TokenDuration,
 		TokenService:  tokenService,
-		EmailSender: services.EmailSender{
-			FromEmail:    args.EmailSenderAddress,
-			FromName:     args.EmailSenderName,
-			ReplyToEmail: args.EmailSenderAddress,
-			ReplyToName:  args.EmailSenderName,
-		},
-		DataStorage: dataStorage,
+		EmailSender:   emailService,
+		DataStorage:   dataStorage,
 	})
 }
 
-func getFeedbackService(args models.BindWebArgs, logger models.Logger, emailService models.EmailService, feedbackDataStorage models.FeedbackDataStorage, userDataStorage models.AuthorizationDataStorage) (models.FeedbackService, error) {
+func getFeedbackService(args models.BindWebArgs, logger models.Logger, emailService *services.ReloadableEmailService, feedbackDataStorage models.FeedbackDataStorage, userDataStorage models.AuthorizationDataStorage, spamClassifier models.SpamClassifier, fileStorage models.FileStorage, thumbnailService models.ThumbnailService) (models.FeedbackService, error) {
 	return services.NewFeedbackService(services.FeedbackServiceArgs{
-		Logger:          logger,
-		EmailService:    emailService,
-		DataStorage:     feedbackDataStorage,
-		UserDataStorage: userDataStorage,
-		Args:            args,
+		Logger:           logger,
+		EmailService:     emailService,
+		DataStorage:      feedbackDataStorage,
+		UserDataStorage:  userDataStorage,
+		SpamClassifier:   spamClassifier,
+		FileStorage:      fileStorage,
+		ThumbnailService: thumbnailService,
+		EmailSender:      emailService,
 	}), nil
 }
+
+func getSpamDataStorage(ctx context.Context, args models.BindWebArgs, logger models.Logger, encryptor models.Encryptor) (models.SpamDataStorage, error) {
+	return flatfile.NewSpamDataStorage(ctx, flatfile.SpamDataStorageArgs{
+		Logger:       logger,
+		Filename:     path.Join(args.DatabaseFolderName, "spam.json"),
+		SaveInterval: args.SaveInterval,
+		Encryptor:    encryptor,
+	})
+}
+
+func getDataSubjectLogStorage(ctx context.Context, args models.BindWebArgs, logger models.Logger, encryptor models.Encryptor) (models.DataSubjectLogStorage, error) {
+	return flatfile.NewDataSubjectLogStorage(ctx, flatfile.DataSubjectLogStorageArgs{
+		Logger:       logger,
+		Filename:     path.Join(args.DatabaseFolderName, "dataSubjectLog.json"),
+		SaveInterval: args.SaveInterval,
+		Encryptor:    encryptor,
+	})
+}
+
+// Builds the spam defence pipeline. Cheap checks are run first,
+// so obvious bots are rejected before the classifier is consulted
+func getSpamFilter(args models.BindWebArgs, logger models.Logger, formTokenFilter models.SpamFilter, classifier models.SpamFilter) models.SpamFilter {
+	filters := make([]models.SpamFilter, 0)
+
+	if args.SpamHoneypot {
+		filters = append(filters, spam.NewHoneypotFilter())
+	}
+
+	if args.SpamMinSubmitTime > 0 || args.SpamProofOfWorkDifficulty > 0 {
+		filters = append(filters, formTokenFilter)
+	}
+
+	filters = append(filters, classifier)
+
+	return spam.NewPipeline(logger, filters...)
+}
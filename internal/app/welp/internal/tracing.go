@@ -0,0 +1,68 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package internal
+
+import (
+	"fmt"
+	"github.com/zlepper/welp/internal/pkg/consts"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/tracing"
+	"os"
+)
+
+// Starts recording traces, if an exporter is configured. Returns nil if tracing is disabled
+func StartTracing(args models.BindWebArgs, logger models.Logger) (*tracing.Tracer, error) {
+	if args.Tracing.Exporter == "" {
+		return nil, nil
+	}
+
+	exporter, err := getTraceExporter(args.Tracing.Exporter)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("Recording %g of the traces with the %s exporter", args.Tracing.SampleRatio, args.Tracing.Exporter)
+
+	return tracing.Start(tracing.TracerArgs{
+		Exporter:    exporter,
+		SampleRatio: args.Tracing.SampleRatio,
+		Logger:      logger,
+	}), nil
+}
+
+func getTraceExporter(driver string) (tracing.Exporter, error) {
+	kind, location := splitDriver(driver)
+	switch {
+	case kind == "stdout" && location == "":
+		return tracing.NewWriterExporter(os.Stdout), nil
+	case kind == "file" && location != "":
+		return tracing.NewFileExporter(location)
+	case kind == "otlp" && location != "":
+		return tracing.NewOtlpExporter(tracing.OtlpExporterArgs{
+			Endpoint:    location,
+			ServiceName: consts.Issuer,
+		})
+	default:
+		return nil, fmt.Errorf("unknown trace exporter '%s', it should be stdout, file:<path> or otlp:<url>", driver)
+	}
+}
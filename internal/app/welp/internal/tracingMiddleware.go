@@ -0,0 +1,66 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package internal
+
+import (
+	"github.com/labstack/echo"
+	"github.com/zlepper/welp/internal/pkg/tracing"
+	"net/http"
+)
+
+// Starts a span for every request, which continues the trace of the caller if it sent a traceparent header
+func TracingMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			request := c.Request()
+			ctx := request.Context()
+			if parent, ok := tracing.Extract(request.Header); ok {
+				ctx = tracing.WithRemoteParent(ctx, parent)
+			}
+
+			route := c.Path()
+			if route == "" {
+				route = unmatchedRoute
+			}
+
+			ctx, span := tracing.StartServerSpan(ctx, request.Method+" "+route)
+			defer span.End()
+			c.SetRequest(request.WithContext(ctx))
+
+			span.SetAttribute("http.method", request.Method)
+			span.SetAttribute("http.route", route)
+			// Only the path is recorded, as the query can have tokens
+			span.SetAttribute("http.target", request.URL.Path)
+
+			err := next(c)
+
+			status := getResponseStatus(c, err)
+			span.SetAttribute("http.status_code", status)
+			if status >= http.StatusInternalServerError {
+				span.SetError(err)
+			}
+
+			return err
+		}
+	}
+}
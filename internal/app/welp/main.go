@@ -23,12 +23,19 @@
 package welp
 
 import (
+	"context"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 	"github.com/labstack/gommon/log"
 	"github.com/zlepper/welp/internal/app/welp/internal"
+	"github.com/zlepper/welp/internal/pkg/logging"
 	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/ratelimit"
 	"github.com/zlepper/welp/internal/pkg/templates"
+	"github.com/zlepper/welp/internal/pkg/webapi"
+	stdLog "log"
+	"net"
+	"strings"
 )
 
 func BindWeb(args models.BindWebArgs) {
@@ -36,18 +43,51 @@ func BindWeb(args models.BindWebArgs) {
 
 	e.HideBanner = true
 
-	var logger models.Logger = e.Logger
+	logger := internal.NewLogger(args)
+	useLogger(e, logger)
 
-	loadedServices, err := internal.GetServices(args, logger)
+	// The standard library has its own logger too, which is used by some of the libraries
+	stdLog.SetFlags(0)
+	stdLog.SetOutput(logging.NewLevelWriter(logger))
+
+	// Cancelled when welp shuts down, which stops the background work and saves the last changes
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tracer, err := internal.StartTracing(args, logger)
 	if err != nil {
-		e.Logger.Fatal(err)
+		logger.Fatal(err)
 		return
 	}
 
-	e.Logger.SetLevel(log.DEBUG)
+	loadedServices, err := internal.GetServices(ctx, args, logger)
+	if err != nil {
+		logger.Fatal(err)
+		return
+	}
 
-	setupMiddleware(args, e)
-	jwtMiddleware := internal.GetJWTMiddlware(loadedServices.SecretService, logger)
+	trustedProxies, err := webapi.ParseTrustedProxies(args.TrustedProxies)
+	if err != nil {
+		logger.Fatal(err)
+		return
+	}
+
+	reloader := newConfigReloader(args, trustedProxies, loadedServices.ReloadableEmailService, logger)
+
+	setupMiddleware(args, e, reloader.cors.Middleware(), trustedProxies, logger)
+	// Files aren't rate limited, as a single feedback list can have many attachments and thumbnails
+	filesJwtMiddleware := internal.GetJWTMiddlware(loadedServices.SecretService, logger)
+	// The login is kept in a cookie, so changes from other sites are rejected
+	jwtMiddleware := internal.ChainMiddleware(
+		internal.SameOriginMiddleware(trustedProxies, logger),
+		filesJwtMiddleware,
+		reloader.apiRateLimit.Middleware(),
+	)
+
+	submissionRateLimitMiddleware := internal.ChainMiddleware(
+		reloader.submissionRateLimit.Middleware(),
+		reloader.totalSubmissionRateLimit.Middleware(),
+	)
 
 	t := &templateRenderer{
 		templates: templates.Must(templates.GetTemplates()),
@@ -57,27 +97,77 @@ func BindWeb(args models.BindWebArgs) {
 
 	rootGroup := e.Group("")
 
+	bindHealthApi(rootGroup, bindHealthApiArgs{
+		Logger:        logger,
+		HealthService: loadedServices.HealthService,
+	})
+
+	// The metrics are served on the normal port, unless they have their own address
+	var metricsServer *echo.Echo
+	if args.Metrics.Address != "" {
+		metricsServer = newMetricsServer(args, trustedProxies, logger)
+	} else {
+		bindMetricsApi(rootGroup, bindMetricsApiArgs{
+			Logger:         logger,
+			Token:          args.Metrics.Token,
+			TrustedProxies: trustedProxies,
+		})
+	}
+
 	bindFeedbackApi(rootGroup, bindFeedbackApiArgs{
-		FileStorage:     loadedServices.FileStorage,
-		Logger:          logger,
-		FeedbackService: loadedServices.FeedbackService,
-		JwtMiddleware:   jwtMiddleware,
+		FileStorage:                   loadedServices.FileStorage,
+		ThumbnailService:              loadedServices.ThumbnailService,
+		FileSanitizer:                 loadedServices.FileSanitizer,
+		UploadLimits:                  args.UploadLimits,
+		Logger:                        logger,
+		FeedbackService:               loadedServices.FeedbackService,
+		SpamFilter:                    loadedServices.SpamFilter,
+		SpamChallengeService:          loadedServices.SpamChallengeService,
+		JwtMiddleware:                 jwtMiddleware,
+		AdminMiddleware:               internal.RequiresRoleMiddleware(models.AdminRole.Key, logger),
+		AllowedOriginMiddleware:       reloader.allowedOrigin.Middleware(),
+		SubmissionRateLimitMiddleware: submissionRateLimitMiddleware,
+		FrameAncestorsMiddleware:      reloader.frameAncestors.Middleware(),
 	})
 
 	bindAuthorizationApi(rootGroup, AuthorizationApiArgs{
-		Logger:        logger,
-		AuthService:   loadedServices.AuthorizationService,
-		LoginDuration: args.TokenDuration,
-		JwtMiddleware: jwtMiddleware,
+		Logger:              logger,
+		AuthService:         loadedServices.AuthorizationService,
+		LoginDuration:       args.TokenDuration,
+		JwtMiddleware:       jwtMiddleware,
+		RateLimitMiddleware: reloader.loginRateLimit.Middleware(),
 	})
 
 	bindFilesApi(rootGroup, filesApiArgs{
-		JwtMiddleware:       jwtMiddleware,
+		JwtMiddleware:       filesJwtMiddleware,
 		FileStorage:         loadedServices.FileStorage,
+		ThumbnailService:    loadedServices.ThumbnailService,
 		FeedbackDataStorage: loadedServices.FeedbackDataStorage,
 		Logger:              logger,
 	})
 
+	bindDataSubjectApi(rootGroup, bindDataSubjectApiArgs{
+		Logger:             logger,
+		DataSubjectService: loadedServices.DataSubjectService,
+		JwtMiddleware:      jwtMiddleware,
+		AdminMiddleware:    internal.RequiresRoleMiddleware(models.AdminRole.Key, logger),
+	})
+
+	bindBackupApi(rootGroup, bindBackupApiArgs{
+		Logger:          logger,
+		BackupService:   loadedServices.BackupService,
+		JwtMiddleware:   jwtMiddleware,
+		AdminMiddleware: internal.RequiresRoleMiddleware(models.AdminRole.Key, logger),
+	})
+
+	bindImportApi(rootGroup, bindImportApiArgs{
+		Logger:          logger,
+		ImportService:   loadedServices.ImportService,
+		UploadLimits:    args.UploadLimits,
+		JwtMiddleware:   jwtMiddleware,
+		AdminMiddleware: internal.RequiresRoleMiddleware(models.AdminRole.Key, logger),
+	})
+
 	bindUserManagementApi(rootGroup, bindUserManagementApiArgs{
 		Logger:        logger,
 		JwtMiddleware: jwtMiddleware,
@@ -85,19 +175,74 @@ func BindWeb(args models.BindWebArgs) {
 		AuthService:   loadedServices.AuthorizationService,
 	})
 
-	host(args, e)
+	bindReloadApi(rootGroup, bindReloadApiArgs{
+		Logger:          logger,
+		ConfigReloader:  reloader,
+		JwtMiddleware:   jwtMiddleware,
+		AdminMiddleware: internal.RequiresRoleMiddleware(models.AdminRole.Key, logger),
+	})
+
+	loadedServices.StartSchedules(ctx)
+	reloadOnSignal(ctx, reloader, logger)
+
+	err = host(args, e, metricsServer, logger)
+
+	cancel()
+	internal.WaitForServices()
+
+	if tracer != nil {
+		// The spans of the last requests are exported, but a collector that is gone can't hold up the shutdown
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), args.ShutdownTimeout)
+		tracerErr := tracer.Shutdown(shutdownCtx)
+		cancelShutdown()
+		if tracerErr != nil {
+			logger.Warnf("Failed to export the last spans: %v", tracerErr)
+		}
+	}
+
+	if err != nil {
+		logger.Fatal(err)
+		return
+	}
+
+	logger.Info("Welp has shut down")
 }
 
-func setupMiddleware(args models.BindWebArgs, e *echo.Echo) {
+// Sends what echo logs through welp's logger, so everything has the same format and is redacted.
+// Where the server listens is logged by host instead
+func useLogger(e *echo.Echo, logger models.Logger) {
+	e.HidePort = true
+	e.Logger.SetHeader("${level}")
+	e.Logger.SetLevel(log.DEBUG)
+	e.Logger.SetOutput(logging.NewLevelWriter(logger))
+}
+
+func rateLimit(policy models.RateLimitPolicy, keyFunc internal.RateLimitKeyFunc, logger models.Logger) echo.MiddlewareFunc {
+	return internal.RateLimitMiddleware(ratelimit.NewTokenBucketLimiter(policy), keyFunc, logger)
+}
+
+func setupMiddleware(args models.BindWebArgs, e *echo.Echo, corsMiddleware echo.MiddlewareFunc, trustedProxies []*net.IPNet, logger models.Logger) {
 	e.Use(
+		internal.RequestIdMiddleware(),
+		internal.TracingMiddleware(),
+		internal.AccessLogMiddleware(trustedProxies, logger),
+		internal.MetricsMiddleware(),
 		middleware.Recover(),
-		middleware.Logger(),
 		middleware.RemoveTrailingSlash(),
-		middleware.CORS(),
-		middleware.Gzip(),
+		corsMiddleware,
+		middleware.GzipWithConfig(middleware.GzipConfig{
+			Skipper: skipGzip,
+		}),
 	)
 
 	if args.UseHttps {
 		e.Pre(middleware.HTTPSRedirect())
 	}
 }
+
+// Files are served with range support, which compressing the response breaks.
+// They are mostly compressed media anyway. Backups are already gzipped
+func skipGzip(c echo.Context) bool {
+	urlPath := c.Request().URL.Path
+	return strings.HasPrefix(urlPath, "/files/") || urlPath == "/admin/backup"
+}
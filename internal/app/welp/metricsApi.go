@@ -0,0 +1,87 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package welp
+
+import (
+	"crypto/subtle"
+	"github.com/labstack/echo"
+	"github.com/zlepper/welp/internal/pkg/consts"
+	"github.com/zlepper/welp/internal/pkg/metrics"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/webapi"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type bindMetricsApiArgs struct {
+	Logger models.Logger
+	// If set, scrapers have to send it as a bearer token
+	Token string
+	// Proxies that are trusted to set the client ip
+	TrustedProxies []*net.IPNet
+}
+
+func bindMetricsApi(e *echo.Group, args bindMetricsApiArgs) {
+	server := &metricsServer{
+		bindMetricsApiArgs: args,
+	}
+
+	e.GET("/metrics", echo.WrapHandler(metrics.Handler()), server.tokenMiddleware)
+}
+
+type metricsServer struct {
+	bindMetricsApiArgs
+}
+
+func (s *metricsServer) tokenMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if s.Token == "" {
+			return next(c)
+		}
+
+		header := c.Request().Header.Get(echo.HeaderAuthorization)
+		token := strings.TrimPrefix(header, consts.Bearer)
+		if token == header || subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+			s.Logger.WithContext(webapi.GetContext(c.Request())).Warnf("Rejected metrics request from '%s' without the right token", webapi.GetClientIP(c.Request(), s.TrustedProxies))
+			return echo.NewHTTPError(http.StatusUnauthorized)
+		}
+
+		return next(c)
+	}
+}
+
+// Creates the server the metrics are served on when they have their own address
+func newMetricsServer(args models.BindWebArgs, trustedProxies []*net.IPNet, logger models.Logger) *echo.Echo {
+	e := echo.New()
+	e.HideBanner = true
+	useLogger(e, logger)
+
+	bindMetricsApi(e.Group(""), bindMetricsApiArgs{
+		Logger:         logger,
+		Token:          args.Metrics.Token,
+		TrustedProxies: trustedProxies,
+	})
+
+	return e
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package welp
+
+import (
+	"context"
+	"fmt"
+	"github.com/zlepper/welp/internal/app/welp/internal"
+	"github.com/zlepper/welp/internal/pkg/migrate"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"os"
+	"text/tabwriter"
+)
+
+type MigrateArgs struct {
+	// The data drivers to migrate from and to. From defaults to the configured database
+	From, To string
+	// The file drivers to migrate from and to. FromFiles defaults to the configured file storage.
+	// If ToFiles is empty, the files stay where they are
+	FromFiles, ToFiles string
+	// Where the progress is kept, so the migration can be resumed
+	StateFile string
+}
+
+// Migrates all the data from one set of storages to another, and prints a report
+func Migrate(args models.BindWebArgs, migrateArgs MigrateArgs) {
+	logger := internal.NewLogger(args)
+
+	dataDriver, fileDriver := internal.GetConfiguredDrivers(args)
+	if migrateArgs.From == "" {
+		migrateArgs.From = dataDriver
+	}
+	if migrateArgs.FromFiles == "" {
+		migrateArgs.FromFiles = fileDriver
+	}
+
+	if migrateArgs.To == "" && migrateArgs.ToFiles == "" {
+		logger.Fatal("Nothing to migrate to. Set --to, --toFiles or both")
+		return
+	}
+	if migrateArgs.To == migrateArgs.From || migrateArgs.ToFiles == migrateArgs.FromFiles {
+		logger.Fatal("The data can't be migrated to where it already is")
+		return
+	}
+	if args.DeduplicateFiles && (migrateArgs.To == "" || migrateArgs.ToFiles == "") {
+		logger.Fatal(internal.ErrDeduplicationNeedsDatabase)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer internal.WaitForServices()
+	defer cancel()
+
+	// The files are only read from the source, if they are also migrated
+	fromFiles := migrateArgs.FromFiles
+	if migrateArgs.ToFiles == "" {
+		fromFiles = ""
+	}
+
+	from, err := internal.GetMigrationStorages(ctx, args, migrateArgs.From, fromFiles, logger)
+	if err != nil {
+		logger.Fatal(err)
+		return
+	}
+
+	to, err := internal.GetMigrationStorages(ctx, args, migrateArgs.To, migrateArgs.ToFiles, logger)
+	if err != nil {
+		logger.Fatal(err)
+		return
+	}
+
+	migrator := migrate.NewMigrator(migrate.MigratorArgs{
+		StateFile: migrateArgs.StateFile,
+		Logger:    logger,
+	})
+
+	report, err := migrator.Migrate(ctx, from, to)
+	printMigrationReport(report)
+
+	if err != nil {
+		logger.Errorf("The migration didn't finish: %v. Run the same command again to resume it", err)
+		return
+	}
+
+	// Everything is verified, so there is nothing to resume anymore
+	err = os.Remove(migrateArgs.StateFile)
+	if err != nil && !os.IsNotExist(err) {
+		logger.Warnf("Failed to remove the state file of the migration: %v", err)
+	}
+
+	fmt.Println("\nThe migration is complete. Start welp with the new storages to use them")
+}
+
+func printMigrationReport(report models.MigrationReport) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "KIND\tSOURCE\tCOPIED\tALREADY COPIED\tFAILED")
+	fmt.Fprintf(writer, "Secrets\t%d\t%d\t%d\t%d\n", report.Secrets.Source, report.Secrets.Copied, report.Secrets.Skipped, report.Secrets.Failed)
+	fmt.Fprintf(writer, "Users\t%d\t%d\t%d\t%d\n", report.Users.Source, report.Users.Copied, report.Users.Skipped, report.Users.Failed)
+	fmt.Fprintf(writer, "Spam tokens\t%d\t%d\t%d\t%d\n", report.SpamTokens.Source, report.SpamTokens.Copied, report.SpamTokens.Skipped, report.SpamTokens.Failed)
+	fmt.Fprintf(writer, "Data subject log\t%d\t%d\t%d\t%d\n", report.DataSubjectLog.Source, report.DataSubjectLog.Copied, report.DataSubjectLog.Skipped, report.DataSubjectLog.Failed)
+	fmt.Fprintf(writer, "Feedback\t%d\t%d\t%d\t%d\n", report.Feedback.Source, report.Feedback.Copied, report.Feedback.Skipped, report.Feedback.Failed)
+	fmt.Fprintf(writer, "Files\t%d\t%d\t%d\t%d\n", report.Files.Source, report.Files.Copied, report.Files.Skipped, report.Files.Failed)
+	fmt.Fprintf(writer, "Thumbnails\t%d\t%d\t%d\t%d\n", report.Thumbnails.Source, report.Thumbnails.Copied, report.Thumbnails.Skipped, report.Thumbnails.Failed)
+	writer.Flush()
+
+	if len(report.Failures) == 0 {
+		return
+	}
+
+	fmt.Println()
+	writer = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "KIND\tID\tREASON")
+	for _, failure := range report.Failures {
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", failure.Kind, failure.Id, failure.Reason)
+	}
+	writer.Flush()
+}
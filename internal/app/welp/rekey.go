@@ -0,0 +1,101 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package welp
+
+import (
+	"github.com/zlepper/welp/internal/app/welp/internal"
+	"github.com/zlepper/welp/internal/pkg/encryption"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The environment variable the new master key is read from, if no key file is given
+const newMasterKeyEnvironmentVariable = "WELP_NEW_MASTER_KEY"
+
+// Makes all the database files and stored files readable with the new master key.
+// Files that are not encrypted yet are encrypted with the new key.
+// Welp should not be running meanwhile, as it could overwrite the files while they are being changed.
+func Rekey(args models.BindWebArgs, newMasterKeyFile string) {
+	logger := internal.NewLogger(args)
+
+	oldMasterKey, err := encryption.LoadMasterKey(args.MasterKeyFile, encryption.MasterKeyEnvironmentVariable)
+	if err != nil {
+		logger.Fatalf("Failed to load the current master key: %v", err)
+		return
+	}
+
+	newMasterKey, err := encryption.LoadMasterKey(newMasterKeyFile, newMasterKeyEnvironmentVariable)
+	if err != nil {
+		logger.Fatalf("Failed to load the new master key: %v", err)
+		return
+	}
+	if newMasterKey == nil {
+		logger.Fatalf("A new master key is required, either from --newMasterKeyFile or %s", newMasterKeyEnvironmentVariable)
+		return
+	}
+
+	folders := []string{args.DatabaseFolderName}
+	if args.S3.Bucket == "" {
+		folders = append(folders, args.FolderPath)
+	} else {
+		logger.Info("Files stored in S3 are not encrypted by welp, so only the database files are changed")
+	}
+
+	changed, failed := 0, 0
+	for _, folder := range folders {
+		err = filepath.Walk(folder, func(filename string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			// Temp files are left behind from saves that didn't finish
+			if info.IsDir() || strings.HasSuffix(filename, ".temp") {
+				return nil
+			}
+
+			fileChanged, err := encryption.RekeyFile(filename, oldMasterKey, newMasterKey)
+			if err != nil {
+				logger.Errorf("Failed to rekey '%s': %v", filename, err)
+				failed++
+				return nil
+			}
+
+			if fileChanged {
+				changed++
+			}
+
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			logger.Fatalf("Failed to rekey the files in '%s': %v", folder, err)
+			return
+		}
+	}
+
+	logger.Infof("Rekeyed %d files, %d failed", changed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,215 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package welp
+
+import (
+	"context"
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+	"github.com/zlepper/welp/internal/app/welp/internal"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/services"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// The settings that can be changed while welp runs. Everything else requires a restart
+var reloadableSettings = map[string]bool{
+	"allowedOrigins":           true,
+	"apiRateLimit":             true,
+	"emailSenderAddress":       true,
+	"emailSenderName":          true,
+	"loginRateLimit":           true,
+	"sendGridApiKey":           true,
+	"submissionRateLimit":      true,
+	"totalSubmissionRateLimit": true,
+}
+
+// Swaps the settings that can be changed while welp runs into the running services
+type configReloader struct {
+	reloadConfig   func() (models.BindWebArgs, []string, error)
+	logger         models.Logger
+	trustedProxies []*net.IPNet
+	emailService   *services.ReloadableEmailService
+
+	cors           *internal.ReloadableMiddleware
+	allowedOrigin  *internal.ReloadableMiddleware
+	frameAncestors *internal.ReloadableMiddleware
+
+	submissionRateLimit      *internal.ReloadableMiddleware
+	totalSubmissionRateLimit *internal.ReloadableMiddleware
+	loginRateLimit           *internal.ReloadableMiddleware
+	apiRateLimit             *internal.ReloadableMiddleware
+
+	// Only one reload runs at a time, so two reloads can't mix their settings
+	lock sync.Mutex
+	// The settings the services are running with
+	current models.BindWebArgs
+}
+
+func newConfigReloader(args models.BindWebArgs, trustedProxies []*net.IPNet, emailService *services.ReloadableEmailService, logger models.Logger) *configReloader {
+	return &configReloader{
+		reloadConfig:   args.ReloadConfig,
+		logger:         logger,
+		trustedProxies: trustedProxies,
+		emailService:   emailService,
+
+		cors:           internal.NewReloadableMiddleware(corsMiddleware(args.AllowedOrigins)),
+		allowedOrigin:  internal.NewReloadableMiddleware(internal.AllowedOriginMiddleware(args.AllowedOrigins, trustedProxies, logger)),
+		frameAncestors: internal.NewReloadableMiddleware(internal.FrameAncestorsMiddleware(args.AllowedOrigins)),
+
+		submissionRateLimit:      internal.NewReloadableMiddleware(rateLimit(args.SubmissionRateLimit, internal.ClientIPKey(trustedProxies), logger)),
+		totalSubmissionRateLimit: internal.NewReloadableMiddleware(rateLimit(args.TotalSubmissionRateLimit, internal.GlobalKey, logger)),
+		loginRateLimit:           internal.NewReloadableMiddleware(rateLimit(args.LoginRateLimit, internal.ClientIPKey(trustedProxies), logger)),
+		apiRateLimit:             internal.NewReloadableMiddleware(rateLimit(args.ApiRateLimit, internal.UserKey(trustedProxies), logger)),
+
+		current: args,
+	}
+}
+
+func corsMiddleware(allowedOrigins []string) echo.MiddlewareFunc {
+	return middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOrigins: allowedOrigins,
+	})
+}
+
+func (r *configReloader) Reload(ctx context.Context) (models.ReloadReport, error) {
+	if r.reloadConfig == nil {
+		return models.ReloadReport{}, models.ErrReloadNotSupported
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	args, changed, err := r.reloadConfig()
+	if err != nil {
+		return models.ReloadReport{}, err
+	}
+
+	// Before anything else, so the new secrets are never logged
+	internal.AddConfigSecrets(r.logger, args)
+
+	report := models.ReloadReport{
+		Applied:         make([]string, 0),
+		RequiresRestart: make([]string, 0),
+	}
+	for _, setting := range changed {
+		if !reloadableSettings[setting] {
+			report.RequiresRestart = append(report.RequiresRestart, setting)
+		}
+	}
+
+	// Made before anything is swapped, so nothing is changed if it fails
+	emailChanged := args.SendGridApiKey != r.current.SendGridApiKey ||
+		args.EmailSenderName != r.current.EmailSenderName ||
+		args.EmailSenderAddress != r.current.EmailSenderAddress
+	var emailService models.EmailService
+	if emailChanged {
+		emailService, err = internal.GetEmailService(args, r.logger)
+		if err != nil {
+			return models.ReloadReport{}, err
+		}
+	}
+
+	if strings.Join(args.AllowedOrigins, ",") != strings.Join(r.current.AllowedOrigins, ",") {
+		r.cors.Set(corsMiddleware(args.AllowedOrigins))
+		r.allowedOrigin.Set(internal.AllowedOriginMiddleware(args.AllowedOrigins, r.trustedProxies, r.logger))
+		r.frameAncestors.Set(internal.FrameAncestorsMiddleware(args.AllowedOrigins))
+		report.Applied = append(report.Applied, "allowedOrigins")
+	}
+
+	// The rate limiters are only replaced when their policy changes, as the clients start over with a full bucket
+	if args.ApiRateLimit != r.current.ApiRateLimit {
+		r.apiRateLimit.Set(rateLimit(args.ApiRateLimit, internal.UserKey(r.trustedProxies), r.logger))
+		report.Applied = append(report.Applied, "apiRateLimit")
+	}
+	if args.LoginRateLimit != r.current.LoginRateLimit {
+		r.loginRateLimit.Set(rateLimit(args.LoginRateLimit, internal.ClientIPKey(r.trustedProxies), r.logger))
+		report.Applied = append(report.Applied, "loginRateLimit")
+	}
+	if args.SubmissionRateLimit != r.current.SubmissionRateLimit {
+		r.submissionRateLimit.Set(rateLimit(args.SubmissionRateLimit, internal.ClientIPKey(r.trustedProxies), r.logger))
+		report.Applied = append(report.Applied, "submissionRateLimit")
+	}
+	if args.TotalSubmissionRateLimit != r.current.TotalSubmissionRateLimit {
+		r.totalSubmissionRateLimit.Set(rateLimit(args.TotalSubmissionRateLimit, internal.GlobalKey, r.logger))
+		report.Applied = append(report.Applied, "totalSubmissionRateLimit")
+	}
+
+	if emailChanged {
+		r.emailService.Set(emailService, internal.GetEmailSender(args))
+		if args.EmailSenderAddress != r.current.EmailSenderAddress {
+			report.Applied = append(report.Applied, "emailSenderAddress")
+		}
+		if args.EmailSenderName != r.current.EmailSenderName {
+			report.Applied = append(report.Applied, "emailSenderName")
+		}
+		if args.SendGridApiKey != r.current.SendGridApiKey {
+			report.Applied = append(report.Applied, "sendGridApiKey")
+		}
+	}
+
+	r.current.AllowedOrigins = args.AllowedOrigins
+	r.current.ApiRateLimit = args.ApiRateLimit
+	r.current.LoginRateLimit = args.LoginRateLimit
+	r.current.SubmissionRateLimit = args.SubmissionRateLimit
+	r.current.TotalSubmissionRateLimit = args.TotalSubmissionRateLimit
+	r.current.SendGridApiKey = args.SendGridApiKey
+	r.current.EmailSenderName = args.EmailSenderName
+	r.current.EmailSenderAddress = args.EmailSenderAddress
+
+	logger := r.logger.WithContext(ctx)
+	logger.Infof("Reloaded the configuration, changed: [%s]", strings.Join(report.Applied, ", "))
+	if len(report.RequiresRestart) > 0 {
+		logger.Warnf("These settings have changed, but only take effect when welp is restarted: [%s]", strings.Join(report.RequiresRestart, ", "))
+	}
+
+	return report, nil
+}
+
+// Reloads the configuration every time welp gets SIGHUP, until the context is cancelled
+func reloadOnSignal(ctx context.Context, reloader models.ConfigReloader, logger models.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(signals)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-signals:
+				logger.Infof("Received %s, reloading the configuration", sig)
+				_, err := reloader.Reload(ctx)
+				if err != nil {
+					logger.Errorf("Failed to reload the configuration, the current configuration is kept: %v", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package welp
+
+import (
+	"github.com/labstack/echo"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/webapi"
+	"net/http"
+)
+
+type bindReloadApiArgs struct {
+	Logger         models.Logger
+	ConfigReloader models.ConfigReloader
+	JwtMiddleware  echo.MiddlewareFunc
+	// Only lets admins through
+	AdminMiddleware echo.MiddlewareFunc
+}
+
+func bindReloadApi(e *echo.Group, args bindReloadApiArgs) {
+	server := &reloadServer{
+		bindReloadApiArgs: args,
+	}
+
+	e.POST("/admin/reload", server.reloadHandler, args.JwtMiddleware, args.AdminMiddleware)
+}
+
+type reloadServer struct {
+	bindReloadApiArgs
+}
+
+// Reloads the configuration, and tells which settings were changed, and which require a restart
+func (s *reloadServer) reloadHandler(c echo.Context) error {
+	ctx := webapi.GetContext(c.Request())
+
+	report, err := s.ConfigReloader.Reload(ctx)
+	if err == models.ErrReloadNotSupported {
+		return echo.NewHTTPError(http.StatusNotImplemented, err.Error())
+	}
+	if err != nil {
+		s.Logger.WithContext(ctx).Errorf("Failed to reload the configuration, the current configuration is kept: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,141 @@
+package welp
+
+import (
+	"bytes"
+	"context"
+	"github.com/labstack/echo"
+	"github.com/zlepper/welp/internal/app/welp/internal"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type reloadTest struct {
+	reloader *configReloader
+	// What the next reload reads, and which settings it says have changed
+	next    models.BindWebArgs
+	changed []string
+	output  *bytes.Buffer
+	logger  models.Logger
+}
+
+func newReloadTest(t *testing.T, args models.BindWebArgs) *reloadTest {
+	test := &reloadTest{
+		next:   args,
+		output: &bytes.Buffer{},
+	}
+	test.logger = internal.NewLoggerWithOutput(args, test.output)
+
+	emailService, err := internal.GetEmailService(args, test.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args.ReloadConfig = func() (models.BindWebArgs, []string, error) {
+		return test.next, test.changed, nil
+	}
+	test.reloader = newConfigReloader(args, nil, services.NewReloadableEmailService(emailService, internal.GetEmailSender(args)), test.logger)
+
+	return test
+}
+
+func (r *reloadTest) reload(t *testing.T) models.ReloadReport {
+	report, err := r.reloader.Reload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+// Sends a request from the origin through the allowed origin middleware
+func (r *reloadTest) requestFrom(origin string) int {
+	e := echo.New()
+	e.GET("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, r.reloader.allowedOrigin.Middleware())
+
+	request := httptest.NewRequest(http.MethodGet, "http://welp.example/", nil)
+	request.Header.Set(echo.HeaderOrigin, origin)
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, request)
+
+	return recorder.Code
+}
+
+func TestReloadReport(t *testing.T) {
+	test := newReloadTest(t, models.BindWebArgs{
+		AllowedOrigins: []string{"https://a.example"},
+		ApiRateLimit:   models.RateLimitPolicy{Requests: 10, Period: time.Minute},
+		Port:           8080,
+	})
+
+	test.next.AllowedOrigins = []string{"https://a.example", "https://b.example"}
+	test.next.ApiRateLimit = models.RateLimitPolicy{Requests: 20, Period: time.Minute}
+	test.next.Port = 9090
+	test.changed = []string{"allowedOrigins", "apiRateLimit", "port"}
+
+	report := test.reload(t)
+	if strings.Join(report.Applied, ",") != "allowedOrigins,apiRateLimit" {
+		t.Errorf("expected the origins and the rate limit to be applied, got %v", report.Applied)
+	}
+	if strings.Join(report.RequiresRestart, ",") != "port" {
+		t.Errorf("expected the port to require a restart, got %v", report.RequiresRestart)
+	}
+
+	// Nothing has changed since, so nothing is applied again
+	report = test.reload(t)
+	if len(report.Applied) != 0 {
+		t.Errorf("expected nothing to be applied again, got %v", report.Applied)
+	}
+}
+
+func TestReloadSwapsMiddleware(t *testing.T) {
+	test := newReloadTest(t, models.BindWebArgs{
+		AllowedOrigins: []string{"https://a.example"},
+	})
+
+	if code := test.requestFrom("https://b.example"); code != http.StatusForbidden {
+		t.Fatalf("expected the origin to be rejected before the reload, got %d", code)
+	}
+
+	test.next.AllowedOrigins = []string{"https://a.example", "https://b.example"}
+	test.changed = []string{"allowedOrigins"}
+	test.reload(t)
+
+	if code := test.requestFrom("https://b.example"); code != http.StatusOK {
+		t.Errorf("expected the origin to be allowed after the reload, got %d", code)
+	}
+	if code := test.requestFrom("https://a.example"); code != http.StatusOK {
+		t.Errorf("expected the origin to still be allowed after the reload, got %d", code)
+	}
+}
+
+func TestReloadRedactsNewSecrets(t *testing.T) {
+	test := newReloadTest(t, models.BindWebArgs{
+		SendGridApiKey: "old-sendgrid-key",
+	})
+
+	test.next.SendGridApiKey = "new-sendgrid-key"
+	test.changed = []string{"sendGridApiKey"}
+	report := test.reload(t)
+	if strings.Join(report.Applied, ",") != "sendGridApiKey" {
+		t.Errorf("expected the api key to be applied, got %v", report.Applied)
+	}
+
+	test.logger.Infof("keys: %s %s", "old-sendgrid-key", "new-sendgrid-key")
+	if output := test.output.String(); strings.Contains(output, "sendgrid-key") {
+		t.Errorf("an api key was logged:\n%s", output)
+	}
+}
+
+func TestReloadNotSupported(t *testing.T) {
+	reloader := newConfigReloader(models.BindWebArgs{}, nil, nil, internal.NewLoggerWithOutput(models.BindWebArgs{}, &bytes.Buffer{}))
+
+	if _, err := reloader.Reload(context.Background()); err != models.ErrReloadNotSupported {
+		t.Errorf("expected ErrReloadNotSupported without a way to reload the configuration, got %v", err)
+	}
+}
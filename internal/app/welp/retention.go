@@ -0,0 +1,86 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package welp
+
+import (
+	"context"
+	"fmt"
+	"github.com/zlepper/welp/internal/app/welp/internal"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"os"
+	"text/tabwriter"
+)
+
+// Enforces the retention policy once, and prints a report of the feedback that was purged.
+// If dryRun is true, nothing is changed, and the report tells what would have been purged
+func EnforceRetention(args models.BindWebArgs, dryRun bool) {
+	logger := internal.NewLogger(args)
+
+	if !args.Retention.Enabled() {
+		logger.Fatal("No retention rules are enabled. Set --retentionDays or --retentionDaysAfterResolution")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer internal.WaitForServices()
+	defer cancel()
+
+	loadedServices, err := internal.GetServices(ctx, args, logger)
+	if err != nil {
+		logger.Fatal(err)
+		return
+	}
+
+	report, err := loadedServices.RetentionService.Enforce(ctx, dryRun)
+	if err != nil {
+		logger.Fatal(err)
+		return
+	}
+
+	printRetentionReport(report)
+}
+
+func printRetentionReport(report models.RetentionReport) {
+	if len(report.Items) == 0 {
+		fmt.Println("No feedback has to be purged")
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "FEEDBACK\tCREATED\tACTION\tREASON\tERROR")
+
+	failed := 0
+	for _, item := range report.Items {
+		if item.Error != "" {
+			failed++
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", item.FeedbackId, item.Created.Format("2006-01-02"), item.Action, item.Reason, item.Error)
+	}
+	writer.Flush()
+
+	if report.DryRun {
+		fmt.Printf("\n%d feedback entries would be purged. Nothing has been changed, as this was a dry run\n", len(report.Items))
+	} else {
+		fmt.Printf("\n%d feedback entries were purged, %d failed\n", len(report.Items)-failed, failed)
+	}
+}
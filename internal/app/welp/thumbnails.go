@@ -0,0 +1,93 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package welp
+
+import (
+	"context"
+	"github.com/zlepper/welp/internal/app/welp/internal"
+	"github.com/zlepper/welp/internal/pkg/models"
+)
+
+// Makes sure all image attachments have thumbnails, so files uploaded
+// before thumbnails existed doesn't have to wait for them on the first request.
+// If force is true, existing thumbnails are made again.
+func BackfillThumbnails(args models.BindWebArgs, force bool) {
+	logger := internal.NewLogger(args)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	// The thumbnails can change the blob references, so they have to be saved before exiting
+	defer internal.WaitForServices()
+	defer cancel()
+
+	loadedServices, err := internal.GetServices(ctx, args, logger)
+	if err != nil {
+		logger.Fatal(err)
+		return
+	}
+
+	feedback, err := loadedServices.FeedbackDataStorage.GetAllFeedback(ctx)
+	if err != nil {
+		logger.Fatal(err)
+		return
+	}
+
+	generated, failed := 0, 0
+	for _, f := range feedback {
+		for _, file := range f.Files {
+			if !file.IsImage() {
+				continue
+			}
+
+			err = ensureThumbnails(ctx, loadedServices.ThumbnailService, file, force)
+			if err == models.ErrThumbnailNotSupported {
+				continue
+			}
+			if err != nil {
+				logger.Errorf("Failed to generate thumbnails for '%s': %v", file.Id, err)
+				failed++
+				continue
+			}
+
+			generated++
+		}
+	}
+
+	logger.Infof("Thumbnails are ready for %d images, %d failed", generated, failed)
+}
+
+func ensureThumbnails(ctx context.Context, thumbnailService models.ThumbnailService, file models.File, force bool) error {
+	if force {
+		return thumbnailService.GenerateThumbnails(ctx, file)
+	}
+
+	// Loading the thumbnails makes them if they are missing
+	for size := range models.ThumbnailSizes {
+		_, reader, err := thumbnailService.LoadThumbnail(ctx, file, size)
+		if err != nil {
+			return err
+		}
+		reader.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package welp
+
+import (
+	"fmt"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/webapi"
+	"mime/multipart"
+)
+
+type uploadError struct {
+	// The name of the file the error is about. Empty if the error is about the request as a whole
+	File  string `json:"file" xml:"file"`
+	Error string `json:"error" xml:"error"`
+}
+
+type uploadRejectedResponse struct {
+	Message string        `json:"message" xml:"message"`
+	Errors  []uploadError `json:"errors" xml:"errors>error"`
+}
+
+// Checks the files against the upload limits, before anything is saved.
+// The content type is sniffed from the content of the files, as the filename can't be trusted
+func validateUploads(limits models.UploadLimits, files []*multipart.FileHeader) ([]uploadError, error) {
+	errors := make([]uploadError, 0)
+
+	count := 0
+	for _, file := range files {
+		if file.Size == 0 {
+			continue
+		}
+		count++
+
+		if limits.MaxFileSize > 0 && file.Size > int64(limits.MaxFileSize) {
+			errors = append(errors, uploadError{
+				File:  file.Filename,
+				Error: fmt.Sprintf("file is larger than the maximum of %s", limits.MaxFileSize),
+			})
+			continue
+		}
+
+		contentType, err := sniffContentType(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if !limits.IsContentTypeAllowed(contentType) {
+			errors = append(errors, uploadError{
+				File:  file.Filename,
+				Error: fmt.Sprintf("files of type '%s' are not allowed", contentType),
+			})
+		}
+	}
+
+	if limits.MaxFiles > 0 && count > limits.MaxFiles {
+		errors = append(errors, uploadError{
+			Error: fmt.Sprintf("too many files, at most %d can be attached", limits.MaxFiles),
+		})
+	}
+
+	return errors, nil
+}
+
+func sniffContentType(file *multipart.FileHeader) (string, error) {
+	src, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	contentType, _, err := webapi.DetectContentType(src)
+	return contentType, err
+}
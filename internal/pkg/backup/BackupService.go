@@ -0,0 +1,305 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/zlepper/welp/internal/pkg/flatfile"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// The version of the backup format that is written
+	formatVersion = 1
+
+	// The name of the manifest in the backup
+	manifestName = "manifest.json"
+	// The folder in the backup the database files are put in
+	databasePrefix = "db"
+	// The folder in the backup the uploaded files are put in
+	storagePrefix = "storage"
+)
+
+type BackupServiceArgs struct {
+	// The folder with the database files
+	DatabaseFolder string
+	// The folder with the uploaded files. Empty if the files are not stored on the local disk
+	StorageFolder string
+	Logger        models.Logger
+}
+
+func NewBackupService(args BackupServiceArgs) models.BackupService {
+	return &backupService{
+		BackupServiceArgs: args,
+	}
+}
+
+type backupService struct {
+	BackupServiceArgs
+}
+
+func (s *backupService) Backup(ctx context.Context, writer io.Writer, options models.BackupOptions) (models.BackupManifest, error) {
+	manifest := models.BackupManifest{
+		Version:       formatVersion,
+		Created:       time.Now(),
+		IncludesFiles: !options.DatabaseOnly,
+		Files:         []models.BackupFile{},
+	}
+
+	if manifest.IncludesFiles && s.StorageFolder == "" {
+		return manifest, models.ErrBackupFilesNotLocal
+	}
+
+	// Changes wait while the snapshot is made, so the database and the uploaded files match.
+	// The database files are small, so they are copied. The uploaded files are hard linked, so
+	// a file deleted after the snapshot, e.g. by the retention, is still in the backup.
+	// The snapshot is made next to the storage folder, as hard links can't cross disks
+	snapshotFolder, err := ioutil.TempDir(s.getSnapshotParent(), ".welp-backup-")
+	if err != nil {
+		return manifest, err
+	}
+	defer os.RemoveAll(snapshotFolder)
+
+	databaseSnapshot := filepath.Join(snapshotFolder, databasePrefix)
+	storageSnapshot := filepath.Join(snapshotFolder, storagePrefix)
+	err = flatfile.Snapshot(func() error {
+		err := copyFolder(s.DatabaseFolder, databaseSnapshot, copyFile)
+		if err == nil && manifest.IncludesFiles {
+			err = copyFolder(s.StorageFolder, storageSnapshot, linkFile)
+		}
+		return err
+	})
+	if err != nil {
+		return manifest, err
+	}
+
+	compressed := gzip.NewWriter(writer)
+	archive := tar.NewWriter(compressed)
+
+	// The manifest is written last, as the checksums are made while the files are written
+	err = s.addFolder(ctx, archive, &manifest, databaseSnapshot, databasePrefix)
+	if err != nil {
+		return manifest, err
+	}
+
+	if manifest.IncludesFiles {
+		err = s.addFolder(ctx, archive, &manifest, storageSnapshot, storagePrefix)
+		if err != nil {
+			return manifest, err
+		}
+	}
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+
+	err = archive.WriteHeader(&tar.Header{
+		Name:    manifestName,
+		Mode:    0644,
+		Size:    int64(len(content)),
+		ModTime: manifest.Created,
+	})
+	if err != nil {
+		return manifest, err
+	}
+
+	_, err = archive.Write(content)
+	if err != nil {
+		return manifest, err
+	}
+
+	err = archive.Close()
+	if err != nil {
+		return manifest, err
+	}
+
+	err = compressed.Close()
+	if err != nil {
+		return manifest, err
+	}
+
+	s.Logger.Infof("Backup made with %d files", len(manifest.Files))
+
+	return manifest, nil
+}
+
+// Gets the folder the snapshot is made in, which is next to the storage folder if it is on the local disk
+func (s *backupService) getSnapshotParent() string {
+	if s.StorageFolder == "" {
+		return filepath.Dir(filepath.Clean(s.DatabaseFolder))
+	}
+	return filepath.Dir(filepath.Clean(s.StorageFolder))
+}
+
+// Copies the files in the folder to the destination with transfer, leaving out temp files
+func copyFolder(folder, destination string, transfer func(source, destination string) error) error {
+	err := filepath.Walk(folder, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || !info.Mode().IsRegular() || flatfile.IsTempFile(filename) {
+			return nil
+		}
+
+		relative, err := filepath.Rel(folder, filename)
+		if err != nil {
+			return err
+		}
+
+		return transfer(filename, filepath.Join(destination, relative))
+	})
+	if os.IsNotExist(err) {
+		// Nothing has been saved yet
+		return nil
+	}
+	return err
+}
+
+// Hard links the file, or copies it if the file system doesn't support hard links
+func linkFile(source, destination string) error {
+	err := os.MkdirAll(filepath.Dir(destination), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = os.Link(source, destination)
+	if err != nil {
+		return copyFile(source, destination)
+	}
+
+	return nil
+}
+
+func copyFile(source, destination string) error {
+	input, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	err = os.MkdirAll(filepath.Dir(destination), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	output, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+
+	_, err = io.Copy(output, input)
+	if err != nil {
+		return err
+	}
+
+	return output.Close()
+}
+
+// Adds all the files in the folder to the archive, below the prefix
+func (s *backupService) addFolder(ctx context.Context, archive *tar.Writer, manifest *models.BackupManifest, folder, prefix string) error {
+	err := filepath.Walk(folder, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Temp files are either from saves that didn't finish, or from uploads and rekeys in progress
+		if info.IsDir() || !info.Mode().IsRegular() || flatfile.IsTempFile(filename) {
+			return nil
+		}
+
+		// Stop if the client has gone away
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		relative, err := filepath.Rel(folder, filename)
+		if err != nil {
+			return err
+		}
+
+		file, err := s.addFile(archive, filename, path.Join(prefix, filepath.ToSlash(relative)))
+		if err != nil {
+			return err
+		}
+
+		manifest.Files = append(manifest.Files, file)
+
+		return nil
+	})
+	if os.IsNotExist(err) {
+		// Nothing has been saved yet
+		return nil
+	}
+	return err
+}
+
+func (s *backupService) addFile(archive *tar.Writer, filename, name string) (models.BackupFile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return models.BackupFile{}, err
+	}
+	defer file.Close()
+
+	// The size is taken from the open file, as it is what will be copied
+	info, err := file.Stat()
+	if err != nil {
+		return models.BackupFile{}, err
+	}
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return models.BackupFile{}, err
+	}
+	header.Name = name
+
+	err = archive.WriteHeader(header)
+	if err != nil {
+		return models.BackupFile{}, err
+	}
+
+	hash := sha256.New()
+	_, err = io.CopyN(io.MultiWriter(archive, hash), file, info.Size())
+	if err != nil {
+		return models.BackupFile{}, err
+	}
+
+	return models.BackupFile{
+		Path:   name,
+		Size:   info.Size(),
+		Sha256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
@@ -0,0 +1,258 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"github.com/zlepper/welp/internal/pkg/logging"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, folder string, files map[string]string) {
+	for name, content := range files {
+		filename := filepath.Join(folder, name)
+		if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFile(t *testing.T, filename string) string {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func makeBackup(t *testing.T, root string) []byte {
+	writeFiles(t, filepath.Join(root, "db"), map[string]string{
+		"feedback.json":      `{"a":{}}`,
+		"feedback.json.temp": "half written",
+	})
+	writeFiles(t, filepath.Join(root, "storage"), map[string]string{
+		"a.png":        "image",
+		"nested/b.txt": "text",
+	})
+
+	service := NewBackupService(BackupServiceArgs{
+		DatabaseFolder: filepath.Join(root, "db"),
+		StorageFolder:  filepath.Join(root, "storage"),
+		Logger:         logging.NewLogger(logging.LoggerArgs{}),
+	})
+
+	var buffer bytes.Buffer
+	manifest, err := service.Backup(context.Background(), &buffer, models.BackupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifest.Files) != 3 {
+		t.Fatalf("expected 3 files in the manifest, got %v", manifest.Files)
+	}
+
+	return buffer.Bytes()
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	root, err := ioutil.TempDir("", "welp-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	backup := makeBackup(t, root)
+
+	// Change the data, so it can be seen that the backup is restored
+	writeFiles(t, filepath.Join(root, "db"), map[string]string{"feedback.json": `{}`})
+
+	_, err = Restore(bytes.NewReader(backup), RestoreArgs{
+		DatabaseFolder: filepath.Join(root, "db"),
+		StorageFolder:  filepath.Join(root, "storage"),
+		Logger:         logging.NewLogger(logging.LoggerArgs{}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if content := readFile(t, filepath.Join(root, "db", "feedback.json")); content != `{"a":{}}` {
+		t.Errorf("expected the feedback to be restored, got %s", content)
+	}
+	if content := readFile(t, filepath.Join(root, "storage", "nested", "b.txt")); content != "text" {
+		t.Errorf("expected the nested file to be restored, got %s", content)
+	}
+	if _, err := os.Stat(filepath.Join(root, "db", "feedback.json.temp")); !os.IsNotExist(err) {
+		t.Error("expected temp files to be left out of the backup")
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(root, "db.before-restore-*"))
+	if len(matches) != 1 || readFile(t, filepath.Join(matches[0], "feedback.json")) != `{}` {
+		t.Errorf("expected the previous data to be kept, got %v", matches)
+	}
+}
+
+func TestBackupWithoutLocalFiles(t *testing.T) {
+	root, err := ioutil.TempDir("", "welp-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	writeFiles(t, filepath.Join(root, "db"), map[string]string{"feedback.json": `{}`})
+
+	// The files are stored in S3
+	service := NewBackupService(BackupServiceArgs{
+		DatabaseFolder: filepath.Join(root, "db"),
+		Logger:         logging.NewLogger(logging.LoggerArgs{}),
+	})
+
+	var buffer bytes.Buffer
+	if _, err := service.Backup(context.Background(), &buffer, models.BackupOptions{}); err != models.ErrBackupFilesNotLocal {
+		t.Fatalf("expected the backup to fail with ErrBackupFilesNotLocal, got %v", err)
+	}
+	if buffer.Len() != 0 {
+		t.Errorf("expected nothing to be written before failing, got %d bytes", buffer.Len())
+	}
+
+	manifest, err := service.Backup(context.Background(), &buffer, models.BackupOptions{DatabaseOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.IncludesFiles || len(manifest.Files) != 1 || manifest.Files[0].Path != "db/feedback.json" {
+		t.Errorf("expected only the database in the backup, got %v", manifest)
+	}
+}
+
+// Deletes a file the first time something is written, which is after the snapshot has been made
+type deletingWriter struct {
+	bytes.Buffer
+	filename string
+}
+
+func (w *deletingWriter) Write(p []byte) (int, error) {
+	if w.filename != "" {
+		os.Remove(w.filename)
+		w.filename = ""
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestBackupKeepsFilesDeletedAfterTheSnapshot(t *testing.T) {
+	root, err := ioutil.TempDir("", "welp-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	writeFiles(t, filepath.Join(root, "db"), map[string]string{"feedback.json": `{"a":{}}`})
+	writeFiles(t, filepath.Join(root, "storage"), map[string]string{"a.png": "image"})
+
+	service := NewBackupService(BackupServiceArgs{
+		DatabaseFolder: filepath.Join(root, "db"),
+		StorageFolder:  filepath.Join(root, "storage"),
+		Logger:         logging.NewLogger(logging.LoggerArgs{}),
+	})
+
+	// Like the retention deleting the feedback while the backup is downloaded
+	writer := &deletingWriter{filename: filepath.Join(root, "storage", "a.png")}
+	manifest, err := service.Backup(context.Background(), writer, models.BackupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "storage", "a.png")); !os.IsNotExist(err) {
+		t.Fatal("expected the file to be deleted while the backup was written")
+	}
+	if len(manifest.Files) != 2 || manifest.Files[1].Path != "storage/a.png" {
+		t.Errorf("expected the deleted file to be in the backup, got %v", manifest.Files)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(root, ".welp-backup-*"))
+	if len(matches) != 0 {
+		t.Errorf("expected the snapshot to be removed, got %v", matches)
+	}
+}
+
+// Copies the backup, replacing the content of the named file
+func tamper(t *testing.T, backup []byte, name string, content string) []byte {
+	decompressed, err := gzip.NewReader(bytes.NewReader(backup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := tar.NewReader(decompressed)
+
+	var buffer bytes.Buffer
+	compressed := gzip.NewWriter(&buffer)
+	writer := tar.NewWriter(compressed)
+
+	for {
+		header, err := reader.Next()
+		if err != nil {
+			break
+		}
+		data, _ := ioutil.ReadAll(reader)
+		if header.Name == name {
+			data = []byte(content)
+			header.Size = int64(len(data))
+		}
+		writer.WriteHeader(header)
+		writer.Write(data)
+	}
+	writer.Close()
+	compressed.Close()
+
+	return buffer.Bytes()
+}
+
+func TestRestoreRejectsTamperedBackup(t *testing.T) {
+	root, err := ioutil.TempDir("", "welp-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	backup := tamper(t, makeBackup(t, root), "storage/a.png", "changed")
+
+	_, err = Restore(bytes.NewReader(backup), RestoreArgs{
+		DatabaseFolder: filepath.Join(root, "db"),
+		StorageFolder:  filepath.Join(root, "storage"),
+		Logger:         logging.NewLogger(logging.LoggerArgs{}),
+	})
+	if err == nil || !strings.Contains(err.Error(), models.ErrBackupChecksumMismatch.Error()) {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+
+	if content := readFile(t, filepath.Join(root, "storage", "a.png")); content != "image" {
+		t.Errorf("expected the data to be left alone, got %s", content)
+	}
+	if _, err := os.Stat(filepath.Join(root, "storage.restoring")); !os.IsNotExist(err) {
+		t.Error("expected the staging folder to be removed")
+	}
+}
+
+func TestGetRestorePathRejectsOtherFolders(t *testing.T) {
+	targets := map[string]*restoreTarget{
+		databasePrefix: {folder: "db"},
+	}
+
+	for _, name := range []string{"../etc/passwd", "db/../../x", "/db/x", "other/x", "db/"} {
+		_, _, err := getRestorePath(name, targets)
+		if err == nil {
+			t.Errorf("expected '%s' to be rejected", name)
+		}
+	}
+
+	target, relative, err := getRestorePath("db/feedback.json", targets)
+	if err != nil || target == nil || relative != "feedback.json" {
+		t.Errorf("expected db/feedback.json to be restored, got %v %s %v", target, relative, err)
+	}
+}
@@ -0,0 +1,280 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type RestoreArgs struct {
+	// The folder the database files are restored to
+	DatabaseFolder string
+	// The folder the uploaded files are restored to. Empty if the files are not stored on the local disk
+	StorageFolder string
+	Logger        models.Logger
+}
+
+// A folder that is restored
+type restoreTarget struct {
+	// Where the data should end up
+	folder string
+	// Where the files are put until everything has been verified
+	staging string
+}
+
+// Restores a backup made by the BackupService. Welp must not be running meanwhile.
+// Everything is extracted and verified against the manifest before any data is replaced.
+// The replaced folders are kept next to the restored ones, in case the restore has to be undone
+func Restore(reader io.Reader, args RestoreArgs) (models.BackupManifest, error) {
+	targets := map[string]*restoreTarget{
+		databasePrefix: {folder: args.DatabaseFolder},
+	}
+	if args.StorageFolder != "" {
+		targets[storagePrefix] = &restoreTarget{folder: args.StorageFolder}
+	}
+
+	for _, target := range targets {
+		target.staging = path.Clean(target.folder) + ".restoring"
+		err := os.RemoveAll(target.staging)
+		if err != nil {
+			return models.BackupManifest{}, err
+		}
+	}
+
+	manifest, err := extract(reader, targets, args.Logger)
+	if err != nil {
+		for _, target := range targets {
+			os.RemoveAll(target.staging)
+		}
+		return manifest, err
+	}
+
+	if !manifest.IncludesFiles {
+		args.Logger.Warn("The backup doesn't include the uploaded files, so only the database is restored")
+	}
+
+	suffix := ".before-restore-" + time.Now().Format("20060102150405")
+	for prefix, target := range targets {
+		if prefix == storagePrefix && !manifest.IncludesFiles {
+			os.RemoveAll(target.staging)
+			continue
+		}
+
+		err = replaceFolder(target, suffix, args.Logger)
+		if err != nil {
+			return manifest, err
+		}
+	}
+
+	return manifest, nil
+}
+
+// Moves the current folder out of the way, and the staged folder in its place
+func replaceFolder(target *restoreTarget, suffix string, logger models.Logger) error {
+	// Make sure there is a folder to move in place, even if the backup had no files for it
+	err := os.MkdirAll(target.staging, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stat(target.folder)
+	if err == nil {
+		previous := path.Clean(target.folder) + suffix
+		err = os.Rename(target.folder, previous)
+		if err != nil {
+			return err
+		}
+		logger.Infof("The previous content of '%s' has been moved to '%s'", target.folder, previous)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.Rename(target.staging, target.folder)
+}
+
+// Extracts the backup to the staging folders, and verifies the files against the manifest
+func extract(reader io.Reader, targets map[string]*restoreTarget, logger models.Logger) (models.BackupManifest, error) {
+	var manifest models.BackupManifest
+
+	decompressed, err := gzip.NewReader(reader)
+	if err != nil {
+		return manifest, err
+	}
+	defer decompressed.Close()
+
+	archive := tar.NewReader(decompressed)
+
+	extracted := map[string]models.BackupFile{}
+	hasManifest := false
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, err
+		}
+
+		if header.Name == manifestName {
+			err = json.NewDecoder(archive).Decode(&manifest)
+			if err != nil {
+				return manifest, err
+			}
+			hasManifest = true
+			continue
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		target, relative, err := getRestorePath(header.Name, targets)
+		if err != nil {
+			return manifest, err
+		}
+
+		// Files that are not restored are still read, so they are verified
+		filename := ""
+		if target != nil {
+			filename = filepath.Join(target.staging, filepath.FromSlash(relative))
+		}
+
+		file, err := extractFile(archive, header, filename)
+		if err != nil {
+			return manifest, err
+		}
+
+		extracted[file.Path] = file
+	}
+
+	if !hasManifest {
+		return manifest, models.ErrBackupManifestMissing
+	}
+
+	if manifest.Version > formatVersion {
+		return manifest, models.ErrBackupVersionUnknown
+	}
+
+	for _, file := range manifest.Files {
+		actual, exists := extracted[file.Path]
+		if !exists {
+			if strings.HasPrefix(file.Path, storagePrefix+"/") && targets[storagePrefix] == nil {
+				// The files are not stored on the local disk, so they are not restored
+				continue
+			}
+			return manifest, fmt.Errorf("%v: %s", models.ErrBackupFileMissing, file.Path)
+		}
+
+		if actual.Size != file.Size || actual.Sha256 != file.Sha256 {
+			return manifest, fmt.Errorf("%v: %s", models.ErrBackupChecksumMismatch, file.Path)
+		}
+
+		delete(extracted, file.Path)
+	}
+
+	for name := range extracted {
+		return manifest, fmt.Errorf("%v: %s", models.ErrBackupFileNotInManifest, name)
+	}
+
+	logger.Infof("All %d files in the backup from %s have been verified", len(manifest.Files), manifest.Created.Format(time.RFC3339))
+
+	return manifest, nil
+}
+
+// Finds the folder the file in the backup should be restored to. If the file shouldn't be restored, the target is nil.
+// Files outside of the known folders are rejected, so a backup can't write anywhere else
+func getRestorePath(name string, targets map[string]*restoreTarget) (*restoreTarget, string, error) {
+	cleaned := path.Clean("/" + name)[1:]
+	if cleaned != name {
+		return nil, "", fmt.Errorf("%v: %s", models.ErrBackupInvalidPath, name)
+	}
+
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, "", fmt.Errorf("%v: %s", models.ErrBackupInvalidPath, name)
+	}
+
+	target, exists := targets[parts[0]]
+	if !exists {
+		if parts[0] == storagePrefix {
+			// The files are not stored on the local disk
+			return nil, "", nil
+		}
+		return nil, "", fmt.Errorf("%v: %s", models.ErrBackupInvalidPath, name)
+	}
+
+	return target, parts[1], nil
+}
+
+// Writes the file to the filename while hashing it. If the filename is empty, the file is only hashed
+func extractFile(archive io.Reader, header *tar.Header, filename string) (models.BackupFile, error) {
+	hash := sha256.New()
+	var writer io.Writer = hash
+
+	var file *os.File
+	if filename != "" {
+		err := os.MkdirAll(filepath.Dir(filename), os.ModePerm)
+		if err != nil {
+			return models.BackupFile{}, err
+		}
+
+		file, err = os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return models.BackupFile{}, err
+		}
+		defer file.Close()
+
+		writer = io.MultiWriter(file, hash)
+	}
+
+	size, err := io.Copy(writer, archive)
+	if err != nil {
+		return models.BackupFile{}, err
+	}
+
+	if file != nil {
+		err = file.Close()
+		if err != nil {
+			return models.BackupFile{}, err
+		}
+	}
+
+	return models.BackupFile{
+		Path:   header.Name,
+		Size:   size,
+		Sha256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
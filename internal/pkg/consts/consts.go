@@ -28,5 +28,12 @@ const (
 	Version = "0.0.1"
 )
 
+// Describes the build. Set when building with
+// -ldflags "-X github.com/zlepper/welp/internal/pkg/consts.Commit=..."
+var (
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
 // Just nothing
 var Nothing = struct{}{}
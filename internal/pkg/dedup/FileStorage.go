@@ -0,0 +1,312 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package dedup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/tracing"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+type FileStorageArgs struct {
+	// Where the blobs are actually stored
+	Storage models.FileStorage
+	// Keeps track of which blob each file refers to
+	References models.BlobReferenceStorage
+	// Where files are buffered while their hash is calculated. Defaults to the temp folder of the system
+	TempFolder string
+
+	Logger models.Logger
+}
+
+// Gets a file storage that stores files by the sha256 hash of their content, so
+// files with the same content are only stored once. A blob is only deleted
+// when no files refer to it anymore.
+// Files stored before deduplication was enabled are still loaded from the underlying storage.
+func NewFileStorage(args FileStorageArgs) models.FileStorage {
+	return &fileStorage{
+		args:   args,
+		logger: args.Logger,
+		locks:  map[string]*hashLock{},
+	}
+}
+
+type fileStorage struct {
+	args   FileStorageArgs
+	logger models.Logger
+
+	// Makes sure a blob isn't deleted while another file starts referring to it
+	lock  sync.Mutex
+	locks map[string]*hashLock
+}
+
+type hashLock struct {
+	sync.Mutex
+	users int
+}
+
+// Locks the blob with the given hash, and returns a function to unlock it again
+func (s *fileStorage) lockHash(hash string) func() {
+	s.lock.Lock()
+	l, exists := s.locks[hash]
+	if !exists {
+		l = &hashLock{}
+		s.locks[hash] = l
+	}
+	l.users++
+	s.lock.Unlock()
+
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+
+		s.lock.Lock()
+		l.users--
+		if l.users == 0 {
+			delete(s.locks, hash)
+		}
+		s.lock.Unlock()
+	}
+}
+
+func getBlobId(hash string) string {
+	return "sha256-" + hash
+}
+
+func (s *fileStorage) SaveFile(ctx context.Context, id string, reader io.Reader) (size int64, err error) {
+	ctx, span := tracing.StartSpan(ctx, "dedup.SaveFile")
+	defer func() {
+		span.EndWithError(err)
+	}()
+	span.SetAttribute("file.id", id)
+
+	// The hash has to be known before the blob can be stored, so buffer the file on disk meanwhile
+	temp, err := ioutil.TempFile(s.args.TempFolder, "welp-upload-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	hasher := sha256.New()
+	size, err = io.Copy(io.MultiWriter(temp, hasher), reader)
+	if err != nil {
+		return 0, err
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+
+	previousHash, err := s.saveBlob(ctx, id, hash, temp)
+	if err != nil {
+		return 0, err
+	}
+
+	// The file was overwritten with new content, so the old content might not be needed anymore
+	if previousHash != "" && previousHash != hash {
+		unlock := s.lockHash(previousHash)
+		defer unlock()
+
+		err = s.deleteUnreferencedBlob(ctx, previousHash)
+		if err != nil {
+			s.logger.WithContext(ctx).Errorf("Failed to delete blob '%s': %v", previousHash, err)
+		}
+	}
+
+	return size, nil
+}
+
+// Stores the content as a blob, unless it's already stored, and makes the file refer to it
+func (s *fileStorage) saveBlob(ctx context.Context, id, hash string, content io.ReadSeeker) (previousHash string, err error) {
+	unlock := s.lockHash(hash)
+	defer unlock()
+
+	references, err := s.args.References.CountReferences(ctx, hash)
+	if err != nil {
+		return "", err
+	}
+
+	if references == 0 {
+		_, err = content.Seek(0, io.SeekStart)
+		if err != nil {
+			return "", err
+		}
+
+		_, err = s.args.Storage.SaveFile(ctx, getBlobId(hash), content)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		s.logger.WithContext(ctx).Debugf("File '%s' has the same content as an existing blob '%s'", id, hash)
+	}
+
+	return s.args.References.SetReference(ctx, id, hash)
+}
+
+func (s *fileStorage) LoadFile(ctx context.Context, id string) (reader io.ReadCloser, err error) {
+	ctx, span := tracing.StartSpan(ctx, "dedup.LoadFile")
+	defer func() {
+		span.EndWithError(err)
+	}()
+	span.SetAttribute("file.id", id)
+
+	hash, err := s.args.References.GetReference(ctx, id)
+	if err == models.ErrFileNotFound {
+		return s.args.Storage.LoadFile(ctx, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.args.Storage.LoadFile(ctx, getBlobId(hash))
+}
+
+func (s *fileStorage) DeleteFile(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.StartSpan(ctx, "dedup.DeleteFile")
+	defer func() {
+		span.EndWithError(err)
+	}()
+	span.SetAttribute("file.id", id)
+
+	hash, err := s.args.References.GetReference(ctx, id)
+	if err == models.ErrFileNotFound {
+		return s.args.Storage.DeleteFile(ctx, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	unlock := s.lockHash(hash)
+
+	removedHash, err := s.args.References.RemoveReference(ctx, id)
+	if err != nil {
+		unlock()
+		return err
+	}
+
+	// The file was overwritten while waiting for the lock
+	if removedHash != hash {
+		unlock()
+		unlock = s.lockHash(removedHash)
+	}
+	defer unlock()
+
+	return s.deleteUnreferencedBlob(ctx, removedHash)
+}
+
+// Deletes the blob if no files refer to it. Expects the hash to be locked
+func (s *fileStorage) deleteUnreferencedBlob(ctx context.Context, hash string) error {
+	references, err := s.args.References.CountReferences(ctx, hash)
+	if err != nil {
+		return err
+	}
+
+	if references > 0 {
+		return nil
+	}
+
+	s.logger.WithContext(ctx).Debugf("Deleting blob '%s', as nothing refers to it anymore", hash)
+
+	err = s.args.Storage.DeleteFile(ctx, getBlobId(hash))
+	if err == models.ErrFileNotFound {
+		return nil
+	}
+	return err
+}
+
+// Lists the files that refer to blobs, the files stored before deduplication was enabled,
+// and the blobs no files refer to anymore, so they can be found and deleted
+func (s *fileStorage) ListFiles(ctx context.Context) ([]models.StoredFile, error) {
+	lister, ok := s.args.Storage.(models.FileLister)
+	if !ok {
+		return nil, models.ErrListingNotSupported
+	}
+
+	stored, err := lister.ListFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	references, err := s.args.References.GetAllReferences(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs := make(map[string]models.StoredFile, len(stored))
+	files := make([]models.StoredFile, 0, len(stored)+len(references))
+	for _, file := range stored {
+		if strings.HasPrefix(file.Id, getBlobId("")) {
+			blobs[file.Id] = file
+		} else {
+			files = append(files, file)
+		}
+	}
+
+	referenced := map[string]bool{}
+	for _, reference := range references {
+		blob, exists := blobs[getBlobId(reference.Hash)]
+		if !exists {
+			// The blob is missing, which shows as the file missing
+			continue
+		}
+		referenced[blob.Id] = true
+
+		files = append(files, models.StoredFile{
+			Id:       reference.FileId,
+			Size:     blob.Size,
+			Modified: reference.Created,
+		})
+	}
+
+	// Blobs that are still left, are not referred to by any files
+	for id, blob := range blobs {
+		if !referenced[id] {
+			files = append(files, blob)
+		}
+	}
+
+	return files, nil
+}
+
+func (s *fileStorage) GetDownloadUrl(ctx context.Context, id, contentType, contentDisposition string) (string, error) {
+	provider, ok := s.args.Storage.(models.DownloadUrlProvider)
+	if !ok {
+		return "", models.ErrDownloadUrlNotSupported
+	}
+
+	hash, err := s.args.References.GetReference(ctx, id)
+	if err == models.ErrFileNotFound {
+		return provider.GetDownloadUrl(ctx, id, contentType, contentDisposition)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return provider.GetDownloadUrl(ctx, getBlobId(hash), contentType, contentDisposition)
+}
@@ -0,0 +1,202 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"github.com/zlepper/welp/internal/pkg/flatfile"
+	"github.com/zlepper/welp/internal/pkg/logging"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errCrashed = errors.New("crashed")
+
+// Fails to save references while crashed is set, like welp stopping right after the blob was written
+type crashingReferenceStorage struct {
+	models.BlobReferenceStorage
+	crashed bool
+}
+
+func (s *crashingReferenceStorage) SetReference(ctx context.Context, fileId, hash string) (string, error) {
+	if s.crashed {
+		return "", errCrashed
+	}
+	return s.BlobReferenceStorage.SetReference(ctx, fileId, hash)
+}
+
+type testStorage struct {
+	models.FileStorage
+	blobs      models.FileStorage
+	references *crashingReferenceStorage
+	// Holds everything, so it can be removed after the test
+	folder string
+}
+
+func newTestStorage(t *testing.T, ctx context.Context) *testStorage {
+	folder, err := ioutil.TempDir("", "welp-dedup-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logging.NewLogger(logging.LoggerArgs{})
+
+	blobs, err := flatfile.NewFileStorage(flatfile.FileStorageArgs{
+		FolderPath: filepath.Join(folder, "blobs"),
+		Logger:     logger,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	references, err := flatfile.NewBlobReferenceStorage(ctx, flatfile.BlobReferenceStorageArgs{
+		Filename:     filepath.Join(folder, "blobs.json"),
+		SaveInterval: time.Hour,
+		Logger:       logger,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crashing := &crashingReferenceStorage{BlobReferenceStorage: references}
+
+	return &testStorage{
+		FileStorage: NewFileStorage(FileStorageArgs{
+			Storage:    blobs,
+			References: crashing,
+			TempFolder: folder,
+			Logger:     logger,
+		}),
+		blobs:      blobs,
+		references: crashing,
+		folder:     folder,
+	}
+}
+
+func (s *testStorage) save(t *testing.T, ctx context.Context, id, content string) {
+	if _, err := s.SaveFile(ctx, id, strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *testStorage) load(t *testing.T, ctx context.Context, id string) string {
+	reader, err := s.LoadFile(ctx, id)
+	if err != nil {
+		t.Fatalf("failed to load '%s': %v", id, err)
+	}
+	defer reader.Close()
+
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+// Gets the ids of the blobs that are actually stored
+func (s *testStorage) storedBlobs(t *testing.T, ctx context.Context) []string {
+	files, err := s.blobs.(models.FileLister).ListFiles(ctx)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	ids := make([]string, 0, len(files))
+	for _, file := range files {
+		ids = append(ids, file.Id)
+	}
+	return ids
+}
+
+func TestSharedBlobs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newTestStorage(t, ctx)
+	defer os.RemoveAll(storage.folder)
+
+	storage.save(t, ctx, "a.txt", "content")
+	storage.save(t, ctx, "b.txt", "content")
+
+	if blobs := storage.storedBlobs(t, ctx); len(blobs) != 1 {
+		t.Fatalf("expected the content to be stored once, got %v", blobs)
+	}
+
+	if err := storage.DeleteFile(ctx, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.LoadFile(ctx, "a.txt"); err != models.ErrFileNotFound {
+		t.Errorf("expected the deleted file to be gone, got %v", err)
+	}
+	if content := storage.load(t, ctx, "b.txt"); content != "content" {
+		t.Errorf("the file sharing the blob was changed: '%s'", content)
+	}
+	if blobs := storage.storedBlobs(t, ctx); len(blobs) != 1 {
+		t.Fatalf("the blob was deleted while another file still refers to it: %v", blobs)
+	}
+
+	if err := storage.DeleteFile(ctx, "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if blobs := storage.storedBlobs(t, ctx); len(blobs) != 0 {
+		t.Errorf("the blob was not deleted with the last reference: %v", blobs)
+	}
+}
+
+func TestOverwriteDeletesOldBlob(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newTestStorage(t, ctx)
+	defer os.RemoveAll(storage.folder)
+
+	storage.save(t, ctx, "a.txt", "old")
+	storage.save(t, ctx, "a.txt", "new")
+
+	if content := storage.load(t, ctx, "a.txt"); content != "new" {
+		t.Errorf("expected the new content, got '%s'", content)
+	}
+	if blobs := storage.storedBlobs(t, ctx); len(blobs) != 1 {
+		t.Errorf("expected only the new blob to be stored, got %v", blobs)
+	}
+}
+
+func TestCrashBetweenBlobAndReference(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newTestStorage(t, ctx)
+	defer os.RemoveAll(storage.folder)
+
+	storage.references.crashed = true
+	if _, err := storage.SaveFile(ctx, "a.txt", strings.NewReader("content")); err != errCrashed {
+		t.Fatalf("expected the save to fail, got %v", err)
+	}
+	storage.references.crashed = false
+
+	blobs := storage.storedBlobs(t, ctx)
+	if len(blobs) != 1 {
+		t.Fatalf("expected the blob to be written before the reference, got %v", blobs)
+	}
+
+	// The blob nothing refers to is listed, so the consistency check can find it
+	files, err := storage.FileStorage.(models.FileLister).ListFiles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Id != blobs[0] {
+		t.Errorf("expected only the unreferenced blob to be listed, got %v", files)
+	}
+
+	// Saving the content again reuses the blob, and it's cleaned up with the file
+	storage.save(t, ctx, "a.txt", "content")
+	if content := storage.load(t, ctx, "a.txt"); content != "content" {
+		t.Errorf("expected the content to be saved again, got '%s'", content)
+	}
+	if err := storage.DeleteFile(ctx, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if blobs := storage.storedBlobs(t, ctx); len(blobs) != 0 {
+		t.Errorf("the blob was not deleted with the file: %v", blobs)
+	}
+}
@@ -23,21 +23,37 @@
 package email
 
 import (
+	"context"
+	"github.com/zlepper/welp/internal/pkg/metrics"
 	"github.com/zlepper/welp/internal/pkg/models"
-	"log"
 )
 
+type NoOpEmailServiceArgs struct {
+	Logger models.Logger
+}
+
 // Creates an email service that doesn't do anything
 // Useful if you don't ever want emails to leave the system
-func NewNoOpEmailService() models.EmailService {
-	return &noOpEmailService{}
+func NewNoOpEmailService(args NoOpEmailServiceArgs) models.EmailService {
+	return &noOpEmailService{
+		logger: args.Logger,
+	}
+}
+
+// Tells if the email service actually sends the emails somewhere
+func IsSending(service models.EmailService) bool {
+	_, noOp := service.(*noOpEmailService)
+	return !noOp
 }
 
 type noOpEmailService struct {
+	logger models.Logger
 }
 
-func (*noOpEmailService) SendEmail(args models.SendEmailArgs) error {
-	log.Printf("NoOp sending email: %v\n", args)
+// Only logs who the email is to and the subject, as the content can contain links with tokens
+func (s *noOpEmailService) SendEmail(ctx context.Context, args models.SendEmailArgs) error {
+	s.logger.WithContext(ctx).Debugf("NoOp sending email '%s' to '%s'", args.Subject, args.To.Address)
+	metrics.EmailSkipped()
 
 	return nil
 }
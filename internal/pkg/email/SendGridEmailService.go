@@ -23,9 +23,15 @@
 package email
 
 import (
+	"context"
+	"fmt"
+	"github.com/sendgrid/rest"
 	"github.com/sendgrid/sendgrid-go"
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
+	"github.com/zlepper/welp/internal/pkg/metrics"
 	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/tracing"
+	"net/http"
 )
 
 type SendGridEmailServiceArgs struct {
@@ -38,15 +44,27 @@ func NewSendGridEmailService(args SendGridEmailServiceArgs) (models.EmailService
 	return &sendGridEmailService{
 		logger: args.Logger,
 		apiKey: args.ApiKey,
+		// The requests go through the tracing transport, so they continue the trace
+		client: &rest.Client{
+			HTTPClient: &http.Client{
+				Transport: tracing.NewTransport(nil),
+			},
+		},
 	}, nil
 }
 
 type sendGridEmailService struct {
 	logger models.Logger
 	apiKey string
+	client *rest.Client
 }
 
-func (s *sendGridEmailService) SendEmail(args models.SendEmailArgs) error {
+func (s *sendGridEmailService) SendEmail(ctx context.Context, args models.SendEmailArgs) (err error) {
+	ctx, span := tracing.StartClientSpan(ctx, "sendgrid.send")
+	defer func() {
+		metrics.EmailSent(err)
+		span.EndWithError(err)
+	}()
 
 	from := mail.NewEmail(args.From.Name, args.From.Address)
 	var replyTo *mail.Email
@@ -63,13 +81,21 @@ func (s *sendGridEmailService) SendEmail(args models.SendEmailArgs) error {
 	message := mail.NewSingleEmail(from, subject, to, plainTextContent, htmlContent)
 
 	message.SetReplyTo(replyTo)
-	client := sendgrid.NewSendClient(s.apiKey)
+	request := sendgrid.GetRequest(s.apiKey, "/v3/mail/send", "")
+	request.Method = rest.Post
+	request.Body = mail.GetRequestBody(message)
 
-	_, err := client.Send(message)
+	response, err := s.client.SendWithContext(ctx, request)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	span.SetAttribute("http.status_code", response.StatusCode)
+
+	// Sendgrid only returns an error if it couldn't be reached, rejected emails are told by the status code
+	if response.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("sendgrid rejected the email with status %d: %s", response.StatusCode, response.Body)
+	}
 
+	return nil
 }
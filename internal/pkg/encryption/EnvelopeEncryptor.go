@@ -0,0 +1,481 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var (
+	ErrInvalidMasterKey  = errors.New("the master key should be 32 random bytes, encoded as base64")
+	ErrMasterKeyRequired = errors.New("the data is encrypted, but no master key was given")
+	ErrWrongMasterKey    = errors.New("the data is encrypted with a different master key")
+	ErrCorruptedData     = errors.New("the encrypted data is corrupted")
+	ErrWriterClosed      = errors.New("the encrypting writer has been closed")
+	ErrSeekNotSupported  = errors.New("the underlying reader can't seek")
+	ErrInvalidSeekOffset = errors.New("invalid seek offset")
+)
+
+// The environment variable the master key is read from, if no key file is given
+const MasterKeyEnvironmentVariable = "WELP_MASTER_KEY"
+
+const (
+	// Put in front of all encrypted data, so it can be told apart from data stored before encryption was enabled
+	magic = "WELPENC\x01"
+
+	keySize   = 32
+	keyIdSize = 8
+	nonceSize = 12
+	tagSize   = 16
+
+	// The magic, the id of the master key, and the data key encrypted with the master key
+	headerSize = len(magic) + keyIdSize + nonceSize + keySize + tagSize
+
+	// The data is encrypted in chunks, so large files can be streamed, and read from the middle
+	chunkSize          = 64 * 1024
+	encryptedChunkSize = chunkSize + tagSize
+)
+
+// Loads the master key from the file. If no file is given, the key
+// is read from the environment variable instead.
+// Returns nil if neither are set, meaning nothing should be encrypted
+func LoadMasterKey(filename, environmentVariable string) ([]byte, error) {
+	var encoded string
+	if filename != "" {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(content)
+	} else {
+		encoded = os.Getenv(environmentVariable)
+	}
+
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != keySize {
+		return nil, ErrInvalidMasterKey
+	}
+
+	return key, nil
+}
+
+// Creates an encryptor that uses envelope encryption. Every file is encrypted with its own
+// random data key using AES-GCM, and the data key is stored next to the data, encrypted with the master key.
+// This way the master key can be rotated, without having to encrypt all the data again.
+func NewEnvelopeEncryptor(masterKey []byte) (models.Encryptor, error) {
+	if len(masterKey) != keySize {
+		return nil, ErrInvalidMasterKey
+	}
+
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &envelopeEncryptor{
+		masterKey: aead,
+		keyId:     getKeyId(masterKey),
+	}, nil
+}
+
+// Creates an encryptor that doesn't encrypt anything, but refuses to read encrypted data
+func NewPlaintextEncryptor() models.Encryptor {
+	return &envelopeEncryptor{}
+}
+
+type envelopeEncryptor struct {
+	// Nil if nothing should be encrypted
+	masterKey cipher.AEAD
+	keyId     []byte
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Identifies the master key, so it's possible to tell which key the data was encrypted with
+func getKeyId(masterKey []byte) []byte {
+	hash := sha256.Sum256(masterKey)
+	return hash[:keyIdSize]
+}
+
+// Encrypts the data key with the master key
+func (e *envelopeEncryptor) makeHeader(dataKey []byte) ([]byte, error) {
+	header := make([]byte, 0, headerSize)
+	header = append(header, magic...)
+	header = append(header, e.keyId...)
+
+	nonce := make([]byte, nonceSize)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	header = append(header, nonce...)
+
+	// The start of the header is authenticated as well, so the key id can't be swapped
+	return e.masterKey.Seal(header, nonce, dataKey, header[:len(magic)+keyIdSize]), nil
+}
+
+// Decrypts the data key from the header
+func (e *envelopeEncryptor) openHeader(header []byte) ([]byte, error) {
+	if e.masterKey == nil {
+		return nil, ErrMasterKeyRequired
+	}
+
+	keyId := header[len(magic) : len(magic)+keyIdSize]
+	if !bytes.Equal(keyId, e.keyId) {
+		return nil, ErrWrongMasterKey
+	}
+
+	nonceStart := len(magic) + keyIdSize
+	nonce := header[nonceStart : nonceStart+nonceSize]
+	dataKey, err := e.masterKey.Open(nil, nonce, header[nonceStart+nonceSize:], header[:nonceStart])
+	if err != nil {
+		return nil, ErrCorruptedData
+	}
+
+	return dataKey, nil
+}
+
+func (e *envelopeEncryptor) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	if e.masterKey == nil {
+		return nopWriteCloser{w}, nil
+	}
+
+	dataKey := make([]byte, keySize)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := e.makeHeader(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Write(header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptingWriter{
+		w:      w,
+		aead:   aead,
+		buffer: make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (e *envelopeEncryptor) Decrypt(r io.Reader) (io.Reader, error) {
+	reader := bufio.NewReaderSize(r, encryptedChunkSize+1)
+	seeker, canSeek := r.(io.Seeker)
+
+	start, err := reader.Peek(len(magic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if string(start) != magic {
+		// Stored before encryption was enabled
+		if canSeek {
+			_, err = seeker.Seek(0, io.SeekStart)
+			return r, err
+		}
+		return reader, nil
+	}
+
+	header := make([]byte, headerSize)
+	_, err = io.ReadFull(reader, header)
+	if err != nil {
+		return nil, ErrCorruptedData
+	}
+
+	dataKey, err := e.openHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypting := &decryptingReader{
+		source: r,
+		reader: reader,
+		aead:   aead,
+		chunk:  make([]byte, encryptedChunkSize),
+	}
+
+	if canSeek {
+		return &seekableDecryptingReader{
+			decryptingReader: decrypting,
+			seeker:           seeker,
+			size:             -1,
+		}, nil
+	}
+
+	return decrypting, nil
+}
+
+// The nonce of each chunk is its number, and a flag telling if it's the last chunk.
+// This way chunks can't be reordered, and the data can't be cut short without it being noticed
+// The data key is never reused, so the nonces doesn't have to be random
+func getChunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, nonceSize)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+type encryptingWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buffer  []byte
+	counter uint64
+	closed  bool
+}
+
+func (w *encryptingWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrWriterClosed
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only written once more data arrives, as the last chunk has to be marked
+		if len(w.buffer) == chunkSize {
+			err := w.writeChunk(false)
+			if err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(w.buffer[len(w.buffer):chunkSize], p)
+		w.buffer = w.buffer[:len(w.buffer)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+func (w *encryptingWriter) writeChunk(last bool) error {
+	encrypted := w.aead.Seal(nil, getChunkNonce(w.counter, last), w.buffer, nil)
+	w.counter++
+	w.buffer = w.buffer[:0]
+
+	_, err := w.w.Write(encrypted)
+	return err
+}
+
+func (w *encryptingWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	return w.writeChunk(true)
+}
+
+type decryptingReader struct {
+	source io.Reader
+	reader *bufio.Reader
+	aead   cipher.AEAD
+
+	// The encrypted chunk being read
+	chunk []byte
+	// What is left of the decrypted chunk
+	plaintext []byte
+	counter   uint64
+	done      bool
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	for len(r.plaintext) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		err := r.readChunk()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.plaintext)
+	r.plaintext = r.plaintext[n:]
+	return n, nil
+}
+
+func (r *decryptingReader) readChunk() error {
+	n, err := io.ReadFull(r.reader, r.chunk)
+	last := false
+	switch err {
+	case nil:
+		// The chunk is only the last one if nothing follows it
+		_, err = r.reader.Peek(1)
+		if err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	case io.ErrUnexpectedEOF:
+		last = true
+	case io.EOF:
+		// The last chunk is always there, even when it's empty
+		return ErrCorruptedData
+	default:
+		return err
+	}
+
+	plaintext, err := r.aead.Open(r.chunk[:0], getChunkNonce(r.counter, last), r.chunk[:n], nil)
+	if err != nil {
+		return ErrCorruptedData
+	}
+
+	r.plaintext = plaintext
+	r.counter++
+	r.done = last
+
+	return nil
+}
+
+type seekableDecryptingReader struct {
+	*decryptingReader
+	seeker io.Seeker
+
+	// The position in the decrypted data
+	position int64
+	// The size of the decrypted data, -1 until it's needed
+	size int64
+}
+
+func (r *seekableDecryptingReader) Read(p []byte) (int, error) {
+	n, err := r.decryptingReader.Read(p)
+	r.position += int64(n)
+	return n, err
+}
+
+func (r *seekableDecryptingReader) getSize() (int64, error) {
+	if r.size >= 0 {
+		return r.size, nil
+	}
+
+	end, err := r.seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	encrypted := end - int64(headerSize)
+	chunks := (encrypted + encryptedChunkSize - 1) / encryptedChunkSize
+	if chunks == 0 || encrypted < chunks*tagSize {
+		return 0, ErrCorruptedData
+	}
+
+	r.size = encrypted - chunks*tagSize
+	return r.size, nil
+}
+
+func (r *seekableDecryptingReader) Seek(offset int64, whence int) (int64, error) {
+	size, err := r.getSize()
+	if err != nil {
+		return 0, err
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.position
+	case io.SeekEnd:
+		offset += size
+	default:
+		return 0, ErrInvalidSeekOffset
+	}
+
+	if offset < 0 {
+		return 0, ErrInvalidSeekOffset
+	}
+
+	r.position = offset
+	r.plaintext = nil
+
+	if offset >= size {
+		r.done = true
+		return offset, nil
+	}
+
+	chunk := offset / chunkSize
+	_, err = r.seeker.Seek(int64(headerSize)+chunk*encryptedChunkSize, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	r.reader.Reset(r.source)
+	r.counter = uint64(chunk)
+	r.done = false
+
+	err = r.readChunk()
+	if err != nil {
+		return 0, err
+	}
+	r.plaintext = r.plaintext[offset%chunkSize:]
+
+	return offset, nil
+}
@@ -0,0 +1,178 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newKey(t *testing.T) []byte {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func encrypt(t *testing.T, key, data []byte) []byte {
+	encryptor, err := NewEnvelopeEncryptor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buffer bytes.Buffer
+	writer, err := encryptor.Encrypt(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+func decrypt(key, data []byte) ([]byte, error) {
+	encryptor, err := NewEnvelopeEncryptor(key)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := encryptor.Decrypt(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(reader)
+}
+
+func TestRoundTrip(t *testing.T) {
+	key := newKey(t)
+
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 17} {
+		data := make([]byte, size)
+		rand.Read(data)
+
+		encrypted := encrypt(t, key, data)
+		if size > 16 && bytes.Contains(encrypted, data[:16]) {
+			t.Errorf("size %d: data was not encrypted", size)
+		}
+
+		decrypted, err := decrypt(key, encrypted)
+		if err != nil {
+			t.Errorf("size %d: %v", size, err)
+		} else if !bytes.Equal(decrypted, data) {
+			t.Errorf("size %d: decrypted data doesn't match", size)
+		}
+	}
+}
+
+func TestTamperedData(t *testing.T) {
+	key := newKey(t)
+	data := make([]byte, 2*chunkSize+5)
+	encrypted := encrypt(t, key, data)
+
+	if _, err := decrypt(newKey(t), encrypted); err != ErrWrongMasterKey {
+		t.Errorf("expected wrong master key error, got %v", err)
+	}
+
+	// Cutting off the last chunk should be noticed, even though the rest is intact
+	if _, err := decrypt(key, encrypted[:headerSize+2*encryptedChunkSize]); err != ErrCorruptedData {
+		t.Errorf("expected truncated data to be rejected, got %v", err)
+	}
+
+	flipped := append([]byte{}, encrypted...)
+	flipped[headerSize+10] ^= 1
+	if _, err := decrypt(key, flipped); err != ErrCorruptedData {
+		t.Errorf("expected modified data to be rejected, got %v", err)
+	}
+
+	reader, err := NewPlaintextEncryptor().Decrypt(bytes.NewReader(encrypted))
+	if err != ErrMasterKeyRequired {
+		t.Errorf("expected master key to be required, got %v, %v", reader, err)
+	}
+}
+
+func TestPlaintextIsReadAsIs(t *testing.T) {
+	data := []byte("{\"plain\": true}")
+
+	decrypted, err := decrypt(newKey(t), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Errorf("expected plaintext to be returned as it is, got %q", decrypted)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	key := newKey(t)
+	data := make([]byte, 3*chunkSize+100)
+	rand.Read(data)
+
+	encryptor, _ := NewEnvelopeEncryptor(key)
+	reader, err := encryptor.Decrypt(bytes.NewReader(encrypt(t, key, data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeker := reader.(io.ReadSeeker)
+
+	size, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil || size != int64(len(data)) {
+		t.Fatalf("expected size %d, got %d, %v", len(data), size, err)
+	}
+
+	for _, offset := range []int64{0, 5, chunkSize, 2*chunkSize + 7, size - 1} {
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+
+		buffer := make([]byte, 50)
+		n, _ := io.ReadFull(seeker, buffer)
+		if !bytes.Equal(buffer[:n], data[offset:offset+int64(n)]) || n == 0 {
+			t.Errorf("wrong data after seeking to %d", offset)
+		}
+	}
+}
+
+func TestRekeyFile(t *testing.T) {
+	folder, err := ioutil.TempDir("", "welp-rekey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	oldKey, nextKey := newKey(t), newKey(t)
+	data := make([]byte, chunkSize+3)
+	rand.Read(data)
+
+	encryptedFile := filepath.Join(folder, "encrypted")
+	plainFile := filepath.Join(folder, "plain")
+	ioutil.WriteFile(encryptedFile, encrypt(t, oldKey, data), 0600)
+	ioutil.WriteFile(plainFile, data, 0600)
+
+	for _, filename := range []string{encryptedFile, plainFile} {
+		changed, err := RekeyFile(filename, oldKey, nextKey)
+		if err != nil || !changed {
+			t.Fatalf("%s: expected file to be changed, got %v, %v", filename, changed, err)
+		}
+
+		content, _ := ioutil.ReadFile(filename)
+		decrypted, err := decrypt(nextKey, content)
+		if err != nil || !bytes.Equal(decrypted, data) {
+			t.Errorf("%s: can't be decrypted with the new key: %v", filename, err)
+		}
+
+		changed, err = RekeyFile(filename, oldKey, nextKey)
+		if err != nil || changed {
+			t.Errorf("%s: expected nothing to change the second time, got %v, %v", filename, changed, err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package encryption
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Files are encrypted into a temp file next to them, named after the file with this and a random suffix
+const encryptingTempFileMarker = ".encrypting-"
+
+// Returns true if the file is a temp file a file is being encrypted into
+func IsEncryptingTempFile(name string) bool {
+	return strings.Contains(filepath.Base(name), encryptingTempFileMarker)
+}
+
+// Makes the file readable with the new master key. Files that are already encrypted only get their
+// data key encrypted again, while files that are not encrypted yet are encrypted entirely.
+// The old master key can be nil, if nothing has been encrypted before.
+// Returns true if the file was changed
+func RekeyFile(filename string, oldMasterKey, newMasterKey []byte) (bool, error) {
+	newEncryptor, err := NewEnvelopeEncryptor(newMasterKey)
+	if err != nil {
+		return false, err
+	}
+	newEnvelope := newEncryptor.(*envelopeEncryptor)
+
+	oldEnvelope := &envelopeEncryptor{}
+	if oldMasterKey != nil {
+		oldEncryptor, err := NewEnvelopeEncryptor(oldMasterKey)
+		if err != nil {
+			return false, err
+		}
+		oldEnvelope = oldEncryptor.(*envelopeEncryptor)
+	}
+
+	file, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+
+	if n < len(magic) || string(header[:len(magic)]) != magic {
+		file.Close()
+		return true, encryptFile(filename, newEnvelope)
+	}
+
+	if n < headerSize {
+		return false, ErrCorruptedData
+	}
+
+	// Already done, e.g. because an earlier rekey was interrupted
+	if bytes.Equal(header[len(magic):len(magic)+keyIdSize], newEnvelope.keyId) {
+		return false, nil
+	}
+
+	dataKey, err := oldEnvelope.openHeader(header)
+	if err != nil {
+		return false, err
+	}
+
+	newHeader, err := newEnvelope.makeHeader(dataKey)
+	if err != nil {
+		return false, err
+	}
+
+	// The header has the same size, so only it has to be replaced
+	_, err = file.WriteAt(newHeader, 0)
+	if err != nil {
+		return false, err
+	}
+
+	err = file.Sync()
+	if err != nil {
+		return false, err
+	}
+
+	return true, file.Close()
+}
+
+// Encrypts the whole file. The file is written to a temp file first, so it's never half encrypted
+func encryptFile(filename string, encryptor *envelopeEncryptor) error {
+	source, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	temp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+encryptingTempFileMarker)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	writer, err := encryptor.Encrypt(temp)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(writer, source)
+	if err != nil {
+		return err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	err = temp.Close()
+	if err != nil {
+		return err
+	}
+	source.Close()
+
+	return os.Rename(temp.Name(), filename)
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package export
+
+import (
+	"encoding/csv"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var csvHeader = []string{
+	"id",
+	"created",
+	"contactAddress",
+	"message",
+	"attachments",
+	"quarantined",
+	"quarantineReason",
+	"classification",
+	"archived",
+	"trashed",
+	"anonymised",
+}
+
+// Writes the feedback as csv, with a header row and a row per feedback
+func NewCSVWriter(writer io.Writer) FeedbackWriter {
+	return &csvWriter{
+		writer: csv.NewWriter(writer),
+	}
+}
+
+type csvWriter struct {
+	writer        *csv.Writer
+	headerWritten bool
+}
+
+func (w *csvWriter) writeHeader() error {
+	if w.headerWritten {
+		return nil
+	}
+	w.headerWritten = true
+	return w.writer.Write(csvHeader)
+}
+
+func (w *csvWriter) Write(feedback models.Feedback) error {
+	err := w.writeHeader()
+	if err != nil {
+		return err
+	}
+
+	return w.writer.Write([]string{
+		feedback.Id,
+		feedback.Created.Format(timeFormat),
+		escapeFormula(feedback.ContactAddress),
+		escapeFormula(feedback.Message),
+		escapeFormula(getFileNames(feedback.Files)),
+		strconv.FormatBool(feedback.Quarantined),
+		escapeFormula(feedback.QuarantineReason),
+		string(feedback.Classification),
+		strconv.FormatBool(feedback.Archived),
+		strconv.FormatBool(feedback.Trashed),
+		strconv.FormatBool(feedback.Anonymised),
+	})
+}
+
+func (w *csvWriter) Close() error {
+	// An empty list still gets the header, so it's clear what the file is
+	err := w.writeHeader()
+	if err != nil {
+		return err
+	}
+
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// Spreadsheets runs cells starting with these as formulas
+const formulaPrefixes = "=+-@\t\r"
+
+// Makes sure values from users are shown as text in spreadsheets, instead of being run as formulas
+func escapeFormula(value string) string {
+	if value != "" && strings.ContainsRune(formulaPrefixes, rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,67 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"testing"
+	"time"
+)
+
+func TestCSVWriter(t *testing.T) {
+	var buffer bytes.Buffer
+	writer := NewCSVWriter(&buffer)
+
+	err := writer.Write(models.Feedback{
+		Id:             "a",
+		Message:        "=HYPERLINK(\"http://example.com\")\nsecond line, with a comma",
+		ContactAddress: "user@example.com",
+		Created:        time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC),
+		Files: []models.File{
+			{Id: "1.png", OriginalName: "screenshot.png"},
+			{Id: "2.txt"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buffer).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 2 {
+		t.Fatalf("expected a header and a row, got %v", rows)
+	}
+
+	row := rows[1]
+	if row[1] != "2018-01-02T03:04:05Z" {
+		t.Errorf("unexpected created time %s", row[1])
+	}
+	if row[3] != "'=HYPERLINK(\"http://example.com\")\nsecond line, with a comma" {
+		t.Errorf("expected the formula to be escaped, got %s", row[3])
+	}
+	if row[4] != "screenshot.png; 2.txt" {
+		t.Errorf("unexpected attachments %s", row[4])
+	}
+}
+
+func TestCSVWriterWritesHeaderForEmptyList(t *testing.T) {
+	var buffer bytes.Buffer
+	writer := NewCSVWriter(&buffer)
+
+	err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buffer.String() != "id,created,contactAddress,message,attachments,quarantined,quarantineReason,classification,archived,trashed,anonymised\n" {
+		t.Errorf("unexpected output %q", buffer.String())
+	}
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package export
+
+import (
+	"github.com/zlepper/welp/internal/pkg/models"
+	"strings"
+	"time"
+)
+
+// Writes feedback one entry at a time, so long lists are never kept in memory in their written form
+type FeedbackWriter interface {
+	Write(feedback models.Feedback) error
+	// Writes anything that is still buffered. Must be called after the last feedback has been written
+	Close() error
+}
+
+// The format the times are written in
+const timeFormat = time.RFC3339
+
+// Gets the names of the attached files, joined by "; "
+func getFileNames(files []models.File) string {
+	names := make([]string, len(files))
+	for index, file := range files {
+		names[index] = file.GetName()
+	}
+	return strings.Join(names, "; ")
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"io"
+	"strings"
+)
+
+// Writes the feedback as a markdown document, with a section per feedback
+func NewMarkdownWriter(writer io.Writer) FeedbackWriter {
+	return &markdownWriter{
+		writer: bufio.NewWriter(writer),
+	}
+}
+
+type markdownWriter struct {
+	writer        *bufio.Writer
+	headerWritten bool
+}
+
+func (w *markdownWriter) writeHeader() {
+	if w.headerWritten {
+		return
+	}
+	w.headerWritten = true
+	w.writer.WriteString("# Feedback\n")
+}
+
+func (w *markdownWriter) Write(feedback models.Feedback) error {
+	w.writeHeader()
+
+	from := "no contact address"
+	if feedback.ContactAddress != "" {
+		from = escapeMarkdown(feedback.ContactAddress)
+	}
+
+	fmt.Fprintf(w.writer, "\n## %s, from %s\n\n", feedback.Created.Format(timeFormat), from)
+
+	// The message is quoted, so whatever markdown it contains stays inside the quote
+	for _, line := range strings.Split(feedback.Message, "\n") {
+		fmt.Fprintf(w.writer, "> %s\n", strings.TrimRight(line, "\r"))
+	}
+
+	if len(feedback.Files) > 0 {
+		w.writer.WriteString("\nAttachments:\n\n")
+		for _, file := range feedback.Files {
+			fmt.Fprintf(w.writer, "- %s (%s, %d bytes)\n", escapeMarkdown(file.GetName()), file.ContentType, file.Size)
+		}
+	}
+
+	if status := getStatus(feedback); status != "" {
+		fmt.Fprintf(w.writer, "\n_%s_\n", status)
+	}
+
+	return nil
+}
+
+// Describes where the feedback is, if it isn't in the normal list
+func getStatus(feedback models.Feedback) string {
+	statuses := make([]string, 0)
+	if feedback.Quarantined {
+		statuses = append(statuses, "In quarantine: "+escapeMarkdown(feedback.QuarantineReason))
+	}
+	if feedback.Archived {
+		statuses = append(statuses, "Archived")
+	}
+	if feedback.Trashed {
+		statuses = append(statuses, "In the trash")
+	}
+	if feedback.Anonymised {
+		statuses = append(statuses, "Anonymised")
+	}
+	return strings.Join(statuses, ", ")
+}
+
+func (w *markdownWriter) Close() error {
+	w.writeHeader()
+	return w.writer.Flush()
+}
+
+var markdownEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"*", "\\*",
+	"_", "\\_",
+	"`", "\\`",
+	"[", "\\[",
+	"]", "\\]",
+	"<", "\\<",
+	">", "\\>",
+	"#", "\\#",
+	"\n", " ",
+	"\r", "",
+)
+
+// Makes sure text from users is shown as text, and not as markdown
+func escapeMarkdown(value string) string {
+	return markdownEscaper.Replace(value)
+}
@@ -25,6 +25,7 @@ package flatfile
 import (
 	"context"
 	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/tracing"
 	"sync"
 	"time"
 )
@@ -38,6 +39,9 @@ type AuthorizationDataStorageArgs struct {
 
 	// A logger for logging information
 	Logger models.Logger
+
+	// Encrypts the data file. If nil, the data is saved as plaintext
+	Encryptor models.Encryptor
 }
 
 func NewAuthorizationDataStorage(ctx context.Context, args AuthorizationDataStorageArgs) (models.AuthorizationDataStorage, error) {
@@ -52,6 +56,7 @@ func NewAuthorizationDataStorage(ctx context.Context, args AuthorizationDataStor
 		saveInterval: args.SaveInterval,
 		saveable:     storage,
 		filename:     args.Filename,
+		encryptor:    args.Encryptor,
 	})
 
 	err := saver.LoadData(&storage.data)
@@ -60,7 +65,7 @@ func NewAuthorizationDataStorage(ctx context.Context, args AuthorizationDataStor
 		return nil, err
 	}
 
-	go saver.StartSaveCycle(ctx)
+	saver.Start(ctx)
 
 	return storage, nil
 }
@@ -73,7 +78,10 @@ type authorizationDataStorage struct {
 }
 
 func (s *authorizationDataStorage) GetUser(ctx context.Context, email string) (models.User, error) {
-	s.lock.RLock()
+	_, span := tracing.StartSpan(ctx, "flatfile.GetUser")
+	defer span.End()
+
+	lockTraced(span, s.lock.RLocker())
 	defer s.lock.RUnlock()
 
 	user, exists := s.data[email]
@@ -85,7 +93,10 @@ func (s *authorizationDataStorage) GetUser(ctx context.Context, email string) (m
 }
 
 func (s *authorizationDataStorage) CreateUser(ctx context.Context, user models.User) error {
-	s.Lock()
+	_, span := tracing.StartSpan(ctx, "flatfile.CreateUser")
+	defer span.End()
+
+	lockTraced(span, &s.lock)
 	defer s.Unlock()
 
 	if _, exists := s.data[user.Email]; exists {
@@ -100,7 +111,10 @@ func (s *authorizationDataStorage) CreateUser(ctx context.Context, user models.U
 }
 
 func (s *authorizationDataStorage) GetAllUsers(ctx context.Context) ([]models.User, error) {
-	s.lock.RLock()
+	_, span := tracing.StartSpan(ctx, "flatfile.GetAllUsers")
+	defer span.End()
+
+	lockTraced(span, s.lock.RLocker())
 	defer s.lock.RUnlock()
 
 	out := make([]models.User, len(s.data))
@@ -114,7 +128,10 @@ func (s *authorizationDataStorage) GetAllUsers(ctx context.Context) ([]models.Us
 }
 
 func (s *authorizationDataStorage) DeleteUser(ctx context.Context, email string) error {
-	s.Lock()
+	_, span := tracing.StartSpan(ctx, "flatfile.DeleteUser")
+	defer span.End()
+
+	lockTraced(span, &s.lock)
 	defer s.Unlock()
 
 	delete(s.data, email)
@@ -129,7 +146,10 @@ func (s *authorizationDataStorage) GetUserCount(ctx context.Context) (int, error
 }
 
 func (s *authorizationDataStorage) UpdateUser(ctx context.Context, email string, user models.User) error {
-	s.Lock()
+	_, span := tracing.StartSpan(ctx, "flatfile.UpdateUser")
+	defer span.End()
+
+	lockTraced(span, &s.lock)
 	defer s.Unlock()
 
 	_, ok := s.data[email]
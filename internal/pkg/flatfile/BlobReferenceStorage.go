@@ -0,0 +1,202 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package flatfile
+
+import (
+	"context"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"sync"
+	"time"
+)
+
+type BlobReferenceStorageArgs struct {
+	// The name of the file to save data to
+	Filename string
+
+	// How often the data changes should be saved
+	SaveInterval time.Duration
+
+	// A logger for logging information
+	Logger models.Logger
+
+	// Encrypts the data file. If nil, the data is saved as plaintext
+	Encryptor models.Encryptor
+}
+
+// Stores which blobs files refer to in a flatfile
+func NewBlobReferenceStorage(ctx context.Context, args BlobReferenceStorageArgs) (models.BlobReferenceStorage, error) {
+	storage := &blobReferenceStorage{
+		data: blobData{
+			Files:      map[string]string{},
+			References: map[string]int{},
+			Created:    map[string]time.Time{},
+		},
+		logger: args.Logger,
+	}
+
+	saver := NewDataSaver(DataSaverArgs{
+		filename:     args.Filename,
+		saveable:     storage,
+		logger:       args.Logger,
+		saveInterval: args.SaveInterval,
+		encryptor:    args.Encryptor,
+	})
+
+	err := saver.LoadData(&storage.data)
+	if err != nil {
+		args.Logger.Errorf("Failed to load stored data: %v", err)
+		return nil, err
+	}
+
+	if storage.data.Files == nil {
+		storage.data.Files = map[string]string{}
+	}
+	if storage.data.References == nil {
+		storage.data.References = map[string]int{}
+	}
+	if storage.data.Created == nil {
+		storage.data.Created = map[string]time.Time{}
+	}
+
+	saver.Start(ctx)
+
+	return storage, nil
+}
+
+type blobData struct {
+	// The hash of the blob each file refers to
+	Files map[string]string `json:"files"`
+	// How many files refers to each blob
+	References map[string]int `json:"references"`
+	// When each file started referring to its blob
+	Created map[string]time.Time `json:"created"`
+}
+
+type blobReferenceStorage struct {
+	lock    sync.RWMutex
+	changed bool
+	logger  models.Logger
+	data    blobData
+}
+
+func (s *blobReferenceStorage) SetReference(ctx context.Context, fileId, hash string) (previousHash string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	previousHash, exists := s.data.Files[fileId]
+	if exists && previousHash == hash {
+		return previousHash, nil
+	}
+
+	if exists {
+		s.removeReference(previousHash)
+	}
+
+	s.data.Files[fileId] = hash
+	s.data.References[hash]++
+	s.data.Created[fileId] = time.Now()
+	s.changed = true
+
+	return previousHash, nil
+}
+
+func (s *blobReferenceStorage) GetReference(ctx context.Context, fileId string) (hash string, err error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	hash, exists := s.data.Files[fileId]
+	if !exists {
+		return "", models.ErrFileNotFound
+	}
+
+	return hash, nil
+}
+
+func (s *blobReferenceStorage) RemoveReference(ctx context.Context, fileId string) (hash string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	hash, exists := s.data.Files[fileId]
+	if !exists {
+		return "", models.ErrFileNotFound
+	}
+
+	delete(s.data.Files, fileId)
+	delete(s.data.Created, fileId)
+	s.removeReference(hash)
+	s.changed = true
+
+	return hash, nil
+}
+
+// Counts down the references to the blob. Expects the lock to be held
+func (s *blobReferenceStorage) removeReference(hash string) {
+	if s.data.References[hash] <= 1 {
+		delete(s.data.References, hash)
+	} else {
+		s.data.References[hash]--
+	}
+}
+
+func (s *blobReferenceStorage) CountReferences(ctx context.Context, hash string) (int, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.data.References[hash], nil
+}
+
+func (s *blobReferenceStorage) GetAllReferences(ctx context.Context) ([]models.BlobReference, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	references := make([]models.BlobReference, 0, len(s.data.Files))
+	for fileId, hash := range s.data.Files {
+		references = append(references, models.BlobReference{
+			FileId:  fileId,
+			Hash:    hash,
+			Created: s.data.Created[fileId],
+		})
+	}
+
+	return references, nil
+}
+
+func (s *blobReferenceStorage) Lock() {
+	s.lock.Lock()
+}
+
+func (s *blobReferenceStorage) Unlock() {
+	s.lock.Unlock()
+}
+
+func (s *blobReferenceStorage) GetData() interface{} {
+	return s.data
+}
+
+func (s *blobReferenceStorage) HasChanged() bool {
+	return s.changed
+}
+
+func (s *blobReferenceStorage) SetChanged(changed bool) {
+	s.changed = changed
+}
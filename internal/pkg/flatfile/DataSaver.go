@@ -25,12 +25,25 @@ package flatfile
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"github.com/zlepper/welp/internal/pkg/encryption"
+	"github.com/zlepper/welp/internal/pkg/metrics"
 	"github.com/zlepper/welp/internal/pkg/models"
 	"os"
 	"path"
+	"sync"
 	"time"
 )
 
+var (
+	// Keeps track of the save cycles that are still running
+	saveCycles sync.WaitGroup
+
+	// The data savers with running save cycles, so they can be locked together
+	runningSavers     []*DataSaver
+	runningSaversLock sync.Mutex
+)
+
 type dataSaveable interface {
 	// Should put the data in readonly mode to avoid mutations during save
 	// Will be invoked before data is saved
@@ -51,14 +64,22 @@ type DataSaverArgs struct {
 	saveInterval time.Duration
 	saveable     dataSaveable
 	logger       models.Logger
+	// Encrypts the data file. If nil, the data is saved as plaintext
+	encryptor models.Encryptor
 }
 
 func NewDataSaver(args DataSaverArgs) *DataSaver {
+	encryptor := args.encryptor
+	if encryptor == nil {
+		encryptor = encryption.NewPlaintextEncryptor()
+	}
+
 	return &DataSaver{
 		filename:     args.filename,
 		saveable:     args.saveable,
 		logger:       args.logger,
 		saveInterval: args.saveInterval,
+		encryptor:    encryptor,
 	}
 }
 
@@ -67,6 +88,92 @@ type DataSaver struct {
 	saveable     dataSaveable
 	logger       models.Logger
 	saveInterval time.Duration
+	encryptor    models.Encryptor
+
+	// The result of the last save, so readiness checks can tell if saving fails
+	lastSaveError error
+	saveErrorLock sync.Mutex
+}
+
+// Starts the save cycle in the background. The changes are saved a final time
+// when the context is cancelled, which WaitForSaveCycles waits for
+func (d *DataSaver) Start(ctx context.Context) {
+	saveCycles.Add(1)
+	setRunning(d, true)
+	go func() {
+		defer saveCycles.Done()
+		defer setRunning(d, false)
+		d.StartSaveCycle(ctx)
+	}()
+}
+
+func setRunning(saver *DataSaver, running bool) {
+	runningSaversLock.Lock()
+	defer runningSaversLock.Unlock()
+
+	for index, s := range runningSavers {
+		if s == saver {
+			runningSavers = append(runningSavers[:index], runningSavers[index+1:]...)
+			break
+		}
+	}
+
+	if running {
+		runningSavers = append(runningSavers, saver)
+	}
+}
+
+// Saves all changes, and keeps all the running storages locked while fn runs,
+// so none of the data files change while fn reads them.
+// All changes wait for fn, so it should only copy the files somewhere else.
+// Nothing that changes the storages should be called from fn
+func Snapshot(fn func() error) error {
+	runningSaversLock.Lock()
+	savers := make([]*DataSaver, len(runningSavers))
+	copy(savers, runningSavers)
+	runningSaversLock.Unlock()
+
+	for _, saver := range savers {
+		saver.saveable.Lock()
+	}
+	defer func() {
+		for _, saver := range savers {
+			saver.saveable.Unlock()
+		}
+	}()
+
+	for _, saver := range savers {
+		err := saver.saveLocked()
+		if err != nil {
+			return err
+		}
+	}
+
+	return fn()
+}
+
+// Returns an error if the last save of any of the running data savers failed
+func GetSaveError() error {
+	runningSaversLock.Lock()
+	defer runningSaversLock.Unlock()
+
+	for _, saver := range runningSavers {
+		saver.saveErrorLock.Lock()
+		err := saver.lastSaveError
+		saver.saveErrorLock.Unlock()
+
+		if err != nil {
+			return fmt.Errorf("failed to save '%s': %v", saver.filename, err)
+		}
+	}
+
+	return nil
+}
+
+// Waits for all the started save cycles to save their final changes
+// after their contexts have been cancelled
+func WaitForSaveCycles() {
+	saveCycles.Wait()
 }
 
 func (d *DataSaver) StartSaveCycle(ctx context.Context) {
@@ -74,7 +181,10 @@ func (d *DataSaver) StartSaveCycle(ctx context.Context) {
 		select {
 		case <-ctx.Done():
 			d.logger.Info("Shutting down file data storage")
-			d.saveChanges()
+			err := d.saveChanges()
+			if err != nil {
+				d.logger.Error(err)
+			}
 			// We are completely done saving
 			return
 		case <-time.After(d.saveInterval):
@@ -88,15 +198,34 @@ func (d *DataSaver) StartSaveCycle(ctx context.Context) {
 }
 
 func (d *DataSaver) saveChanges() error {
+	d.saveable.Lock()
+	defer d.saveable.Unlock()
+
+	return d.saveLocked()
+}
+
+// Saves the data if it has changed. The saveable has to be locked already
+func (d *DataSaver) saveLocked() (err error) {
+	defer func() {
+		d.saveErrorLock.Lock()
+		d.lastSaveError = err
+		d.saveErrorLock.Unlock()
+	}()
+
 	if !d.saveable.HasChanged() {
 		return nil
 	}
 
+	start := time.Now()
+	defer func() {
+		metrics.ObserveSave(path.Base(d.filename), time.Since(start), err)
+	}()
+
 	d.logger.Info("Data has changed. Saving...")
 
 	// Ensure the directory exists
 	dir := path.Dir(d.filename)
-	err := os.MkdirAll(dir, os.ModePerm)
+	err = os.MkdirAll(dir, os.ModePerm)
 	if err != nil {
 		return err
 	}
@@ -110,10 +239,16 @@ func (d *DataSaver) saveChanges() error {
 	}
 	defer file.Close()
 
-	d.saveable.Lock()
-	defer d.saveable.Unlock()
+	writer, err := d.encryptor.Encrypt(file)
+	if err != nil {
+		return err
+	}
 
-	err = json.NewEncoder(file).Encode(d.saveable.GetData())
+	err = json.NewEncoder(writer).Encode(d.saveable.GetData())
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
 	if err != nil {
 		return err
 	}
@@ -146,5 +281,10 @@ func (d *DataSaver) LoadData(data interface{}) error {
 	}
 	defer file.Close()
 
-	return json.NewDecoder(file).Decode(data)
+	reader, err := d.encryptor.Decrypt(file)
+	if err != nil {
+		return err
+	}
+
+	return json.NewDecoder(reader).Decode(data)
 }
@@ -0,0 +1,122 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package flatfile
+
+import (
+	"context"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+type DataSubjectLogStorageArgs struct {
+	// The name of the file to save data to
+	Filename string
+
+	// How often the data changes should be saved
+	SaveInterval time.Duration
+
+	// A logger for logging information
+	Logger models.Logger
+
+	// Encrypts the data file. If nil, the data is saved as plaintext
+	Encryptor models.Encryptor
+}
+
+// Stores the log of data subject actions in a flatfile
+func NewDataSubjectLogStorage(ctx context.Context, args DataSubjectLogStorageArgs) (models.DataSubjectLogStorage, error) {
+	storage := &dataSubjectLogStorage{
+		entries: []models.DataSubjectLogEntry{},
+		logger:  args.Logger,
+	}
+
+	saver := NewDataSaver(DataSaverArgs{
+		filename:     args.Filename,
+		saveable:     storage,
+		logger:       args.Logger,
+		saveInterval: args.SaveInterval,
+		encryptor:    args.Encryptor,
+	})
+
+	err := saver.LoadData(&storage.entries)
+	if err != nil {
+		args.Logger.Errorf("Failed to load stored data: %v", err)
+		return nil, err
+	}
+
+	saver.Start(ctx)
+
+	return storage, nil
+}
+
+type dataSubjectLogStorage struct {
+	lock    sync.RWMutex
+	changed bool
+	logger  models.Logger
+	entries []models.DataSubjectLogEntry
+}
+
+func (s *dataSubjectLogStorage) AddEntry(ctx context.Context, entry models.DataSubjectLogEntry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.entries = append(s.entries, entry)
+	s.changed = true
+
+	return nil
+}
+
+func (s *dataSubjectLogStorage) GetEntries(ctx context.Context) ([]models.DataSubjectLogEntry, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	out := make([]models.DataSubjectLogEntry, len(s.entries))
+	copy(out, s.entries)
+
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Time.After(out[j].Time)
+	})
+
+	return out, nil
+}
+
+func (s *dataSubjectLogStorage) Lock() {
+	s.lock.Lock()
+}
+
+func (s *dataSubjectLogStorage) Unlock() {
+	s.lock.Unlock()
+}
+
+func (s *dataSubjectLogStorage) GetData() interface{} {
+	return s.entries
+}
+
+func (s *dataSubjectLogStorage) HasChanged() bool {
+	return s.changed
+}
+
+func (s *dataSubjectLogStorage) SetChanged(changed bool) {
+	s.changed = changed
+}
@@ -25,6 +25,7 @@ package flatfile
 import (
 	"context"
 	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/tracing"
 	"sort"
 	"sync"
 	"time"
@@ -39,6 +40,9 @@ type DataStorageArgs struct {
 
 	// A logger for logging information
 	Logger models.Logger
+
+	// Encrypts the data file. If nil, the data is saved as plaintext
+	Encryptor models.Encryptor
 }
 
 // A simple file storage. Just saves things to a local flatfile
@@ -56,6 +60,7 @@ func NewFeedbackDataStorage(ctx context.Context, args DataStorageArgs) (models.F
 		saveable:     storage,
 		logger:       args.Logger,
 		saveInterval: args.SaveInterval,
+		encryptor:    args.Encryptor,
 	})
 
 	err := saver.LoadData(&storage.data)
@@ -64,7 +69,7 @@ func NewFeedbackDataStorage(ctx context.Context, args DataStorageArgs) (models.F
 		return nil, err
 	}
 
-	go saver.StartSaveCycle(ctx)
+	saver.Start(ctx)
 
 	return storage, nil
 }
@@ -93,6 +98,14 @@ func (s *feedbackFileDataStorage) Unlock() {
 	s.lock.Unlock()
 }
 
+// Takes the lock, and records on the span how long it had to wait for it,
+// as everything waits for the lock while the data is being saved
+func lockTraced(span *tracing.Span, lock sync.Locker) {
+	start := time.Now()
+	lock.Lock()
+	span.SetAttribute("lock.wait_seconds", time.Since(start).Seconds())
+}
+
 func (s *feedbackFileDataStorage) GetData() interface{} {
 	return s.data
 }
@@ -106,7 +119,10 @@ func (s *feedbackFileDataStorage) SetChanged(changed bool) {
 }
 
 func (s *feedbackFileDataStorage) SaveFeedback(ctx context.Context, feedback models.Feedback) error {
-	s.lock.Lock()
+	_, span := tracing.StartSpan(ctx, "flatfile.SaveFeedback")
+	defer span.End()
+
+	lockTraced(span, &s.lock)
 	defer s.lock.Unlock()
 	s.changed = true
 
@@ -116,7 +132,10 @@ func (s *feedbackFileDataStorage) SaveFeedback(ctx context.Context, feedback mod
 }
 
 func (s *feedbackFileDataStorage) GetAllFeedback(ctx context.Context) ([]models.Feedback, error) {
-	s.lock.RLock()
+	_, span := tracing.StartSpan(ctx, "flatfile.GetAllFeedback")
+	defer span.End()
+
+	lockTraced(span, s.lock.RLocker())
 	defer s.lock.RUnlock()
 
 	out := make([]models.Feedback, len(s.data))
@@ -132,3 +151,77 @@ func (s *feedbackFileDataStorage) GetAllFeedback(ctx context.Context) ([]models.
 
 	return out, nil
 }
+
+func (s *feedbackFileDataStorage) GetFeedback(ctx context.Context, id string) (models.Feedback, error) {
+	_, span := tracing.StartSpan(ctx, "flatfile.GetFeedback")
+	defer span.End()
+
+	lockTraced(span, s.lock.RLocker())
+	defer s.lock.RUnlock()
+
+	feedback, exists := s.data[id]
+	if !exists {
+		return models.Feedback{}, models.ErrFeedbackNotFound
+	}
+
+	return feedback, nil
+}
+
+func (s *feedbackFileDataStorage) UpdateFeedback(ctx context.Context, id string, update func(feedback *models.Feedback) error) (models.Feedback, error) {
+	_, span := tracing.StartSpan(ctx, "flatfile.UpdateFeedback")
+	defer span.End()
+
+	lockTraced(span, &s.lock)
+	defer s.lock.Unlock()
+
+	feedback, exists := s.data[id]
+	if !exists {
+		return models.Feedback{}, models.ErrFeedbackNotFound
+	}
+
+	err := update(&feedback)
+	if err != nil {
+		return models.Feedback{}, err
+	}
+
+	s.data[id] = feedback
+	s.changed = true
+
+	return feedback, nil
+}
+
+func (s *feedbackFileDataStorage) DeleteFeedback(ctx context.Context, id string) error {
+	_, span := tracing.StartSpan(ctx, "flatfile.DeleteFeedback")
+	defer span.End()
+
+	lockTraced(span, &s.lock)
+	defer s.lock.Unlock()
+
+	_, exists := s.data[id]
+	if !exists {
+		return models.ErrFeedbackNotFound
+	}
+
+	delete(s.data, id)
+	s.changed = true
+
+	return nil
+}
+
+func (s *feedbackFileDataStorage) GetFile(ctx context.Context, id string) (models.File, error) {
+	_, span := tracing.StartSpan(ctx, "flatfile.GetFile")
+	defer span.End()
+
+	lockTraced(span, s.lock.RLocker())
+	defer s.lock.RUnlock()
+
+	for _, feedback := range s.data {
+		for _, file := range feedback.Files {
+			if file.Id == id {
+				return file, nil
+			}
+		}
+	}
+
+	return models.File{}, models.ErrFileNotFound
+}
@@ -24,10 +24,14 @@ package flatfile
 
 import (
 	"context"
+	"github.com/zlepper/welp/internal/pkg/encryption"
 	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/tracing"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 )
 
 type FileStorageArgs struct {
@@ -35,13 +39,21 @@ type FileStorageArgs struct {
 	FolderPath string
 
 	Logger models.Logger
+
+	// Encrypts the files. If nil, the files are saved as plaintext
+	Encryptor models.Encryptor
 }
 
 // Gets a new file storage for saving files to the local disk
 func NewFileStorage(args FileStorageArgs) (models.FileStorage, error) {
 	storage := &fileStorage{
-		args:   args,
-		logger: args.Logger,
+		args:      args,
+		logger:    args.Logger,
+		encryptor: args.Encryptor,
+	}
+
+	if storage.encryptor == nil {
+		storage.encryptor = encryption.NewPlaintextEncryptor()
 	}
 
 	err := os.MkdirAll(args.FolderPath, os.ModePerm)
@@ -54,8 +66,9 @@ func NewFileStorage(args FileStorageArgs) (models.FileStorage, error) {
 }
 
 type fileStorage struct {
-	args   FileStorageArgs
-	logger models.Logger
+	args      FileStorageArgs
+	logger    models.Logger
+	encryptor models.Encryptor
 }
 
 func (s *fileStorage) getPath(name string) string {
@@ -63,6 +76,12 @@ func (s *fileStorage) getPath(name string) string {
 }
 
 func (s *fileStorage) SaveFile(ctx context.Context, name string, reader io.Reader) (size int64, err error) {
+	ctx, span := tracing.StartSpan(ctx, "flatfile.SaveFile")
+	defer func() {
+		span.EndWithError(err)
+	}()
+	span.SetAttribute("file.id", name)
+
 	filename := s.getPath(name)
 	s.logger.Debugf("Saving file to: '%s'", filename)
 
@@ -72,10 +91,30 @@ func (s *fileStorage) SaveFile(ctx context.Context, name string, reader io.Reade
 	}
 	defer file.Close()
 
-	return io.Copy(file, reader)
+	writer, err := s.encryptor.Encrypt(file)
+	if err == nil {
+		size, err = io.Copy(writer, reader)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		// Don't leave half written files behind
+		file.Close()
+		os.Remove(filename)
+		return 0, err
+	}
+
+	return size, file.Close()
 }
 
 func (s *fileStorage) LoadFile(ctx context.Context, name string) (reader io.ReadCloser, err error) {
+	ctx, span := tracing.StartSpan(ctx, "flatfile.LoadFile")
+	defer func() {
+		span.EndWithError(err)
+	}()
+	span.SetAttribute("file.id", name)
+
 	filename := s.getPath(name)
 	s.logger.Debugf("Loading file from '%s'", filename)
 
@@ -87,5 +126,84 @@ func (s *fileStorage) LoadFile(ctx context.Context, name string) (reader io.Read
 		return nil, err
 	}
 
-	return file, nil
+	reader, err = s.decrypt(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+type readSeekCloser struct {
+	io.ReadSeeker
+	io.Closer
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Decrypts the file, while still allowing seeking if the decrypted reader can seek
+func (s *fileStorage) decrypt(file *os.File) (io.ReadCloser, error) {
+	decrypted, err := s.encryptor.Decrypt(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if seeker, ok := decrypted.(io.ReadSeeker); ok {
+		return readSeekCloser{ReadSeeker: seeker, Closer: file}, nil
+	}
+
+	return readCloser{Reader: decrypted, Closer: file}, nil
+}
+
+func (s *fileStorage) DeleteFile(ctx context.Context, name string) (err error) {
+	ctx, span := tracing.StartSpan(ctx, "flatfile.DeleteFile")
+	defer func() {
+		span.EndWithError(err)
+	}()
+	span.SetAttribute("file.id", name)
+
+	filename := s.getPath(name)
+	s.logger.Debugf("Deleting file '%s'", filename)
+
+	err = os.Remove(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return models.ErrFileNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *fileStorage) ListFiles(ctx context.Context) ([]models.StoredFile, error) {
+	infos, err := ioutil.ReadDir(s.args.FolderPath)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]models.StoredFile, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() || IsTempFile(info.Name()) {
+			continue
+		}
+
+		files = append(files, models.StoredFile{
+			Id: info.Name(),
+			// Encrypted files are larger on disk than their content
+			Size:     -1,
+			Modified: info.ModTime(),
+		})
+	}
+
+	return files, nil
+}
+
+// Returns true if the file is still being written, either by a save or by welp rekey
+func IsTempFile(name string) bool {
+	return strings.HasSuffix(name, ".temp") || encryption.IsEncryptingTempFile(name)
 }
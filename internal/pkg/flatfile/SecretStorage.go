@@ -26,7 +26,9 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/json"
+	"github.com/zlepper/welp/internal/pkg/encryption"
 	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/tracing"
 	"os"
 	"path"
 )
@@ -34,9 +36,16 @@ import (
 type SecretStorageArgs struct {
 	Filename string
 	Logger   models.Logger
+
+	// Encrypts the secrets file. If nil, the secrets are saved as plaintext
+	Encryptor models.Encryptor
 }
 
 func NewSecretStorage(args SecretStorageArgs) (models.SecretService, error) {
+	if args.Encryptor == nil {
+		args.Encryptor = encryption.NewPlaintextEncryptor()
+	}
+
 	storage := &secretStorage{
 		SecretStorageArgs: args,
 	}
@@ -56,7 +65,7 @@ type secretStorage struct {
 }
 
 type secretData struct {
-	signingSecret []byte
+	SigningSecret []byte `json:"signingSecret"`
 }
 
 func (s *secretStorage) prepare() error {
@@ -77,7 +86,12 @@ func (s *secretStorage) prepare() error {
 	}
 	defer file.Close()
 
-	err = json.NewDecoder(file).Decode(&s.secretData)
+	reader, err := s.Encryptor.Decrypt(file)
+	if err != nil {
+		return err
+	}
+
+	err = json.NewDecoder(reader).Decode(&s.secretData)
 	if err != nil {
 		return err
 	}
@@ -87,6 +101,12 @@ func (s *secretStorage) prepare() error {
 		return err
 	}
 
+	// Older versions didn't actually save the secret
+	if len(s.SigningSecret) == 0 {
+		s.Logger.Warn("No signing secret was stored, generating a new one. Everyone will have to login again.")
+		return s.generate()
+	}
+
 	return nil
 }
 
@@ -97,15 +117,29 @@ func (s *secretStorage) generate() error {
 		return err
 	}
 
-	s.signingSecret = secret
+	s.SigningSecret = secret
 
+	return s.save()
+}
+
+func (s *secretStorage) save() error {
 	file, err := os.Create(s.Filename)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	err = json.NewEncoder(file).Encode(s.secretData)
+	writer, err := s.Encryptor.Encrypt(file)
+	if err != nil {
+		return err
+	}
+
+	err = json.NewEncoder(writer).Encode(s.secretData)
+	if err != nil {
+		return err
+	}
+
+	err = writer.Close()
 	if err != nil {
 		return err
 	}
@@ -119,5 +153,18 @@ func (s *secretStorage) generate() error {
 }
 
 func (s *secretStorage) GetSigningSecret(ctx context.Context) ([]byte, error) {
-	return s.signingSecret, nil
+	_, span := tracing.StartSpan(ctx, "flatfile.GetSigningSecret")
+	defer span.End()
+
+	return s.SigningSecret, nil
+}
+
+func (s *secretStorage) SetSigningSecret(ctx context.Context, secret []byte) (err error) {
+	_, span := tracing.StartSpan(ctx, "flatfile.SetSigningSecret")
+	defer func() {
+		span.EndWithError(err)
+	}()
+
+	s.SigningSecret = secret
+	return s.save()
 }
@@ -0,0 +1,217 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package flatfile
+
+import (
+	"context"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/tracing"
+	"sync"
+	"time"
+)
+
+type SpamDataStorageArgs struct {
+	// The name of the file to save data to
+	Filename string
+
+	// How often the data changes should be saved
+	SaveInterval time.Duration
+
+	// A logger for logging information
+	Logger models.Logger
+
+	// Encrypts the data file. If nil, the data is saved as plaintext
+	Encryptor models.Encryptor
+}
+
+// Stores the training data for the spam classifier in a flatfile
+func NewSpamDataStorage(ctx context.Context, args SpamDataStorageArgs) (models.SpamDataStorage, error) {
+	storage := &spamDataStorage{
+		data: spamData{
+			Tokens: map[string]models.SpamTokenCount{},
+		},
+		logger: args.Logger,
+	}
+
+	saver := NewDataSaver(DataSaverArgs{
+		filename:     args.Filename,
+		saveable:     storage,
+		logger:       args.Logger,
+		saveInterval: args.SaveInterval,
+		encryptor:    args.Encryptor,
+	})
+
+	err := saver.LoadData(&storage.data)
+	if err != nil {
+		args.Logger.Errorf("Failed to load stored data: %v", err)
+		return nil, err
+	}
+
+	if storage.data.Tokens == nil {
+		storage.data.Tokens = map[string]models.SpamTokenCount{}
+	}
+
+	saver.Start(ctx)
+
+	return storage, nil
+}
+
+type spamData struct {
+	Tokens        map[string]models.SpamTokenCount `json:"tokens"`
+	SpamDocuments int                              `json:"spamDocuments"`
+	HamDocuments  int                              `json:"hamDocuments"`
+}
+
+type spamDataStorage struct {
+	lock    sync.RWMutex
+	changed bool
+	logger  models.Logger
+	data    spamData
+}
+
+func (s *spamDataStorage) AddTokens(ctx context.Context, tokens []string, spam bool, delta int) error {
+	_, span := tracing.StartSpan(ctx, "flatfile.AddTokens")
+	defer span.End()
+	span.SetAttribute("spam.tokens", len(tokens))
+
+	lockTraced(span, &s.lock)
+	defer s.lock.Unlock()
+
+	if spam {
+		s.data.SpamDocuments = clampCount(s.data.SpamDocuments + delta)
+	} else {
+		s.data.HamDocuments = clampCount(s.data.HamDocuments + delta)
+	}
+
+	for _, token := range tokens {
+		count := s.data.Tokens[token]
+		if spam {
+			count.Spam = clampCount(count.Spam + delta)
+		} else {
+			count.Ham = clampCount(count.Ham + delta)
+		}
+
+		if count.Spam == 0 && count.Ham == 0 {
+			delete(s.data.Tokens, token)
+		} else {
+			s.data.Tokens[token] = count
+		}
+	}
+
+	s.changed = true
+
+	return nil
+}
+
+// Counts can never go below 0, even if something is untrained more times than it was trained
+func clampCount(count int) int {
+	if count < 0 {
+		return 0
+	}
+	return count
+}
+
+func (s *spamDataStorage) GetStatistics(ctx context.Context, tokens []string) (models.SpamStatistics, error) {
+	_, span := tracing.StartSpan(ctx, "flatfile.GetStatistics")
+	defer span.End()
+	span.SetAttribute("spam.tokens", len(tokens))
+
+	lockTraced(span, s.lock.RLocker())
+	defer s.lock.RUnlock()
+
+	stats := models.SpamStatistics{
+		Tokens:        make(map[string]models.SpamTokenCount, len(tokens)),
+		SpamDocuments: s.data.SpamDocuments,
+		HamDocuments:  s.data.HamDocuments,
+	}
+
+	for _, token := range tokens {
+		if count, exists := s.data.Tokens[token]; exists {
+			stats.Tokens[token] = count
+		}
+	}
+
+	return stats, nil
+}
+
+func (s *spamDataStorage) GetAllStatistics(ctx context.Context) (models.SpamStatistics, error) {
+	_, span := tracing.StartSpan(ctx, "flatfile.GetAllStatistics")
+	defer span.End()
+
+	lockTraced(span, s.lock.RLocker())
+	defer s.lock.RUnlock()
+
+	stats := models.SpamStatistics{
+		Tokens:        make(map[string]models.SpamTokenCount, len(s.data.Tokens)),
+		SpamDocuments: s.data.SpamDocuments,
+		HamDocuments:  s.data.HamDocuments,
+	}
+
+	for token, count := range s.data.Tokens {
+		stats.Tokens[token] = count
+	}
+
+	return stats, nil
+}
+
+func (s *spamDataStorage) SetStatistics(ctx context.Context, statistics models.SpamStatistics) error {
+	_, span := tracing.StartSpan(ctx, "flatfile.SetStatistics")
+	defer span.End()
+
+	lockTraced(span, &s.lock)
+	defer s.lock.Unlock()
+
+	tokens := make(map[string]models.SpamTokenCount, len(statistics.Tokens))
+	for token, count := range statistics.Tokens {
+		tokens[token] = count
+	}
+
+	s.data = spamData{
+		Tokens:        tokens,
+		SpamDocuments: statistics.SpamDocuments,
+		HamDocuments:  statistics.HamDocuments,
+	}
+	s.changed = true
+
+	return nil
+}
+
+func (s *spamDataStorage) Lock() {
+	s.lock.Lock()
+}
+
+func (s *spamDataStorage) Unlock() {
+	s.lock.Unlock()
+}
+
+func (s *spamDataStorage) GetData() interface{} {
+	return s.data
+}
+
+func (s *spamDataStorage) HasChanged() bool {
+	return s.changed
+}
+
+func (s *spamDataStorage) SetChanged(changed bool) {
+	s.changed = changed
+}
@@ -0,0 +1,364 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/webapi"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errFileTooLarge = errors.New("the attachment is larger than the maximum file size")
+)
+
+// Ids are made from this namespace when the imported feedback doesn't have one
+var importNamespace = uuid.MustParse("3c5b4a6e-9f0e-4d5c-8b1a-7e2f6d9c0a41")
+
+// The layouts tried when no time format is given
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC822Z,
+	time.RFC822,
+}
+
+type ImportServiceArgs struct {
+	// The imported feedback is saved directly in the storage, so no emails are sent about it
+	DataStorage      models.FeedbackDataStorage
+	FileStorage      models.FileStorage
+	FileSanitizer    models.FileSanitizer
+	ThumbnailService models.ThumbnailService
+	Logger           models.Logger
+}
+
+func NewImportService(args ImportServiceArgs) models.ImportService {
+	return &importService{
+		ImportServiceArgs: args,
+	}
+}
+
+type importService struct {
+	ImportServiceArgs
+}
+
+func (s *importService) Import(ctx context.Context, reader io.Reader, options models.ImportOptions) (models.ImportReport, error) {
+	report := models.ImportReport{
+		Rejected: make([]models.ImportRejection, 0),
+	}
+
+	if options.Mapping.Message == "" {
+		return report, errors.New("the column the message is imported from is required")
+	}
+
+	records, err := newRecordReader(reader, options.Format)
+	if err != nil {
+		return report, err
+	}
+
+	client := newAttachmentClient(options.AllowPrivateNetworks)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		row, record, err := records.next()
+		if err == io.EOF {
+			break
+		}
+		if rowErr, ok := err.(rowError); ok {
+			report.Rejected = append(report.Rejected, models.ImportRejection{Row: rowErr.row, Reason: rowErr.reason})
+			continue
+		}
+		if err != nil {
+			return report, fmt.Errorf("failed to read row %d: %v", row+1, err)
+		}
+
+		duplicate, id, err := s.importRecord(ctx, client, record, options)
+		if err != nil {
+			report.Rejected = append(report.Rejected, models.ImportRejection{Row: row, Id: id, Reason: err.Error()})
+			continue
+		}
+
+		if duplicate {
+			report.Duplicates++
+		} else {
+			report.Imported++
+		}
+	}
+
+	s.Logger.Infof("Imported %d feedback entries, skipped %d duplicates and rejected %d", report.Imported, report.Duplicates, len(report.Rejected))
+
+	return report, nil
+}
+
+// Imports a single record. Returns true if the feedback already existed
+func (s *importService) importRecord(ctx context.Context, client *http.Client, record record, options models.ImportOptions) (duplicate bool, id string, err error) {
+	mapping := options.Mapping
+
+	message := record.get(mapping.Message)
+	if message == "" {
+		return false, record.get(mapping.Id), errors.New("the message is empty")
+	}
+
+	contactAddress := record.get(mapping.ContactAddress)
+	createdValue := record.get(mapping.Created)
+
+	id = record.get(mapping.Id)
+	if id == "" {
+		// Made from the content, so the same feedback gets the same id when it's imported again
+		id = uuid.NewSHA1(importNamespace, []byte(message+"\x00"+contactAddress+"\x00"+createdValue)).String()
+	}
+
+	if strings.ContainsAny(id, "/\\") {
+		return false, id, errors.New("the id can't contain slashes")
+	}
+
+	_, err = s.DataStorage.GetFeedback(ctx, id)
+	if err == nil {
+		return true, id, nil
+	}
+	if err != models.ErrFeedbackNotFound {
+		return false, id, err
+	}
+
+	created := time.Now()
+	if createdValue != "" {
+		created, err = parseTime(createdValue, options.TimeFormat)
+		if err != nil {
+			return false, id, err
+		}
+	}
+
+	attachments := record.getList(mapping.Attachments)
+	if options.UploadLimits.MaxFiles > 0 && len(attachments) > options.UploadLimits.MaxFiles {
+		return false, id, fmt.Errorf("the feedback has more than the maximum of %d attachments", options.UploadLimits.MaxFiles)
+	}
+
+	files := make([]models.File, 0, len(attachments))
+	for _, attachment := range attachments {
+		file, err := s.importAttachment(ctx, client, attachment, options)
+		if err != nil {
+			s.deleteFiles(ctx, files)
+			return false, id, fmt.Errorf("failed to import attachment '%s': %v", attachment, err)
+		}
+		files = append(files, file)
+	}
+
+	feedback := models.Feedback{
+		Id:             id,
+		Message:        message,
+		ContactAddress: contactAddress,
+		Files:          files,
+		Created:        created,
+	}
+
+	err = s.DataStorage.SaveFeedback(ctx, feedback)
+	if err != nil {
+		s.deleteFiles(ctx, files)
+		return false, id, err
+	}
+
+	return false, id, nil
+}
+
+func (s *importService) importAttachment(ctx context.Context, client *http.Client, location string, options models.ImportOptions) (createdFile models.File, err error) {
+	src, name, err := openAttachment(ctx, client, location, options.AttachmentFolder)
+	if err != nil {
+		return createdFile, err
+	}
+	defer src.Close()
+
+	contentType, reader, err := webapi.DetectContentType(src)
+	if err != nil {
+		return createdFile, err
+	}
+
+	if !options.UploadLimits.IsContentTypeAllowed(contentType) {
+		return createdFile, fmt.Errorf("files of type '%s' are not allowed", contentType)
+	}
+
+	if options.UploadLimits.MaxFileSize > 0 {
+		reader = &limitedReader{reader: reader, remaining: int64(options.UploadLimits.MaxFileSize)}
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return createdFile, err
+	}
+
+	filename := id.String() + path.Ext(name)
+
+	reader, sanitized, err := s.FileSanitizer.Sanitize(ctx, contentType, reader)
+	if err != nil {
+		return createdFile, err
+	}
+
+	size, err := s.FileStorage.SaveFile(ctx, filename, reader)
+	if err != nil {
+		// Something might have been written before it failed
+		s.FileStorage.DeleteFile(ctx, filename)
+		return createdFile, err
+	}
+
+	createdFile = models.File{
+		Id:           filename,
+		Size:         size,
+		ContentType:  contentType,
+		OriginalName: name,
+		Sanitized:    sanitized,
+	}
+
+	if createdFile.IsImage() {
+		err = s.ThumbnailService.GenerateThumbnails(ctx, createdFile)
+		if err != nil && err != models.ErrThumbnailNotSupported {
+			s.Logger.WithContext(ctx).Warnf("Failed to generate thumbnails for '%s': %v", createdFile.Id, err)
+		}
+	}
+
+	return createdFile, nil
+}
+
+// Removes the files that was saved for feedback that couldn't be imported
+func (s *importService) deleteFiles(ctx context.Context, files []models.File) {
+	for _, file := range files {
+		err := s.FileStorage.DeleteFile(ctx, file.Id)
+		if err != nil {
+			s.Logger.WithContext(ctx).Errorf("Failed to delete file '%s': %v", file.Id, err)
+		}
+
+		err = s.ThumbnailService.DeleteThumbnails(ctx, file)
+		if err != nil {
+			s.Logger.WithContext(ctx).Errorf("Failed to delete thumbnails of file '%s': %v", file.Id, err)
+		}
+	}
+}
+
+// Opens the attachment, either by downloading it or reading it from the attachment folder.
+// Also returns the name of the file
+func openAttachment(ctx context.Context, client *http.Client, location, folder string) (io.ReadCloser, string, error) {
+	if u, err := url.Parse(location); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		return downloadAttachment(ctx, client, u)
+	}
+
+	if folder == "" {
+		return nil, "", errors.New("attachments can only be imported from urls")
+	}
+
+	filename := location
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(folder, filename)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return file, filepath.Base(filename), nil
+}
+
+func downloadAttachment(ctx context.Context, client *http.Client, u *url.URL) (io.ReadCloser, string, error) {
+	request, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, "", fmt.Errorf("the download failed with status %d", response.StatusCode)
+	}
+
+	name := path.Base(u.Path)
+	if name == "." || name == "/" {
+		name = ""
+	}
+
+	return response.Body, name, nil
+}
+
+func parseTime(value, layout string) (time.Time, error) {
+	if layout != "" {
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("the created time '%s' doesn't match the format '%s'", value, layout)
+		}
+		return t, nil
+	}
+
+	for _, layout := range timeLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	// Unix timestamps, in either seconds or milliseconds
+	if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if number > 1e11 {
+			return time.Unix(0, number*int64(time.Millisecond)), nil
+		}
+		return time.Unix(number, 0), nil
+	}
+
+	return time.Time{}, fmt.Errorf("the created time '%s' is not in a known format", value)
+}
+
+// Fails the read when more than the remaining bytes are read
+type limitedReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, errFileTooLarge
+	}
+	return n, err
+}
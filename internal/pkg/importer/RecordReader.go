@@ -0,0 +1,274 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"io"
+	"strings"
+)
+
+// A single feedback entry in the imported file
+type record interface {
+	// Gets the value of the field, or an empty string if it's missing
+	get(field string) string
+	// Gets all the values of a field that can have more than one
+	getList(field string) []string
+}
+
+// Reads the records of the imported file one at a time
+type recordReader interface {
+	// Gets the next record, and the row it's from. Returns io.EOF when there are no more records.
+	// If only the single record is broken, a rowError is returned
+	next() (int, record, error)
+}
+
+// An error that only affects a single row, so the rest can still be imported
+type rowError struct {
+	row    int
+	reason string
+}
+
+func (e rowError) Error() string {
+	return fmt.Sprintf("row %d: %s", e.row, e.reason)
+}
+
+func newRecordReader(reader io.Reader, format models.ImportFormat) (recordReader, error) {
+	switch format {
+	case models.ImportCSV:
+		return newCSVRecordReader(reader)
+	case models.ImportJSON:
+		return newJSONRecordReader(reader)
+	default:
+		return nil, models.ErrUnknownImportFormat
+	}
+}
+
+type csvRecordReader struct {
+	reader *csv.Reader
+	// The index of each column, by the name in the header
+	columns map[string]int
+	row     int
+}
+
+func newCSVRecordReader(reader io.Reader) (recordReader, error) {
+	csvReader := csv.NewReader(reader)
+	// Missing columns are treated as empty values instead
+	csvReader.FieldsPerRecord = -1
+
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the csv file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for index, name := range header {
+		// Some tools start the file with a byte order mark
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		columns[name] = index
+	}
+
+	return &csvRecordReader{
+		reader:  csvReader,
+		columns: columns,
+	}, nil
+}
+
+func (r *csvRecordReader) next() (int, record, error) {
+	values, err := r.reader.Read()
+	if err != nil {
+		return r.row, nil, err
+	}
+	r.row++
+
+	return r.row, csvRecord{values: values, columns: r.columns}, nil
+}
+
+type csvRecord struct {
+	values  []string
+	columns map[string]int
+}
+
+func (r csvRecord) get(field string) string {
+	index, exists := r.columns[field]
+	if !exists || index >= len(r.values) {
+		return ""
+	}
+	return strings.TrimSpace(r.values[index])
+}
+
+func (r csvRecord) getList(field string) []string {
+	values := make([]string, 0)
+	for _, value := range strings.Split(r.get(field), ";") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+type jsonRecordReader struct {
+	decoder *json.Decoder
+	// True if the records are in a json array, false if they are newline delimited
+	inArray bool
+	row     int
+}
+
+func newJSONRecordReader(reader io.Reader) (recordReader, error) {
+	buffered := bufio.NewReader(reader)
+
+	// Find out if the file is an array, or newline delimited objects
+	inArray := false
+	for {
+		b, err := buffered.Peek(1)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n' {
+			buffered.ReadByte()
+			continue
+		}
+		inArray = b[0] == '['
+		break
+	}
+
+	decoder := json.NewDecoder(buffered)
+	// Keeps large numeric ids and timestamps exactly as they were
+	decoder.UseNumber()
+
+	if inArray {
+		// Skips the start of the array, so the objects in it can be decoded one at a time
+		_, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &jsonRecordReader{
+		decoder: decoder,
+		inArray: inArray,
+	}, nil
+}
+
+func (r *jsonRecordReader) next() (int, record, error) {
+	if r.inArray && !r.decoder.More() {
+		return r.row, nil, io.EOF
+	}
+
+	var value interface{}
+	err := r.decoder.Decode(&value)
+	if err != nil {
+		return r.row, nil, err
+	}
+	r.row++
+
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return r.row, nil, rowError{row: r.row, reason: "not a json object"}
+	}
+
+	return r.row, jsonRecord(object), nil
+}
+
+type jsonRecord map[string]interface{}
+
+// Finds the value of the field, following dots into nested objects
+func (r jsonRecord) find(field string) interface{} {
+	if field == "" {
+		return nil
+	}
+
+	var current interface{} = map[string]interface{}(r)
+	for _, part := range strings.Split(field, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = object[part]
+	}
+
+	return current
+}
+
+func (r jsonRecord) get(field string) string {
+	return strings.TrimSpace(formatJSONValue(r.find(field)))
+}
+
+func (r jsonRecord) getList(field string) []string {
+	value := r.find(field)
+
+	items, ok := value.([]interface{})
+	if !ok {
+		items = []interface{}{value}
+	}
+
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		// Attachments are sometimes objects, with the location in a url or path field
+		if object, ok := item.(map[string]interface{}); ok {
+			if url, exists := object["url"]; exists {
+				item = url
+			} else {
+				item = object["path"]
+			}
+		}
+
+		text := strings.TrimSpace(formatJSONValue(item))
+		if text != "" {
+			values = append(values, text)
+		}
+	}
+
+	return values
+}
+
+func formatJSONValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	default:
+		// Objects and arrays can't be used as a single value
+		return ""
+	}
+}
@@ -0,0 +1,82 @@
+package importer
+
+import (
+	"github.com/zlepper/welp/internal/pkg/models"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readAll(t *testing.T, content string, format models.ImportFormat) ([]record, []int) {
+	reader, err := newRecordReader(strings.NewReader(content), format)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := make([]record, 0)
+	rejected := make([]int, 0)
+	for {
+		row, record, err := reader.next()
+		if err == io.EOF {
+			return records, rejected
+		}
+		if rowErr, ok := err.(rowError); ok {
+			rejected = append(rejected, rowErr.row)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("row %d: %v", row, err)
+		}
+		records = append(records, record)
+	}
+}
+
+func TestCSVRecordReader(t *testing.T) {
+	records, _ := readAll(t, "\ufeffid,message,attachments\n1,\"Hello, world\",a.png; b.png\n2,Short\n", models.ImportCSV)
+
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+
+	if id := records[0].get("id"); id != "1" {
+		t.Errorf("expected the id to be read after a byte order mark, got '%s'", id)
+	}
+
+	if message := records[0].get("message"); message != "Hello, world" {
+		t.Errorf("unexpected message '%s'", message)
+	}
+
+	if attachments := records[0].getList("attachments"); !reflect.DeepEqual(attachments, []string{"a.png", "b.png"}) {
+		t.Errorf("unexpected attachments %v", attachments)
+	}
+
+	if attachments := records[1].getList("attachments"); len(attachments) != 0 {
+		t.Errorf("expected a missing column to give no attachments, got %v", attachments)
+	}
+}
+
+func TestJSONRecordReader(t *testing.T) {
+	array := `[{"id": 12345678901234567890, "from": {"email": "a@b.c"}, "attachments": ["x.png", {"url": "https://example.com/y.png"}]}, "text"]`
+	ndjson := "{\"id\": 12345678901234567890, \"from\": {\"email\": \"a@b.c\"}, \"attachments\": [\"x.png\", {\"url\": \"https://example.com/y.png\"}]}\n\"text\"\n"
+
+	for _, content := range []string{array, ndjson} {
+		records, rejected := readAll(t, content, models.ImportJSON)
+
+		if len(records) != 1 || !reflect.DeepEqual(rejected, []int{2}) {
+			t.Fatalf("expected 1 record and row 2 to be rejected, got %d records and %v rejected", len(records), rejected)
+		}
+
+		if id := records[0].get("id"); id != "12345678901234567890" {
+			t.Errorf("expected large ids to be kept exactly, got '%s'", id)
+		}
+
+		if contactAddress := records[0].get("from.email"); contactAddress != "a@b.c" {
+			t.Errorf("expected nested fields to be found, got '%s'", contactAddress)
+		}
+
+		if attachments := records[0].getList("attachments"); !reflect.DeepEqual(attachments, []string{"x.png", "https://example.com/y.png"}) {
+			t.Errorf("unexpected attachments %v", attachments)
+		}
+	}
+}
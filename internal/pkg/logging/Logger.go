@@ -0,0 +1,298 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/tracing"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type LoggerArgs struct {
+	Level  models.LogLevel
+	Format models.LogFormat
+	// Where the entries are written. Defaults to stdout
+	Output io.Writer
+	// Values that are replaced in everything that is logged, like api keys from the configuration
+	Secrets []string
+}
+
+// Creates a logger that writes an entry per line. Everything is redacted before it is written,
+// so passwords, tokens and api keys never reach the log
+func NewLogger(args LoggerArgs) models.Logger {
+	output := args.Output
+	if output == nil {
+		output = os.Stdout
+	}
+
+	format := args.Format
+	if format == "" {
+		format = models.LogText
+	}
+
+	return &logger{
+		core: &core{
+			level:    getSeverity(args.Level),
+			format:   format,
+			output:   output,
+			redactor: newRedactor(args.Secrets),
+		},
+	}
+}
+
+// Adds secret values to redact from everything the logger, and every logger made from it, logs from now on.
+// Used when the configuration is reloaded, so new api keys are redacted too.
+// Does nothing with loggers that weren't made by NewLogger
+func AddSecrets(l models.Logger, secrets ...string) {
+	if logger, ok := l.(*logger); ok {
+		logger.redactor.addSecrets(secrets)
+	}
+}
+
+type severity int
+
+const (
+	debugSeverity severity = iota
+	infoSeverity
+	warnSeverity
+	errorSeverity
+	fatalSeverity
+	panicSeverity
+)
+
+var severityNames = map[severity]string{
+	debugSeverity: "DEBUG",
+	infoSeverity:  "INFO",
+	warnSeverity:  "WARN",
+	errorSeverity: "ERROR",
+	fatalSeverity: "FATAL",
+	panicSeverity: "PANIC",
+}
+
+func getSeverity(level models.LogLevel) severity {
+	switch level {
+	case models.LogDebug:
+		return debugSeverity
+	case models.LogWarn:
+		return warnSeverity
+	case models.LogError:
+		return errorSeverity
+	default:
+		return infoSeverity
+	}
+}
+
+// What is shared between a logger and the loggers made from it with WithField and WithContext
+type core struct {
+	level    severity
+	format   models.LogFormat
+	redactor *redactor
+
+	// Keeps entries from different goroutines from being mixed together
+	lock   sync.Mutex
+	output io.Writer
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+type logger struct {
+	*core
+	fields []field
+}
+
+func (l *logger) WithContext(ctx context.Context) models.Logger {
+	var result models.Logger = l
+	if requestId := GetRequestId(ctx); requestId != "" {
+		result = result.WithField("requestId", requestId)
+	}
+
+	// Makes it possible to go from a log entry to the trace of the request
+	if spanContext, ok := tracing.GetSpanContext(ctx); ok {
+		result = result.WithField("traceId", spanContext.TraceIdString()).WithField("spanId", spanContext.SpanIdString())
+	}
+
+	return result
+}
+
+func (l *logger) WithField(key string, value interface{}) models.Logger {
+	fields := make([]field, len(l.fields), len(l.fields)+1)
+	copy(fields, l.fields)
+
+	return &logger{
+		core:   l.core,
+		fields: append(fields, field{key: key, value: value}),
+	}
+}
+
+func (l *logger) Debug(i ...interface{}) {
+	l.log(debugSeverity, fmt.Sprint(i...))
+}
+
+func (l *logger) Debugf(s string, i ...interface{}) {
+	l.log(debugSeverity, fmt.Sprintf(s, i...))
+}
+
+func (l *logger) Info(i ...interface{}) {
+	l.log(infoSeverity, fmt.Sprint(i...))
+}
+
+func (l *logger) Infof(s string, i ...interface{}) {
+	l.log(infoSeverity, fmt.Sprintf(s, i...))
+}
+
+func (l *logger) Warn(i ...interface{}) {
+	l.log(warnSeverity, fmt.Sprint(i...))
+}
+
+func (l *logger) Warnf(s string, i ...interface{}) {
+	l.log(warnSeverity, fmt.Sprintf(s, i...))
+}
+
+func (l *logger) Error(i ...interface{}) {
+	l.log(errorSeverity, fmt.Sprint(i...))
+}
+
+func (l *logger) Errorf(s string, i ...interface{}) {
+	l.log(errorSeverity, fmt.Sprintf(s, i...))
+}
+
+func (l *logger) Fatal(i ...interface{}) {
+	l.log(fatalSeverity, fmt.Sprint(i...))
+	os.Exit(1)
+}
+
+func (l *logger) Fatalf(s string, i ...interface{}) {
+	l.log(fatalSeverity, fmt.Sprintf(s, i...))
+	os.Exit(1)
+}
+
+func (l *logger) Panic(i ...interface{}) {
+	message := fmt.Sprint(i...)
+	l.log(panicSeverity, message)
+	panic(message)
+}
+
+func (l *logger) Panicf(s string, i ...interface{}) {
+	message := fmt.Sprintf(s, i...)
+	l.log(panicSeverity, message)
+	panic(message)
+}
+
+func (l *logger) log(level severity, message string) {
+	if level < l.level {
+		return
+	}
+
+	now := time.Now()
+	message = l.redactor.redact(message)
+
+	var line []byte
+	if l.format == models.LogJson {
+		line = l.formatJson(now, level, message)
+	} else {
+		line = l.formatText(now, level, message)
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.output.Write(line)
+}
+
+// Gets the values of the fields as they should be logged. Fields with secret names are redacted entirely
+func (l *logger) getFields() map[string]interface{} {
+	values := make(map[string]interface{}, len(l.fields))
+	for _, f := range l.fields {
+		switch value := f.value.(type) {
+		case string:
+			values[f.key] = l.redactor.redactField(f.key, value)
+		case error:
+			values[f.key] = l.redactor.redactField(f.key, value.Error())
+		case fmt.Stringer:
+			values[f.key] = l.redactor.redactField(f.key, value.String())
+		default:
+			if l.redactor.isSecretName(f.key) {
+				values[f.key] = redacted
+			} else {
+				values[f.key] = value
+			}
+		}
+	}
+	return values
+}
+
+func (l *logger) formatJson(now time.Time, level severity, message string) []byte {
+	entry := l.getFields()
+	entry["time"] = now.Format(time.RFC3339Nano)
+	entry["level"] = severityNames[level]
+	entry["message"] = message
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		// Some field couldn't be encoded, so the entry is logged without the fields
+		line, _ = json.Marshal(map[string]interface{}{
+			"time":    entry["time"],
+			"level":   entry["level"],
+			"message": message,
+		})
+	}
+
+	return append(line, '\n')
+}
+
+func (l *logger) formatText(now time.Time, level severity, message string) []byte {
+	var b strings.Builder
+	b.WriteString(now.Format(time.RFC3339))
+	b.WriteString(" ")
+	b.WriteString(fmt.Sprintf("%-5s", severityNames[level]))
+	b.WriteString(" ")
+	b.WriteString(message)
+
+	fields := l.getFields()
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := fmt.Sprint(fields[key])
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		b.WriteString(" " + key + "=" + value)
+	}
+
+	b.WriteString("\n")
+	return []byte(b.String())
+}
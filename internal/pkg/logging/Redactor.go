@@ -0,0 +1,128 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package logging
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// What secrets are replaced with
+const redacted = "[REDACTED]"
+
+// Secrets shorter than this are not replaced, as they would match all over the log
+const minSecretLength = 4
+
+var (
+	// Names of fields and parameters that hold secrets, like password=... or "apiKey": "..."
+	secretName = `(?:password|passwd|pwd|secret|token|api[_-]?key|access[_-]?key|authorization|cookie)`
+
+	secretNamePattern = regexp.MustCompile(`(?i)` + secretName)
+
+	// The value of anything named like a secret, in query strings, json and go structs
+	secretAssignmentPattern = regexp.MustCompile(`(?i)([\w-]*` + secretName + `[\w-]*"?\s*[:=]\s*)("(?:[^"\\]|\\.)*"|[^\s&,;}\])]+)`)
+
+	secretPatterns = []*regexp.Regexp{
+		// Authorization headers
+		regexp.MustCompile(`(?i)(?:bearer|basic)\s+[A-Za-z0-9\-._~+/]+=*`),
+		// Jwt tokens, which are used for logins and form tokens
+		regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+		// Bcrypt password hashes
+		regexp.MustCompile(`\$2[abxy]?\$\d{2}\$[./A-Za-z0-9]{53}`),
+		// Sendgrid api keys
+		regexp.MustCompile(`SG\.[A-Za-z0-9_-]{16,}\.[A-Za-z0-9_-]{16,}`),
+	}
+)
+
+// Removes secrets from what is logged
+type redactor struct {
+	// Guards the secrets, as more can be added while logging, when the configuration is reloaded
+	lock sync.RWMutex
+	// The secret values that are replaced, and the replacer made from them
+	known   map[string]bool
+	secrets *strings.Replacer
+}
+
+func newRedactor(secrets []string) *redactor {
+	r := &redactor{
+		known: map[string]bool{},
+	}
+	r.addSecrets(secrets)
+	return r
+}
+
+// Adds more secret values to replace. The ones already known are kept,
+// as they may still be in use somewhere, e.g. by requests that started before a reload
+func (r *redactor) addSecrets(secrets []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, secret := range secrets {
+		if len(secret) >= minSecretLength {
+			r.known[secret] = true
+		}
+	}
+
+	// The longest secrets go first, so a secret containing another is replaced entirely
+	known := make([]string, 0, len(r.known))
+	for secret := range r.known {
+		known = append(known, secret)
+	}
+	sort.Slice(known, func(i, j int) bool {
+		return len(known[i]) > len(known[j])
+	})
+
+	pairs := make([]string, 0, len(known)*2)
+	for _, secret := range known {
+		pairs = append(pairs, secret, redacted)
+	}
+	r.secrets = strings.NewReplacer(pairs...)
+}
+
+func (r *redactor) redact(message string) string {
+	r.lock.RLock()
+	secrets := r.secrets
+	r.lock.RUnlock()
+
+	message = secrets.Replace(message)
+
+	for _, pattern := range secretPatterns {
+		message = pattern.ReplaceAllString(message, redacted)
+	}
+
+	return secretAssignmentPattern.ReplaceAllString(message, "${1}"+redacted)
+}
+
+// Redacts the value of a field, which is removed entirely if the field is named like a secret
+func (r *redactor) redactField(key, value string) string {
+	if r.isSecretName(key) {
+		return redacted
+	}
+	return r.redact(value)
+}
+
+func (r *redactor) isSecretName(key string) bool {
+	return secretNamePattern.MatchString(key)
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package logging
+
+import (
+	"bytes"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"io"
+	"strings"
+)
+
+// Creates a writer that logs every line written to it. Lines starting with a level,
+// like "WARN something happened", are logged with that level, everything else as errors.
+// Used for the output of libraries that has their own loggers
+func NewLevelWriter(logger models.Logger) io.Writer {
+	return &levelWriter{
+		logger: logger,
+	}
+}
+
+type levelWriter struct {
+	logger models.Logger
+}
+
+func (w *levelWriter) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		w.writeLine(string(line))
+	}
+	return len(p), nil
+}
+
+func (w *levelWriter) writeLine(line string) {
+	level := line
+	message := ""
+	if index := strings.IndexByte(line, ' '); index >= 0 {
+		level = line[:index]
+		message = line[index+1:]
+	}
+
+	switch level {
+	case "DEBUG":
+		w.logger.Debug(message)
+	case "INFO":
+		w.logger.Info(message)
+	case "WARN":
+		w.logger.Warn(message)
+	case "ERROR":
+		w.logger.Error(message)
+	default:
+		w.logger.Error(line)
+	}
+}
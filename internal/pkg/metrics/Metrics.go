@@ -0,0 +1,151 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const namespace = "welp"
+
+var (
+	// Only welp's own metrics are exposed, not whatever the libraries register globally
+	registry = prometheus.NewRegistry()
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "The number of http requests, by route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "How long http requests took, by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	feedbackCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "feedback_created_total",
+		Help:      "The number of feedback entries created, and if they were put in quarantine.",
+	}, []string{"quarantined"})
+
+	attachmentBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "attachment_bytes",
+		Help:      "The size of the files attached to feedback.",
+		// 1 KiB to 256 MiB
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 10),
+	})
+
+	saveDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "save_duration_seconds",
+		Help:      "How long saving a database file took.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"file"})
+
+	saveFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "save_failures_total",
+		Help:      "The number of times saving a database file failed.",
+	}, []string{"file"})
+
+	emails = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "emails_total",
+		Help:      "The number of emails welp tried to send, by result. Skipped emails were not sent, as no email backend is configured.",
+	}, []string{"result"})
+
+	loginFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_failures_total",
+		Help:      "The number of failed login attempts.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		httpRequests,
+		httpRequestDuration,
+		feedbackCreated,
+		attachmentBytes,
+		saveDuration,
+		saveFailures,
+		emails,
+		loginFailures,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+}
+
+// Serves the metrics in the prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Records a handled http request. The route should be the pattern the request matched,
+// not the actual path, so ids don't create a new series for every request
+func ObserveRequest(method, route string, status int, duration time.Duration) {
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	httpRequestDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+func FeedbackCreated(quarantined bool) {
+	feedbackCreated.WithLabelValues(strconv.FormatBool(quarantined)).Inc()
+}
+
+func AttachmentSaved(size int64) {
+	attachmentBytes.Observe(float64(size))
+}
+
+// Records saving a database file, and if it failed
+func ObserveSave(file string, duration time.Duration, err error) {
+	saveDuration.WithLabelValues(file).Observe(duration.Seconds())
+	if err != nil {
+		saveFailures.WithLabelValues(file).Inc()
+	}
+}
+
+// Records the result of sending an email
+func EmailSent(err error) {
+	if err != nil {
+		emails.WithLabelValues("failed").Inc()
+	} else {
+		emails.WithLabelValues("sent").Inc()
+	}
+}
+
+// Records an email that wasn't sent, because no email backend is configured
+func EmailSkipped() {
+	emails.WithLabelValues("skipped").Inc()
+}
+
+func LoginFailed() {
+	loginFailures.Inc()
+}
@@ -0,0 +1,547 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/thumbnail"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const (
+	// The state is saved after this many files have been copied, or when stateSaveInterval has passed,
+	// so it isn't rewritten for every file. Files copied since the last save are just copied again when resuming
+	stateSaveBatch    = 100
+	stateSaveInterval = 10 * time.Second
+)
+
+type MigratorArgs struct {
+	// Where the files that have been copied are remembered, so an interrupted migration can be resumed
+	StateFile string
+	Logger    models.Logger
+}
+
+// Creates a migrator that copies the data between any two sets of storages
+func NewMigrator(args MigratorArgs) models.Migrator {
+	return &migrator{
+		MigratorArgs: args,
+	}
+}
+
+type migrator struct {
+	MigratorArgs
+}
+
+// A file that has been copied and verified
+type copiedFile struct {
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+type migrationState struct {
+	Files map[string]copiedFile `json:"files"`
+	// How many files have been copied since the state was saved, and when it was
+	unsaved int
+	savedAt time.Time
+}
+
+func (m *migrator) Migrate(ctx context.Context, from, to models.MigrationStorages) (models.MigrationReport, error) {
+	report := models.MigrationReport{
+		Failures: make([]models.MigrationFailure, 0),
+	}
+
+	if from.FeedbackDataStorage == nil {
+		return report, errors.New("the feedback has to be read from the source, to know which files to migrate")
+	}
+
+	state, err := m.loadState()
+	if err != nil {
+		return report, err
+	}
+	defer func() {
+		err := m.saveState(state)
+		if err != nil {
+			m.Logger.Errorf("Failed to save the state of the migration: %v", err)
+		}
+	}()
+
+	if from.SecretService != nil && to.SecretService != nil {
+		err = m.migrateSecrets(ctx, from.SecretService, to.SecretService, &report)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	if from.AuthorizationDataStorage != nil && to.AuthorizationDataStorage != nil {
+		err = m.migrateUsers(ctx, from.AuthorizationDataStorage, to.AuthorizationDataStorage, &report)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	if from.SpamDataStorage != nil && to.SpamDataStorage != nil {
+		err = migrateSpamData(ctx, from.SpamDataStorage, to.SpamDataStorage, &report)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	if from.DataSubjectLogStorage != nil && to.DataSubjectLogStorage != nil {
+		err = migrateDataSubjectLog(ctx, from.DataSubjectLogStorage, to.DataSubjectLogStorage, &report)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	feedback, err := from.FeedbackDataStorage.GetAllFeedback(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	if to.FeedbackDataStorage != nil {
+		report.Feedback.Source = len(feedback)
+	}
+
+	for _, f := range feedback {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		// The files are copied first, so the target never has feedback referring to missing files
+		filesCopied := true
+		if from.FileStorage != nil && to.FileStorage != nil {
+			for _, file := range f.Files {
+				report.Files.Source++
+				skipped, err := m.migrateFile(ctx, from.FileStorage, to.FileStorage, file, state)
+				switch {
+				case err != nil:
+					filesCopied = false
+					report.Files.Failed++
+					report.Failures = append(report.Failures, models.MigrationFailure{Kind: "file", Id: file.Id, Reason: err.Error()})
+				case skipped:
+					report.Files.Skipped++
+				default:
+					report.Files.Copied++
+				}
+
+				if err == nil {
+					m.migrateThumbnails(ctx, from.FileStorage, to.FileStorage, file, state, &report)
+				}
+			}
+		}
+
+		if to.FeedbackDataStorage == nil {
+			continue
+		}
+
+		if !filesCopied {
+			report.Feedback.Failed++
+			report.Failures = append(report.Failures, models.MigrationFailure{Kind: "feedback", Id: f.Id, Reason: "not all the attached files could be migrated"})
+			continue
+		}
+
+		err = migrateFeedback(ctx, to.FeedbackDataStorage, f)
+		if err != nil {
+			report.Feedback.Failed++
+			report.Failures = append(report.Failures, models.MigrationFailure{Kind: "feedback", Id: f.Id, Reason: err.Error()})
+			continue
+		}
+		report.Feedback.Copied++
+	}
+
+	if to.FeedbackDataStorage != nil {
+		err = verifyFeedbackCount(ctx, feedback, to.FeedbackDataStorage, &report)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	if err := m.saveState(state); err != nil {
+		return report, err
+	}
+
+	m.Logger.Infof("Migrated %d feedback entries, %d users and %d files, %d failed", report.Feedback.Copied, report.Users.Copied, report.Files.Copied+report.Files.Skipped, len(report.Failures))
+
+	if len(report.Failures) > 0 {
+		return report, models.ErrMigrationFailed
+	}
+
+	return report, nil
+}
+
+func (m *migrator) migrateSecrets(ctx context.Context, from, to models.SecretService, report *models.MigrationReport) error {
+	report.Secrets.Source = 1
+
+	secret, err := from.GetSigningSecret(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = to.SetSigningSecret(ctx, secret)
+	if err != nil {
+		return err
+	}
+
+	copied, err := to.GetSigningSecret(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(secret, copied) {
+		report.Secrets.Failed++
+		report.Failures = append(report.Failures, models.MigrationFailure{Kind: "secret", Id: "signingSecret", Reason: "the copy doesn't match the source"})
+		return nil
+	}
+
+	report.Secrets.Copied++
+	return nil
+}
+
+func (m *migrator) migrateUsers(ctx context.Context, from, to models.AuthorizationDataStorage, report *models.MigrationReport) error {
+	users, err := from.GetAllUsers(ctx)
+	if err != nil {
+		return err
+	}
+
+	report.Users.Source = len(users)
+
+	for _, user := range users {
+		err := migrateUser(ctx, to, user)
+		if err != nil {
+			report.Users.Failed++
+			report.Failures = append(report.Failures, models.MigrationFailure{Kind: "user", Id: user.Email, Reason: err.Error()})
+			continue
+		}
+		report.Users.Copied++
+	}
+
+	count, err := to.GetUserCount(ctx)
+	if err != nil {
+		return err
+	}
+
+	if count < len(users) {
+		report.Failures = append(report.Failures, models.MigrationFailure{Kind: "user", Reason: fmt.Sprintf("the target only has %d of the %d users", count, len(users))})
+	}
+
+	return nil
+}
+
+// Copies the user, or updates it if an earlier migration already copied it
+func migrateUser(ctx context.Context, to models.AuthorizationDataStorage, user models.User) error {
+	_, err := to.GetUser(ctx, user.Email)
+	if err == nil {
+		err = to.UpdateUser(ctx, user.Email, user)
+	} else if err == models.ErrNoSuchUser {
+		err = to.CreateUser(ctx, user)
+	}
+	if err != nil {
+		return err
+	}
+
+	copied, err := to.GetUser(ctx, user.Email)
+	if err != nil {
+		return err
+	}
+
+	return verifyChecksum(user, copied)
+}
+
+// Replaces the training data of the spam classifier in the target, so migrating it again is harmless
+func migrateSpamData(ctx context.Context, from, to models.SpamDataStorage, report *models.MigrationReport) error {
+	statistics, err := from.GetAllStatistics(ctx)
+	if err != nil {
+		return err
+	}
+
+	report.SpamTokens.Source = len(statistics.Tokens)
+
+	err = to.SetStatistics(ctx, statistics)
+	if err != nil {
+		return err
+	}
+
+	copied, err := to.GetAllStatistics(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = verifyChecksum(statistics, copied)
+	if err != nil {
+		report.SpamTokens.Failed = len(statistics.Tokens)
+		report.Failures = append(report.Failures, models.MigrationFailure{Kind: "spam", Reason: err.Error()})
+		return nil
+	}
+
+	report.SpamTokens.Copied = len(statistics.Tokens)
+	return nil
+}
+
+// Adds the entries that aren't in the target yet. The log is only ever added to, so entries with
+// the same id as one in the target were copied by an earlier migration
+func migrateDataSubjectLog(ctx context.Context, from, to models.DataSubjectLogStorage, report *models.MigrationReport) error {
+	entries, err := from.GetEntries(ctx)
+	if err != nil {
+		return err
+	}
+
+	report.DataSubjectLog.Source = len(entries)
+
+	existing, err := to.GetEntries(ctx)
+	if err != nil {
+		return err
+	}
+
+	copied := make(map[string]models.DataSubjectLogEntry, len(existing))
+	for _, entry := range existing {
+		copied[entry.Id] = entry
+	}
+
+	for _, entry := range entries {
+		if previous, exists := copied[entry.Id]; exists {
+			if err := verifyChecksum(entry, previous); err != nil {
+				report.DataSubjectLog.Failed++
+				report.Failures = append(report.Failures, models.MigrationFailure{Kind: "dataSubjectLog", Id: entry.Id, Reason: err.Error()})
+				continue
+			}
+			report.DataSubjectLog.Skipped++
+			continue
+		}
+
+		err = to.AddEntry(ctx, entry)
+		if err != nil {
+			report.DataSubjectLog.Failed++
+			report.Failures = append(report.Failures, models.MigrationFailure{Kind: "dataSubjectLog", Id: entry.Id, Reason: err.Error()})
+			continue
+		}
+		report.DataSubjectLog.Copied++
+	}
+
+	migrated, err := to.GetEntries(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(migrated) < len(entries) {
+		report.Failures = append(report.Failures, models.MigrationFailure{Kind: "dataSubjectLog", Reason: fmt.Sprintf("the target only has %d of the %d log entries", len(migrated), len(entries))})
+	}
+
+	return nil
+}
+
+// Copies the feedback. Saving it again is harmless, so it's always done, even when resuming
+func migrateFeedback(ctx context.Context, to models.FeedbackDataStorage, feedback models.Feedback) error {
+	err := to.SaveFeedback(ctx, feedback)
+	if err != nil {
+		return err
+	}
+
+	copied, err := to.GetFeedback(ctx, feedback.Id)
+	if err != nil {
+		return err
+	}
+
+	return verifyChecksum(feedback, copied)
+}
+
+func verifyFeedbackCount(ctx context.Context, feedback []models.Feedback, to models.FeedbackDataStorage, report *models.MigrationReport) error {
+	copied, err := to.GetAllFeedback(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(copied) < len(feedback) {
+		report.Failures = append(report.Failures, models.MigrationFailure{Kind: "feedback", Reason: fmt.Sprintf("the target only has %d of the %d feedback entries", len(copied), len(feedback))})
+	}
+
+	return nil
+}
+
+// Compares the checksums of the json of the source and the copy
+func verifyChecksum(source, copy interface{}) error {
+	sourceSum, err := jsonChecksum(source)
+	if err != nil {
+		return err
+	}
+
+	copySum, err := jsonChecksum(copy)
+	if err != nil {
+		return err
+	}
+
+	if sourceSum != copySum {
+		return errors.New("the copy doesn't match the source")
+	}
+
+	return nil
+}
+
+func jsonChecksum(value interface{}) (string, error) {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Copies the file, unless an earlier migration already did. Returns true if it was already copied
+func (m *migrator) migrateFile(ctx context.Context, from, to models.FileStorage, file models.File, state *migrationState) (skipped bool, err error) {
+	if copied, exists := state.Files[file.Id]; exists {
+		// The content of a file never changes, so the copy only has to be verified
+		size, sum, err := checksumFile(ctx, to, file.Id)
+		if err == nil && size == copied.Size && sum == copied.Sha256 {
+			return true, nil
+		}
+		m.Logger.Warnf("The earlier copy of file '%s' doesn't match, copying it again", file.Id)
+	}
+
+	reader, err := from.LoadFile(ctx, file.Id)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	size, err := to.SaveFile(ctx, file.Id, io.TeeReader(reader, hash))
+	if err != nil {
+		return false, err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	copiedSize, copiedSum, err := checksumFile(ctx, to, file.Id)
+	if err != nil {
+		return false, err
+	}
+
+	if copiedSize != size || copiedSum != sum {
+		return false, errors.New("the copy doesn't match the source")
+	}
+
+	state.Files[file.Id] = copiedFile{Size: size, Sha256: sum}
+	state.unsaved++
+	if state.unsaved < stateSaveBatch && time.Since(state.savedAt) < stateSaveInterval {
+		return false, nil
+	}
+	return false, m.saveState(state)
+}
+
+// Copies the thumbnails that have been made of the file, so they don't all have to be made again
+func (m *migrator) migrateThumbnails(ctx context.Context, from, to models.FileStorage, file models.File, state *migrationState, report *models.MigrationReport) {
+	for _, id := range thumbnail.GetThumbnailIds(file) {
+		skipped, err := m.migrateFile(ctx, from, to, models.File{Id: id}, state)
+		switch {
+		case err == models.ErrFileNotFound:
+			// Not made yet
+			continue
+		case err != nil:
+			report.Thumbnails.Failed++
+			m.Logger.Warnf("Failed to copy thumbnail '%s', it will be made again when needed: %v", id, err)
+		case skipped:
+			report.Thumbnails.Skipped++
+		default:
+			report.Thumbnails.Copied++
+		}
+		report.Thumbnails.Source++
+	}
+}
+
+// Reads the file, and gets its size and sha256 checksum
+func checksumFile(ctx context.Context, storage models.FileStorage, id string) (int64, string, error) {
+	reader, err := storage.LoadFile(ctx, id)
+	if err != nil {
+		return 0, "", err
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, reader)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (m *migrator) loadState() (*migrationState, error) {
+	state := &migrationState{
+		Files:   map[string]copiedFile{},
+		savedAt: time.Now(),
+	}
+
+	content, err := ioutil.ReadFile(m.StateFile)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(content, state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the state of the earlier migration in '%s': %v", m.StateFile, err)
+	}
+
+	m.Logger.Infof("Resuming the migration, %d files have already been copied", len(state.Files))
+
+	return state, nil
+}
+
+// Saves the state to a temporary file first, so an interruption never leaves it half written.
+// Does nothing if no files have been copied since the last save
+func (m *migrator) saveState(state *migrationState) error {
+	if state.unsaved == 0 {
+		return nil
+	}
+
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	temp := m.StateFile + ".temp"
+	err = ioutil.WriteFile(temp, content, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(temp, m.StateFile)
+	if err != nil {
+		return err
+	}
+
+	state.unsaved = 0
+	state.savedAt = time.Now()
+	return nil
+}
@@ -0,0 +1,187 @@
+package migrate
+
+import (
+	"context"
+	"github.com/zlepper/welp/internal/pkg/flatfile"
+	"github.com/zlepper/welp/internal/pkg/logging"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestStorages(t *testing.T, ctx context.Context, folder string) models.MigrationStorages {
+	logger := logging.NewLogger(logging.LoggerArgs{})
+
+	feedbackDataStorage, err := flatfile.NewFeedbackDataStorage(ctx, flatfile.DataStorageArgs{
+		Filename:     filepath.Join(folder, "feedback.json"),
+		SaveInterval: time.Hour,
+		Logger:       logger,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spamDataStorage, err := flatfile.NewSpamDataStorage(ctx, flatfile.SpamDataStorageArgs{
+		Filename:     filepath.Join(folder, "spam.json"),
+		SaveInterval: time.Hour,
+		Logger:       logger,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataSubjectLogStorage, err := flatfile.NewDataSubjectLogStorage(ctx, flatfile.DataSubjectLogStorageArgs{
+		Filename:     filepath.Join(folder, "dataSubjectLog.json"),
+		SaveInterval: time.Hour,
+		Logger:       logger,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileStorage, err := flatfile.NewFileStorage(flatfile.FileStorageArgs{
+		FolderPath: filepath.Join(folder, "files"),
+		Logger:     logger,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return models.MigrationStorages{
+		FeedbackDataStorage:   feedbackDataStorage,
+		SpamDataStorage:       spamDataStorage,
+		DataSubjectLogStorage: dataSubjectLogStorage,
+		FileStorage:           fileStorage,
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	folder, err := ioutil.TempDir("", "welp-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	from := newTestStorages(t, ctx, filepath.Join(folder, "from"))
+	to := newTestStorages(t, ctx, filepath.Join(folder, "to"))
+
+	if _, err := from.FileStorage.SaveFile(ctx, "a.txt", strings.NewReader("content")); err != nil {
+		t.Fatal(err)
+	}
+	feedback, err := models.NewFeedback("message", "", []models.File{{Id: "a.txt", Size: 7}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := from.FeedbackDataStorage.SaveFeedback(ctx, feedback); err != nil {
+		t.Fatal(err)
+	}
+	if err := from.SpamDataStorage.AddTokens(ctx, []string{"buy", "now"}, true, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := from.DataSubjectLogStorage.AddEntry(ctx, models.DataSubjectLogEntry{Id: "entry", Action: models.DataSubjectExport}); err != nil {
+		t.Fatal(err)
+	}
+
+	migrator := NewMigrator(MigratorArgs{
+		StateFile: filepath.Join(folder, "state.json"),
+		Logger:    logging.NewLogger(logging.LoggerArgs{}),
+	})
+
+	report, err := migrator.Migrate(ctx, from, to)
+	if err != nil {
+		t.Fatalf("the migration failed: %v %v", err, report.Failures)
+	}
+	if report.Feedback.Copied != 1 || report.Files.Copied != 1 || report.SpamTokens.Copied != 2 || report.DataSubjectLog.Copied != 1 {
+		t.Errorf("expected everything to be copied, got %+v", report)
+	}
+
+	statistics, err := to.SpamDataStorage.GetStatistics(ctx, []string{"buy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statistics.SpamDocuments != 1 || statistics.Tokens["buy"].Spam != 1 {
+		t.Errorf("the spam training was not migrated: %+v", statistics)
+	}
+
+	entries, err := to.DataSubjectLogStorage.GetEntries(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Id != "entry" {
+		t.Errorf("the data subject log was not migrated: %v", entries)
+	}
+
+	if _, err := os.Stat(filepath.Join(folder, "state.json")); err != nil {
+		t.Fatalf("the state was not saved: %v", err)
+	}
+
+	// Resuming only verifies what was already copied
+	report, err = migrator.Migrate(ctx, from, to)
+	if err != nil {
+		t.Fatalf("the migration failed: %v %v", err, report.Failures)
+	}
+	if report.Files.Skipped != 1 || report.Files.Copied != 0 || report.DataSubjectLog.Skipped != 1 {
+		t.Errorf("expected the copies to be reused, got %+v", report)
+	}
+
+	entries, err = to.DataSubjectLogStorage.GetEntries(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("the data subject log was copied twice: %v", entries)
+	}
+}
+
+func TestMigrateThumbnails(t *testing.T) {
+	folder, err := ioutil.TempDir("", "welp-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	from := newTestStorages(t, ctx, filepath.Join(folder, "from"))
+	to := newTestStorages(t, ctx, filepath.Join(folder, "to"))
+
+	// Only the small thumbnail has been made
+	thumbnailId := "a.png.thumb-" + string(models.SmallThumbnail) + ".png"
+	for _, id := range []string{"a.png", thumbnailId} {
+		if _, err := from.FileStorage.SaveFile(ctx, id, strings.NewReader("content")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	feedback, err := models.NewFeedback("message", "", []models.File{{Id: "a.png", Size: 7, ContentType: "image/png"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := from.FeedbackDataStorage.SaveFeedback(ctx, feedback); err != nil {
+		t.Fatal(err)
+	}
+
+	migrator := NewMigrator(MigratorArgs{
+		StateFile: filepath.Join(folder, "state.json"),
+		Logger:    logging.NewLogger(logging.LoggerArgs{}),
+	})
+
+	report, err := migrator.Migrate(ctx, from, to)
+	if err != nil {
+		t.Fatalf("the migration failed: %v %v", err, report.Failures)
+	}
+	if report.Files.Copied != 1 || report.Thumbnails.Source != 1 || report.Thumbnails.Copied != 1 {
+		t.Errorf("expected the file and the thumbnail to be copied, got %+v", report)
+	}
+
+	reader, err := to.FileStorage.LoadFile(ctx, thumbnailId)
+	if err != nil {
+		t.Fatalf("the thumbnail was not migrated: %v", err)
+	}
+	reader.Close()
+}
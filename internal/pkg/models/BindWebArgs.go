@@ -34,9 +34,33 @@ type BindWebArgs struct {
 
 	// The port to bind to
 	Port int
+	// How long requests in progress get to finish when welp shuts down
+	ShutdownTimeout time.Duration
+	// Where the prometheus metrics are served
+	Metrics MetricsOptions
+	// What is logged, and how
+	Logging LoggingOptions
+	// Where the traces of the requests are sent
+	Tracing TracingOptions
 
 	// Where to save the uploaded files
 	FolderPath string
+	// Limits for what can be uploaded along with feedback
+	UploadLimits UploadLimits
+	// If metadata, like exif and gps locations, should be removed from uploaded images
+	StripImageMetadata bool
+	// Where to store the files in S3. Files are only stored in S3 if a bucket is set
+	S3 S3Options
+	// Store files by their content, so files with the same content are only stored once
+	DeduplicateFiles bool
+	// The file with the master key used to encrypt the files and the database files.
+	// If empty, the key is read from the environment instead. If there is no key, nothing is encrypted
+	MasterKeyFile string
+
+	// How long feedback is kept
+	Retention RetentionPolicy
+	// How often the stored files are checked against the feedback
+	Consistency ConsistencyPolicy
 
 	// The name of the folder where the database files should be stored
 	// when using flat-file storage
@@ -51,4 +75,34 @@ type BindWebArgs struct {
 	TokenDuration time.Duration
 
 	EmailSenderName, EmailSenderAddress string
+
+	// The origins that are allowed to submit feedback and embed the feedback form.
+	// "*" allows every origin
+	AllowedOrigins []string
+
+	// If the honeypot field in the feedback form should be checked
+	SpamHoneypot bool
+	// Submissions faster than this after the form was loaded are rejected as spam
+	SpamMinSubmitTime time.Duration
+	// How many leading zero bits the proof of work clients has to do should have.
+	// 0 disables the proof of work
+	SpamProofOfWorkDifficulty int
+	// Feedback the spam classifier thinks is spam with at least this probability
+	// is put in quarantine. 0 disables the classifier
+	SpamThreshold float64
+
+	// Proxies that are trusted to set the X-Forwarded-For header. Ip addresses or cidr ranges
+	TrustedProxies []string
+	// How often a single client can submit feedback
+	SubmissionRateLimit RateLimitPolicy
+	// How often feedback can be submitted in total, across all clients
+	TotalSubmissionRateLimit RateLimitPolicy
+	// How often a single client can attempt to login
+	LoginRateLimit RateLimitPolicy
+	// How often a single user can call apis that requires authentication
+	ApiRateLimit RateLimitPolicy
+
+	// Reads the configuration again, and returns the new args along with the settings
+	// that are different from when welp was started. Nil if the configuration can't be reloaded
+	ReloadConfig func() (args BindWebArgs, changed []string, err error)
 }
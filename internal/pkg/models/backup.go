@@ -0,0 +1,76 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package models
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	ErrBackupManifestMissing   = errors.New("the backup doesn't have a manifest")
+	ErrBackupVersionUnknown    = errors.New("the backup was made by a newer version of welp")
+	ErrBackupChecksumMismatch  = errors.New("a file in the backup doesn't match the checksum in the manifest")
+	ErrBackupFileMissing       = errors.New("a file in the manifest is missing from the backup")
+	ErrBackupFileNotInManifest = errors.New("the backup has a file that isn't in the manifest")
+	ErrBackupInvalidPath       = errors.New("the backup has a file outside of the data folders")
+	ErrBackupFilesNotLocal     = errors.New("the uploaded files are not stored on the local disk, so they can't be part of the backup. Back them up separately, and make a backup of only the database")
+)
+
+// What is included in a backup
+type BackupOptions struct {
+	// Only backs up the database files. Required when the uploaded files are stored in S3,
+	// as they have to be backed up separately
+	DatabaseOnly bool
+}
+
+// Describes what is in a backup
+type BackupManifest struct {
+	// The version of the backup format
+	Version int `json:"version"`
+	// When the backup was made
+	Created time.Time `json:"created"`
+	// True if the uploaded files are part of the backup. They aren't if they are stored in S3
+	IncludesFiles bool `json:"includesFiles"`
+	// All the files in the backup, except the manifest itself
+	Files []BackupFile `json:"files"`
+}
+
+// A single file in a backup
+type BackupFile struct {
+	// The path of the file in the backup, e.g. db/feedback.json
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// The hex encoded sha256 hash of the content
+	Sha256 string `json:"sha256"`
+}
+
+// Makes backups of all the stored data
+type BackupService interface {
+	// Writes a tar.gz snapshot of the database files and the uploaded files.
+	// Changes to the data wait while the database files are copied.
+	// Fails with ErrBackupFilesNotLocal, before anything is written, if the uploaded files can't be included
+	Backup(ctx context.Context, writer io.Writer, options BackupOptions) (BackupManifest, error)
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// A file that refers to a blob
+type BlobReference struct {
+	FileId string
+	// The hash of the blob the file refers to
+	Hash string
+	// When the file started referring to the blob. Zero for references made before this was recorded
+	Created time.Time
+}
+
+// Keeps track of which blobs files refer to, when files are stored by their content.
+// Blobs are identified by the sha256 hash of their content
+type BlobReferenceStorage interface {
+	// Makes the file refer to the blob with the given hash
+	// If the file already referred to a blob, the hash of that blob is returned
+	SetReference(ctx context.Context, fileId, hash string) (previousHash string, err error)
+
+	// Should get the hash of the blob the file refers to
+	// If the file doesn't refer to any blob, ErrFileNotFound should be returned
+	GetReference(ctx context.Context, fileId string) (hash string, err error)
+
+	// Removes the reference from the file, and returns the hash of the blob it referred to
+	// If the file doesn't refer to any blob, ErrFileNotFound should be returned
+	RemoveReference(ctx context.Context, fileId string) (hash string, err error)
+
+	// Gets how many files refer to the blob
+	CountReferences(ctx context.Context, hash string) (int, error)
+	// Gets the references of all the files
+	GetAllReferences(ctx context.Context) ([]BlobReference, error)
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidByteSize = errors.New("size should be a number of bytes, optionally followed by a unit, e.g. 25MB")
+)
+
+// A number of bytes, that can be used directly as a command line flag
+type ByteSize int64
+
+const (
+	Byte     ByteSize = 1
+	KiloByte          = 1024 * Byte
+	MegaByte          = 1024 * KiloByte
+	GigaByte          = 1024 * MegaByte
+)
+
+var byteSizeUnits = []struct {
+	suffix string
+	size   ByteSize
+}{
+	{"GB", GigaByte},
+	{"MB", MegaByte},
+	{"KB", KiloByte},
+	{"B", Byte},
+}
+
+func (s ByteSize) String() string {
+	for _, unit := range byteSizeUnits {
+		if s != 0 && s%unit.size == 0 {
+			return fmt.Sprintf("%d%s", s/unit.size, unit.suffix)
+		}
+	}
+	return strconv.FormatInt(int64(s), 10)
+}
+
+// Parses a size like 25MB, 512KB or 1024
+func (s *ByteSize) Set(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+
+	multiplier := Byte
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			multiplier = unit.size
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			break
+		}
+	}
+
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || number < 0 {
+		return ErrInvalidByteSize
+	}
+
+	*s = ByteSize(number) * multiplier
+	return nil
+}
+
+func (s *ByteSize) Type() string {
+	return "size"
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// What is wrong with a stored file
+type ConsistencyIssueKind string
+
+const (
+	// Feedback refers to a file that isn't stored
+	MissingFile ConsistencyIssueKind = "missing"
+	// A file is stored, but no feedback refers to it
+	OrphanedFile ConsistencyIssueKind = "orphaned"
+	// The stored file doesn't have the size the feedback says it has
+	SizeMismatch ConsistencyIssueKind = "size-mismatch"
+)
+
+// Options for checking that the feedback and the stored files match
+type ConsistencyOptions struct {
+	// Removes missing files from the feedback, and corrects the sizes that don't match
+	Repair bool
+	// Deletes orphaned files
+	CollectGarbage bool
+	// Orphaned files are only deleted when they are older than this, so files
+	// that are being uploaded right now aren't deleted before their feedback is saved
+	GracePeriod time.Duration
+}
+
+// How often the consistency of the stored files is checked
+type ConsistencyPolicy struct {
+	ConsistencyOptions
+	// How often the check runs. 0 disables it
+	Interval time.Duration
+}
+
+// Something that is wrong with a stored file
+type ConsistencyIssue struct {
+	Kind   ConsistencyIssueKind `json:"kind"`
+	FileId string               `json:"fileId"`
+	// The feedback the file is attached to. Empty for orphaned files
+	FeedbackId string `json:"feedbackId,omitempty"`
+	// The size the feedback says the file has
+	ExpectedSize int64 `json:"expectedSize,omitempty"`
+	// The size of the stored file
+	ActualSize int64 `json:"actualSize,omitempty"`
+	// True if the issue was repaired, or the orphaned file was deleted
+	Fixed bool `json:"fixed"`
+	// Why the issue couldn't be fixed, if it failed
+	Error string `json:"error,omitempty"`
+}
+
+// Describes what is wrong with the file
+func (i ConsistencyIssue) Describe() string {
+	switch i.Kind {
+	case MissingFile:
+		return fmt.Sprintf("File '%s' is missing, but feedback '%s' refers to it", i.FileId, i.FeedbackId)
+	case OrphanedFile:
+		return fmt.Sprintf("File '%s' is orphaned, no feedback refers to it", i.FileId)
+	case SizeMismatch:
+		return fmt.Sprintf("File '%s' is %d bytes, but feedback '%s' says it is %d bytes", i.FileId, i.ActualSize, i.FeedbackId, i.ExpectedSize)
+	default:
+		return fmt.Sprintf("File '%s' is %s", i.FileId, i.Kind)
+	}
+}
+
+// What a consistency check found
+type ConsistencyReport struct {
+	// When the check was made
+	Time time.Time `json:"time"`
+	// How many feedback entries and stored files were checked
+	Feedback int                `json:"feedback"`
+	Files    int                `json:"files"`
+	Issues   []ConsistencyIssue `json:"issues"`
+}
+
+// Checks that the feedback and the stored files match
+type ConsistencyService interface {
+	// Finds the files that are missing, orphaned or have the wrong size, and fixes them if the options say so
+	Check(ctx context.Context, options ConsistencyOptions) (ConsistencyReport, error)
+	// Runs the check on the interval of the policy, until the context is cancelled
+	RunSchedule(ctx context.Context)
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	ErrContactAddressRequired = errors.New("a contact address is required")
+)
+
+// Something that was done to the data of a data subject
+type DataSubjectAction string
+
+const (
+	// The feedback and attachments were exported
+	DataSubjectExport DataSubjectAction = "export"
+	// The feedback and attachments were deleted permanently
+	DataSubjectErase DataSubjectAction = "erase"
+	// The feedback was anonymised
+	DataSubjectAnonymise DataSubjectAction = "anonymise"
+)
+
+// A record of something done to the data of a data subject
+type DataSubjectLogEntry struct {
+	Id string `json:"id"`
+	// When the action was done
+	Time   time.Time         `json:"time"`
+	Action DataSubjectAction `json:"action"`
+	// The sha256 hash of the normalized contact address, so the log doesn't keep the address
+	// after the data has been erased. Use HashContactAddress to find the entries of an address
+	ContactAddressHash string `json:"contactAddressHash"`
+	// Who did it, either the email of a user, or "command line"
+	PerformedBy string `json:"performedBy"`
+	// The feedback the action included
+	FeedbackIds []string `json:"feedbackIds"`
+}
+
+// Keeps the log of the data subject actions
+type DataSubjectLogStorage interface {
+	AddEntry(ctx context.Context, entry DataSubjectLogEntry) error
+	// Gets all the entries, newest first
+	GetEntries(ctx context.Context) ([]DataSubjectLogEntry, error)
+}
+
+// Handles requests from the people who has sent feedback, about the data welp has about them
+type DataSubjectService interface {
+	// Finds all feedback with the contact address. Case and surrounding spaces are ignored
+	FindFeedback(ctx context.Context, contactAddress string) ([]Feedback, error)
+	// Writes a zip file with all feedback with the contact address, and the attached files
+	Export(ctx context.Context, contactAddress, performedBy string, writer io.Writer) (DataSubjectLogEntry, error)
+	// Deletes all feedback with the contact address permanently, together with the attached files
+	Erase(ctx context.Context, contactAddress, performedBy string) (DataSubjectLogEntry, error)
+	// Anonymises all feedback with the contact address
+	Anonymise(ctx context.Context, contactAddress, performedBy string) (DataSubjectLogEntry, error)
+	// Gets the log of all the actions, newest first
+	GetLog(ctx context.Context) ([]DataSubjectLogEntry, error)
+}
+
+// Normalizes the contact address, so addresses that only differ in case or surrounding spaces are the same
+func NormalizeContactAddress(contactAddress string) string {
+	return strings.ToLower(strings.TrimSpace(contactAddress))
+}
+
+// Hashes the normalized contact address, as it is stored in the data subject log
+func HashContactAddress(contactAddress string) string {
+	hash := sha256.Sum256([]byte(NormalizeContactAddress(contactAddress)))
+	return hex.EncodeToString(hash[:])
+}
@@ -22,6 +22,8 @@
 
 package models
 
+import "context"
+
 type EmailAddress struct {
 	Name    string
 	Address string
@@ -42,5 +44,5 @@ type SendEmailArgs struct {
 }
 
 type EmailService interface {
-	SendEmail(args SendEmailArgs) error
+	SendEmail(ctx context.Context, args SendEmailArgs) error
 }
@@ -24,20 +24,82 @@ package models
 
 import (
 	"context"
+	"errors"
 	"github.com/google/uuid"
 	"time"
 )
 
+var (
+	ErrFeedbackNotFound = errors.New("feedback not found")
+	// Feedback has to be in the trash before it can be deleted permanently
+	ErrFeedbackNotTrashed = errors.New("feedback has to be moved to the trash before it can be deleted")
+)
+
 type FeedbackDataStorage interface {
 	SaveFeedback(ctx context.Context, feedback Feedback) error
 	GetAllFeedback(ctx context.Context) ([]Feedback, error)
+	// Should get the feedback with the given id
+	// If the feedback doesn't exist, ErrFeedbackNotFound should be returned
+	GetFeedback(ctx context.Context, id string) (Feedback, error)
+	// Should change the feedback with the given id, and save it, without anything else changing it in between.
+	// If update returns an error, nothing is saved and the error is returned.
+	// If the feedback doesn't exist, ErrFeedbackNotFound should be returned
+	UpdateFeedback(ctx context.Context, id string, update func(feedback *Feedback) error) (Feedback, error)
+	// Should remove the feedback with the given id completely
+	// If the feedback doesn't exist, ErrFeedbackNotFound should be returned
+	DeleteFeedback(ctx context.Context, id string) error
+	// Should get the file with the given id, from the feedback it's attached to
+	// If no feedback has the file, ErrFileNotFound should be returned
+	GetFile(ctx context.Context, id string) (File, error)
 }
 
 type FeedbackService interface {
 	CreateFeedback(ctx context.Context, message, contactAddress string, files []File) (Feedback, error)
+	// Creates feedback that is suspected to be spam. The feedback is put
+	// in quarantine, and no one is notified about it
+	CreateQuarantinedFeedback(ctx context.Context, message, contactAddress string, files []File, reason string) (Feedback, error)
+	// Gets all feedback that isn't in quarantine, archived or in the trash
 	GetAllFeedback(ctx context.Context) ([]Feedback, error)
+	// Gets all feedback that is in quarantine, and not in the trash
+	GetQuarantinedFeedback(ctx context.Context) ([]Feedback, error)
+	// Gets all feedback that is archived, and not in the trash
+	GetArchivedFeedback(ctx context.Context) ([]Feedback, error)
+	// Gets all feedback that is in the trash
+	GetTrashedFeedback(ctx context.Context) ([]Feedback, error)
+	// Marks the feedback as spam, puts it in quarantine and trains the spam classifier
+	MarkAsSpam(ctx context.Context, id string) (Feedback, error)
+	// Marks the feedback as not spam, releases it from quarantine and trains the spam classifier
+	MarkAsNotSpam(ctx context.Context, id string) (Feedback, error)
+	// Archives the feedback, which hides it from the normal list
+	Archive(ctx context.Context, id string) (Feedback, error)
+	// Moves archived feedback back to the normal list
+	Unarchive(ctx context.Context, id string) (Feedback, error)
+	// Moves the feedback to the trash, where it can be restored from
+	MoveToTrash(ctx context.Context, id string) (Feedback, error)
+	// Moves the feedback out of the trash, back to where it was before
+	Restore(ctx context.Context, id string) (Feedback, error)
+	// Deletes the feedback permanently, together with all the files attached to it.
+	// The feedback has to be in the trash first, otherwise ErrFeedbackNotTrashed is returned
+	DeleteFeedback(ctx context.Context, id string) error
+	// Deletes the feedback permanently, whether it's in the trash or not.
+	// For when the feedback has to be deleted, e.g. by the retention policy or a data subject request
+	PurgeFeedback(ctx context.Context, id string) error
+	// Removes everything that can identify the sender from the feedback: The contact address,
+	// the attached files and any email addresses in the message
+	Anonymise(ctx context.Context, id string) (Feedback, error)
 }
 
+type SpamClassification string
+
+const (
+	// No one has classified the feedback yet
+	Unclassified SpamClassification = ""
+	// An admin has marked the feedback as spam
+	ClassifiedSpam SpamClassification = "spam"
+	// An admin has marked the feedback as not spam
+	ClassifiedHam SpamClassification = "ham"
+)
+
 func NewFeedback(message, contactAddress string, files []File) (Feedback, error) {
 	id, err := uuid.NewRandom()
 	if err != nil {
@@ -64,4 +126,22 @@ type Feedback struct {
 	ContactAddress string `json:"contactAddress"`
 	// A timestamp for when the feedback was submitted
 	Created time.Time `json:"created"`
+	// True if the feedback is suspected to be spam, and is hidden from the normal list
+	Quarantined bool `json:"quarantined"`
+	// Why the feedback was put in quarantine
+	QuarantineReason string `json:"quarantineReason"`
+	// How an admin has classified the feedback
+	Classification SpamClassification `json:"classification"`
+	// True if the feedback has been archived, and is hidden from the normal list
+	Archived bool `json:"archived"`
+	// When the feedback was archived
+	ArchivedAt time.Time `json:"archivedAt"`
+	// True if the feedback is in the trash, and can either be restored or deleted permanently
+	Trashed bool `json:"trashed"`
+	// When the feedback was moved to the trash
+	TrashedAt time.Time `json:"trashedAt"`
+	// True if everything that can identify the sender has been removed from the feedback
+	Anonymised bool `json:"anonymised"`
+	// When the feedback was anonymised
+	AnonymisedAt time.Time `json:"anonymisedAt"`
 }
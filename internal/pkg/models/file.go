@@ -26,11 +26,15 @@ import (
 	"context"
 	"errors"
 	"io"
+	"mime"
 	"strings"
+	"time"
 )
 
 var (
-	ErrFileNotFound = errors.New("file not found")
+	ErrFileNotFound            = errors.New("file not found")
+	ErrDownloadUrlNotSupported = errors.New("the file storage can't give out download urls")
+	ErrListingNotSupported     = errors.New("the file storage can't list the files it stores")
 )
 
 // How to save and load actual attached files
@@ -41,6 +45,58 @@ type FileStorage interface {
 
 	// Should load the given file name from disk/storage
 	LoadFile(ctx context.Context, id string) (reader io.ReadCloser, err error)
+
+	// Should delete the file with the given name
+	// If the file doesn't exist, ErrFileNotFound should be returned
+	DeleteFile(ctx context.Context, id string) error
+}
+
+// File storages that can let users download files directly from the storage
+type DownloadUrlProvider interface {
+	// Should get a url the file can be downloaded from for a limited time.
+	// The download should use the given content type and content disposition.
+	// If the storage is not configured to give out urls, ErrDownloadUrlNotSupported should be returned
+	GetDownloadUrl(ctx context.Context, id, contentType, contentDisposition string) (string, error)
+}
+
+// A file as it is stored in a FileStorage
+type StoredFile struct {
+	// The id the file is stored with
+	Id string
+	// The size of the content, or -1 if it isn't known without reading the file
+	Size int64
+	// When the file was last written. Zero if it isn't known
+	Modified time.Time
+}
+
+// File storages that can list all the files they store
+type FileLister interface {
+	// Should list every stored file, including those no feedback refers to
+	ListFiles(ctx context.Context) ([]StoredFile, error)
+}
+
+// Options for storing files in S3, or anything compatible with the S3 api, like MinIO
+type S3Options struct {
+	// The host of the S3 api, e.g. s3.amazonaws.com or localhost:9000
+	Endpoint string
+	// The region the bucket is in. Can be left empty for most S3 compatible storages
+	Region string
+	// The bucket to store the files in. If empty, files are not stored in S3
+	Bucket string
+	// Put in front of the id of all files, e.g. "welp/"
+	Prefix string
+	// Credentials for the S3 api. If empty, the credentials are found
+	// in the environment or the instance metadata instead
+	AccessKey, SecretKey string
+	// Use the bucket name in the path, instead of the host name. Most self hosted storages needs this
+	PathStyle bool
+	// Use http instead of https
+	Insecure bool
+	// The size of each part when uploading large files
+	PartSize ByteSize
+	// How long a presigned download url should be valid. 0 disables presigned urls,
+	// and makes welp serve the files itself
+	PresignDuration time.Duration
 }
 
 type File struct {
@@ -50,9 +106,75 @@ type File struct {
 	Size int64 `json:"size"`
 	// The contentType of the file
 	ContentType string `json:"contentType"`
+	// The name of the file, as it was uploaded by the user
+	OriginalName string `json:"originalName"`
+	// True if metadata, like exif and gps locations, was removed from the file before it was saved
+	Sanitized bool `json:"sanitized"`
 }
 
 // Returns true if this file is actually an image
 func (f *File) IsImage() bool {
 	return strings.HasPrefix(f.ContentType, "image/")
 }
+
+// Gets the name the file should be downloaded as.
+// Files uploaded before the original name was kept falls back to the id
+func (f *File) GetName() string {
+	if f.OriginalName != "" {
+		return f.OriginalName
+	}
+	return f.Id
+}
+
+// Returns true if the browser can safely show the file itself,
+// instead of downloading it
+func (f *File) CanDisplayInline() bool {
+	switch {
+	case strings.HasPrefix(f.ContentType, "image/"),
+		strings.HasPrefix(f.ContentType, "video/"),
+		strings.HasPrefix(f.ContentType, "audio/"),
+		strings.HasPrefix(f.ContentType, "text/plain"),
+		f.ContentType == "application/pdf":
+		return true
+	default:
+		return false
+	}
+}
+
+// Limits for what can be uploaded along with feedback
+type UploadLimits struct {
+	// The maximum number of files a single feedback can have. 0 means no limit
+	MaxFiles int
+	// The maximum size of a single file. 0 means no limit
+	MaxFileSize ByteSize
+	// The maximum size of an entire request, including all files. 0 means no limit
+	MaxRequestSize ByteSize
+	// The content types that can be uploaded, e.g. "image/png" or "image/*".
+	// If empty, everything is allowed
+	AllowedContentTypes []string
+}
+
+// Checks if the content type is in the list of allowed content types
+func (l UploadLimits) IsContentTypeAllowed(contentType string) bool {
+	if len(l.AllowedContentTypes) == 0 {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range l.AllowedContentTypes {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == "*/*" || allowed == mediaType {
+			return true
+		}
+
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package models
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var (
+	ErrUnknownImportFormat = errors.New("the import format should be either csv or json")
+)
+
+// The format of the file feedback is imported from
+type ImportFormat string
+
+const (
+	// A csv file with a header row
+	ImportCSV ImportFormat = "csv"
+	// Either a json array of objects, or newline delimited json objects
+	ImportJSON ImportFormat = "json"
+)
+
+func ParseImportFormat(format string) (ImportFormat, error) {
+	switch importFormat := ImportFormat(format); importFormat {
+	case ImportCSV, ImportJSON:
+		return importFormat, nil
+	default:
+		return "", ErrUnknownImportFormat
+	}
+}
+
+// Which columns (csv) or fields (json) the values of the feedback are read from.
+// Fields in nested json objects are separated by dots, e.g. "from.email".
+// An empty name means the value isn't imported
+type ImportMapping struct {
+	// The id of the feedback. If it's missing, an id is made from the content,
+	// so importing the same file again doesn't import the feedback twice
+	Id string
+	// The message, the only value that is required
+	Message string
+	// The contact address
+	ContactAddress string
+	// When the feedback was created. If it's missing, the time of the import is used
+	Created string
+	// The attachments, as urls or paths to files. A csv column can have more than one, separated by ";".
+	// A json field can either be a string or an array of strings
+	Attachments string
+}
+
+// The default mapping, which fits the csv and json exports from welp
+var DefaultImportMapping = ImportMapping{
+	Id:             "id",
+	Message:        "message",
+	ContactAddress: "contactAddress",
+	Created:        "created",
+	Attachments:    "attachments",
+}
+
+type ImportOptions struct {
+	Format  ImportFormat
+	Mapping ImportMapping
+	// The layout created times are parsed with, as in time.Parse. If empty,
+	// the most common layouts, and unix timestamps, are tried
+	TimeFormat string
+	// The folder relative attachment paths are read from. If empty, attachments can only be urls
+	AttachmentFolder string
+	// If attachments can be downloaded from loopback and private network addresses
+	AllowPrivateNetworks bool
+	// The limits the attachments has to follow
+	UploadLimits UploadLimits
+}
+
+// A row that couldn't be imported
+type ImportRejection struct {
+	// The number of the row in csv files, not counting the header, or of the object in json files, starting from 1
+	Row int `json:"row"`
+	// The id of the feedback, if it was known
+	Id     string `json:"id,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// What an import did
+type ImportReport struct {
+	// How many feedback entries were imported
+	Imported int `json:"imported"`
+	// How many feedback entries already existed, and were skipped
+	Duplicates int               `json:"duplicates"`
+	Rejected   []ImportRejection `json:"rejected"`
+}
+
+// Imports feedback from other tools
+type ImportService interface {
+	// Imports all the feedback in the reader. Rows that can't be imported are
+	// rejected and reported, while the rest are still imported
+	Import(ctx context.Context, reader io.Reader, options ImportOptions) (ImportReport, error)
+}
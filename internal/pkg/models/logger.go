@@ -22,6 +22,16 @@
 
 package models
 
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrUnknownLogLevel  = errors.New("the log level should be debug, info, warn or error")
+	ErrUnknownLogFormat = errors.New("the log format should be either text or json")
+)
+
 type Logger interface {
 	Info(i ...interface{})
 	Infof(s string, i ...interface{})
@@ -35,4 +45,69 @@ type Logger interface {
 	Fatalf(s string, i ...interface{})
 	Panic(i ...interface{})
 	Panicf(s string, i ...interface{})
+	// Gets a logger that includes the request id of the context in every entry
+	WithContext(ctx context.Context) Logger
+	// Gets a logger that includes the field in every entry
+	WithField(key string, value interface{}) Logger
+}
+
+// The least important entries that are logged
+type LogLevel string
+
+const (
+	LogDebug LogLevel = "debug"
+	LogInfo  LogLevel = "info"
+	LogWarn  LogLevel = "warn"
+	LogError LogLevel = "error"
+)
+
+func (l LogLevel) String() string {
+	return string(l)
+}
+
+func (l *LogLevel) Set(value string) error {
+	switch level := LogLevel(value); level {
+	case LogDebug, LogInfo, LogWarn, LogError:
+		*l = level
+		return nil
+	default:
+		return ErrUnknownLogLevel
+	}
+}
+
+func (l *LogLevel) Type() string {
+	return "level"
+}
+
+// How the log entries are written
+type LogFormat string
+
+const (
+	// A line of text per entry, for people to read
+	LogText LogFormat = "text"
+	// A json object per line, for log collectors
+	LogJson LogFormat = "json"
+)
+
+func (f LogFormat) String() string {
+	return string(f)
+}
+
+func (f *LogFormat) Set(value string) error {
+	switch format := LogFormat(value); format {
+	case LogText, LogJson:
+		*f = format
+		return nil
+	default:
+		return ErrUnknownLogFormat
+	}
+}
+
+func (f *LogFormat) Type() string {
+	return "format"
+}
+
+type LoggingOptions struct {
+	Level  LogLevel
+	Format LogFormat
 }
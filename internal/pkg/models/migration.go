@@ -0,0 +1,87 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package models
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrMigrationFailed = errors.New("some of the data couldn't be migrated, or didn't match after it was copied")
+)
+
+// The storages data is migrated from or to. A nil storage isn't migrated.
+// The references of deduplicated files aren't migrated directly. They are made again
+// when the files are copied to the new deduplicating file storage
+type MigrationStorages struct {
+	FeedbackDataStorage      FeedbackDataStorage
+	AuthorizationDataStorage AuthorizationDataStorage
+	SecretService            SecretService
+	SpamDataStorage          SpamDataStorage
+	DataSubjectLogStorage    DataSubjectLogStorage
+	FileStorage              FileStorage
+}
+
+// How many of a kind of data was migrated
+type MigrationCount struct {
+	// How many there are in the source
+	Source int `json:"source"`
+	// How many were copied and verified
+	Copied int `json:"copied"`
+	// How many were already copied by an earlier migration, and still matched
+	Skipped int `json:"skipped"`
+	// How many couldn't be copied, or didn't match after they were copied
+	Failed int `json:"failed"`
+}
+
+// Something that couldn't be migrated
+type MigrationFailure struct {
+	// The kind of data, e.g. "feedback" or "file"
+	Kind string `json:"kind"`
+	// The id of the feedback or file, or the email of the user
+	Id     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// What a migration did
+type MigrationReport struct {
+	Feedback MigrationCount `json:"feedback"`
+	Users    MigrationCount `json:"users"`
+	Secrets  MigrationCount `json:"secrets"`
+	// The tokens the spam classifier has been trained with
+	SpamTokens     MigrationCount `json:"spamTokens"`
+	DataSubjectLog MigrationCount `json:"dataSubjectLog"`
+	Files          MigrationCount `json:"files"`
+	// Thumbnails that fail aren't failures of the migration, as they are made again when needed
+	Thumbnails MigrationCount     `json:"thumbnails"`
+	Failures   []MigrationFailure `json:"failures"`
+}
+
+// Moves all the data from one set of storages to another
+type Migrator interface {
+	// Copies everything from the source to the target, and verifies the copies.
+	// Files that were copied by an earlier, interrupted migration are only verified.
+	// If anything fails, the rest is still migrated, and ErrMigrationFailed is returned
+	Migrate(ctx context.Context, from, to MigrationStorages) (MigrationReport, error)
+}
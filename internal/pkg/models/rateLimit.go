@@ -0,0 +1,96 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidRateLimitPolicy = errors.New("rate limit should be formatted as <requests>/<period>, e.g. 10/1m")
+)
+
+// How many requests a client can make within a period.
+// Can be used directly as a command line flag
+type RateLimitPolicy struct {
+	Requests int
+	Period   time.Duration
+}
+
+// Returns true if requests should actually be limited
+func (p RateLimitPolicy) Enabled() bool {
+	return p.Requests > 0 && p.Period > 0
+}
+
+func (p RateLimitPolicy) String() string {
+	if !p.Enabled() {
+		return "0"
+	}
+	return fmt.Sprintf("%d/%s", p.Requests, p.Period)
+}
+
+// Parses a policy formatted as <requests>/<period>, e.g. 10/1m.
+// "0" or an empty string disables the limit
+func (p *RateLimitPolicy) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "0" {
+		*p = RateLimitPolicy{}
+		return nil
+	}
+
+	parts := strings.Split(value, "/")
+	if len(parts) != 2 {
+		return ErrInvalidRateLimitPolicy
+	}
+
+	requests, err := strconv.Atoi(parts[0])
+	if err != nil || requests < 0 {
+		return ErrInvalidRateLimitPolicy
+	}
+
+	period, err := time.ParseDuration(parts[1])
+	if err != nil || period <= 0 {
+		return ErrInvalidRateLimitPolicy
+	}
+
+	*p = RateLimitPolicy{
+		Requests: requests,
+		Period:   period,
+	}
+	return nil
+}
+
+func (p *RateLimitPolicy) Type() string {
+	return "rateLimit"
+}
+
+type RateLimiter interface {
+	// Should take a request from the bucket with the given key.
+	// If the bucket is empty, false is returned along with how long
+	// it will take before a request is allowed again
+	Allow(key string) (allowed bool, retryAfter time.Duration)
+}
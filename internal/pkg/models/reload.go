@@ -0,0 +1,46 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package models
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrReloadNotSupported = errors.New("the configuration can't be reloaded, as welp wasn't started from the command line")
+)
+
+// What happened when the configuration was reloaded
+type ReloadReport struct {
+	// The settings that were changed in the running services
+	Applied []string `json:"applied"`
+	// The settings that are different from when welp was started, but only take effect when welp is restarted
+	RequiresRestart []string `json:"requiresRestart"`
+}
+
+type ConfigReloader interface {
+	// Reads the configuration again, and swaps the settings that can be changed while welp runs
+	// into the running services. If the configuration is invalid, nothing is changed
+	Reload(ctx context.Context) (ReloadReport, error)
+}
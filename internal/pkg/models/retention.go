@@ -0,0 +1,112 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrUnknownRetentionAction = errors.New("the retention action should be either delete or anonymise")
+)
+
+// What happens to feedback once it should no longer be kept
+type RetentionAction string
+
+const (
+	// The feedback and its attachments are deleted permanently
+	RetentionDelete RetentionAction = "delete"
+	// Everything that can identify the sender is removed from the feedback,
+	// while the message is kept
+	RetentionAnonymise RetentionAction = "anonymise"
+)
+
+func (a RetentionAction) String() string {
+	return string(a)
+}
+
+func (a *RetentionAction) Set(value string) error {
+	switch action := RetentionAction(value); action {
+	case RetentionDelete, RetentionAnonymise:
+		*a = action
+		return nil
+	default:
+		return ErrUnknownRetentionAction
+	}
+}
+
+func (a *RetentionAction) Type() string {
+	return "action"
+}
+
+// How long feedback is kept
+type RetentionPolicy struct {
+	// Feedback is purged this long after it was created. 0 disables the rule
+	AfterCreation time.Duration
+	// Feedback is purged this long after it was resolved (archived). 0 disables the rule
+	AfterResolution time.Duration
+	// What is done to the feedback when it is purged
+	Action RetentionAction
+	// How often the policy is enforced
+	Interval time.Duration
+	// Only log what would be purged, without changing anything
+	DryRun bool
+}
+
+// True if any of the rules are enabled
+func (p RetentionPolicy) Enabled() bool {
+	return p.AfterCreation > 0 || p.AfterResolution > 0
+}
+
+// A single feedback entry the retention policy applies to
+type RetentionReportItem struct {
+	FeedbackId string `json:"feedbackId"`
+	// When the feedback was created
+	Created time.Time `json:"created"`
+	// Which of the rules applies to the feedback
+	Reason string `json:"reason"`
+	// What is done to the feedback
+	Action RetentionAction `json:"action"`
+	// Why the feedback couldn't be purged, if it failed
+	Error string `json:"error,omitempty"`
+}
+
+// What a run of the retention policy did, or would have done
+type RetentionReport struct {
+	// When the policy was enforced
+	Time time.Time `json:"time"`
+	// True if nothing was actually changed
+	DryRun bool                  `json:"dryRun"`
+	Items  []RetentionReportItem `json:"items"`
+}
+
+// Enforces the retention policy
+type RetentionService interface {
+	// Purges all the feedback the policy says should no longer be kept.
+	// If dryRun is true, nothing is changed, but the report still tells what would have been purged
+	Enforce(ctx context.Context, dryRun bool) (RetentionReport, error)
+	// Enforces the policy on the interval of the policy, until the context is cancelled
+	RunSchedule(ctx context.Context)
+}
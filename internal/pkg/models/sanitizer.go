@@ -0,0 +1,42 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package models
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var (
+	ErrImageNotSanitized = errors.New("the metadata couldn't be removed from the image")
+)
+
+// Cleans uploaded files before they are stored
+type FileSanitizer interface {
+	// Removes anything from the file that shouldn't be stored, like the location
+	// a photo was taken at. Returns the cleaned content, and true if the file was sanitized.
+	// Files the sanitizer doesn't know how to clean are returned as they are, with false.
+	// If a file it knows can't be cleaned, ErrImageNotSanitized is returned, so it isn't stored.
+	Sanitize(ctx context.Context, contentType string, reader io.Reader) (io.Reader, bool, error)
+}
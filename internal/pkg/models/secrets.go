@@ -27,4 +27,6 @@ import "context"
 type SecretService interface {
 	// Should get the secret used to sign json tokens
 	GetSigningSecret(ctx context.Context) ([]byte, error)
+	// Should replace the secret used to sign json tokens, e.g. when migrating from another storage
+	SetSigningSecret(ctx context.Context, secret []byte) error
 }
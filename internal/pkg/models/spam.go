@@ -0,0 +1,113 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package models
+
+import (
+	"context"
+	"errors"
+	"net/url"
+)
+
+var (
+	ErrSpamRejected = errors.New("submission rejected as spam")
+)
+
+type SpamVerdict int
+
+const (
+	// Nothing suggests that the submission is spam
+	NotSpam SpamVerdict = iota
+	// The submission might be spam, and should be put in quarantine
+	SuspectedSpam SpamVerdict = iota
+	// The submission is definitely not from a real user, and should be rejected completely
+	RejectedSpam SpamVerdict = iota
+)
+
+type SpamCheckResult struct {
+	Verdict SpamVerdict
+	// A human readable reason for the verdict
+	Reason string
+}
+
+type SpamCheckArgs struct {
+	// The message from the user
+	Message string
+	// The contact address provided by the user
+	ContactAddress string
+	// All the form values that was submitted along with the feedback
+	Form url.Values
+}
+
+// A single step in the spam defence pipeline
+type SpamFilter interface {
+	// Should check if the submission looks like spam
+	Check(ctx context.Context, args SpamCheckArgs) (SpamCheckResult, error)
+}
+
+// A challenge clients has to include when submitting feedback
+type SpamChallenge struct {
+	// A signed token, that should be sent back in the formToken field
+	Token string `json:"token" xml:"token"`
+	// How many leading zero bits sha256(token + ":" + proofOfWork) should have.
+	// 0 means no proof of work is required
+	Difficulty int `json:"difficulty" xml:"difficulty"`
+}
+
+type SpamChallengeService interface {
+	// Should issue a new challenge for a client about to submit feedback
+	NewChallenge(ctx context.Context) (SpamChallenge, error)
+}
+
+type SpamClassifier interface {
+	// Should teach the classifier that the given text is (or isn't) spam.
+	// If untrain is true, an earlier training of the text should be reverted instead
+	Train(ctx context.Context, text string, spam bool, untrain bool) error
+	// Should return the probability that the given text is spam, between 0 and 1
+	SpamProbability(ctx context.Context, text string) (float64, error)
+}
+
+// The number of times a token has been seen in spam and non-spam texts
+type SpamTokenCount struct {
+	Spam int `json:"spam"`
+	Ham  int `json:"ham"`
+}
+
+type SpamStatistics struct {
+	// The counts for the requested tokens
+	Tokens map[string]SpamTokenCount
+	// The number of texts that has been trained as spam
+	SpamDocuments int
+	// The number of texts that has been trained as not spam
+	HamDocuments int
+}
+
+type SpamDataStorage interface {
+	// Should add (or subtract, if delta is negative) delta to the counts of all the given tokens
+	AddTokens(ctx context.Context, tokens []string, spam bool, delta int) error
+	// Should get the counts of the given tokens
+	GetStatistics(ctx context.Context, tokens []string) (SpamStatistics, error)
+	// Should get the counts of all the tokens, e.g. to migrate them
+	GetAllStatistics(ctx context.Context) (SpamStatistics, error)
+	// Should replace all the counts with the given ones
+	SetStatistics(ctx context.Context, statistics SpamStatistics) error
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package models
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var (
+	ErrUnknownThumbnailSize  = errors.New("unknown thumbnail size")
+	ErrThumbnailNotSupported = errors.New("thumbnails can't be made for this file")
+)
+
+// The size of a thumbnail
+type ThumbnailSize string
+
+const (
+	// Small enough to show many in a list
+	SmallThumbnail ThumbnailSize = "small"
+	// Big enough to preview the image on most screens
+	LargeThumbnail ThumbnailSize = "large"
+)
+
+// All the thumbnail sizes, and the maximum width and height in pixels for each
+var ThumbnailSizes = map[ThumbnailSize]int{
+	SmallThumbnail: 320,
+	LargeThumbnail: 1280,
+}
+
+// Parses the size of a thumbnail. An empty size gives the small thumbnail
+func ParseThumbnailSize(size string) (ThumbnailSize, error) {
+	if size == "" {
+		return SmallThumbnail, nil
+	}
+
+	thumbnailSize := ThumbnailSize(size)
+	if _, exists := ThumbnailSizes[thumbnailSize]; !exists {
+		return "", ErrUnknownThumbnailSize
+	}
+
+	return thumbnailSize, nil
+}
+
+// A resized variant of an image
+type Thumbnail struct {
+	// The id the thumbnail is stored with in the FileStorage
+	Id string
+	// The content type of the thumbnail, either image/jpeg or image/png
+	ContentType string
+}
+
+// Creates and loads resized variants of image files
+type ThumbnailService interface {
+	// Creates all the thumbnails for the file, and saves them in the FileStorage
+	// If the file isn't an image that can be resized, ErrThumbnailNotSupported is returned
+	GenerateThumbnails(ctx context.Context, file File) error
+
+	// Loads the thumbnail of the given size. If it doesn't exist yet, it is created first.
+	// If the file isn't an image that can be resized, ErrThumbnailNotSupported is returned
+	LoadThumbnail(ctx context.Context, file File, size ThumbnailSize) (Thumbnail, io.ReadCloser, error)
+
+	// Deletes all the thumbnails of the file, if there are any
+	DeleteThumbnails(ctx context.Context, file File) error
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package ratelimit
+
+import (
+	"github.com/zlepper/welp/internal/pkg/models"
+	"math"
+	"sync"
+	"time"
+)
+
+// Creates a token bucket rate limiter. Each key gets a bucket that
+// holds up to policy.Requests tokens, and is refilled evenly over policy.Period.
+// If the policy isn't enabled, everything is allowed.
+func NewTokenBucketLimiter(policy models.RateLimitPolicy) models.RateLimiter {
+	if !policy.Enabled() {
+		return &unlimited{}
+	}
+
+	return &tokenBucketLimiter{
+		capacity:    float64(policy.Requests),
+		refillRate:  float64(policy.Requests) / policy.Period.Seconds(),
+		period:      policy.Period,
+		buckets:     map[string]*bucket{},
+		lastCleanup: time.Now(),
+		now:         time.Now,
+	}
+}
+
+type bucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+type tokenBucketLimiter struct {
+	// The maximum number of tokens in a bucket
+	capacity float64
+	// Tokens per second
+	refillRate float64
+	period     time.Duration
+
+	lock        sync.Mutex
+	buckets     map[string]*bucket
+	lastCleanup time.Time
+
+	// Gets the current time, replaced in tests
+	now func() time.Time
+}
+
+func (l *tokenBucketLimiter) Allow(key string) (bool, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+	l.cleanup(now)
+
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &bucket{
+			tokens:     l.capacity,
+			lastRefill: now,
+		}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.capacity, b.tokens+now.Sub(b.lastRefill).Seconds()*l.refillRate)
+	b.lastRefill = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	missing := 1 - b.tokens
+	return false, time.Duration(missing / l.refillRate * float64(time.Second))
+}
+
+// Removes buckets that has been refilled completely, as they are no different from new buckets.
+// Runs at most once per period, so it doesn't slow down every request
+func (l *tokenBucketLimiter) cleanup(now time.Time) {
+	if now.Sub(l.lastCleanup) < l.period {
+		return
+	}
+	l.lastCleanup = now
+
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.lastRefill).Seconds()*l.refillRate >= l.capacity {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+type unlimited struct {
+}
+
+func (*unlimited) Allow(key string) (bool, time.Duration) {
+	return true, 0
+}
@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"github.com/zlepper/welp/internal/pkg/models"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(policy models.RateLimitPolicy) (*tokenBucketLimiter, *time.Time) {
+	now := time.Now()
+	limiter := NewTokenBucketLimiter(policy).(*tokenBucketLimiter)
+	limiter.lastCleanup = now
+	limiter.now = func() time.Time {
+		return now
+	}
+	return limiter, &now
+}
+
+func TestTokenBucketLimiter(t *testing.T) {
+	limiter, now := newTestLimiter(models.RateLimitPolicy{Requests: 2, Period: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		if allowed, _ := limiter.Allow("a"); !allowed {
+			t.Fatalf("request %d was not allowed", i)
+		}
+	}
+
+	allowed, retryAfter := limiter.Allow("a")
+	if allowed {
+		t.Fatal("request over the limit was allowed")
+	}
+	if retryAfter != 30*time.Second {
+		t.Errorf("expected to retry after 30s, got %v", retryAfter)
+	}
+
+	if allowed, _ := limiter.Allow("b"); !allowed {
+		t.Error("the limit of one key was used for another key")
+	}
+
+	*now = now.Add(30 * time.Second)
+	if allowed, _ := limiter.Allow("a"); !allowed {
+		t.Error("request was not allowed after the bucket was refilled")
+	}
+	if allowed, _ := limiter.Allow("a"); allowed {
+		t.Error("the bucket was refilled more than the time allows")
+	}
+}
+
+func TestTokenBucketLimiterCleanup(t *testing.T) {
+	limiter, now := newTestLimiter(models.RateLimitPolicy{Requests: 2, Period: time.Minute})
+
+	limiter.Allow("a")
+	*now = now.Add(30 * time.Second)
+	limiter.Allow("b")
+	limiter.Allow("b")
+
+	// By now "a" is full again, but "b" is only half full
+	*now = now.Add(30 * time.Second)
+	limiter.Allow("c")
+
+	if _, found := limiter.buckets["a"]; found {
+		t.Error("full bucket was not removed")
+	}
+	if _, found := limiter.buckets["b"]; !found {
+		t.Error("bucket in use was removed")
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	limiter := NewTokenBucketLimiter(models.RateLimitPolicy{})
+
+	for i := 0; i < 100; i++ {
+		if allowed, _ := limiter.Allow("a"); !allowed {
+			t.Fatal("request was limited without a policy")
+		}
+	}
+}
@@ -0,0 +1,225 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package s3
+
+import (
+	"context"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/tracing"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+type FileStorageArgs struct {
+	models.S3Options
+
+	Logger models.Logger
+}
+
+// Gets a new file storage for saving files in S3, or anything compatible with the S3 api.
+// The bucket is created if it doesn't exist already.
+func NewFileStorage(ctx context.Context, args FileStorageArgs) (models.FileStorage, error) {
+	bucketLookup := minio.BucketLookupAuto
+	if args.PathStyle {
+		bucketLookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(args.Endpoint, &minio.Options{
+		Creds:        getCredentials(args.S3Options),
+		Secure:       !args.Insecure,
+		Region:       args.Region,
+		BucketLookup: bucketLookup,
+	})
+	if err != nil {
+		args.Logger.Errorf("Failed to create S3 client: %v", err)
+		return nil, err
+	}
+
+	exists, err := client.BucketExists(ctx, args.Bucket)
+	if err != nil {
+		args.Logger.Errorf("Failed to check if the S3 bucket '%s' exists: %v", args.Bucket, err)
+		return nil, err
+	}
+
+	if !exists {
+		args.Logger.Infof("Creating S3 bucket '%s'", args.Bucket)
+		err = client.MakeBucket(ctx, args.Bucket, minio.MakeBucketOptions{Region: args.Region})
+		if err != nil {
+			args.Logger.Errorf("Failed to create S3 bucket '%s': %v", args.Bucket, err)
+			return nil, err
+		}
+	}
+
+	return &fileStorage{
+		args:   args,
+		client: client,
+		logger: args.Logger,
+	}, nil
+}
+
+// Uses the given credentials if there are any, otherwise they are found
+// in the environment, the aws credentials file, or the instance metadata
+func getCredentials(options models.S3Options) *credentials.Credentials {
+	if options.AccessKey != "" || options.SecretKey != "" {
+		return credentials.NewStaticV4(options.AccessKey, options.SecretKey, "")
+	}
+
+	return credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{
+			Client: &http.Client{
+				Transport: http.DefaultTransport,
+			},
+		},
+	})
+}
+
+type fileStorage struct {
+	args   FileStorageArgs
+	client *minio.Client
+	logger models.Logger
+}
+
+func (s *fileStorage) getKey(name string) string {
+	return path.Join(s.args.Prefix, name)
+}
+
+func (s *fileStorage) SaveFile(ctx context.Context, name string, reader io.Reader) (size int64, err error) {
+	ctx, span := tracing.StartClientSpan(ctx, "s3.SaveFile")
+	defer func() {
+		span.EndWithError(err)
+	}()
+	span.SetAttribute("file.id", name)
+
+	key := s.getKey(name)
+	s.logger.WithContext(ctx).Debugf("Saving file to S3: '%s'", key)
+
+	// The size isn't known up front, so the file is streamed as a multipart upload,
+	// with one part in memory at a time
+	info, err := s.client.PutObject(ctx, s.args.Bucket, key, reader, -1, minio.PutObjectOptions{
+		PartSize: uint64(s.args.PartSize),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size, nil
+}
+
+func (s *fileStorage) LoadFile(ctx context.Context, name string) (reader io.ReadCloser, err error) {
+	ctx, span := tracing.StartClientSpan(ctx, "s3.LoadFile")
+	defer func() {
+		span.EndWithError(err)
+	}()
+	span.SetAttribute("file.id", name)
+
+	key := s.getKey(name)
+	s.logger.WithContext(ctx).Debugf("Loading file from S3: '%s'", key)
+
+	object, err := s.client.GetObject(ctx, s.args.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	// The object is only fetched when it's first used, so check that it's actually there
+	_, err = object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, convertError(err)
+	}
+
+	return object, nil
+}
+
+func (s *fileStorage) DeleteFile(ctx context.Context, name string) (err error) {
+	ctx, span := tracing.StartClientSpan(ctx, "s3.DeleteFile")
+	defer func() {
+		span.EndWithError(err)
+	}()
+	span.SetAttribute("file.id", name)
+
+	key := s.getKey(name)
+	s.logger.WithContext(ctx).Debugf("Deleting file from S3: '%s'", key)
+
+	// Deleting is allowed even if the object doesn't exist, so check first
+	_, err = s.client.StatObject(ctx, s.args.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return convertError(err)
+	}
+
+	return s.client.RemoveObject(ctx, s.args.Bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *fileStorage) ListFiles(ctx context.Context) ([]models.StoredFile, error) {
+	prefix := ""
+	if s.args.Prefix != "" {
+		prefix = path.Clean(s.args.Prefix) + "/"
+	}
+
+	files := make([]models.StoredFile, 0)
+	for object := range s.client.ListObjects(ctx, s.args.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, convertError(object.Err)
+		}
+
+		files = append(files, models.StoredFile{
+			Id:       strings.TrimPrefix(object.Key, prefix),
+			Size:     object.Size,
+			Modified: object.LastModified,
+		})
+	}
+
+	return files, nil
+}
+
+func (s *fileStorage) GetDownloadUrl(ctx context.Context, name, contentType, contentDisposition string) (string, error) {
+	if s.args.PresignDuration <= 0 {
+		return "", models.ErrDownloadUrlNotSupported
+	}
+
+	params := url.Values{}
+	params.Set("response-content-type", contentType)
+	params.Set("response-content-disposition", contentDisposition)
+
+	presigned, err := s.client.PresignedGetObject(ctx, s.args.Bucket, s.getKey(name), s.args.PresignDuration, params)
+	if err != nil {
+		return "", err
+	}
+
+	return presigned.String(), nil
+}
+
+func convertError(err error) error {
+	response := minio.ToErrorResponse(err)
+	if response.StatusCode == http.StatusNotFound || response.Code == "NoSuchKey" {
+		return models.ErrFileNotFound
+	}
+	return err
+}
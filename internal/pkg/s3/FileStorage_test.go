@@ -0,0 +1,358 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/zlepper/welp/internal/pkg/logging"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A small stand-in for an S3 compatible storage, with path style buckets.
+// Only supports what the file storage uses
+type fakeS3 struct {
+	lock    sync.Mutex
+	buckets map[string]bool
+	objects map[string][]byte
+	// The parts of unfinished multipart uploads, by upload id and part number
+	uploads map[string]map[int][]byte
+	// How many parts the last multipart upload was completed with
+	lastPartCount int
+	nextUploadId  int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		buckets: map[string]bool{},
+		objects: map[string][]byte{},
+		uploads: map[string]map[int][]byte{},
+	}
+}
+
+type listBucketResult struct {
+	XMLName     xml.Name `xml:"ListBucketResult"`
+	Name        string
+	Prefix      string
+	KeyCount    int
+	IsTruncated bool
+	Contents    []listObject
+}
+
+type listObject struct {
+	Key          string
+	Size         int64
+	LastModified string
+	ETag         string
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int
+	} `xml:"Part"`
+}
+
+var modified = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func writeXML(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+// Reads the body of an upload. Uploads over http are signed chunk by chunk, as
+// <size in hex>;chunk-signature=<signature>\r\n<data>\r\n, ending with a chunk of size 0
+func readBody(r *http.Request) ([]byte, error) {
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil || !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return content, err
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(content))
+	var decoded []byte
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		var size int
+		if _, err := fmt.Sscanf(strings.SplitN(header, ";", 2)[0], "%x", &size); err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return decoded, nil
+		}
+
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return nil, err
+		}
+		decoded = append(decoded, chunk[:size]...)
+	}
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket := parts[0]
+	query := r.URL.Query()
+
+	if len(parts) == 1 || parts[1] == "" {
+		switch r.Method {
+		case http.MethodHead:
+			if !s.buckets[bucket] {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodPut:
+			s.buckets[bucket] = true
+		case http.MethodGet:
+			s.listObjects(w, bucket, query.Get("prefix"))
+		default:
+			writeError(w, http.StatusNotImplemented, "NotImplemented")
+		}
+		return
+	}
+
+	key := bucket + "/" + parts[1]
+	_, isUpload := query["uploads"]
+	uploadId := query.Get("uploadId")
+
+	switch {
+	case r.Method == http.MethodPost && isUpload:
+		s.nextUploadId++
+		id := fmt.Sprintf("upload-%d", s.nextUploadId)
+		s.uploads[id] = map[int][]byte{}
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucket, Key: parts[1], UploadId: id})
+	case r.Method == http.MethodPut && uploadId != "":
+		var partNumber int
+		fmt.Sscan(query.Get("partNumber"), &partNumber)
+		content, err := readBody(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		s.uploads[uploadId][partNumber] = content
+		w.Header().Set("ETag", fmt.Sprintf(`"part-%d"`, partNumber))
+	case r.Method == http.MethodPost && uploadId != "":
+		var complete completeMultipartUpload
+		if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil {
+			writeError(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		var content []byte
+		for _, part := range complete.Parts {
+			content = append(content, s.uploads[uploadId][part.PartNumber]...)
+		}
+		s.objects[key] = content
+		s.lastPartCount = len(complete.Parts)
+		delete(s.uploads, uploadId)
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: bucket, Key: parts[1], ETag: `"complete"`})
+	case r.Method == http.MethodDelete && uploadId != "":
+		delete(s.uploads, uploadId)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		content, exists := s.objects[key]
+		if !exists {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		if contentType := query.Get("response-content-type"); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.Header().Set("ETag", `"object"`)
+		http.ServeContent(w, r, key, modified, bytes.NewReader(content))
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (s *fakeS3) listObjects(w http.ResponseWriter, bucket, prefix string) {
+	result := listBucketResult{Name: bucket, Prefix: prefix}
+	for key, content := range s.objects {
+		name := strings.TrimPrefix(key, bucket+"/")
+		if strings.HasPrefix(key, bucket+"/") && strings.HasPrefix(name, prefix) {
+			result.Contents = append(result.Contents, listObject{
+				Key:          name,
+				Size:         int64(len(content)),
+				LastModified: modified.Format(time.RFC3339),
+				ETag:         `"object"`,
+			})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool {
+		return result.Contents[i].Key < result.Contents[j].Key
+	})
+	result.KeyCount = len(result.Contents)
+	writeXML(w, result)
+}
+
+func newTestStorage(t *testing.T, fake *fakeS3, presignDuration time.Duration) (*fileStorage, *httptest.Server) {
+	server := httptest.NewServer(fake)
+
+	storage, err := NewFileStorage(context.Background(), FileStorageArgs{
+		S3Options: models.S3Options{
+			Endpoint:        strings.TrimPrefix(server.URL, "http://"),
+			Region:          "us-east-1",
+			Bucket:          "welp",
+			Prefix:          "files/",
+			AccessKey:       "access",
+			SecretKey:       "secret",
+			PathStyle:       true,
+			Insecure:        true,
+			PartSize:        5 * 1024 * 1024,
+			PresignDuration: presignDuration,
+		},
+		Logger: logging.NewLogger(logging.LoggerArgs{}),
+	})
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+
+	return storage.(*fileStorage), server
+}
+
+func TestFileStorage(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeS3()
+	storage, server := newTestStorage(t, fake, 0)
+	defer server.Close()
+
+	if !fake.buckets["welp"] {
+		t.Fatal("the bucket was not created")
+	}
+
+	// Larger than a single part, so the upload needs more than one
+	content := bytes.Repeat([]byte("0123456789"), 600*1024)
+	size, err := storage.SaveFile(ctx, "a.txt", bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(content)) {
+		t.Errorf("expected the size to be %d, got %d", len(content), size)
+	}
+	if fake.lastPartCount != 2 {
+		t.Errorf("expected the file to be uploaded in 2 parts, got %d", fake.lastPartCount)
+	}
+	if !bytes.Equal(fake.objects["welp/files/a.txt"], content) {
+		t.Error("the uploaded file was not stored with the prefix")
+	}
+
+	reader, err := storage.LoadFile(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	seeker, ok := reader.(io.ReadSeeker)
+	if !ok {
+		t.Fatal("the loaded file can't seek")
+	}
+	if _, err := seeker.Seek(5*1024*1024+3, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	part := make([]byte, 4)
+	if _, err := io.ReadFull(seeker, part); err != nil {
+		t.Fatal(err)
+	}
+	if string(part) != "3456" {
+		t.Errorf("expected to read '3456' after seeking, got '%s'", part)
+	}
+
+	files, err := storage.ListFiles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Id != "a.txt" || files[0].Size != int64(len(content)) {
+		t.Errorf("unexpected files listed: %v", files)
+	}
+
+	if _, err := storage.GetDownloadUrl(ctx, "a.txt", "text/plain", "inline"); err != models.ErrDownloadUrlNotSupported {
+		t.Errorf("expected presigned urls to be disabled, got %v", err)
+	}
+
+	if err := storage.DeleteFile(ctx, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.DeleteFile(ctx, "a.txt"); err != models.ErrFileNotFound {
+		t.Errorf("expected deleting a missing file to fail with ErrFileNotFound, got %v", err)
+	}
+	if _, err := storage.LoadFile(ctx, "a.txt"); err != models.ErrFileNotFound {
+		t.Errorf("expected loading a deleted file to fail with ErrFileNotFound, got %v", err)
+	}
+}
+
+func TestFileStoragePresign(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeS3()
+	storage, server := newTestStorage(t, fake, 5*time.Minute)
+	defer server.Close()
+
+	if _, err := storage.SaveFile(ctx, "a.txt", strings.NewReader("content")); err != nil {
+		t.Fatal(err)
+	}
+
+	downloadUrl, err := storage.GetDownloadUrl(ctx, "a.txt", "text/plain", "inline")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(downloadUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/welp/files/a.txt" {
+		t.Errorf("the presigned url points to the wrong object: %s", u.Path)
+	}
+	query := u.Query()
+	if query.Get("X-Amz-Expires") != "300" || query.Get("X-Amz-Signature") == "" {
+		t.Errorf("the url is not presigned for 5 minutes: %s", downloadUrl)
+	}
+
+	response, err := http.Get(downloadUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	body, _ := ioutil.ReadAll(response.Body)
+	if string(body) != "content" || response.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("unexpected download: %s %s", response.Header.Get("Content-Type"), body)
+	}
+}
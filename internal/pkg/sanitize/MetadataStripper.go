@@ -0,0 +1,401 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package sanitize
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/tracing"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+)
+
+var (
+	errInvalidImage = errors.New("invalid image")
+)
+
+type MetadataStripperArgs struct {
+	Logger models.Logger
+}
+
+// Creates a sanitizer that removes metadata, like exif and xmp, from jpeg, png and webp images.
+// The image data itself is left untouched, so the images doesn't lose any quality.
+// The orientation of jpeg images is kept, as the images would be shown rotated without it.
+// Jpeg and png images that can't be parsed are decoded and encoded again instead, which drops
+// all the metadata, including the orientation. Images that can't be cleaned either way are rejected.
+func NewMetadataStripper(args MetadataStripperArgs) models.FileSanitizer {
+	return &metadataStripper{
+		logger: args.Logger,
+	}
+}
+
+type metadataStripper struct {
+	logger models.Logger
+}
+
+func (s *metadataStripper) Sanitize(ctx context.Context, contentType string, reader io.Reader) (io.Reader, bool, error) {
+	var strip func(data []byte) ([]byte, error)
+	var encode func(writer io.Writer, img image.Image) error
+	switch contentType {
+	case "image/jpeg":
+		strip = stripJpeg
+		encode = func(writer io.Writer, img image.Image) error {
+			return jpeg.Encode(writer, img, &jpeg.Options{Quality: 90})
+		}
+	case "image/png":
+		strip = stripPng
+		encode = png.Encode
+	case "image/webp":
+		// There is no webp encoder, so webp images can only be stripped
+		strip = stripWebp
+	default:
+		return reader, false, nil
+	}
+
+	_, span := tracing.StartSpan(ctx, "sanitize.Sanitize")
+	defer span.End()
+	span.SetAttribute("file.contentType", contentType)
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, false, err
+	}
+
+	stripped, err := strip(data)
+	if err == nil {
+		return bytes.NewReader(stripped), true, nil
+	}
+
+	s.logger.WithContext(ctx).Warnf("Failed to strip metadata from '%s' file, encoding it again instead: %v", contentType, err)
+	if encode == nil {
+		return nil, false, models.ErrImageNotSanitized
+	}
+
+	encoded, err := reencode(data, encode)
+	if err != nil {
+		// The file only looked like an image, and might still have the metadata
+		s.logger.WithContext(ctx).Warnf("Failed to encode '%s' file again: %v", contentType, err)
+		return nil, false, models.ErrImageNotSanitized
+	}
+
+	return bytes.NewReader(encoded), true, nil
+}
+
+// Decodes the image and encodes only the pixels again, so none of the metadata is kept
+func reencode(data []byte, encode func(writer io.Writer, img image.Image) error) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	err = encode(&buffer, img)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+const (
+	jpegTEM   = 0x01
+	jpegRST0  = 0xD0
+	jpegRST7  = 0xD7
+	jpegSOI   = 0xD8
+	jpegEOI   = 0xD9
+	jpegSOS   = 0xDA
+	jpegAPP0  = 0xE0
+	jpegAPP1  = 0xE1
+	jpegAPP2  = 0xE2
+	jpegAPP14 = 0xEE
+	jpegAPP15 = 0xEF
+	jpegCOM   = 0xFE
+
+	exifOrientationTag = 0x0112
+)
+
+var (
+	exifHeader       = []byte("Exif\x00\x00")
+	iccProfileHeader = []byte("ICC_PROFILE\x00")
+)
+
+// Removes all segments with metadata from the jpeg. Only the color profile and
+// the segments needed to decode the image are kept. Anything after the end of the image is dropped.
+func stripJpeg(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != jpegSOI {
+		return nil, errInvalidImage
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	pos := 2
+
+	for {
+		if pos >= len(data) || data[pos] != 0xFF {
+			return nil, errInvalidImage
+		}
+		// Markers can be padded with any number of 0xFF
+		for pos < len(data) && data[pos] == 0xFF {
+			pos++
+		}
+		if pos >= len(data) {
+			return nil, errInvalidImage
+		}
+
+		marker := data[pos]
+		pos++
+
+		if marker == jpegEOI {
+			return append(out, 0xFF, marker), nil
+		}
+
+		if marker == jpegTEM || (marker >= jpegRST0 && marker <= jpegRST7) {
+			out = append(out, 0xFF, marker)
+			continue
+		}
+
+		if pos+2 > len(data) {
+			return nil, errInvalidImage
+		}
+		length := int(binary.BigEndian.Uint16(data[pos:]))
+		if length < 2 || pos+length > len(data) {
+			return nil, errInvalidImage
+		}
+		segment := data[pos+2 : pos+length]
+
+		switch {
+		case marker == jpegAPP1 && bytes.HasPrefix(segment, exifHeader):
+			orientation := getExifOrientation(segment[len(exifHeader):])
+			if orientation > 1 && orientation <= 8 {
+				out = append(out, getOrientationSegment(orientation)...)
+			}
+		case marker == jpegAPP2 && bytes.HasPrefix(segment, iccProfileHeader):
+			out = append(out, 0xFF, marker)
+			out = append(out, data[pos:pos+length]...)
+		case marker == jpegCOM, marker >= jpegAPP1 && marker <= jpegAPP15 && marker != jpegAPP14:
+			// Metadata, so just leave it out
+		default:
+			out = append(out, 0xFF, marker)
+			out = append(out, data[pos:pos+length]...)
+		}
+
+		pos += length
+
+		if marker == jpegSOS {
+			// The compressed image data follows the scan header, and runs until the next marker
+			start := pos
+			pos = findNextJpegMarker(data, pos)
+			out = append(out, data[start:pos]...)
+
+			if pos >= len(data) {
+				// Some encoders forgets the end of image marker
+				return out, nil
+			}
+		}
+	}
+}
+
+// Finds the next marker in the compressed image data. 0xFF bytes in the
+// data is always followed by a 0x00 byte or a restart marker.
+func findNextJpegMarker(data []byte, pos int) int {
+	for ; pos+1 < len(data); pos++ {
+		if data[pos] != 0xFF {
+			continue
+		}
+
+		next := data[pos+1]
+		if next != 0x00 && next != 0xFF && !(next >= jpegRST0 && next <= jpegRST7) {
+			return pos
+		}
+	}
+
+	return len(data)
+}
+
+// Reads the orientation from the first image directory of the exif data
+// Returns 0 if the orientation can't be found
+func getExifOrientation(tiff []byte) uint16 {
+	if len(tiff) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 0
+	}
+
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+
+		if order.Uint16(tiff[entry:]) == exifOrientationTag {
+			return order.Uint16(tiff[entry+8:])
+		}
+	}
+
+	return 0
+}
+
+// Makes an exif segment, that only contains the orientation of the image
+func getOrientationSegment(orientation uint16) []byte {
+	// Header, a directory with a single entry, and the offset of the next directory
+	tiff := make([]byte, 8+2+12+4)
+	copy(tiff, "MM\x00\x2a")
+	binary.BigEndian.PutUint32(tiff[4:], 8)
+	binary.BigEndian.PutUint16(tiff[8:], 1)
+	binary.BigEndian.PutUint16(tiff[10:], exifOrientationTag)
+	// The type is SHORT, with a single value
+	binary.BigEndian.PutUint16(tiff[12:], 3)
+	binary.BigEndian.PutUint32(tiff[14:], 1)
+	binary.BigEndian.PutUint16(tiff[18:], orientation)
+
+	segment := []byte{0xFF, jpegAPP1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(2+len(exifHeader)+len(tiff)))
+	segment = append(segment, exifHeader...)
+	return append(segment, tiff...)
+}
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+	// Chunks that can contain metadata, and are not needed to show the image
+	pngMetadataChunks = map[string]bool{
+		"eXIf": true,
+		"tEXt": true,
+		"zTXt": true,
+		"iTXt": true,
+		"tIME": true,
+	}
+)
+
+// Removes all text, time and exif chunks from the png. Anything after the end of the image is dropped.
+func stripPng(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errInvalidImage
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	pos := len(pngSignature)
+
+	for pos+8 <= len(data) {
+		// Length, type, data and crc
+		length := uint64(binary.BigEndian.Uint32(data[pos:]))
+		if length+12 > uint64(len(data)-pos) {
+			return nil, errInvalidImage
+		}
+		chunkType := string(data[pos+4 : pos+8])
+		end := pos + 12 + int(length)
+
+		if !pngMetadataChunks[chunkType] {
+			out = append(out, data[pos:end]...)
+		}
+
+		pos = end
+
+		if chunkType == "IEND" {
+			return out, nil
+		}
+	}
+
+	return nil, errInvalidImage
+}
+
+const (
+	webpXmpFlag  = 0x04
+	webpExifFlag = 0x08
+)
+
+// Removes the exif and xmp chunks from the webp, and updates the header to match
+func stripWebp(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errInvalidImage
+	}
+
+	// Only the chunks within the size of the riff container counts
+	size := uint64(binary.LittleEndian.Uint32(data[4:])) + 8
+	if size < 12 || size > uint64(len(data)) {
+		return nil, errInvalidImage
+	}
+	data = data[:size]
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+	pos := 12
+	vp8xFlags := -1
+
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, errInvalidImage
+		}
+
+		chunkType := string(data[pos : pos+4])
+		// Chunks are padded to an even size
+		length := uint64(binary.LittleEndian.Uint32(data[pos+4:]))
+		length += length & 1
+		if length+8 > uint64(len(data)-pos) {
+			return nil, errInvalidImage
+		}
+		end := pos + 8 + int(length)
+
+		switch chunkType {
+		case "EXIF", "XMP ":
+			// Metadata, so just leave it out
+		case "VP8X":
+			vp8xFlags = len(out) + 8
+			fallthrough
+		default:
+			out = append(out, data[pos:end]...)
+		}
+
+		pos = end
+	}
+
+	// The extended header says if there is metadata, so it has to be updated as well
+	if vp8xFlags >= 0 && vp8xFlags < len(out) {
+		out[vp8xFlags] &^= webpExifFlag | webpXmpFlag
+	}
+
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+
+	return out, nil
+}
@@ -0,0 +1,187 @@
+package sanitize
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/zlepper/welp/internal/pkg/logging"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"testing"
+)
+
+const secret = "GPS 55.6761 12.5683"
+
+func getTestImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	return img
+}
+
+// Makes an exif segment with the orientation, followed by something that should be removed
+func getExifSegment(orientation uint16) []byte {
+	segment := getOrientationSegment(orientation)
+	segment = append(segment, secret...)
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(segment)-2))
+	return segment
+}
+
+func TestStripJpeg(t *testing.T) {
+	var buffer bytes.Buffer
+	err := jpeg.Encode(&buffer, getTestImage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := buffer.Bytes()
+
+	comment := []byte{0xFF, jpegCOM, 0, byte(len(secret) + 2)}
+	comment = append(comment, secret...)
+
+	data := append([]byte{}, original[:2]...)
+	data = append(data, getExifSegment(6)...)
+	data = append(data, comment...)
+	data = append(data, original[2:]...)
+	data = append(data, secret...)
+
+	stripped, err := stripJpeg(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(stripped, []byte(secret)) {
+		t.Error("metadata was not removed")
+	}
+
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped image can't be decoded: %v", err)
+	}
+
+	exif := bytes.Index(stripped, exifHeader)
+	if exif < 0 || getExifOrientation(stripped[exif+len(exifHeader):]) != 6 {
+		t.Error("orientation was not kept")
+	}
+}
+
+func TestStripPng(t *testing.T) {
+	var buffer bytes.Buffer
+	err := png.Encode(&buffer, getTestImage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := buffer.Bytes()
+
+	// The text chunk is put right after the header chunk
+	headerEnd := len(pngSignature) + 12 + 13
+	text := make([]byte, 8, 12+len(secret))
+	binary.BigEndian.PutUint32(text, uint32(len(secret)))
+	copy(text[4:], "tEXt")
+	text = append(text, secret...)
+	text = append(text, 0, 0, 0, 0)
+
+	data := append([]byte{}, original[:headerEnd]...)
+	data = append(data, text...)
+	data = append(data, original[headerEnd:]...)
+
+	stripped, err := stripPng(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(stripped, original) {
+		t.Error("metadata was not removed")
+	}
+}
+
+func TestStripWebp(t *testing.T) {
+	chunk := func(chunkType string, content []byte) []byte {
+		out := make([]byte, 8)
+		copy(out, chunkType)
+		binary.LittleEndian.PutUint32(out[4:], uint32(len(content)))
+		out = append(out, content...)
+		if len(content)%2 == 1 {
+			out = append(out, 0)
+		}
+		return out
+	}
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = webpExifFlag | webpXmpFlag | 0x10
+
+	body := []byte("WEBP")
+	body = append(body, chunk("VP8X", vp8x)...)
+	body = append(body, chunk("VP8L", []byte{1, 2, 3})...)
+	body = append(body, chunk("EXIF", []byte(secret))...)
+	body = append(body, chunk("XMP ", []byte(secret))...)
+
+	data := []byte("RIFF\x00\x00\x00\x00")
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(body)))
+	data = append(data, body...)
+
+	stripped, err := stripWebp(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(stripped, []byte(secret)) {
+		t.Error("metadata was not removed")
+	}
+
+	if size := binary.LittleEndian.Uint32(stripped[4:]); int(size) != len(stripped)-8 {
+		t.Errorf("riff size was not updated, expected %d, got %d", len(stripped)-8, size)
+	}
+
+	if flags := stripped[20]; flags != 0x10 {
+		t.Errorf("metadata flags was not cleared: %x", flags)
+	}
+}
+
+func TestSanitizeEncodesUnparsableImagesAgain(t *testing.T) {
+	var buffer bytes.Buffer
+	err := jpeg.Encode(&buffer, getTestImage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := buffer.Bytes()
+
+	// The decoder skips junk before a marker, but the stripper gives up on it
+	data := append([]byte{}, original[:2]...)
+	data = append(data, getExifSegment(6)...)
+	data = append(data, "junk"...)
+	data = append(data, original[2:]...)
+	if _, err := stripJpeg(data); err == nil {
+		t.Fatal("expected the stripper to give up on the image")
+	}
+
+	stripper := NewMetadataStripper(MetadataStripperArgs{Logger: logging.NewLogger(logging.LoggerArgs{})})
+	reader, sanitized, err := stripper.Sanitize(context.Background(), "image/jpeg", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleaned, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !sanitized || bytes.Contains(cleaned, []byte(secret)) {
+		t.Error("metadata was not removed")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(cleaned)); err != nil {
+		t.Errorf("encoded image can't be decoded: %v", err)
+	}
+}
+
+func TestSanitizeRejectsImagesThatCantBeCleaned(t *testing.T) {
+	stripper := NewMetadataStripper(MetadataStripperArgs{Logger: logging.NewLogger(logging.LoggerArgs{})})
+
+	for _, contentType := range []string{"image/jpeg", "image/png", "image/webp"} {
+		_, sanitized, err := stripper.Sanitize(context.Background(), contentType, bytes.NewReader([]byte(secret)))
+		if err != models.ErrImageNotSanitized || sanitized {
+			t.Errorf("expected a broken '%s' file to be rejected, got %v", contentType, err)
+		}
+	}
+}
@@ -25,6 +25,7 @@ package services
 import (
 	"context"
 	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/tracing"
 	"golang.org/x/crypto/bcrypt"
 	"time"
 )
@@ -39,7 +40,7 @@ type AuthorizationServiceArgs struct {
 	Logger        models.Logger
 	DataStorage   models.AuthorizationDataStorage
 	EmailService  models.EmailService
-	EmailSender   EmailSender
+	EmailSender   EmailSenderProvider
 	TokenService  models.TokenService
 	TokenDuration time.Duration
 }
@@ -59,7 +60,7 @@ type authorizationService struct {
 	logger        models.Logger
 	dataStorage   models.AuthorizationDataStorage
 	emailService  models.EmailService
-	emailSender   EmailSender
+	emailSender   EmailSenderProvider
 	tokenService  models.TokenService
 	tokenDuration time.Duration
 }
@@ -73,7 +74,11 @@ func (s *authorizationService) hashPassword(password string) (hash string, err e
 	return string(h), nil
 }
 
-func (s *authorizationService) comparePasswords(password, hash string) error {
+// bcrypt is slow on purpose, so it gets its own span
+func (s *authorizationService) comparePasswords(ctx context.Context, password, hash string) error {
+	_, span := tracing.StartSpan(ctx, "bcrypt.CompareHashAndPassword")
+	defer span.End()
+
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
@@ -110,15 +115,16 @@ outerSearch:
 		return err
 	}
 
-	err = s.sendWelcomeEmail(email)
+	err = s.sendWelcomeEmail(ctx, email)
 	return err
 }
 
-func (s *authorizationService) sendWelcomeEmail(emailAddress string) error {
+func (s *authorizationService) sendWelcomeEmail(ctx context.Context, emailAddress string) error {
 	// TODO Better welcome email
-	return s.emailService.SendEmail(models.SendEmailArgs{
-		From:         models.NewEmailAddress(s.emailSender.FromName, s.emailSender.FromEmail),
-		ReplyTo:      models.NewEmailAddress(s.emailSender.ReplyToName, s.emailSender.ReplyToEmail),
+	sender := s.emailSender.GetEmailSender()
+	return s.emailService.SendEmail(ctx, models.SendEmailArgs{
+		From:         models.NewEmailAddress(sender.FromName, sender.FromEmail),
+		ReplyTo:      models.NewEmailAddress(sender.ReplyToName, sender.ReplyToEmail),
 		To:           models.NewEmailAddress(emailAddress, emailAddress),
 		Subject:      "Welcome to Welp",
 		PlainContent: "Welcome to Welp",
@@ -156,8 +162,13 @@ func (s *authorizationService) ensureAtLeastOneUserExists(ctx context.Context) e
 	return nil
 }
 
-func (s *authorizationService) Login(ctx context.Context, email, password string) (string, error) {
-	err := s.ensureAtLeastOneUserExists(ctx)
+func (s *authorizationService) Login(ctx context.Context, email, password string) (_ string, err error) {
+	ctx, span := tracing.StartSpan(ctx, "AuthorizationService.Login")
+	defer func() {
+		span.EndWithError(err)
+	}()
+
+	err = s.ensureAtLeastOneUserExists(ctx)
 	if err != nil {
 		return "", nil
 	}
@@ -167,8 +178,7 @@ func (s *authorizationService) Login(ctx context.Context, email, password string
 		return "", err
 	}
 
-	s.logger.Debugf("pw: %s, hash: %s", password, user.Password)
-	err = s.comparePasswords(password, user.Password)
+	err = s.comparePasswords(ctx, password, user.Password)
 	if err != nil {
 		return "", err
 	}
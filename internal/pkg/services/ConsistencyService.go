@@ -0,0 +1,304 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package services
+
+import (
+	"context"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/thumbnail"
+	"io"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+type ConsistencyServiceArgs struct {
+	// Where the feedback is found, and repaired feedback is saved
+	DataStorage models.FeedbackDataStorage
+	// Where the files are stored. It has to be able to list its files
+	FileStorage models.FileStorage
+	Policy      models.ConsistencyPolicy
+	Logger      models.Logger
+}
+
+func NewConsistencyService(args ConsistencyServiceArgs) models.ConsistencyService {
+	return &consistencyService{
+		ConsistencyServiceArgs: args,
+	}
+}
+
+type consistencyService struct {
+	ConsistencyServiceArgs
+}
+
+func (s *consistencyService) Check(ctx context.Context, options models.ConsistencyOptions) (models.ConsistencyReport, error) {
+	report := models.ConsistencyReport{
+		Time:   time.Now(),
+		Issues: []models.ConsistencyIssue{},
+	}
+
+	lister, ok := s.FileStorage.(models.FileLister)
+	if !ok {
+		return report, models.ErrListingNotSupported
+	}
+
+	// The feedback is loaded before the files are listed, so files uploaded in between are orphans
+	// inside the grace period, rather than missing from feedback the list was made before
+	all, err := s.DataStorage.GetAllFeedback(ctx)
+	if err != nil {
+		return report, err
+	}
+	report.Feedback = len(all)
+
+	stored, err := lister.ListFiles(ctx)
+	if err != nil {
+		return report, err
+	}
+	report.Files = len(stored)
+
+	storedFiles := make(map[string]models.StoredFile, len(stored))
+	for _, file := range stored {
+		storedFiles[file.Id] = file
+	}
+
+	referenced := map[string]bool{}
+	for _, feedback := range all {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		issues := make([]models.ConsistencyIssue, 0)
+
+		for _, file := range feedback.Files {
+			referenced[file.Id] = true
+
+			issue, found, err := s.checkFile(ctx, feedback, file, storedFiles)
+			if err != nil {
+				s.Logger.Errorf("Failed to check file '%s': %v", file.Id, err)
+				continue
+			}
+			if found {
+				issues = append(issues, issue)
+			}
+		}
+
+		if options.Repair && len(issues) > 0 {
+			err = s.repair(ctx, feedback.Id, issues)
+			for index := range issues {
+				if err != nil {
+					issues[index].Error = err.Error()
+				} else {
+					issues[index].Fixed = true
+				}
+			}
+		}
+
+		report.Issues = append(report.Issues, issues...)
+	}
+
+	orphans := s.findOrphans(stored, referenced)
+	for _, orphan := range orphans {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		issue := models.ConsistencyIssue{
+			Kind:   models.OrphanedFile,
+			FileId: orphan.Id,
+		}
+
+		// Files that are this new might belong to feedback that is being created right now.
+		// Files without a modification time might be just as new, so they are kept too
+		if options.CollectGarbage && !orphan.Modified.IsZero() && report.Time.Sub(orphan.Modified) >= options.GracePeriod {
+			err = s.FileStorage.DeleteFile(ctx, orphan.Id)
+			if err != nil && err != models.ErrFileNotFound {
+				issue.Error = err.Error()
+			} else {
+				issue.Fixed = true
+			}
+		}
+
+		report.Issues = append(report.Issues, issue)
+	}
+
+	return report, nil
+}
+
+// Checks that the file is stored with the right size. Returns true if something is wrong with it.
+// Files that aren't in the list are looked up directly before they are reported missing,
+// as they might have been saved after the list was made
+func (s *consistencyService) checkFile(ctx context.Context, feedback models.Feedback, file models.File, storedFiles map[string]models.StoredFile) (models.ConsistencyIssue, bool, error) {
+	issue := models.ConsistencyIssue{
+		FileId:       file.Id,
+		FeedbackId:   feedback.Id,
+		ExpectedSize: file.Size,
+	}
+
+	size := int64(-1)
+	if storedFile, exists := storedFiles[file.Id]; exists {
+		size = storedFile.Size
+	}
+
+	if size < 0 {
+		var err error
+		size, err = s.getSize(ctx, file.Id)
+		if err == models.ErrFileNotFound {
+			issue.Kind = models.MissingFile
+			return issue, true, nil
+		}
+		if err != nil {
+			return issue, false, err
+		}
+	}
+
+	if size != file.Size {
+		issue.Kind = models.SizeMismatch
+		issue.ActualSize = size
+		return issue, true, nil
+	}
+
+	return issue, false, nil
+}
+
+// Gets the size of the content of the file, preferably without reading all of it
+func (s *consistencyService) getSize(ctx context.Context, id string) (int64, error) {
+	reader, err := s.FileStorage.LoadFile(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	if seeker, ok := reader.(io.Seeker); ok {
+		return seeker.Seek(0, io.SeekEnd)
+	}
+
+	return io.Copy(ioutil.Discard, reader)
+}
+
+// Finds the stored files no feedback refers to. Thumbnails belong to the file they were made from
+func (s *consistencyService) findOrphans(stored []models.StoredFile, referenced map[string]bool) []models.StoredFile {
+	orphans := make([]models.StoredFile, 0)
+
+	for _, file := range stored {
+		id := file.Id
+		if originalId, isThumbnail := thumbnail.GetOriginalFileId(id); isThumbnail {
+			id = originalId
+		}
+
+		if !referenced[id] {
+			orphans = append(orphans, file)
+		}
+	}
+
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].Id < orphans[j].Id
+	})
+
+	return orphans
+}
+
+// Removes the missing files from the feedback, and corrects the sizes that don't match
+func (s *consistencyService) repair(ctx context.Context, feedbackId string, issues []models.ConsistencyIssue) error {
+	// The feedback is updated under the storage lock, so changes made since the check started aren't lost
+	_, err := s.DataStorage.UpdateFeedback(ctx, feedbackId, func(feedback *models.Feedback) error {
+		repairFiles(feedback, issues)
+		return nil
+	})
+	return err
+}
+
+func repairFiles(feedback *models.Feedback, issues []models.ConsistencyIssue) {
+	files := make([]models.File, 0, len(feedback.Files))
+	for _, file := range feedback.Files {
+		missing := false
+		for _, issue := range issues {
+			if issue.FileId != file.Id {
+				continue
+			}
+
+			switch issue.Kind {
+			case models.MissingFile:
+				missing = true
+			case models.SizeMismatch:
+				file.Size = issue.ActualSize
+			}
+		}
+
+		if !missing {
+			files = append(files, file)
+		}
+	}
+	feedback.Files = files
+}
+
+func (s *consistencyService) RunSchedule(ctx context.Context) {
+	if s.Policy.Interval <= 0 {
+		return
+	}
+
+	if _, ok := s.FileStorage.(models.FileLister); !ok {
+		s.Logger.Errorf("The stored files can't be checked, as the file storage can't list its files")
+		return
+	}
+
+	s.Logger.Infof("Checking the consistency of the stored files every %s", s.Policy.Interval)
+
+	ticker := time.NewTicker(s.Policy.Interval)
+	defer ticker.Stop()
+
+	// The first check waits for the interval too, so starting welp stays fast
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.checkAndLog(ctx)
+	}
+}
+
+func (s *consistencyService) checkAndLog(ctx context.Context) {
+	report, err := s.Check(ctx, s.Policy.ConsistencyOptions)
+	if err != nil {
+		// Stopped because welp is shutting down
+		if ctx.Err() != nil {
+			return
+		}
+		s.Logger.Errorf("Failed to check the consistency of the stored files: %v", err)
+		return
+	}
+
+	fixed := 0
+	for _, issue := range report.Issues {
+		if issue.Fixed {
+			fixed++
+			continue
+		}
+		s.Logger.Warnf("Consistency check: %s", issue.Describe())
+	}
+
+	if len(report.Issues) > 0 {
+		s.Logger.Infof("Consistency check: Found %d issues in %d files, fixed %d", len(report.Issues), report.Files, fixed)
+	}
+}
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"context"
+	"github.com/zlepper/welp/internal/pkg/logging"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Leaves a file out of the list, like a file that was saved after the files were listed
+type hidingFileStorage struct {
+	models.FileStorage
+	hidden string
+}
+
+func (s hidingFileStorage) ListFiles(ctx context.Context) ([]models.StoredFile, error) {
+	files, err := s.FileStorage.(models.FileLister).ListFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	listed := make([]models.StoredFile, 0, len(files))
+	for _, file := range files {
+		if file.Id != s.hidden {
+			listed = append(listed, file)
+		}
+	}
+	return listed, nil
+}
+
+// Lists the files without their modification time, like storages that don't keep it
+type agelessFileStorage struct {
+	models.FileStorage
+}
+
+func (s agelessFileStorage) ListFiles(ctx context.Context) ([]models.StoredFile, error) {
+	files, err := s.FileStorage.(models.FileLister).ListFiles(ctx)
+	for i := range files {
+		files[i].Modified = time.Time{}
+	}
+	return files, err
+}
+
+type consistencyTest struct {
+	testFeedbackService
+	ctx    context.Context
+	t      *testing.T
+	folder string
+}
+
+func newConsistencyTest(t *testing.T, ctx context.Context) consistencyTest {
+	folder, err := ioutil.TempDir("", "welp-consistency")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return consistencyTest{
+		testFeedbackService: newTestFeedbackService(t, ctx, folder),
+		ctx:                 ctx,
+		t:                   t,
+		folder:              folder,
+	}
+}
+
+func (c consistencyTest) saveFile(id, content string) {
+	if _, err := c.fileStorage.SaveFile(c.ctx, id, strings.NewReader(content)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c consistencyTest) saveFeedback(files ...models.File) models.Feedback {
+	feedback, err := models.NewFeedback("message", "", files)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := c.dataStorage.SaveFeedback(c.ctx, feedback); err != nil {
+		c.t.Fatal(err)
+	}
+	return feedback
+}
+
+func (c consistencyTest) check(fileStorage models.FileStorage, options models.ConsistencyOptions) map[string]models.ConsistencyIssue {
+	service := NewConsistencyService(ConsistencyServiceArgs{
+		DataStorage: c.dataStorage,
+		FileStorage: fileStorage,
+		Logger:      logging.NewLogger(logging.LoggerArgs{}),
+	})
+
+	report, err := service.Check(c.ctx, options)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	issues := map[string]models.ConsistencyIssue{}
+	for _, issue := range report.Issues {
+		if issue.Error != "" {
+			c.t.Errorf("failed to fix '%s': %s", issue.FileId, issue.Error)
+		}
+		issues[issue.FileId] = issue
+	}
+	return issues
+}
+
+func TestConsistencyCheck(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	test := newConsistencyTest(t, ctx)
+	defer os.RemoveAll(test.folder)
+
+	test.saveFile("right.txt", "content")
+	test.saveFile("wrong-size.txt", "content")
+	test.saveFile("orphan.txt", "content")
+	feedback := test.saveFeedback(
+		models.File{Id: "right.txt", Size: 7},
+		models.File{Id: "wrong-size.txt", Size: 3},
+		models.File{Id: "missing.txt", Size: 7},
+	)
+
+	// A file welp rekey is encrypting right now isn't a stored file
+	if err := ioutil.WriteFile(filepath.Join(test.folder, "files", "right.txt.encrypting-123"), []byte("half"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	issues := test.check(test.fileStorage, models.ConsistencyOptions{})
+	if len(issues) != 3 {
+		t.Errorf("expected 3 issues, got %v", issues)
+	}
+	if issue := issues["missing.txt"]; issue.Kind != models.MissingFile || issue.FeedbackId != feedback.Id || issue.Fixed {
+		t.Errorf("expected the missing file to be found, got %v", issue)
+	}
+	if issue := issues["wrong-size.txt"]; issue.Kind != models.SizeMismatch || issue.ActualSize != 7 || issue.ExpectedSize != 3 {
+		t.Errorf("expected the size mismatch to be found, got %v", issue)
+	}
+	if issue := issues["orphan.txt"]; issue.Kind != models.OrphanedFile || issue.Fixed {
+		t.Errorf("expected the orphaned file to be found, got %v", issue)
+	}
+
+	saved, err := test.dataStorage.GetFeedback(ctx, feedback.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Files) != 3 {
+		t.Errorf("the feedback was changed without repairing: %v", saved.Files)
+	}
+}
+
+func TestConsistencyRepair(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	test := newConsistencyTest(t, ctx)
+	defer os.RemoveAll(test.folder)
+
+	test.saveFile("wrong-size.txt", "content")
+	feedback := test.saveFeedback(
+		models.File{Id: "wrong-size.txt", Size: 3},
+		models.File{Id: "missing.txt", Size: 7},
+	)
+
+	issues := test.check(test.fileStorage, models.ConsistencyOptions{Repair: true})
+	if !issues["wrong-size.txt"].Fixed || !issues["missing.txt"].Fixed {
+		t.Errorf("expected the issues to be fixed, got %v", issues)
+	}
+
+	saved, err := test.dataStorage.GetFeedback(ctx, feedback.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Files) != 1 || saved.Files[0].Id != "wrong-size.txt" || saved.Files[0].Size != 7 {
+		t.Errorf("expected only the file that exists, with the right size, got %v", saved.Files)
+	}
+
+	if issues := test.check(test.fileStorage, models.ConsistencyOptions{}); len(issues) != 0 {
+		t.Errorf("expected no issues after repairing, got %v", issues)
+	}
+}
+
+func TestConsistencyConfirmsMissingFiles(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	test := newConsistencyTest(t, ctx)
+	defer os.RemoveAll(test.folder)
+
+	test.saveFile("late.txt", "content")
+	feedback := test.saveFeedback(models.File{Id: "late.txt", Size: 7})
+
+	storage := hidingFileStorage{FileStorage: test.fileStorage, hidden: "late.txt"}
+	if issues := test.check(storage, models.ConsistencyOptions{Repair: true}); len(issues) != 0 {
+		t.Errorf("expected the file to be found when it was looked up, got %v", issues)
+	}
+
+	saved, err := test.dataStorage.GetFeedback(ctx, feedback.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Files) != 1 {
+		t.Errorf("a file that exists was removed from the feedback: %v", saved.Files)
+	}
+}
+
+func TestConsistencyGracePeriod(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	test := newConsistencyTest(t, ctx)
+	defer os.RemoveAll(test.folder)
+
+	test.saveFile("orphan.txt", "content")
+
+	issues := test.check(test.fileStorage, models.ConsistencyOptions{CollectGarbage: true, GracePeriod: time.Hour})
+	if issue := issues["orphan.txt"]; issue.Kind != models.OrphanedFile || issue.Fixed {
+		t.Errorf("expected the new orphan to be kept, got %v", issue)
+	}
+	if _, err := test.fileStorage.LoadFile(ctx, "orphan.txt"); err != nil {
+		t.Fatalf("the orphan was deleted inside the grace period: %v", err)
+	}
+
+	// Made older than the grace period
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(test.folder, "files", "orphan.txt"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	issues = test.check(test.fileStorage, models.ConsistencyOptions{CollectGarbage: true, GracePeriod: time.Hour})
+	if !issues["orphan.txt"].Fixed {
+		t.Errorf("expected the old orphan to be deleted, got %v", issues["orphan.txt"])
+	}
+	if _, err := test.fileStorage.LoadFile(ctx, "orphan.txt"); err != models.ErrFileNotFound {
+		t.Errorf("expected the orphan to be deleted, got %v", err)
+	}
+}
+
+func TestConsistencyKeepsOrphansOfUnknownAge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	test := newConsistencyTest(t, ctx)
+	defer os.RemoveAll(test.folder)
+
+	test.saveFile("orphan.txt", "content")
+
+	issues := test.check(agelessFileStorage{FileStorage: test.fileStorage}, models.ConsistencyOptions{CollectGarbage: true, GracePeriod: time.Hour})
+	if issue := issues["orphan.txt"]; issue.Kind != models.OrphanedFile || issue.Fixed {
+		t.Errorf("expected the orphan to be reported but kept, got %v", issue)
+	}
+	if _, err := test.fileStorage.LoadFile(ctx, "orphan.txt"); err != nil {
+		t.Errorf("the orphan of unknown age was deleted: %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"io"
+	"path"
+	"time"
+)
+
+type DataSubjectServiceArgs struct {
+	// Where the feedback is found
+	DataStorage models.FeedbackDataStorage
+	// Deletes and anonymises the feedback, together with the attached files
+	FeedbackService models.FeedbackService
+	// Where the attached files are loaded from when exporting
+	FileStorage models.FileStorage
+	// Where the actions are logged
+	LogStorage models.DataSubjectLogStorage
+	Logger     models.Logger
+}
+
+func NewDataSubjectService(args DataSubjectServiceArgs) models.DataSubjectService {
+	return &dataSubjectService{
+		DataSubjectServiceArgs: args,
+	}
+}
+
+type dataSubjectService struct {
+	DataSubjectServiceArgs
+}
+
+func (s *dataSubjectService) FindFeedback(ctx context.Context, contactAddress string) ([]models.Feedback, error) {
+	contactAddress = models.NormalizeContactAddress(contactAddress)
+	if contactAddress == "" {
+		return nil, models.ErrContactAddressRequired
+	}
+
+	all, err := s.DataStorage.GetAllFeedback(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]models.Feedback, 0)
+	for _, feedback := range all {
+		if models.NormalizeContactAddress(feedback.ContactAddress) == contactAddress {
+			out = append(out, feedback)
+		}
+	}
+
+	return out, nil
+}
+
+func (s *dataSubjectService) Export(ctx context.Context, contactAddress, performedBy string, writer io.Writer) (models.DataSubjectLogEntry, error) {
+	feedback, err := s.FindFeedback(ctx, contactAddress)
+	if err != nil {
+		return models.DataSubjectLogEntry{}, err
+	}
+
+	archive := zip.NewWriter(writer)
+
+	feedbackWriter, err := archive.Create("feedback.json")
+	if err != nil {
+		return models.DataSubjectLogEntry{}, err
+	}
+
+	encoder := json.NewEncoder(feedbackWriter)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(feedback)
+	if err != nil {
+		return models.DataSubjectLogEntry{}, err
+	}
+
+	for _, f := range feedback {
+		for _, file := range f.Files {
+			err = s.exportFile(ctx, archive, file)
+			if err == models.ErrFileNotFound {
+				s.Logger.WithContext(ctx).Warnf("File '%s' of feedback '%s' is missing, so it isn't part of the export", file.Id, f.Id)
+				continue
+			}
+			if err != nil {
+				return models.DataSubjectLogEntry{}, err
+			}
+		}
+	}
+
+	err = archive.Close()
+	if err != nil {
+		return models.DataSubjectLogEntry{}, err
+	}
+
+	return s.log(ctx, models.DataSubjectExport, contactAddress, performedBy, feedback)
+}
+
+// Adds the file to the zip archive, as attachments/<id>/<original name>
+func (s *dataSubjectService) exportFile(ctx context.Context, archive *zip.Writer, file models.File) error {
+	reader, err := s.FileStorage.LoadFile(ctx, file.Id)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	name := path.Base(file.GetName())
+	if name == "." || name == ".." || name == "/" {
+		name = file.Id
+	}
+
+	fileWriter, err := archive.Create(path.Join("attachments", file.Id, name))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(fileWriter, reader)
+	return err
+}
+
+func (s *dataSubjectService) Erase(ctx context.Context, contactAddress, performedBy string) (models.DataSubjectLogEntry, error) {
+	return s.forEachFeedback(ctx, models.DataSubjectErase, contactAddress, performedBy, func(feedback models.Feedback) error {
+		return s.FeedbackService.PurgeFeedback(ctx, feedback.Id)
+	})
+}
+
+func (s *dataSubjectService) Anonymise(ctx context.Context, contactAddress, performedBy string) (models.DataSubjectLogEntry, error) {
+	return s.forEachFeedback(ctx, models.DataSubjectAnonymise, contactAddress, performedBy, func(feedback models.Feedback) error {
+		_, err := s.FeedbackService.Anonymise(ctx, feedback.Id)
+		return err
+	})
+}
+
+// Runs the action for each feedback with the contact address. The feedback the action
+// was done to is logged, even if it fails for some of the feedback
+func (s *dataSubjectService) forEachFeedback(ctx context.Context, action models.DataSubjectAction, contactAddress, performedBy string, do func(feedback models.Feedback) error) (models.DataSubjectLogEntry, error) {
+	feedback, err := s.FindFeedback(ctx, contactAddress)
+	if err != nil {
+		return models.DataSubjectLogEntry{}, err
+	}
+
+	done := make([]models.Feedback, 0, len(feedback))
+	var actionErr error
+	for _, f := range feedback {
+		actionErr = do(f)
+		if actionErr != nil {
+			s.Logger.WithContext(ctx).Errorf("Failed to %s feedback '%s': %v", action, f.Id, actionErr)
+			break
+		}
+		done = append(done, f)
+	}
+
+	entry, err := s.log(ctx, action, contactAddress, performedBy, done)
+	if actionErr != nil {
+		return entry, actionErr
+	}
+	return entry, err
+}
+
+func (s *dataSubjectService) log(ctx context.Context, action models.DataSubjectAction, contactAddress, performedBy string, feedback []models.Feedback) (models.DataSubjectLogEntry, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return models.DataSubjectLogEntry{}, err
+	}
+
+	feedbackIds := make([]string, len(feedback))
+	for index, f := range feedback {
+		feedbackIds[index] = f.Id
+	}
+
+	entry := models.DataSubjectLogEntry{
+		Id:                 id.String(),
+		Time:               time.Now(),
+		Action:             action,
+		ContactAddressHash: models.HashContactAddress(contactAddress),
+		PerformedBy:        performedBy,
+		FeedbackIds:        feedbackIds,
+	}
+
+	err = s.LogStorage.AddEntry(ctx, entry)
+	if err != nil {
+		return models.DataSubjectLogEntry{}, err
+	}
+
+	s.Logger.WithContext(ctx).Infof("Data subject %s by %s included %d feedback entries", action, performedBy, len(feedbackIds))
+
+	return entry, nil
+}
+
+func (s *dataSubjectService) GetLog(ctx context.Context) ([]models.DataSubjectLogEntry, error) {
+	return s.LogStorage.GetEntries(ctx)
+}
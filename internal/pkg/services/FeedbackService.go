@@ -2,15 +2,25 @@ package services
 
 import (
 	"context"
+	"github.com/zlepper/welp/internal/pkg/metrics"
 	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/tracing"
+	"regexp"
+	"time"
 )
 
 type FeedbackServiceArgs struct {
 	DataStorage     models.FeedbackDataStorage
 	EmailService    models.EmailService
 	UserDataStorage models.AuthorizationDataStorage
-	Logger          models.Logger
-	Args            models.BindWebArgs
+	SpamClassifier  models.SpamClassifier
+	// The storage the attached files are deleted from
+	FileStorage models.FileStorage
+	// Deletes the thumbnails of the attached files
+	ThumbnailService models.ThumbnailService
+	Logger           models.Logger
+	// Who the emails are sent from, if the feedback has no contact address
+	EmailSender EmailSenderProvider
 }
 
 func NewFeedbackService(args FeedbackServiceArgs) models.FeedbackService {
@@ -23,43 +33,357 @@ type feedbackService struct {
 	FeedbackServiceArgs
 }
 
-func (s *feedbackService) CreateFeedback(ctx context.Context, message, contactAddress string, files []models.File) (models.Feedback, error) {
+func (s *feedbackService) CreateFeedback(ctx context.Context, message, contactAddress string, files []models.File) (_ models.Feedback, err error) {
+	ctx, span := tracing.StartSpan(ctx, "FeedbackService.CreateFeedback")
+	defer func() {
+		span.EndWithError(err)
+	}()
+
+	feedback, err := models.NewFeedback(message, contactAddress, files)
+	if err != nil {
+		return models.Feedback{}, err
+	}
+
+	err = s.DataStorage.SaveFeedback(ctx, feedback)
+	if err != nil {
+		return models.Feedback{}, err
+	}
+
+	metrics.FeedbackCreated(false)
+
+	ctx = detachContext(ctx)
+	RunInBackground(func() {
+		s.sendFeedbackEmails(ctx, feedback)
+	})
+
+	return feedback, nil
+}
+
+func (s *feedbackService) CreateQuarantinedFeedback(ctx context.Context, message, contactAddress string, files []models.File, reason string) (_ models.Feedback, err error) {
+	ctx, span := tracing.StartSpan(ctx, "FeedbackService.CreateQuarantinedFeedback")
+	defer func() {
+		span.EndWithError(err)
+	}()
+
 	feedback, err := models.NewFeedback(message, contactAddress, files)
 	if err != nil {
 		return models.Feedback{}, err
 	}
 
+	feedback.Quarantined = true
+	feedback.QuarantineReason = reason
+
 	err = s.DataStorage.SaveFeedback(ctx, feedback)
 	if err != nil {
 		return models.Feedback{}, err
 	}
 
-	go s.sendFeedbackEmails(ctx, feedback)
+	metrics.FeedbackCreated(true)
 
 	return feedback, nil
 }
 
 func (s *feedbackService) GetAllFeedback(ctx context.Context) ([]models.Feedback, error) {
-	return s.DataStorage.GetAllFeedback(ctx)
+	return s.getFeedbackWhere(ctx, func(feedback models.Feedback) bool {
+		return !feedback.Quarantined && !feedback.Archived && !feedback.Trashed
+	})
+}
+
+func (s *feedbackService) GetQuarantinedFeedback(ctx context.Context) ([]models.Feedback, error) {
+	return s.getFeedbackWhere(ctx, func(feedback models.Feedback) bool {
+		return feedback.Quarantined && !feedback.Trashed
+	})
+}
+
+func (s *feedbackService) GetArchivedFeedback(ctx context.Context) ([]models.Feedback, error) {
+	return s.getFeedbackWhere(ctx, func(feedback models.Feedback) bool {
+		return feedback.Archived && !feedback.Trashed
+	})
+}
+
+func (s *feedbackService) GetTrashedFeedback(ctx context.Context) ([]models.Feedback, error) {
+	return s.getFeedbackWhere(ctx, func(feedback models.Feedback) bool {
+		return feedback.Trashed
+	})
+}
+
+func (s *feedbackService) getFeedbackWhere(ctx context.Context, predicate func(feedback models.Feedback) bool) ([]models.Feedback, error) {
+	all, err := s.DataStorage.GetAllFeedback(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]models.Feedback, 0, len(all))
+	for _, feedback := range all {
+		if predicate(feedback) {
+			out = append(out, feedback)
+		}
+	}
+
+	return out, nil
+}
+
+func (s *feedbackService) MarkAsSpam(ctx context.Context, id string) (_ models.Feedback, err error) {
+	ctx, span := tracing.StartSpan(ctx, "FeedbackService.MarkAsSpam")
+	defer func() {
+		span.EndWithError(err)
+	}()
+
+	return s.DataStorage.UpdateFeedback(ctx, id, func(feedback *models.Feedback) error {
+		err := s.classify(ctx, feedback, models.ClassifiedSpam)
+		if err != nil {
+			return err
+		}
+
+		if !feedback.Quarantined {
+			feedback.Quarantined = true
+			feedback.QuarantineReason = "marked as spam"
+		}
+
+		return nil
+	})
+}
+
+func (s *feedbackService) MarkAsNotSpam(ctx context.Context, id string) (_ models.Feedback, err error) {
+	ctx, span := tracing.StartSpan(ctx, "FeedbackService.MarkAsNotSpam")
+	defer func() {
+		span.EndWithError(err)
+	}()
+
+	var wasQuarantined bool
+	feedback, err := s.DataStorage.UpdateFeedback(ctx, id, func(feedback *models.Feedback) error {
+		err := s.classify(ctx, feedback, models.ClassifiedHam)
+		if err != nil {
+			return err
+		}
+
+		wasQuarantined = feedback.Quarantined
+		feedback.Quarantined = false
+		feedback.QuarantineReason = ""
+
+		return nil
+	})
+	if err != nil {
+		return models.Feedback{}, err
+	}
+
+	// People haven't heard about the feedback yet, as it was caught in quarantine
+	if wasQuarantined {
+		ctx = detachContext(ctx)
+		RunInBackground(func() {
+			s.sendFeedbackEmails(ctx, feedback)
+		})
+	}
+
+	return feedback, nil
+}
+
+func (s *feedbackService) Archive(ctx context.Context, id string) (_ models.Feedback, err error) {
+	ctx, span := tracing.StartSpan(ctx, "FeedbackService.Archive")
+	defer func() {
+		span.EndWithError(err)
+	}()
+
+	return s.updateFeedback(ctx, id, func(feedback *models.Feedback) {
+		if !feedback.Archived {
+			feedback.Archived = true
+			feedback.ArchivedAt = time.Now()
+		}
+	})
+}
+
+func (s *feedbackService) Unarchive(ctx context.Context, id string) (_ models.Feedback, err error) {
+	ctx, span := tracing.StartSpan(ctx, "FeedbackService.Unarchive")
+	defer func() {
+		span.EndWithError(err)
+	}()
+
+	return s.updateFeedback(ctx, id, func(feedback *models.Feedback) {
+		feedback.Archived = false
+		feedback.ArchivedAt = time.Time{}
+	})
+}
+
+func (s *feedbackService) MoveToTrash(ctx context.Context, id string) (_ models.Feedback, err error) {
+	ctx, span := tracing.StartSpan(ctx, "FeedbackService.MoveToTrash")
+	defer func() {
+		span.EndWithError(err)
+	}()
+
+	return s.updateFeedback(ctx, id, func(feedback *models.Feedback) {
+		if !feedback.Trashed {
+			feedback.Trashed = true
+			feedback.TrashedAt = time.Now()
+		}
+	})
 }
 
-func (s *feedbackService) sendFeedbackEmails(ctx context.Context, feedback models.Feedback) error {
+func (s *feedbackService) Restore(ctx context.Context, id string) (_ models.Feedback, err error) {
+	ctx, span := tracing.StartSpan(ctx, "FeedbackService.Restore")
+	defer func() {
+		span.EndWithError(err)
+	}()
+
+	return s.updateFeedback(ctx, id, func(feedback *models.Feedback) {
+		feedback.Trashed = false
+		feedback.TrashedAt = time.Time{}
+	})
+}
+
+// Changes the feedback and saves it again, without other changes getting lost in between
+func (s *feedbackService) updateFeedback(ctx context.Context, id string, update func(feedback *models.Feedback)) (models.Feedback, error) {
+	return s.DataStorage.UpdateFeedback(ctx, id, func(feedback *models.Feedback) error {
+		update(feedback)
+		return nil
+	})
+}
+
+func (s *feedbackService) DeleteFeedback(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.StartSpan(ctx, "FeedbackService.DeleteFeedback")
+	defer func() {
+		span.EndWithError(err)
+	}()
+
+	feedback, err := s.DataStorage.GetFeedback(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// Deleting can't be undone, so it has to go through the trash, where it can still be restored
+	if !feedback.Trashed {
+		return models.ErrFeedbackNotTrashed
+	}
+
+	return s.deleteFeedback(ctx, feedback)
+}
+
+func (s *feedbackService) PurgeFeedback(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.StartSpan(ctx, "FeedbackService.PurgeFeedback")
+	defer func() {
+		span.EndWithError(err)
+	}()
+
+	feedback, err := s.DataStorage.GetFeedback(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return s.deleteFeedback(ctx, feedback)
+}
+
+func (s *feedbackService) deleteFeedback(ctx context.Context, feedback models.Feedback) error {
+	// The feedback is removed first, so nothing refers to the files
+	// if some of them can't be deleted
+	err := s.DataStorage.DeleteFeedback(ctx, feedback.Id)
+	if err != nil {
+		return err
+	}
+
+	s.deleteFiles(ctx, feedback)
+
+	s.Logger.WithContext(ctx).Infof("Deleted feedback '%s' and %d files", feedback.Id, len(feedback.Files))
+
+	return nil
+}
+
+// Matches anything that looks like an email address
+var emailAddressPattern = regexp.MustCompile(`[^\s@<>()]+@[^\s@<>()]+\.[^\s@<>()]+`)
+
+func (s *feedbackService) Anonymise(ctx context.Context, id string) (_ models.Feedback, err error) {
+	ctx, span := tracing.StartSpan(ctx, "FeedbackService.Anonymise")
+	defer func() {
+		span.EndWithError(err)
+	}()
+
+	var feedback models.Feedback
+	anonymised, err := s.DataStorage.UpdateFeedback(ctx, id, func(anonymised *models.Feedback) error {
+		feedback = *anonymised
+
+		anonymised.ContactAddress = ""
+		anonymised.Message = emailAddressPattern.ReplaceAllString(feedback.Message, "[removed]")
+		anonymised.Files = []models.File{}
+		if !anonymised.Anonymised {
+			anonymised.Anonymised = true
+			anonymised.AnonymisedAt = time.Now()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return models.Feedback{}, err
+	}
+
+	// Saved before the files are deleted, so nothing refers to the files
+	// if some of them can't be deleted
+	s.deleteFiles(ctx, feedback)
+
+	return anonymised, nil
+}
+
+// Deletes the files attached to the feedback. Failures are only logged,
+// as the feedback no longer refers to the files
+func (s *feedbackService) deleteFiles(ctx context.Context, feedback models.Feedback) {
+	for _, file := range feedback.Files {
+		err := s.FileStorage.DeleteFile(ctx, file.Id)
+		if err != nil && err != models.ErrFileNotFound {
+			s.Logger.WithContext(ctx).Errorf("Failed to delete file '%s' of feedback '%s': %v", file.Id, feedback.Id, err)
+		}
+
+		err = s.ThumbnailService.DeleteThumbnails(ctx, file)
+		if err != nil {
+			s.Logger.WithContext(ctx).Errorf("Failed to delete thumbnails of file '%s': %v", file.Id, err)
+		}
+	}
+}
+
+// Trains the spam classifier with the feedback, reverting any earlier training
+// if the feedback was classified differently before
+func (s *feedbackService) classify(ctx context.Context, feedback *models.Feedback, classification models.SpamClassification) error {
+	if feedback.Classification == classification {
+		return nil
+	}
+
+	if feedback.Classification != models.Unclassified {
+		err := s.SpamClassifier.Train(ctx, feedback.Message, feedback.Classification == models.ClassifiedSpam, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.SpamClassifier.Train(ctx, feedback.Message, classification == models.ClassifiedSpam, false)
+	if err != nil {
+		return err
+	}
+
+	feedback.Classification = classification
+
+	return nil
+}
+
+func (s *feedbackService) sendFeedbackEmails(ctx context.Context, feedback models.Feedback) (err error) {
+	// The emails are sent after the response, so this span can end after the span of the request
+	ctx, span := tracing.StartSpan(ctx, "FeedbackService.sendFeedbackEmails")
+	defer func() {
+		span.EndWithError(err)
+	}()
+
 	users, err := s.UserDataStorage.GetAllUsers(ctx)
 	if err != nil {
-		s.Logger.Error(err)
+		s.Logger.WithContext(ctx).Error(err)
 		return err
 	}
 
 	var from models.EmailAddress
 	if feedback.ContactAddress == "" {
-		from = models.NewEmailAddress(s.Args.EmailSenderName, s.Args.EmailSenderAddress)
+		sender := s.EmailSender.GetEmailSender()
+		from = models.NewEmailAddress(sender.FromName, sender.FromEmail)
 	} else {
 		from = models.NewEmailAddress("User", feedback.ContactAddress)
 	}
 
 	for _, user := range users {
 		if user.EmailUpdate == models.Immediately {
-			err = s.EmailService.SendEmail(models.SendEmailArgs{
+			err = s.EmailService.SendEmail(ctx, models.SendEmailArgs{
 				Subject:      "New feedback",
 				From:         from,
 				ReplyTo:      from,
@@ -68,13 +392,13 @@ func (s *feedbackService) sendFeedbackEmails(ctx context.Context, feedback model
 				HtmlContent:  feedback.Message,
 			})
 			if err != nil {
-				s.Logger.Error(err)
+				s.Logger.WithContext(ctx).Error(err)
 				return err
 			}
 		}
 	}
 
-	s.Logger.Info("Emails send to all people who wanted immediate feedback")
+	s.Logger.WithContext(ctx).Info("Emails send to all people who wanted immediate feedback")
 
 	return nil
 }
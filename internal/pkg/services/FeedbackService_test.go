@@ -0,0 +1,328 @@
+package services
+
+import (
+	"context"
+	"github.com/zlepper/welp/internal/pkg/flatfile"
+	"github.com/zlepper/welp/internal/pkg/logging"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/thumbnail"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testFeedbackService struct {
+	models.FeedbackService
+	dataStorage models.FeedbackDataStorage
+	fileStorage models.FileStorage
+}
+
+func newTestFeedbackService(t *testing.T, ctx context.Context, folder string) testFeedbackService {
+	logger := logging.NewLogger(logging.LoggerArgs{})
+
+	dataStorage, err := flatfile.NewFeedbackDataStorage(ctx, flatfile.DataStorageArgs{
+		Filename:     filepath.Join(folder, "feedback.json"),
+		SaveInterval: time.Hour,
+		Logger:       logger,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileStorage, err := flatfile.NewFileStorage(flatfile.FileStorageArgs{
+		FolderPath: filepath.Join(folder, "files"),
+		Logger:     logger,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testFeedbackService{
+		FeedbackService: NewFeedbackService(FeedbackServiceArgs{
+			DataStorage: dataStorage,
+			FileStorage: fileStorage,
+			ThumbnailService: thumbnail.NewThumbnailService(thumbnail.ThumbnailServiceArgs{
+				FileStorage: fileStorage,
+				Logger:      logger,
+			}),
+			Logger: logger,
+		}),
+		dataStorage: dataStorage,
+		fileStorage: fileStorage,
+	}
+}
+
+func (s testFeedbackService) create(t *testing.T, ctx context.Context, files ...models.File) models.Feedback {
+	feedback, err := s.CreateQuarantinedFeedback(ctx, "message", "", files, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Released from quarantine, so it shows in the normal list
+	feedback, err = s.dataStorage.UpdateFeedback(ctx, feedback.Id, func(feedback *models.Feedback) error {
+		feedback.Quarantined = false
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return feedback
+}
+
+func countFeedback(t *testing.T, get func(ctx context.Context) ([]models.Feedback, error)) int {
+	feedback, err := get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(feedback)
+}
+
+func TestArchive(t *testing.T) {
+	folder, err := ioutil.TempDir("", "welp-feedback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service := newTestFeedbackService(t, ctx, folder)
+	feedback := service.create(t, ctx)
+
+	archived, err := service.Archive(ctx, feedback.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !archived.Archived || archived.ArchivedAt.IsZero() {
+		t.Errorf("the feedback was not archived: %v", archived)
+	}
+	if countFeedback(t, service.GetAllFeedback) != 0 || countFeedback(t, service.GetArchivedFeedback) != 1 {
+		t.Error("archived feedback was not moved to the archive")
+	}
+
+	unarchived, err := service.Unarchive(ctx, feedback.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unarchived.Archived || !unarchived.ArchivedAt.IsZero() {
+		t.Errorf("the feedback was not unarchived: %v", unarchived)
+	}
+	if countFeedback(t, service.GetAllFeedback) != 1 || countFeedback(t, service.GetArchivedFeedback) != 0 {
+		t.Error("unarchived feedback was not moved back to the list")
+	}
+
+	if _, err := service.Archive(ctx, "missing"); err != models.ErrFeedbackNotFound {
+		t.Errorf("expected archiving missing feedback to fail with ErrFeedbackNotFound, got %v", err)
+	}
+}
+
+func TestTrashAndRestore(t *testing.T) {
+	folder, err := ioutil.TempDir("", "welp-feedback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service := newTestFeedbackService(t, ctx, folder)
+	feedback := service.create(t, ctx)
+
+	if _, err := service.Archive(ctx, feedback.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	trashed, err := service.MoveToTrash(ctx, feedback.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !trashed.Trashed || trashed.TrashedAt.IsZero() {
+		t.Errorf("the feedback was not moved to the trash: %v", trashed)
+	}
+	if countFeedback(t, service.GetArchivedFeedback) != 0 || countFeedback(t, service.GetTrashedFeedback) != 1 {
+		t.Error("trashed feedback was not moved to the trash")
+	}
+
+	restored, err := service.Restore(ctx, feedback.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Trashed || !restored.Archived {
+		t.Errorf("the feedback was not restored to the archive: %v", restored)
+	}
+	if countFeedback(t, service.GetArchivedFeedback) != 1 || countFeedback(t, service.GetTrashedFeedback) != 0 {
+		t.Error("restored feedback was not moved back to where it was")
+	}
+}
+
+func TestDeleteRequiresTrash(t *testing.T) {
+	folder, err := ioutil.TempDir("", "welp-feedback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service := newTestFeedbackService(t, ctx, folder)
+
+	if _, err := service.fileStorage.SaveFile(ctx, "a.txt", strings.NewReader("content")); err != nil {
+		t.Fatal(err)
+	}
+	feedback := service.create(t, ctx, models.File{Id: "a.txt", ContentType: "text/plain"})
+
+	if err := service.DeleteFeedback(ctx, feedback.Id); err != models.ErrFeedbackNotTrashed {
+		t.Fatalf("expected deleting feedback outside the trash to fail with ErrFeedbackNotTrashed, got %v", err)
+	}
+	if _, err := service.dataStorage.GetFeedback(ctx, feedback.Id); err != nil {
+		t.Fatalf("the feedback was deleted without being in the trash: %v", err)
+	}
+
+	if _, err := service.MoveToTrash(ctx, feedback.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.DeleteFeedback(ctx, feedback.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.dataStorage.GetFeedback(ctx, feedback.Id); err != models.ErrFeedbackNotFound {
+		t.Errorf("expected the feedback to be deleted, got %v", err)
+	}
+	if _, err := service.fileStorage.LoadFile(ctx, "a.txt"); err != models.ErrFileNotFound {
+		t.Errorf("expected the attached file to be deleted, got %v", err)
+	}
+
+	// Purging is for when the feedback has to go, so it doesn't need the trash
+	feedback = service.create(t, ctx)
+	if err := service.PurgeFeedback(ctx, feedback.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.dataStorage.GetFeedback(ctx, feedback.Id); err != models.ErrFeedbackNotFound {
+		t.Errorf("expected the feedback to be purged, got %v", err)
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	folder, err := ioutil.TempDir("", "welp-feedback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service := newTestFeedbackService(t, ctx, folder)
+
+	ids := make([]string, 100)
+	for i := range ids {
+		ids[i] = service.create(t, ctx).Id
+	}
+
+	// Archiving and trashing the same feedback at the same time should keep both changes
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(2)
+		go func(id string) {
+			defer wg.Done()
+			service.Archive(ctx, id)
+		}(id)
+		go func(id string) {
+			defer wg.Done()
+			service.MoveToTrash(ctx, id)
+		}(id)
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		feedback, err := service.dataStorage.GetFeedback(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !feedback.Archived || !feedback.Trashed {
+			t.Fatalf("a change to the feedback was lost: %v", feedback)
+		}
+	}
+}
+
+// Holds back the emails until released, so the request can finish first
+type blockingUserStorage struct {
+	models.AuthorizationDataStorage
+	release chan struct{}
+}
+
+func (s blockingUserStorage) GetAllUsers(ctx context.Context) ([]models.User, error) {
+	<-s.release
+	return []models.User{{Name: "Admin", Email: "admin@admin.com", EmailUpdate: models.Immediately}}, nil
+}
+
+type noOpClassifier struct {
+	models.SpamClassifier
+}
+
+func (noOpClassifier) Train(ctx context.Context, text string, spam bool, untrain bool) error {
+	return nil
+}
+
+type recordingEmailService struct {
+	lock sync.Mutex
+	sent []models.SendEmailArgs
+}
+
+func (s *recordingEmailService) SendEmail(ctx context.Context, args models.SendEmailArgs) error {
+	// Like the http client of SendGrid, which gives up on cancelled contexts
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sent = append(s.sent, args)
+	return nil
+}
+
+func TestEmailsAreSentAfterTheRequest(t *testing.T) {
+	folder, err := ioutil.TempDir("", "welp-feedback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	service := newTestFeedbackService(t, ctx, folder)
+	users := blockingUserStorage{release: make(chan struct{})}
+	emails := &recordingEmailService{}
+	feedbackService := service.FeedbackService.(*feedbackService)
+	feedbackService.UserDataStorage = users
+	feedbackService.EmailService = emails
+	feedbackService.SpamClassifier = noOpClassifier{}
+
+	requestCtx, cancelRequest := context.WithCancel(context.Background())
+	_, err = service.CreateFeedback(requestCtx, "message", "user@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quarantined, err := service.CreateQuarantinedFeedback(requestCtx, "message", "user@example.com", nil, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = service.MarkAsNotSpam(requestCtx, quarantined.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The response has been written, so the request is cancelled before the emails are sent
+	cancelRequest()
+	close(users.release)
+	WaitForBackgroundWork()
+
+	if len(emails.sent) != 2 {
+		t.Errorf("expected an email for the new and the released feedback, got %d", len(emails.sent))
+	}
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// How long the result of writing to a folder is reused, so frequent readiness probes don't write a file every time
+const folderCheckInterval = 5 * time.Second
+
+type HealthServiceArgs struct {
+	// The folders welp writes to, which are checked to be writable.
+	// StorageFolder is empty when the files are not stored on the local disk
+	DatabaseFolder, StorageFolder string
+	// Returns an error if saving the data has failed
+	SaveError func() error
+	// Returns false if emails are not sent anywhere
+	EmailConfigured func() bool
+	// How much work can be running in the background before welp is no longer ready
+	MaxPendingWork int
+	Logger         models.Logger
+}
+
+func NewHealthService(args HealthServiceArgs) models.HealthService {
+	return &healthService{
+		HealthServiceArgs: args,
+		folderChecks:      map[string]cachedHealthCheck{},
+	}
+}
+
+type healthService struct {
+	HealthServiceArgs
+	lock         sync.Mutex
+	folderChecks map[string]cachedHealthCheck
+}
+
+type cachedHealthCheck struct {
+	check     models.HealthCheck
+	checkedAt time.Time
+}
+
+func (s *healthService) CheckReadiness(ctx context.Context) models.ReadinessReport {
+	checks := []models.HealthCheck{
+		s.checkFolder("databaseFolder", s.DatabaseFolder),
+	}
+	if s.StorageFolder != "" {
+		checks = append(checks, s.checkFolder("storageFolder", s.StorageFolder))
+	}
+	checks = append(checks, s.checkSaves(), s.checkEmail(), s.checkBackgroundWork())
+
+	report := models.ReadinessReport{
+		Status: models.HealthOk,
+		Checks: checks,
+	}
+	for _, check := range checks {
+		if check.Status == models.HealthFailing {
+			report.Status = models.HealthFailing
+		} else if check.Status == models.HealthWarning && report.Status == models.HealthOk {
+			report.Status = models.HealthWarning
+		}
+	}
+
+	return report
+}
+
+// Writes and removes a small file in the folder. The file ends with .temp,
+// so it is skipped when the stored files are listed.
+// The result is reused for folderCheckInterval
+func (s *healthService) checkFolder(name, folder string) models.HealthCheck {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	cached, exists := s.folderChecks[name]
+	if exists && time.Since(cached.checkedAt) < folderCheckInterval {
+		return cached.check
+	}
+
+	check := s.writeToFolder(name, folder)
+	s.folderChecks[name] = cachedHealthCheck{
+		check:     check,
+		checkedAt: time.Now(),
+	}
+
+	return check
+}
+
+func (s *healthService) writeToFolder(name, folder string) models.HealthCheck {
+	check := models.HealthCheck{
+		Name:   name,
+		Status: models.HealthOk,
+	}
+
+	err := os.MkdirAll(folder, os.ModePerm)
+	if err == nil {
+		err = writeProbe(path.Join(folder, fmt.Sprintf("welp-ready-%d.temp", time.Now().UnixNano())))
+	}
+	if err != nil {
+		s.Logger.Errorf("Folder '%s' is not writable: %v", folder, err)
+		check.Status = models.HealthFailing
+		check.Message = "not writable"
+	}
+
+	return check
+}
+
+func writeProbe(filename string) error {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write([]byte("welp"))
+	closeErr := file.Close()
+	removeErr := os.Remove(filename)
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return removeErr
+}
+
+func (s *healthService) checkSaves() models.HealthCheck {
+	check := models.HealthCheck{
+		Name:   "saves",
+		Status: models.HealthOk,
+	}
+
+	if s.SaveError == nil {
+		return check
+	}
+
+	err := s.SaveError()
+	if err != nil {
+		check.Status = models.HealthFailing
+		check.Message = err.Error()
+	}
+
+	return check
+}
+
+// Welp works fine without emails, but nobody is told about new feedback
+func (s *healthService) checkEmail() models.HealthCheck {
+	if !s.EmailConfigured() {
+		return models.HealthCheck{
+			Name:    "email",
+			Status:  models.HealthWarning,
+			Message: "no email backend is configured, so no emails are sent",
+		}
+	}
+
+	return models.HealthCheck{
+		Name:   "email",
+		Status: models.HealthOk,
+	}
+}
+
+func (s *healthService) checkBackgroundWork() models.HealthCheck {
+	pending := PendingBackgroundWork()
+	check := models.HealthCheck{
+		Name:    "backgroundWork",
+		Status:  models.HealthOk,
+		Message: fmt.Sprintf("%d running", pending),
+	}
+
+	if s.MaxPendingWork > 0 && pending > s.MaxPendingWork {
+		check.Status = models.HealthFailing
+		check.Message = fmt.Sprintf("%d running, more than the limit of %d", pending, s.MaxPendingWork)
+	}
+
+	return check
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"github.com/zlepper/welp/internal/pkg/logging"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFolderCheckIsCached(t *testing.T) {
+	folder, err := ioutil.TempDir("", "welp-health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	databaseFolder := filepath.Join(folder, "database")
+	service := NewHealthService(HealthServiceArgs{
+		DatabaseFolder: databaseFolder,
+		Logger:         logging.NewLogger(logging.LoggerArgs{}),
+	}).(*healthService)
+
+	if check := service.checkFolder("databaseFolder", databaseFolder); check.Status != models.HealthOk {
+		t.Fatalf("expected the folder to be writable, got %v", check)
+	}
+
+	// A file where the folder should be can't be written to
+	if err := os.RemoveAll(databaseFolder); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(databaseFolder, []byte("not a folder"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if check := service.checkFolder("databaseFolder", databaseFolder); check.Status != models.HealthOk {
+		t.Errorf("expected the last result to be reused, got %v", check)
+	}
+
+	cached := service.folderChecks["databaseFolder"]
+	cached.checkedAt = time.Now().Add(-folderCheckInterval)
+	service.folderChecks["databaseFolder"] = cached
+
+	if check := service.checkFolder("databaseFolder", databaseFolder); check.Status != models.HealthFailing {
+		t.Errorf("expected the folder to be checked again, got %v", check)
+	}
+}
+
+func TestSchedulesAreNotPendingWork(t *testing.T) {
+	stop := make(chan struct{})
+	before := PendingBackgroundWork()
+
+	RunScheduleInBackground(func() {
+		<-stop
+	})
+	if pending := PendingBackgroundWork(); pending != before {
+		t.Errorf("expected the schedule not to be counted, got %d pending work instead of %d", pending, before)
+	}
+
+	RunInBackground(func() {
+		<-stop
+	})
+	if pending := PendingBackgroundWork(); pending != before+1 {
+		t.Errorf("expected the one-shot work to be counted, got %d pending work instead of %d", pending, before+1)
+	}
+
+	close(stop)
+	WaitForBackgroundWork()
+}
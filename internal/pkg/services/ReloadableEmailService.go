@@ -0,0 +1,78 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package services
+
+import (
+	"context"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"sync/atomic"
+)
+
+// Gives who emails are sent from. The sender can change while welp runs
+type EmailSenderProvider interface {
+	GetEmailSender() EmailSender
+}
+
+// A sender that never changes
+func (s EmailSender) GetEmailSender() EmailSender {
+	return s
+}
+
+// Sends emails with an email service and sender that can be replaced while welp runs,
+// e.g. when the SendGrid api key is changed. Emails that are being sent finish with the old service
+type ReloadableEmailService struct {
+	current atomic.Value
+}
+
+type emailSettings struct {
+	service models.EmailService
+	sender  EmailSender
+}
+
+func NewReloadableEmailService(service models.EmailService, sender EmailSender) *ReloadableEmailService {
+	s := &ReloadableEmailService{}
+	s.Set(service, sender)
+	return s
+}
+
+// Replaces the service and the sender together, so an email is never sent with a mix of the old and new settings
+func (s *ReloadableEmailService) Set(service models.EmailService, sender EmailSender) {
+	s.current.Store(emailSettings{service: service, sender: sender})
+}
+
+func (s *ReloadableEmailService) get() emailSettings {
+	return s.current.Load().(emailSettings)
+}
+
+func (s *ReloadableEmailService) SendEmail(ctx context.Context, args models.SendEmailArgs) error {
+	return s.get().service.SendEmail(ctx, args)
+}
+
+func (s *ReloadableEmailService) GetEmailSender() EmailSender {
+	return s.get().sender
+}
+
+// Lets the readiness check tell if emails are actually sent
+func (s *ReloadableEmailService) GetEmailService() models.EmailService {
+	return s.get().service
+}
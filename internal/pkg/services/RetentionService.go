@@ -0,0 +1,198 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"time"
+)
+
+type RetentionServiceArgs struct {
+	// Where the feedback is found
+	DataStorage models.FeedbackDataStorage
+	// Deletes and anonymises the feedback, together with the attached files
+	FeedbackService models.FeedbackService
+	Policy          models.RetentionPolicy
+	Logger          models.Logger
+}
+
+func NewRetentionService(args RetentionServiceArgs) models.RetentionService {
+	return &retentionService{
+		RetentionServiceArgs: args,
+	}
+}
+
+type retentionService struct {
+	RetentionServiceArgs
+}
+
+func (s *retentionService) Enforce(ctx context.Context, dryRun bool) (models.RetentionReport, error) {
+	report := models.RetentionReport{
+		Time:   time.Now(),
+		DryRun: dryRun,
+		Items:  []models.RetentionReportItem{},
+	}
+
+	if !s.Policy.Enabled() {
+		return report, nil
+	}
+
+	all, err := s.DataStorage.GetAllFeedback(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	for _, feedback := range all {
+		// Stops between feedback entries when shutting down, the rest are purged next time
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		reason, expired := s.isExpired(feedback, report.Time)
+		if !expired {
+			continue
+		}
+
+		// Anonymised feedback is kept, so it shouldn't show up in every report after
+		if s.Policy.Action == models.RetentionAnonymise && feedback.Anonymised {
+			continue
+		}
+
+		item := models.RetentionReportItem{
+			FeedbackId: feedback.Id,
+			Created:    feedback.Created,
+			Reason:     reason,
+			Action:     s.Policy.Action,
+		}
+
+		if !dryRun {
+			err = s.purge(ctx, feedback)
+			if err != nil {
+				s.Logger.Errorf("Failed to %s feedback '%s': %v", s.Policy.Action, feedback.Id, err)
+				item.Error = err.Error()
+			}
+		}
+
+		report.Items = append(report.Items, item)
+	}
+
+	return report, nil
+}
+
+// Checks if any of the rules says the feedback should no longer be kept, and if so, why
+func (s *retentionService) isExpired(feedback models.Feedback, now time.Time) (string, bool) {
+	if s.Policy.AfterCreation > 0 && now.Sub(feedback.Created) >= s.Policy.AfterCreation {
+		return fmt.Sprintf("created more than %s ago", formatRetentionDuration(s.Policy.AfterCreation)), true
+	}
+
+	if s.Policy.AfterResolution > 0 && feedback.Archived && now.Sub(feedback.ArchivedAt) >= s.Policy.AfterResolution {
+		return fmt.Sprintf("resolved more than %s ago", formatRetentionDuration(s.Policy.AfterResolution)), true
+	}
+
+	return "", false
+}
+
+// Formats whole days as days, as that is how the rules are configured
+func formatRetentionDuration(duration time.Duration) string {
+	day := 24 * time.Hour
+	if duration%day == 0 {
+		days := int(duration / day)
+		if days == 1 {
+			return "1 day"
+		}
+		return fmt.Sprintf("%d days", days)
+	}
+	return duration.String()
+}
+
+func (s *retentionService) purge(ctx context.Context, feedback models.Feedback) error {
+	switch s.Policy.Action {
+	case models.RetentionAnonymise:
+		_, err := s.FeedbackService.Anonymise(ctx, feedback.Id)
+		return err
+	case models.RetentionDelete:
+		return s.FeedbackService.PurgeFeedback(ctx, feedback.Id)
+	default:
+		return models.ErrUnknownRetentionAction
+	}
+}
+
+func (s *retentionService) RunSchedule(ctx context.Context) {
+	if !s.Policy.Enabled() {
+		return
+	}
+
+	if s.Policy.Interval <= 0 {
+		s.Logger.Errorf("The retention policy is not enforced, as the interval %s isn't positive", s.Policy.Interval)
+		return
+	}
+
+	s.Logger.Infof("Enforcing the retention policy every %s", s.Policy.Interval)
+
+	ticker := time.NewTicker(s.Policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.enforceAndLog(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *retentionService) enforceAndLog(ctx context.Context) {
+	report, err := s.Enforce(ctx, s.Policy.DryRun)
+	if err != nil {
+		// Stopped because welp is shutting down
+		if ctx.Err() != nil {
+			return
+		}
+		s.Logger.Errorf("Failed to enforce the retention policy: %v", err)
+		return
+	}
+
+	if len(report.Items) == 0 {
+		return
+	}
+
+	if report.DryRun {
+		for _, item := range report.Items {
+			s.Logger.Infof("Retention dry run: Would %s feedback '%s', %s", item.Action, item.FeedbackId, item.Reason)
+		}
+		return
+	}
+
+	failed := 0
+	for _, item := range report.Items {
+		if item.Error != "" {
+			failed++
+		}
+	}
+
+	s.Logger.Infof("Retention policy: Purged %d feedback entries, %d failed", len(report.Items)-failed, failed)
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"github.com/zlepper/welp/internal/pkg/logging"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+type retentionTest struct {
+	testFeedbackService
+	ctx context.Context
+	t   *testing.T
+}
+
+// Saves feedback that was created, and optionally archived, the given time ago
+func (r retentionTest) save(created, archived time.Duration) models.Feedback {
+	feedback, err := models.NewFeedback("message from someone@example.com", "someone@example.com", []models.File{})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	feedback.Created = time.Now().Add(-created)
+	if archived > 0 {
+		feedback.Archived = true
+		feedback.ArchivedAt = time.Now().Add(-archived)
+	}
+
+	if err := r.dataStorage.SaveFeedback(r.ctx, feedback); err != nil {
+		r.t.Fatal(err)
+	}
+	return feedback
+}
+
+func (r retentionTest) enforce(policy models.RetentionPolicy, dryRun bool) map[string]models.RetentionReportItem {
+	service := NewRetentionService(RetentionServiceArgs{
+		DataStorage:     r.dataStorage,
+		FeedbackService: r.FeedbackService,
+		Policy:          policy,
+		Logger:          logging.NewLogger(logging.LoggerArgs{}),
+	})
+
+	report, err := service.Enforce(r.ctx, dryRun)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	if report.DryRun != dryRun {
+		r.t.Errorf("expected the report to have dry run %v", dryRun)
+	}
+
+	items := map[string]models.RetentionReportItem{}
+	for _, item := range report.Items {
+		if item.Error != "" {
+			r.t.Errorf("failed to purge '%s': %s", item.FeedbackId, item.Error)
+		}
+		items[item.FeedbackId] = item
+	}
+	return items
+}
+
+func newRetentionTest(t *testing.T, ctx context.Context, folder string) retentionTest {
+	return retentionTest{
+		testFeedbackService: newTestFeedbackService(t, ctx, folder),
+		ctx:                 ctx,
+		t:                   t,
+	}
+}
+
+func TestRetentionDelete(t *testing.T) {
+	folder, err := ioutil.TempDir("", "welp-retention")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := newRetentionTest(t, ctx, folder)
+
+	old := r.save(40*day, 0)
+	resolved := r.save(10*day, 8*day)
+	recentlyResolved := r.save(10*day, 2*day)
+	recent := r.save(2*day, 0)
+
+	policy := models.RetentionPolicy{
+		AfterCreation:   30 * day,
+		AfterResolution: 7 * day,
+		Action:          models.RetentionDelete,
+	}
+
+	items := r.enforce(policy, true)
+	if len(items) != 2 {
+		t.Fatalf("expected 2 entries to be purged, got %v", items)
+	}
+	if items[old.Id].Reason != "created more than 30 days ago" {
+		t.Errorf("unexpected reason for the old feedback: %v", items[old.Id])
+	}
+	if items[resolved.Id].Reason != "resolved more than 7 days ago" {
+		t.Errorf("unexpected reason for the resolved feedback: %v", items[resolved.Id])
+	}
+	if all, _ := r.dataStorage.GetAllFeedback(ctx); len(all) != 4 {
+		t.Fatalf("the dry run changed the feedback: %v", all)
+	}
+
+	items = r.enforce(policy, false)
+	if len(items) != 2 {
+		t.Fatalf("expected 2 entries to be purged, got %v", items)
+	}
+	for _, feedback := range []models.Feedback{old, resolved} {
+		if _, err := r.dataStorage.GetFeedback(ctx, feedback.Id); err != models.ErrFeedbackNotFound {
+			t.Errorf("expected '%s' to be deleted, got %v", feedback.Id, err)
+		}
+	}
+	for _, feedback := range []models.Feedback{recentlyResolved, recent} {
+		if _, err := r.dataStorage.GetFeedback(ctx, feedback.Id); err != nil {
+			t.Errorf("feedback that should be kept was purged: %v", err)
+		}
+	}
+}
+
+func TestRetentionAnonymise(t *testing.T) {
+	folder, err := ioutil.TempDir("", "welp-retention")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := newRetentionTest(t, ctx, folder)
+
+	old := r.save(40*day, 0)
+
+	policy := models.RetentionPolicy{
+		AfterCreation: 30 * day,
+		Action:        models.RetentionAnonymise,
+	}
+
+	if items := r.enforce(policy, false); len(items) != 1 {
+		t.Fatalf("expected 1 entry to be anonymised, got %v", items)
+	}
+
+	feedback, err := r.dataStorage.GetFeedback(ctx, old.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !feedback.Anonymised || feedback.ContactAddress != "" || feedback.Message != "message from [removed]" {
+		t.Errorf("the feedback was not anonymised: %v", feedback)
+	}
+
+	// Anonymised feedback is kept, but shouldn't be reported again
+	if items := r.enforce(policy, false); len(items) != 0 {
+		t.Errorf("anonymised feedback was purged again: %v", items)
+	}
+}
+
+func TestRetentionDisabled(t *testing.T) {
+	folder, err := ioutil.TempDir("", "welp-retention")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := newRetentionTest(t, ctx, folder)
+
+	r.save(1000*day, 1000*day)
+
+	if items := r.enforce(models.RetentionPolicy{Action: models.RetentionDelete}, false); len(items) != 0 {
+		t.Errorf("feedback was purged without any rules: %v", items)
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package services
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// Keeps track of the work that is still running in the background
+	backgroundWork sync.WaitGroup
+
+	// How much one-shot work is running in the background right now. The schedules
+	// run until welp stops, so they aren't counted
+	pendingBackgroundWork int64
+)
+
+// Runs fn in the background, so shutting down can wait for it to finish
+func RunInBackground(fn func()) {
+	backgroundWork.Add(1)
+	atomic.AddInt64(&pendingBackgroundWork, 1)
+	go func() {
+		defer backgroundWork.Done()
+		defer atomic.AddInt64(&pendingBackgroundWork, -1)
+		fn()
+	}()
+}
+
+// A context that keeps the values, like the span and the request id, of the context it was made
+// from, but is never cancelled. The context of a request is cancelled as soon as the response has
+// been written, which would stop the work that continues in the background
+type detachedContext struct {
+	parent context.Context
+}
+
+// Gets a context for work that should continue after the request of ctx has been answered
+func detachContext(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (c detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// Runs a schedule in the background until the context it was given is cancelled, so shutting
+// down can wait for the run in progress. Unlike RunInBackground it is not counted as pending work
+func RunScheduleInBackground(fn func()) {
+	backgroundWork.Add(1)
+	go func() {
+		defer backgroundWork.Done()
+		fn()
+	}()
+}
+
+// Gets how much work started with RunInBackground is still running
+func PendingBackgroundWork() int {
+	return int(atomic.LoadInt64(&pendingBackgroundWork))
+}
+
+// Waits for the work started with RunInBackground, like sending emails, to finish
+func WaitForBackgroundWork() {
+	backgroundWork.Wait()
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package spam
+
+import (
+	"context"
+	"fmt"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"math"
+	"strings"
+	"unicode"
+)
+
+const (
+	minTokenLength = 3
+	maxTokenLength = 30
+	// How many texts of each kind has to be trained before the classifier
+	// starts making guesses. Before that everything is considered not spam
+	minTrainingDocuments = 5
+)
+
+type BayesClassifierArgs struct {
+	DataStorage models.SpamDataStorage
+	Logger      models.Logger
+	// Texts with a spam probability at or above this are suspected to be spam.
+	// 0 disables filtering, the classifier will still be trained
+	Threshold float64
+}
+
+// Creates a naive bayes classifier, that learns what spam looks like
+// from admins marking feedback as spam.
+// The classifier is also a spam filter
+func NewBayesClassifier(args BayesClassifierArgs) *BayesClassifier {
+	return &BayesClassifier{
+		BayesClassifierArgs: args,
+	}
+}
+
+type BayesClassifier struct {
+	BayesClassifierArgs
+}
+
+func (c *BayesClassifier) Train(ctx context.Context, text string, spam bool, untrain bool) error {
+	delta := 1
+	if untrain {
+		delta = -1
+	}
+
+	return c.DataStorage.AddTokens(ctx, tokenize(text), spam, delta)
+}
+
+func (c *BayesClassifier) SpamProbability(ctx context.Context, text string) (float64, error) {
+	tokens := tokenize(text)
+
+	stats, err := c.DataStorage.GetStatistics(ctx, tokens)
+	if err != nil {
+		return 0, err
+	}
+
+	if stats.SpamDocuments < minTrainingDocuments || stats.HamDocuments < minTrainingDocuments {
+		return 0, nil
+	}
+
+	spamDocuments := float64(stats.SpamDocuments)
+	hamDocuments := float64(stats.HamDocuments)
+
+	// Work in log space to avoid underflow with long texts
+	spamScore := math.Log(spamDocuments / (spamDocuments + hamDocuments))
+	hamScore := math.Log(hamDocuments / (spamDocuments + hamDocuments))
+
+	for _, token := range tokens {
+		count := stats.Tokens[token]
+
+		// Laplace smoothing, so unknown tokens doesn't zero out everything
+		spamScore += math.Log((float64(count.Spam) + 1) / (spamDocuments + 2))
+		hamScore += math.Log((float64(count.Ham) + 1) / (hamDocuments + 2))
+	}
+
+	return 1 / (1 + math.Exp(hamScore-spamScore)), nil
+}
+
+func (c *BayesClassifier) Check(ctx context.Context, args models.SpamCheckArgs) (models.SpamCheckResult, error) {
+	if c.Threshold <= 0 {
+		return models.SpamCheckResult{Verdict: models.NotSpam}, nil
+	}
+
+	probability, err := c.SpamProbability(ctx, args.Message)
+	if err != nil {
+		return models.SpamCheckResult{}, err
+	}
+
+	if probability >= c.Threshold {
+		return models.SpamCheckResult{
+			Verdict: models.SuspectedSpam,
+			Reason:  fmt.Sprintf("classified as spam with %.0f%% probability", probability*100),
+		}, nil
+	}
+
+	return models.SpamCheckResult{Verdict: models.NotSpam}, nil
+}
+
+// Splits the text into unique lowercase words
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := make(map[string]bool, len(words))
+	tokens := make([]string, 0, len(words))
+	for _, word := range words {
+		length := len([]rune(word))
+		if length < minTokenLength || length > maxTokenLength || seen[word] {
+			continue
+		}
+
+		seen[word] = true
+		tokens = append(tokens, word)
+	}
+
+	return tokens
+}
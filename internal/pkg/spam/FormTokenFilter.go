@@ -0,0 +1,239 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package spam
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"math/bits"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// The form field the signed token should be sent back in
+	FormTokenField = "formToken"
+	// The form field the solution to the proof of work challenge should be sent in
+	ProofOfWorkField = "proofOfWork"
+
+	// Used to make sure form tokens can't be confused with other things signed by the same secret
+	formTokenPurpose = "welp-form-token."
+	nonceLength      = 16
+	// issued timestamp + difficulty + nonce
+	payloadLength = 8 + 1 + nonceLength
+	// How often the expired tokens are removed from the used tokens
+	pruneInterval = time.Minute
+)
+
+var (
+	errMalformedToken = errors.New("malformed form token")
+	errInvalidToken   = errors.New("invalid form token signature")
+)
+
+type FormTokenFilterArgs struct {
+	SecretService models.SecretService
+	Logger        models.Logger
+	// Submissions that happens faster than this after the token was issued
+	// are rejected, as no human types that fast
+	MinSubmitTime time.Duration
+	// How long a token can be used after it has been issued
+	MaxAge time.Duration
+	// How many leading zero bits the proof of work hash should have.
+	// 0 disables the proof of work
+	Difficulty int
+}
+
+// Creates a filter that requires every submission to include a signed token
+// issued by welp, and optionally a solution to a proof of work challenge.
+// The filter also issues the tokens.
+func NewFormTokenFilter(args FormTokenFilterArgs) *FormTokenFilter {
+	return &FormTokenFilter{
+		FormTokenFilterArgs: args,
+		usedTokens:          map[string]time.Time{},
+	}
+}
+
+type FormTokenFilter struct {
+	FormTokenFilterArgs
+
+	// Tokens that has already been used, and when they expire.
+	// Used to prevent a single solved challenge from being reused for many submissions
+	usedTokens map[string]time.Time
+	// When the expired tokens should be removed from usedTokens next
+	nextPrune time.Time
+	lock      sync.Mutex
+}
+
+func (f *FormTokenFilter) NewChallenge(ctx context.Context) (models.SpamChallenge, error) {
+	payload := make([]byte, payloadLength)
+	binary.BigEndian.PutUint64(payload, uint64(time.Now().UnixNano()))
+	payload[8] = byte(f.Difficulty)
+	_, err := rand.Read(payload[9:])
+	if err != nil {
+		return models.SpamChallenge{}, err
+	}
+
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+
+	signature, err := f.sign(ctx, encodedPayload)
+	if err != nil {
+		return models.SpamChallenge{}, err
+	}
+
+	return models.SpamChallenge{
+		Token:      encodedPayload + "." + base64.RawURLEncoding.EncodeToString(signature),
+		Difficulty: f.Difficulty,
+	}, nil
+}
+
+func (f *FormTokenFilter) sign(ctx context.Context, encodedPayload string) ([]byte, error) {
+	secret, err := f.SecretService.GetSigningSecret(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(formTokenPurpose + encodedPayload))
+	return mac.Sum(nil), nil
+}
+
+// Verifies the signature of the token, and returns when it was issued,
+// and the difficulty of the proof of work
+func (f *FormTokenFilter) parseToken(ctx context.Context, token string) (issued time.Time, difficulty int, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return issued, 0, errMalformedToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(payload) != payloadLength {
+		return issued, 0, errMalformedToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return issued, 0, errMalformedToken
+	}
+
+	expected, err := f.sign(ctx, parts[0])
+	if err != nil {
+		return issued, 0, err
+	}
+
+	if !hmac.Equal(signature, expected) {
+		return issued, 0, errInvalidToken
+	}
+
+	issued = time.Unix(0, int64(binary.BigEndian.Uint64(payload)))
+	return issued, int(payload[8]), nil
+}
+
+func (f *FormTokenFilter) Check(ctx context.Context, args models.SpamCheckArgs) (models.SpamCheckResult, error) {
+	token := args.Form.Get(FormTokenField)
+	if token == "" {
+		return rejected("missing form token"), nil
+	}
+
+	issued, difficulty, err := f.parseToken(ctx, token)
+	if err != nil {
+		if err == errMalformedToken || err == errInvalidToken {
+			return rejected(err.Error()), nil
+		}
+		return models.SpamCheckResult{}, err
+	}
+
+	age := time.Since(issued)
+	if age > f.MaxAge {
+		return rejected("form token expired"), nil
+	}
+
+	if !hasLeadingZeroBits(proofOfWorkHash(token, args.Form.Get(ProofOfWorkField)), difficulty) {
+		return rejected("invalid proof of work"), nil
+	}
+
+	if age < f.MinSubmitTime {
+		return rejected("submitted " + age.Round(time.Millisecond).String() + " after the form was loaded"), nil
+	}
+
+	if !f.markUsed(token, issued.Add(f.MaxAge)) {
+		return rejected("form token already used"), nil
+	}
+
+	return models.SpamCheckResult{Verdict: models.NotSpam}, nil
+}
+
+// Registers the token as used. Returns false if it was already used
+func (f *FormTokenFilter) markUsed(token string, expires time.Time) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	// Pruning on every submission would make every submission go through every used token
+	now := time.Now()
+	if now.After(f.nextPrune) {
+		for usedToken, usedExpires := range f.usedTokens {
+			if usedExpires.Before(now) {
+				delete(f.usedTokens, usedToken)
+			}
+		}
+		f.nextPrune = now.Add(pruneInterval)
+	}
+
+	if _, used := f.usedTokens[token]; used {
+		return false
+	}
+
+	f.usedTokens[token] = expires
+	return true
+}
+
+func proofOfWorkHash(token, proofOfWork string) []byte {
+	hash := sha256.Sum256([]byte(token + ":" + proofOfWork))
+	return hash[:]
+}
+
+func hasLeadingZeroBits(hash []byte, count int) bool {
+	zeros := 0
+	for _, b := range hash {
+		if b != 0 {
+			zeros += bits.LeadingZeros8(b)
+			break
+		}
+		zeros += 8
+	}
+
+	return zeros >= count
+}
+
+func rejected(reason string) models.SpamCheckResult {
+	return models.SpamCheckResult{
+		Verdict: models.RejectedSpam,
+		Reason:  reason,
+	}
+}
@@ -0,0 +1,100 @@
+package spam
+
+import (
+	"context"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type staticSecretService struct {
+}
+
+func (staticSecretService) GetSigningSecret(ctx context.Context) ([]byte, error) {
+	return []byte("secret"), nil
+}
+
+func (staticSecretService) SetSigningSecret(ctx context.Context, secret []byte) error {
+	return nil
+}
+
+func solve(challenge models.SpamChallenge) string {
+	for i := 0; ; i++ {
+		proof := strconv.Itoa(i)
+		if hasLeadingZeroBits(proofOfWorkHash(challenge.Token, proof), challenge.Difficulty) {
+			return proof
+		}
+	}
+}
+
+func TestFormTokenFilter(t *testing.T) {
+	ctx := context.Background()
+	filter := NewFormTokenFilter(FormTokenFilterArgs{
+		SecretService: staticSecretService{},
+		MinSubmitTime: time.Hour,
+		MaxAge:        24 * time.Hour,
+		Difficulty:    8,
+	})
+
+	challenge, err := filter.NewChallenge(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(token, proof string) models.SpamCheckResult {
+		result, err := filter.Check(ctx, models.SpamCheckArgs{
+			Form: url.Values{FormTokenField: {token}, ProofOfWorkField: {proof}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	proof := solve(challenge)
+
+	if result := check(challenge.Token+"x", proof); result.Verdict != models.RejectedSpam {
+		t.Errorf("tampered token was not rejected: %v", result)
+	}
+
+	if result := check(challenge.Token, proof+"x"); result.Verdict != models.RejectedSpam {
+		t.Errorf("invalid proof of work was not rejected: %v", result)
+	}
+
+	// The submission happens right away, which is faster than MinSubmitTime
+	if result := check(challenge.Token, proof); result.Verdict != models.RejectedSpam {
+		t.Errorf("fast submission was not rejected: %v", result)
+	}
+
+	filter.MinSubmitTime = 0
+
+	if result := check(challenge.Token, proof); result.Verdict != models.NotSpam {
+		t.Errorf("valid submission was not accepted: %v", result)
+	}
+
+	if result := check(challenge.Token, proof); result.Verdict != models.RejectedSpam {
+		t.Errorf("reused token was not rejected: %v", result)
+	}
+}
+
+func TestFormTokenFilterPrunesExpiredTokens(t *testing.T) {
+	filter := NewFormTokenFilter(FormTokenFilterArgs{})
+
+	past := time.Now().Add(-time.Minute)
+	if !filter.markUsed("expired", past) {
+		t.Fatal("unused token was reported as used")
+	}
+
+	// The first prune happened before the token was added
+	filter.nextPrune = time.Time{}
+	filter.markUsed("other", time.Now().Add(time.Hour))
+
+	if _, found := filter.usedTokens["expired"]; found {
+		t.Error("expired token was not pruned")
+	}
+	if filter.markUsed("other", time.Now().Add(time.Hour)) {
+		t.Error("used token was not reported as used")
+	}
+}
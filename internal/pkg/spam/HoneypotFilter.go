@@ -0,0 +1,53 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package spam
+
+import (
+	"context"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"strings"
+)
+
+// The name of the form field that is hidden from real users.
+// Bots tend to fill out every field they can find, so if this is filled,
+// it's most likely not a human
+const HoneypotField = "website"
+
+// Creates a filter that suspects everything that fills out the honeypot field
+func NewHoneypotFilter() models.SpamFilter {
+	return &honeypotFilter{}
+}
+
+type honeypotFilter struct {
+}
+
+func (f *honeypotFilter) Check(ctx context.Context, args models.SpamCheckArgs) (models.SpamCheckResult, error) {
+	if strings.TrimSpace(args.Form.Get(HoneypotField)) != "" {
+		return models.SpamCheckResult{
+			Verdict: models.SuspectedSpam,
+			Reason:  "honeypot field was filled",
+		}, nil
+	}
+
+	return models.SpamCheckResult{Verdict: models.NotSpam}, nil
+}
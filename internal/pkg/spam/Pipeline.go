@@ -0,0 +1,82 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package spam
+
+import (
+	"context"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/tracing"
+	"strings"
+)
+
+// Creates a spam filter that runs all the given filters in order.
+// The pipeline stops as soon as a filter rejects the submission,
+// otherwise the worst verdict is returned, with the reasons of all filters
+// that suspected spam.
+func NewPipeline(logger models.Logger, filters ...models.SpamFilter) models.SpamFilter {
+	return &pipeline{
+		logger:  logger,
+		filters: filters,
+	}
+}
+
+type pipeline struct {
+	logger  models.Logger
+	filters []models.SpamFilter
+}
+
+func (p *pipeline) Check(ctx context.Context, args models.SpamCheckArgs) (result models.SpamCheckResult, err error) {
+	ctx, span := tracing.StartSpan(ctx, "spam.Check")
+	defer func() {
+		span.SetAttribute("spam.verdict", int(result.Verdict))
+		span.EndWithError(err)
+	}()
+
+	reasons := make([]string, 0)
+
+	for _, filter := range p.filters {
+		result, err := filter.Check(ctx, args)
+		if err != nil {
+			return models.SpamCheckResult{}, err
+		}
+
+		switch result.Verdict {
+		case models.RejectedSpam:
+			p.logger.WithContext(ctx).Infof("Submission rejected as spam: %s", result.Reason)
+			return result, nil
+		case models.SuspectedSpam:
+			reasons = append(reasons, result.Reason)
+		}
+	}
+
+	if len(reasons) > 0 {
+		reason := strings.Join(reasons, ", ")
+		p.logger.WithContext(ctx).Infof("Submission suspected to be spam: %s", reason)
+		return models.SpamCheckResult{
+			Verdict: models.SuspectedSpam,
+			Reason:  reason,
+		}, nil
+	}
+
+	return models.SpamCheckResult{Verdict: models.NotSpam}, nil
+}
@@ -33,6 +33,11 @@ type templateContent struct {
 
 var contents = []templateContent{
 
+	templateContent{
+		Filename: "bad-request",
+		Content:  "<!DOCTYPE html>\r\n<html lang=\"en\">\r\n<head>\r\n    <meta charset=\"UTF-8\">\r\n    <title>Bad request</title>\r\n</head>\r\n<body>\r\n\r\n<p>{{.Message}}</p>\r\n\r\n</body>\r\n</html>",
+	},
+
 	templateContent{
 		Filename: "create-new-user",
 		Content:  "<!DOCTYPE html>\r\n<html lang=\"en\">\r\n<head>\r\n    <meta charset=\"UTF-8\">\r\n    <title>Create new user</title>\r\n</head>\r\n<body>\r\n{{template \"header\" .AuthState}}\r\n\r\n<style>\r\n    .error {\r\n        color: red;\r\n    }\r\n\r\n    .error.hidden {\r\n        display: none;\r\n    }\r\n</style>\r\n\r\n<form id=\"create-user-form\" action=\"/users/new\" method=\"post\" onsubmit=\"return createUser(event)\">\r\n\r\n    <div>\r\n        <label>\r\n            Name\r\n            <input type=\"text\" name=\"name\" required>\r\n        </label>\r\n    </div>\r\n\r\n    <div>\r\n        <label>\r\n            Email\r\n            <input type=\"email\" name=\"email\" required>\r\n        </label>\r\n    </div>\r\n\r\n    <div>\r\n        <label>\r\n            Password\r\n            <input type=\"password\" name=\"password\" required>\r\n        </label>\r\n    </div>\r\n\r\n    <div>\r\n        <label>\r\n            Repeat Password\r\n            <input type=\"password\" name=\"repeatPassword\" required>\r\n        </label>\r\n    </div>\r\n\r\n    <div id=\"password-no-match-error\" class=\"error hidden\">\r\n        Passwords do not match\r\n    </div>\r\n\r\n    <div>\r\n        <span>Available roles</span>\r\n    {{range .AvailableRoles}}\r\n        <label>\r\n            <input type=\"checkbox\" value=\"{{.Key}}\" name=\"roles\">\r\n        {{.Name}}\r\n        </label>\r\n    {{end}}\r\n    </div>\r\n\r\n    <button type=\"submit\">\r\n        Create\r\n    </button>\r\n</form>\r\n\r\n<script>\r\n    function createUser(event) {\r\n\r\n        event.preventDefault();\r\n\r\n        var form = document.getElementById('create-user-form');\r\n\r\n        var password = form.password.value;\r\n        var repeatPassword = form.repeatPassword.value;\r\n\r\n        var passwordMatchError = document.getElementById('password-no-match-error');\r\n        if (password !== repeatPassword) {\r\n            passwordMatchError.classList.remove('hidden');\r\n            return false;\r\n        } else {\r\n            passwordMatchError.classList.add('hidden');\r\n        }\r\n\r\n        console.log(form);\r\n\r\n        var fd = new FormData(form);\r\n\r\n        var xhr = new XMLHttpRequest();\r\n\r\n        xhr.addEventListener('load', function (event) {\r\n            console.log('response', xhr.responseText);\r\n        });\r\n\r\n        xhr.addEventListener('error', function (event) {\r\n            console.error('Request failed', event);\r\n        });\r\n\r\n        xhr.open('POST', '/users');\r\n\r\n        xhr.setRequestHeader('Accept', 'application/json');\r\n\r\n        xhr.send(fd);\r\n\r\n        return false;\r\n    }\r\n</script>\r\n</body>\r\n</html>",
@@ -45,7 +50,7 @@ var contents = []templateContent{
 
 	templateContent{
 		Filename: "embed",
-		Content:  "<!DOCTYPE html>\r\n<html lang=\"en\">\r\n<head>\r\n    <meta charset=\"UTF-8\">\r\n    <title>Feedback</title>\r\n</head>\r\n<body>\r\n\r\n<form method=\"post\" action=\"/\" onsubmit=\"return handleSubmit(event)\" id=\"form\">\r\n    <label>\r\n        Message\r\n        <textarea id=\"message\" name=\"message\" required></textarea>\r\n    </label>\r\n\r\n    <label>\r\n        Contact address\r\n        <input type=\"email\" name=\"contactAddress\" id=\"email\" />\r\n    </label>\r\n\r\n    <label>\r\n        Attach files\r\n        <input type=\"file\" name=\"files\" id=\"files\" multiple>\r\n    </label>\r\n\r\n    <button type=\"submit\">\r\n        Send feedback\r\n    </button>\r\n</form>\r\n\r\n\r\n<script>\r\n\r\n    function handleSubmit(event) {\r\n\r\n        console.log(event);\r\n\r\n        var form = document.getElementById('form');\r\n        var fd = new FormData(form);\r\n\r\n        var xhr = new XMLHttpRequest();\r\n\r\n        xhr.addEventListener('load', function(event) {\r\n           console.log('submitted without issues', event);\r\n        });\r\n\r\n        xhr.addEventListener('error', function(event) {\r\n            console.error('something went wrong when submitting feedback', event);\r\n        });\r\n\r\n        xhr.open('POST', form.action);\r\n\r\n        xhr.setRequestHeader('Accept', 'application/json');\r\n\r\n        xhr.send(fd);\r\n\r\n        return false;\r\n    }\r\n\r\n</script>\r\n\r\n</body>\r\n</html>",
+		Content:  "<!DOCTYPE html>\r\n<html lang=\"en\">\r\n<head>\r\n    <meta charset=\"UTF-8\">\r\n    <title>Feedback</title>\r\n</head>\r\n<body>\r\n\r\n<style>\r\n    .honeypot {\r\n        position: absolute;\r\n        left: -10000px;\r\n        top: auto;\r\n        width: 1px;\r\n        height: 1px;\r\n        overflow: hidden;\r\n    }\r\n</style>\r\n\r\n<form method=\"post\" action=\"/\" onsubmit=\"return handleSubmit(event)\" id=\"form\">\r\n    <label>\r\n        Message\r\n        <textarea id=\"message\" name=\"message\" required></textarea>\r\n    </label>\r\n\r\n    <label>\r\n        Contact address\r\n        <input type=\"email\" name=\"contactAddress\" id=\"email\" />\r\n    </label>\r\n\r\n    <label>\r\n        Attach files\r\n        <input type=\"file\" name=\"files\" id=\"files\" multiple>\r\n    </label>\r\n\r\n    <!-- Real users can't see this field, so anything filling it out is most likely a bot -->\r\n    <div class=\"honeypot\" aria-hidden=\"true\">\r\n        <label>\r\n            Website\r\n            <input type=\"text\" name=\"{{.HoneypotField}}\" tabindex=\"-1\" autocomplete=\"off\">\r\n        </label>\r\n    </div>\r\n\r\n    <input type=\"hidden\" name=\"formToken\" value=\"{{.Challenge.Token}}\">\r\n    <input type=\"hidden\" name=\"proofOfWork\" id=\"proofOfWork\">\r\n\r\n    <button type=\"submit\">\r\n        Send feedback\r\n    </button>\r\n</form>\r\n\r\n\r\n<script>\r\n\r\n    var difficulty = {{.Challenge.Difficulty}};\r\n    var token = {{.Challenge.Token}};\r\n    var proofOfWorkDone = false;\r\n    var submitWhenDone = false;\r\n\r\n    // A small sha256 implementation, as crypto.subtle is only available on https.\r\n    // Returns the hash as 8 32-bit words\r\n    var sha256 = (function () {\r\n        var k = [], h = [];\r\n        var primeCounter = 0, isComposite = {};\r\n        for (var candidate = 2; primeCounter < 64; candidate++) {\r\n            if (!isComposite[candidate]) {\r\n                for (var i = 0; i < 313; i += candidate) {\r\n                    isComposite[i] = candidate;\r\n                }\r\n                if (primeCounter < 8) {\r\n                    h[primeCounter] = (Math.pow(candidate, 1 / 2) * 4294967296) | 0;\r\n                }\r\n                k[primeCounter++] = (Math.pow(candidate, 1 / 3) * 4294967296) | 0;\r\n            }\r\n        }\r\n\r\n        function rightRotate(value, amount) {\r\n            return (value >>> amount) | (value << (32 - amount));\r\n        }\r\n\r\n        return function (ascii) {\r\n            var words = [], bitLength = ascii.length * 8, hash = h.slice(0), i, j;\r\n\r\n            ascii += '\\x80';\r\n            while (ascii.length % 64 - 56) {\r\n                ascii += '\\x00';\r\n            }\r\n            for (i = 0; i < ascii.length; i++) {\r\n                words[i >> 2] |= ascii.charCodeAt(i) << ((3 - i) % 4) * 8;\r\n            }\r\n            words[words.length] = (bitLength / 4294967296) | 0;\r\n            words[words.length] = bitLength;\r\n\r\n            for (j = 0; j < words.length;) {\r\n                var w = words.slice(j, j += 16), oldHash = hash;\r\n                hash = hash.slice(0, 8);\r\n\r\n                for (i = 0; i < 64; i++) {\r\n                    var w15 = w[i - 15], w2 = w[i - 2];\r\n                    var a = hash[0], e = hash[4];\r\n                    var temp1 = hash[7]\r\n                        + (rightRotate(e, 6) ^ rightRotate(e, 11) ^ rightRotate(e, 25))\r\n                        + ((e & hash[5]) ^ ((~e) & hash[6]))\r\n                        + k[i]\r\n                        + (w[i] = (i < 16) ? w[i] : (\r\n                            w[i - 16]\r\n                            + (rightRotate(w15, 7) ^ rightRotate(w15, 18) ^ (w15 >>> 3))\r\n                            + w[i - 7]\r\n                            + (rightRotate(w2, 17) ^ rightRotate(w2, 19) ^ (w2 >>> 10))\r\n                        ) | 0);\r\n                    var temp2 = (rightRotate(a, 2) ^ rightRotate(a, 13) ^ rightRotate(a, 22))\r\n                        + ((a & hash[1]) ^ (a & hash[2]) ^ (hash[1] & hash[2]));\r\n\r\n                    hash = [(temp1 + temp2) | 0].concat(hash);\r\n                    hash[4] = (hash[4] + temp1) | 0;\r\n                }\r\n\r\n                for (i = 0; i < 8; i++) {\r\n                    hash[i] = (hash[i] + oldHash[i]) | 0;\r\n                }\r\n            }\r\n\r\n            return hash.slice(0, 8);\r\n        };\r\n    })();\r\n\r\n    function hasLeadingZeroBits(hash, count) {\r\n        for (var i = 0; i < hash.length && count > 0; i++) {\r\n            var bits = Math.min(count, 32);\r\n            if ((hash[i] >>> (32 - bits)) !== 0) {\r\n                return false;\r\n            }\r\n            count -= bits;\r\n        }\r\n        return true;\r\n    }\r\n\r\n    // Finds a proof of work in small chunks, so the page doesn't freeze while working\r\n    function solveProofOfWork(start) {\r\n        for (var attempt = start; attempt < start + 2000; attempt++) {\r\n            if (hasLeadingZeroBits(sha256(token + ':' + attempt), difficulty)) {\r\n                document.getElementById('proofOfWork').value = attempt;\r\n                proofOfWorkDone = true;\r\n                if (submitWhenDone) {\r\n                    submit();\r\n                }\r\n                return;\r\n            }\r\n        }\r\n\r\n        setTimeout(function () {\r\n            solveProofOfWork(start + 2000);\r\n        }, 0);\r\n    }\r\n\r\n    function handleSubmit(event) {\r\n        event.preventDefault();\r\n\r\n        if (proofOfWorkDone) {\r\n            submit();\r\n        } else {\r\n            submitWhenDone = true;\r\n        }\r\n\r\n        return false;\r\n    }\r\n\r\n    function submit() {\r\n        var form = document.getElementById('form');\r\n        var fd = new FormData(form);\r\n\r\n        var xhr = new XMLHttpRequest();\r\n\r\n        xhr.addEventListener('load', function(event) {\r\n           console.log('submitted without issues', event);\r\n        });\r\n\r\n        xhr.addEventListener('error', function(event) {\r\n            console.error('something went wrong when submitting feedback', event);\r\n        });\r\n\r\n        xhr.open('POST', form.action);\r\n\r\n        xhr.setRequestHeader('Accept', 'application/json');\r\n\r\n        xhr.send(fd);\r\n    }\r\n\r\n    solveProofOfWork(0);\r\n\r\n</script>\r\n\r\n</body>\r\n</html>",
 	},
 
 	templateContent{
@@ -55,7 +60,7 @@ var contents = []templateContent{
 
 	templateContent{
 		Filename: "feedback-list",
-		Content:  "<!DOCTYPE html>\r\n<html lang=\"en\">\r\n<head>\r\n    <meta charset=\"UTF-8\">\r\n    <title>Feedback list</title>\r\n</head>\r\n<body>\r\n\r\n{{template \"header\" .AuthState}}\r\n\r\n<main>\r\n{{if .Feedback}}\r\n\r\n    <style type=\"text/css\">\r\n        .flex {\r\n            display: flex;\r\n        }\r\n\r\n        .flex.column {\r\n            flex-direction: column;\r\n        }\r\n\r\n        .flex.row {\r\n            flex-direction: row;\r\n        }\r\n\r\n        .flex.wrap {\r\n            flex-wrap: wrap;\r\n        }\r\n\r\n        .feedback-list {\r\n            margin: 0 1rem;\r\n        }\r\n\r\n        .feedback-item {\r\n            flex: 0 0 auto;\r\n            min-height: 0;\r\n            box-shadow: 0 0 15px rgba(0, 0, 0, .15);\r\n            margin-bottom: 1rem;\r\n            border-radius: 0.5rem;\r\n            padding: 1rem;\r\n            box-sizing: border-box;\r\n        }\r\n\r\n        .feedback-item-header {\r\n            flex: 0 0 3rem;\r\n            align-items: center;\r\n        }\r\n\r\n        .feedback-item-filler {\r\n            flex: 1;\r\n        }\r\n\r\n        .feedback-item-header-button {\r\n            text-decoration: none;\r\n            margin-left: 0.3rem;\r\n            height: 3rem;\r\n            color: white;\r\n            background-color: #B63332;\r\n            border: none;\r\n        }\r\n\r\n        .feedback-item-attachment {\r\n            background: no-repeat center;\r\n            background-size: contain;\r\n            position: relative;\r\n            height: 10rem;\r\n            width: 10rem;\r\n            margin: 1rem;\r\n        }\r\n\r\n        .feedback-item-attachment-button {\r\n            text-decoration: none;\r\n            border: none;\r\n            background-color: #B63332;\r\n            color: white;\r\n            padding: 0.5rem;\r\n            line-height: 1rem;\r\n            box-sizing: border-box;\r\n            font-size: 1rem;\r\n            display: inline-block;\r\n        }\r\n\r\n        .feedback-item-message {\r\n            width: 100%;\r\n            padding: 1rem;\r\n            margin-top: .5rem;\r\n            box-sizing: border-box;\r\n            color: black;\r\n        }\r\n\r\n        .feedback-item-attachments {\r\n            max-width: 100%;\r\n            padding: 1rem;\r\n        }\r\n\r\n        .feedback-item-body-item {\r\n            border: 1px solid #ebebeb;\r\n        }\r\n\r\n        .feedback-item-body-item:first-child {\r\n            border-top-left-radius: 3px;\r\n            border-top-right-radius: 3px;\r\n        }\r\n\r\n        .feedback-item-body-item:last-child {\r\n            border-bottom-left-radius: 3px;\r\n            border-bottom-right-radius: 3px;\r\n        }\r\n\r\n        .contact-address {\r\n            color: black;\r\n        }\r\n    </style>\r\n\r\n    <div class=\"feedback-list flex column\">\r\n    {{range .Feedback}}\r\n        <div class=\"feedback-item flex column\">\r\n            <div class=\"feedback-item-header flex row\">\r\n\r\n            {{if .ContactAddress}}\r\n                <span>From <a class=\"contact-address\" href=\"mailto:{{.ContactAddress}}\">{{.ContactAddress}}</a></span>\r\n            {{else}}\r\n                <span>No contact address provided</span>\r\n            {{end}}\r\n\r\n                <span class=\"feedback-item-filler\"></span>\r\n\r\n                <button class=\"feedback-item-header-button\">\r\n                    Mark as read\r\n                </button>\r\n\r\n                <button class=\"feedback-item-header-button\">\r\n                    Reply\r\n                </button>\r\n\r\n            </div>\r\n\r\n            <div class=\"feedback-item-body flex column\">\r\n                <div class=\"feedback-item-message feedback-item-body-item\">\r\n                {{.Message}}\r\n                </div>\r\n\r\n            {{if .Files}}\r\n                <div class=\"feedback-item-attachments feedback-item-body-item flex row wrap\">\r\n                {{range .Files}}\r\n                {{if .IsImage}}\r\n                    <div class=\"feedback-item-attachment\"\r\n                         style=\"background-image: url(/files/{{.Id}})\">\r\n                        <a href=\"/files/{{.Id}}\" download class=\"feedback-item-attachment-button\">\r\n                            Download\r\n                        </a>\r\n                        <button class=\"feedback-item-attachment-button\">\r\n                            Preview\r\n                        </button>\r\n                    </div>\r\n                {{else}}\r\n                    <div class=\"feedback-item-attachment\">\r\n                        <a href=\"/files/{{.Id}}\" download class=\"feedback-item-attachment-button\">\r\n                            Download\r\n                        </a>\r\n                    </div>\r\n                {{end}}\r\n                {{end}}\r\n                </div>\r\n            {{end}}\r\n            </div>\r\n        </div>\r\n    {{end}}\r\n    </div>\r\n{{else}}\r\n\r\n    <style>\r\n        .no-feedback {\r\n            margin: auto;\r\n        }\r\n    </style>\r\n\r\n    <div class=\"no-feedback\">\r\n        No feedback has been sent so far.\r\n    </div>\r\n\r\n{{end}}\r\n</main>\r\n\r\n</body>\r\n</html>",
+		Content:  "<!DOCTYPE html>\r\n<html lang=\"en\">\r\n<head>\r\n    <meta charset=\"UTF-8\">\r\n    <title>Feedback list</title>\r\n</head>\r\n<body>\r\n\r\n{{template \"header\" .AuthState}}\r\n\r\n<main>\r\n{{if .Feedback}}\r\n\r\n    <style type=\"text/css\">\r\n        .flex {\r\n            display: flex;\r\n        }\r\n\r\n        .flex.column {\r\n            flex-direction: column;\r\n        }\r\n\r\n        .flex.row {\r\n            flex-direction: row;\r\n        }\r\n\r\n        .flex.wrap {\r\n            flex-wrap: wrap;\r\n        }\r\n\r\n        .feedback-list {\r\n            margin: 0 1rem;\r\n        }\r\n\r\n        .feedback-item {\r\n            flex: 0 0 auto;\r\n            min-height: 0;\r\n            box-shadow: 0 0 15px rgba(0, 0, 0, .15);\r\n            margin-bottom: 1rem;\r\n            border-radius: 0.5rem;\r\n            padding: 1rem;\r\n            box-sizing: border-box;\r\n        }\r\n\r\n        .feedback-item-header {\r\n            flex: 0 0 3rem;\r\n            align-items: center;\r\n        }\r\n\r\n        .feedback-item-filler {\r\n            flex: 1;\r\n        }\r\n\r\n        .feedback-item-header-button {\r\n            text-decoration: none;\r\n            margin-left: 0.3rem;\r\n            height: 3rem;\r\n            color: white;\r\n            background-color: #B63332;\r\n            border: none;\r\n        }\r\n\r\n        .feedback-item-header-form {\r\n            display: flex;\r\n            margin: 0;\r\n        }\r\n\r\n        .feedback-item-quarantine-reason {\r\n            color: #B63332;\r\n            padding: 0.5rem 0;\r\n        }\r\n\r\n        .feedback-item-attachment {\r\n            background: no-repeat center;\r\n            background-size: contain;\r\n            position: relative;\r\n            height: 10rem;\r\n            width: 10rem;\r\n            margin: 1rem;\r\n        }\r\n\r\n        .feedback-item-attachment-button {\r\n            text-decoration: none;\r\n            border: none;\r\n            background-color: #B63332;\r\n            color: white;\r\n            padding: 0.5rem;\r\n            line-height: 1rem;\r\n            box-sizing: border-box;\r\n            font-size: 1rem;\r\n            display: inline-block;\r\n        }\r\n\r\n        .feedback-item-message {\r\n            width: 100%;\r\n            padding: 1rem;\r\n            margin-top: .5rem;\r\n            box-sizing: border-box;\r\n            color: black;\r\n        }\r\n\r\n        .feedback-item-attachments {\r\n            max-width: 100%;\r\n            padding: 1rem;\r\n        }\r\n\r\n        .feedback-item-body-item {\r\n            border: 1px solid #ebebeb;\r\n        }\r\n\r\n        .feedback-item-body-item:first-child {\r\n            border-top-left-radius: 3px;\r\n            border-top-right-radius: 3px;\r\n        }\r\n\r\n        .feedback-item-body-item:last-child {\r\n            border-bottom-left-radius: 3px;\r\n            border-bottom-right-radius: 3px;\r\n        }\r\n\r\n        .contact-address {\r\n            color: black;\r\n        }\r\n\r\n        .feedback-export {\r\n            margin: 0 1rem 1rem;\r\n            justify-content: flex-end;\r\n        }\r\n    </style>\r\n\r\n    <div class=\"feedback-export flex row\">\r\n        <a href=\"?format=csv\" class=\"feedback-item-attachment-button\">\r\n            Export as CSV\r\n        </a>\r\n        <a href=\"?format=markdown\" class=\"feedback-item-attachment-button\">\r\n            Export as Markdown\r\n        </a>\r\n    </div>\r\n\r\n    <div class=\"feedback-list flex column\">\r\n    {{range .Feedback}}\r\n        <div class=\"feedback-item flex column\">\r\n            <div class=\"feedback-item-header flex row\">\r\n\r\n            {{if .ContactAddress}}\r\n                <span>From <a class=\"contact-address\" href=\"mailto:{{.ContactAddress}}\">{{.ContactAddress}}</a></span>\r\n            {{else}}\r\n                <span>No contact address provided</span>\r\n            {{end}}\r\n\r\n                <span class=\"feedback-item-filler\"></span>\r\n\r\n                <button class=\"feedback-item-header-button\">\r\n                    Mark as read\r\n                </button>\r\n\r\n                <button class=\"feedback-item-header-button\">\r\n                    Reply\r\n                </button>\r\n\r\n            {{if not .Trashed}}\r\n            {{if .Archived}}\r\n                <form method=\"post\" action=\"/feedback/{{.Id}}/unarchive\" class=\"feedback-item-header-form\">\r\n                    <button type=\"submit\" class=\"feedback-item-header-button\">\r\n                        Unarchive\r\n                    </button>\r\n                </form>\r\n            {{else}}\r\n                <form method=\"post\" action=\"/feedback/{{.Id}}/archive\" class=\"feedback-item-header-form\">\r\n                    <button type=\"submit\" class=\"feedback-item-header-button\">\r\n                        Archive\r\n                    </button>\r\n                </form>\r\n            {{end}}\r\n            {{end}}\r\n\r\n            {{if $.AuthState.User.HasRole \"admin\"}}\r\n            {{if .Trashed}}\r\n                <form method=\"post\" action=\"/feedback/{{.Id}}/restore\" class=\"feedback-item-header-form\">\r\n                    <button type=\"submit\" class=\"feedback-item-header-button\">\r\n                        Restore\r\n                    </button>\r\n                </form>\r\n                <form method=\"post\" action=\"/feedback/{{.Id}}/delete\" class=\"feedback-item-header-form\"\r\n                      onsubmit=\"return confirm('The feedback and its attachments will be deleted permanently. Continue?')\">\r\n                    <button type=\"submit\" class=\"feedback-item-header-button\">\r\n                        Delete permanently\r\n                    </button>\r\n                </form>\r\n            {{else}}\r\n                <form method=\"post\" action=\"/feedback/{{.Id}}/trash\" class=\"feedback-item-header-form\">\r\n                    <button type=\"submit\" class=\"feedback-item-header-button\">\r\n                        Move to trash\r\n                    </button>\r\n                </form>\r\n            {{end}}\r\n            {{if $.Quarantine}}\r\n                <form method=\"post\" action=\"/feedback/{{.Id}}/not-spam\" class=\"feedback-item-header-form\">\r\n                    <button type=\"submit\" class=\"feedback-item-header-button\">\r\n                        Not spam\r\n                    </button>\r\n                </form>\r\n            {{end}}\r\n            {{if ne .Classification \"spam\"}}\r\n                <form method=\"post\" action=\"/feedback/{{.Id}}/spam\" class=\"feedback-item-header-form\">\r\n                    <button type=\"submit\" class=\"feedback-item-header-button\">\r\n                        Mark as spam\r\n                    </button>\r\n                </form>\r\n            {{end}}\r\n            {{end}}\r\n\r\n            </div>\r\n\r\n            {{if .Quarantined}}\r\n                <div class=\"feedback-item-quarantine-reason\">\r\n                    In quarantine: {{.QuarantineReason}}\r\n                </div>\r\n            {{end}}\r\n\r\n            <div class=\"feedback-item-body flex column\">\r\n                <div class=\"feedback-item-message feedback-item-body-item\">\r\n                {{.Message}}\r\n                </div>\r\n\r\n            {{if .Files}}\r\n                <div class=\"feedback-item-attachments feedback-item-body-item flex row wrap\">\r\n                {{range .Files}}\r\n                {{if .IsImage}}\r\n                    <div class=\"feedback-item-attachment\"\r\n                         style=\"background-image: url(/files/{{.Id}}/thumb?size=small)\">\r\n                        <a href=\"/files/{{.Id}}?download=true\" download=\"{{.GetName}}\" title=\"{{.GetName}}\" class=\"feedback-item-attachment-button\">\r\n                            Download\r\n                        </a>\r\n                        <a href=\"/files/{{.Id}}/thumb?size=large\" target=\"_blank\" class=\"feedback-item-attachment-button\">\r\n                            Preview\r\n                        </a>\r\n                    </div>\r\n                {{else}}\r\n                    <div class=\"feedback-item-attachment\">\r\n                        <a href=\"/files/{{.Id}}?download=true\" download=\"{{.GetName}}\" title=\"{{.GetName}}\" class=\"feedback-item-attachment-button\">\r\n                            {{.GetName}}\r\n                        </a>\r\n                    </div>\r\n                {{end}}\r\n                {{end}}\r\n                </div>\r\n            {{end}}\r\n            </div>\r\n        </div>\r\n    {{end}}\r\n    </div>\r\n{{else}}\r\n\r\n    <style>\r\n        .no-feedback {\r\n            margin: auto;\r\n        }\r\n    </style>\r\n\r\n    <div class=\"no-feedback\">\r\n    {{if .Quarantine}}\r\n        There is no feedback in quarantine.\r\n    {{else if .Archive}}\r\n        No feedback has been archived.\r\n    {{else if .Trash}}\r\n        The trash is empty.\r\n    {{else}}\r\n        No feedback has been sent so far.\r\n    {{end}}\r\n    </div>\r\n\r\n{{end}}\r\n</main>\r\n\r\n</body>\r\n</html>",
 	},
 
 	templateContent{
@@ -65,7 +70,7 @@ var contents = []templateContent{
 
 	templateContent{
 		Filename: "header",
-		Content:  "<div class=\"header\">\r\n\r\n{{if .Authenticated}}\r\n    <a href=\"/\" class=\"header-button\">\r\n        Feedback list\r\n    </a>\r\n\r\n{{if .User.HasRole \"admin\"}}\r\n    <a href=\"/users\" class=\"header-button\">\r\n        Users\r\n    </a>\r\n{{end}}\r\n{{end}}\r\n\r\n    <span class=\"filler\"></span>\r\n\r\n{{if .Authenticated}}\r\n    <a href=\"/logout\" class=\"header-button\" onclick=\"return logout()\">\r\n        Logout\r\n    </a>\r\n{{else}}\r\n    <a href=\"/login\" class=\"header-button\">\r\n        Login\r\n    </a>\r\n{{end}}\r\n</div>\r\n<style>\r\n    body {\r\n        margin: 0;\r\n    }\r\n\r\n    .header {\r\n        display: flex;\r\n        flex-direction: row;\r\n        align-items: center;\r\n        height: 3rem;\r\n        box-sizing: border-box;\r\n    }\r\n\r\n    .filler {\r\n        flex: 1;\r\n    }\r\n\r\n    .header-button {\r\n        text-decoration: none;\r\n        border: none;\r\n        background-color: #B63332;\r\n        color: white;\r\n        padding: 0.5rem;\r\n        line-height: 1rem;\r\n        box-sizing: border-box;\r\n        font-size: 1rem;\r\n        display: flex;\r\n        align-items: center;\r\n        justify-content: center;\r\n        margin-right: 1rem;\r\n    }\r\n\r\n</style>\r\n\r\n<script>\r\n    function logout() {\r\n        localStorage.removeItem('token');\r\n        return true;\r\n    }\r\n</script>",
+		Content:  "<div class=\"header\">\r\n\r\n{{if .Authenticated}}\r\n    <a href=\"/\" class=\"header-button\">\r\n        Feedback list\r\n    </a>\r\n\r\n    <a href=\"/archive\" class=\"header-button\">\r\n        Archive\r\n    </a>\r\n\r\n{{if .User.HasRole \"admin\"}}\r\n    <a href=\"/users\" class=\"header-button\">\r\n        Users\r\n    </a>\r\n\r\n    <a href=\"/quarantine\" class=\"header-button\">\r\n        Quarantine\r\n    </a>\r\n\r\n    <a href=\"/trash\" class=\"header-button\">\r\n        Trash\r\n    </a>\r\n{{end}}\r\n{{end}}\r\n\r\n    <span class=\"filler\"></span>\r\n\r\n{{if .Authenticated}}\r\n    <a href=\"/logout\" class=\"header-button\" onclick=\"return logout()\">\r\n        Logout\r\n    </a>\r\n{{else}}\r\n    <a href=\"/login\" class=\"header-button\">\r\n        Login\r\n    </a>\r\n{{end}}\r\n</div>\r\n<style>\r\n    body {\r\n        margin: 0;\r\n    }\r\n\r\n    .header {\r\n        display: flex;\r\n        flex-direction: row;\r\n        align-items: center;\r\n        height: 3rem;\r\n        box-sizing: border-box;\r\n    }\r\n\r\n    .filler {\r\n        flex: 1;\r\n    }\r\n\r\n    .header-button {\r\n        text-decoration: none;\r\n        border: none;\r\n        background-color: #B63332;\r\n        color: white;\r\n        padding: 0.5rem;\r\n        line-height: 1rem;\r\n        box-sizing: border-box;\r\n        font-size: 1rem;\r\n        display: flex;\r\n        align-items: center;\r\n        justify-content: center;\r\n        margin-right: 1rem;\r\n    }\r\n\r\n</style>\r\n\r\n<script>\r\n    function logout() {\r\n        localStorage.removeItem('token');\r\n        return true;\r\n    }\r\n</script>",
 	},
 
 	templateContent{
@@ -73,6 +78,16 @@ var contents = []templateContent{
 		Content:  "<!DOCTYPE html>\r\n<html lang=\"en\">\r\n<head>\r\n    <meta charset=\"UTF-8\">\r\n    <title>Title</title>\r\n</head>\r\n<body>\r\n\r\n{{template \"header\" .AuthState}}\r\n\r\n<div>\r\n    <form method=\"post\" action=\"/login\" onsubmit=\"return login(event)\" id=\"login-form\">\r\n\r\n        <label>\r\n            Email\r\n            <input type=\"email\" name=\"email\" id=\"email\">\r\n        </label>\r\n\r\n        <label>\r\n            Password\r\n            <input type=\"password\" name=\"password\" id=\"password\">\r\n        </label>\r\n\r\n        <button type=\"submit\">\r\n            Login\r\n        </button>\r\n\r\n    </form>\r\n</div>\r\n\r\n<script>\r\n    var urlParams = {};\r\n    (function init() {\r\n        var match,\r\n                pl = /\\+/g,  // Regex for replacing addition symbol with a space\r\n                search = /([^&=]+)=?([^&]*)/g,\r\n                decode = function (s) {\r\n                    return decodeURIComponent(s.replace(pl, \" \"));\r\n                },\r\n                query = window.location.search.substring(1);\r\n\r\n        while (match = search.exec(query))\r\n            urlParams[decode(match[1])] = decode(match[2]);\r\n    })();\r\n\r\n    function login(event) {\r\n\r\n        console.log(event);\r\n\r\n        var form = document.getElementById('login-form');\r\n        var fd = new FormData(form);\r\n\r\n        var xhr = new XMLHttpRequest();\r\n\r\n        xhr.addEventListener('load', function (event) {\r\n            var response = JSON.parse(xhr.responseText);\r\n            if (response.token) {\r\n                console.log('login was successful');\r\n                localStorage.setItem('token', response.token);\r\n\r\n                if (urlParams.returnUrl) {\r\n                    window.location.replace(urlParams.returnUrl);\r\n                } else {\r\n                    window.location.replace('/');\r\n                }\r\n\r\n            } else {\r\n                console.log('Got response', event);\r\n            }\r\n        });\r\n\r\n        xhr.addEventListener('error', function (event) {\r\n            console.error('Request failed', event);\r\n        });\r\n\r\n        xhr.open('POST', '/login');\r\n\r\n        xhr.setRequestHeader('Accept', 'application/json');\r\n\r\n        xhr.send(fd);\r\n\r\n        return false;\r\n    }\r\n</script>\r\n\r\n</body>\r\n</html>",
 	},
 
+	templateContent{
+		Filename: "rate-limited",
+		Content:  "<!DOCTYPE html>\r\n<html lang=\"en\">\r\n<head>\r\n    <meta charset=\"UTF-8\">\r\n    <title>Slow down</title>\r\n</head>\r\n<body>\r\n\r\n<style>\r\n    .rate-limited {\r\n        margin: 2rem auto;\r\n        max-width: 30rem;\r\n        text-align: center;\r\n    }\r\n</style>\r\n\r\n<div class=\"rate-limited\">\r\n    <h1>Slow down a bit</h1>\r\n    <p>\r\n        We have received a lot of requests from you in a short time.\r\n        Please wait {{.RetryAfter}} and try again.\r\n    </p>\r\n</div>\r\n\r\n</body>\r\n</html>",
+	},
+
+	templateContent{
+		Filename: "upload-rejected",
+		Content:  "<!DOCTYPE html>\r\n<html lang=\"en\">\r\n<head>\r\n    <meta charset=\"UTF-8\">\r\n    <title>Feedback not sent</title>\r\n</head>\r\n<body>\r\n\r\n<div class=\"upload-rejected\">\r\n    <h1>Your feedback could not be sent</h1>\r\n    <p>{{.Message}}</p>\r\n    <ul>\r\n    {{range .Errors}}\r\n        <li>{{if .File}}{{.File}}: {{end}}{{.Error}}</li>\r\n    {{end}}\r\n    </ul>\r\n</div>\r\n\r\n</body>\r\n</html>",
+	},
+
 	templateContent{
 		Filename: "user-list",
 		Content:  "<!DOCTYPE html>\r\n<html lang=\"en\">\r\n<head>\r\n    <meta charset=\"UTF-8\">\r\n    <title>User list</title>\r\n</head>\r\n<body>\r\n\r\n{{template \"header\" .AuthState}}\r\n\r\n<style>\r\n    .user-table {\r\n        width: 100%;\r\n    }\r\n\r\n    .user-table td {\r\n        text-align: center;\r\n    }\r\n\r\n    .user-table .options {\r\n        display: flex;\r\n        flex-direction: row;\r\n    }\r\n\r\n    .option-button {\r\n        text-decoration: none;\r\n        border: none;\r\n        background-color: #B63332;\r\n        color: white;\r\n        padding: 0.5rem;\r\n        line-height: 1rem;\r\n        box-sizing: border-box;\r\n        font-size: 1rem;\r\n        align-items: center;\r\n        justify-content: center;\r\n        margin-right: 0.5rem;\r\n    }\r\n</style>\r\n\r\n<table class=\"user-table\">\r\n    <tr>\r\n        <th>Name</th>\r\n        <th>Email</th>\r\n        <th>Roles</th>\r\n        <th>Options</th>\r\n    </tr>\r\n{{range .Users}}\r\n    <tr>\r\n        <td>{{.Name}}</td>\r\n        <td>{{.Email}}</td>\r\n        <td class=\"role-list\">\r\n        {{range .Roles}}\r\n        {{with $.GetRole .}}\r\n            <span data-key=\"{{.Key}}\">{{.Name}}</span>\r\n        {{end}}\r\n        {{end}}\r\n        </td>\r\n        <td class=\"options\">\r\n        {{if $.IsLastAdmin . | not}}\r\n            <form class=\"admin-danger\" action=\"/users/deleteUser/{{.Email}}\" method=\"post\"\r\n                  onsubmit=\"return deleteUser(event, {{.Email}})\">\r\n                <button type=\"submit\" class=\"option-button\">\r\n                    Delete\r\n                </button>\r\n            </form>\r\n        {{end}}\r\n\r\n            <a href=\"/users/{{.Email}}\" class=\"option-button\">\r\n                Edit\r\n            </a>\r\n        </td>\r\n    </tr>\r\n{{end}}\r\n</table>\r\n<a href=\"/users/new\" class=\"option-button\">\r\n    Create new user\r\n</a>\r\n\r\n<script>\r\n    function deleteUser(event, email) {\r\n        var xhr = new XMLHttpRequest();\r\n\r\n        xhr.addEventListener('load', function (event) {\r\n            console.log('response', event);\r\n\r\n            deleteUserRow(event);\r\n        });\r\n\r\n        xhr.addEventListener('error', function (event) {\r\n            console.error('Request failed', event);\r\n        });\r\n\r\n        xhr.open('POST', '/login');\r\n\r\n        xhr.setRequestHeader('Accept', 'application/json');\r\n        xhr.setRequestHeader(\"Content-Type\", \"application/json;charset=UTF-8\");\r\n\r\n        xhr.send(JSON.stringify({email: email}));\r\n\r\n        return false;\r\n    }\r\n\r\n    function deleteUserRow(event) {\r\n        var target = event.currentTarget;\r\n\r\n        while (target && target.tagName !== 'TR') {\r\n            target = target.parentElement;\r\n        }\r\n\r\n        if (target) {\r\n            target.parentNode.removeChild(target);\r\n        }\r\n        console.log('removed user row');\r\n\r\n        var adminRows = [];\r\n        var roleListElements = document.querySelectorAll('.role-list');\r\n        for (var i = 0; i < roleListElements.length; i++) {\r\n            var rle = roleListElements[i];\r\n            var roleElements = rle.querySelectorAll('span');\r\n\r\n            for (var j = 0; j < roleElements.length; j++) {\r\n                var el = roleElements[i];\r\n                var key = el.dataset.key;\r\n                if (key === 'admin') {\r\n                    adminRows.push(rle.parentNode);\r\n                    break;\r\n                }\r\n            }\r\n        }\r\n\r\n        console.log('admin rows', adminRows);\r\n\r\n        // Remove the last delete forms\r\n        if (adminRows.length === 1) {\r\n            var forms = adminRows[0].querySelectorAll('admin-danger');\r\n            for (i = 0; i < forms.length; i++) {\r\n                var form = forms[i];\r\n                form.parentNode.removeChild(form);\r\n            }\r\n        }\r\n    }\r\n</script>\r\n\r\n</body>\r\n</html>",
@@ -0,0 +1,317 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"github.com/zlepper/welp/internal/pkg/tracing"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Images with more pixels than this are not resized, as decoding
+// them would take up too much memory
+const maxPixels = 50 * 1000 * 1000
+
+const jpegQuality = 85
+
+// The content types that can be decoded
+var supportedContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+type ThumbnailServiceArgs struct {
+	// Where the original files are loaded from, and the thumbnails are saved to
+	FileStorage models.FileStorage
+
+	Logger models.Logger
+}
+
+// Creates a thumbnail service that saves the thumbnails next to the original files
+func NewThumbnailService(args ThumbnailServiceArgs) models.ThumbnailService {
+	return &thumbnailService{
+		fileStorage: args.FileStorage,
+		logger:      args.Logger,
+		locks:       map[string]*fileLock{},
+	}
+}
+
+type thumbnailService struct {
+	fileStorage models.FileStorage
+	logger      models.Logger
+
+	// Makes sure the same thumbnails aren't generated multiple times at once,
+	// while thumbnails of different files can be generated at the same time
+	lock  sync.Mutex
+	locks map[string]*fileLock
+}
+
+type fileLock struct {
+	sync.Mutex
+	users int
+}
+
+// Locks the thumbnails of the file with the given id, and returns a function to unlock them again.
+// Every size is generated from the same decoded image, so they share the lock
+func (s *thumbnailService) lockFile(id string) func() {
+	s.lock.Lock()
+	l, exists := s.locks[id]
+	if !exists {
+		l = &fileLock{}
+		s.locks[id] = l
+	}
+	l.users++
+	s.lock.Unlock()
+
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+
+		s.lock.Lock()
+		l.users--
+		if l.users == 0 {
+			delete(s.locks, id)
+		}
+		s.lock.Unlock()
+	}
+}
+
+// Gets the ids of all the thumbnails that can be made of the file, whether they have been made or not
+func GetThumbnailIds(file models.File) []string {
+	if !supportedContentTypes[file.ContentType] {
+		return nil
+	}
+
+	ids := make([]string, 0, len(models.ThumbnailSizes))
+	for size := range models.ThumbnailSizes {
+		ids = append(ids, getThumbnail(file, size).Id)
+	}
+	return ids
+}
+
+// Gets the id and content type of the thumbnail of the given size
+// Formats that can be transparent are kept as png, everything else becomes jpeg
+func getThumbnail(file models.File, size models.ThumbnailSize) models.Thumbnail {
+	switch file.ContentType {
+	case "image/png", "image/gif":
+		return models.Thumbnail{
+			Id:          file.Id + ".thumb-" + string(size) + ".png",
+			ContentType: "image/png",
+		}
+	default:
+		return models.Thumbnail{
+			Id:          file.Id + ".thumb-" + string(size) + ".jpg",
+			ContentType: "image/jpeg",
+		}
+	}
+}
+
+// Gets the id of the file the thumbnail was made from. Returns false if the id isn't the id of a thumbnail
+func GetOriginalFileId(thumbnailId string) (string, bool) {
+	for size := range models.ThumbnailSizes {
+		for _, ext := range []string{".png", ".jpg"} {
+			suffix := ".thumb-" + string(size) + ext
+			if strings.HasSuffix(thumbnailId, suffix) {
+				return strings.TrimSuffix(thumbnailId, suffix), true
+			}
+		}
+	}
+
+	return "", false
+}
+
+func (s *thumbnailService) GenerateThumbnails(ctx context.Context, file models.File) error {
+	if !supportedContentTypes[file.ContentType] {
+		return models.ErrThumbnailNotSupported
+	}
+
+	unlock := s.lockFile(file.Id)
+	defer unlock()
+
+	return s.generateThumbnails(ctx, file)
+}
+
+// Generates the thumbnails of every size. The file should be locked by the caller
+func (s *thumbnailService) generateThumbnails(ctx context.Context, file models.File) (err error) {
+	ctx, span := tracing.StartSpan(ctx, "thumbnail.GenerateThumbnails")
+	defer func() {
+		span.EndWithError(err)
+	}()
+	span.SetAttribute("file.id", file.Id)
+
+	img, err := s.loadImage(ctx, file)
+	if err != nil {
+		return err
+	}
+
+	for size, maxDimension := range models.ThumbnailSizes {
+		thumbnail := getThumbnail(file, size)
+
+		var buffer bytes.Buffer
+		err = encode(&buffer, resize(img, maxDimension, thumbnail.ContentType), thumbnail.ContentType)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.fileStorage.SaveFile(ctx, thumbnail.Id, &buffer)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.logger.WithContext(ctx).Debugf("Generated thumbnails for '%s'", file.Id)
+
+	return nil
+}
+
+func (s *thumbnailService) LoadThumbnail(ctx context.Context, file models.File, size models.ThumbnailSize) (models.Thumbnail, io.ReadCloser, error) {
+	if !supportedContentTypes[file.ContentType] {
+		return models.Thumbnail{}, nil, models.ErrThumbnailNotSupported
+	}
+
+	thumbnail := getThumbnail(file, size)
+
+	reader, err := s.fileStorage.LoadFile(ctx, thumbnail.Id)
+	if err != models.ErrFileNotFound {
+		return thumbnail, reader, err
+	}
+
+	// Files uploaded before thumbnails existed are handled on first request
+	unlock := s.lockFile(file.Id)
+	defer unlock()
+
+	// Another request might have generated the thumbnails while this one waited for the lock
+	reader, err = s.fileStorage.LoadFile(ctx, thumbnail.Id)
+	if err != models.ErrFileNotFound {
+		return thumbnail, reader, err
+	}
+
+	err = s.generateThumbnails(ctx, file)
+	if err != nil {
+		return thumbnail, nil, err
+	}
+
+	reader, err = s.fileStorage.LoadFile(ctx, thumbnail.Id)
+	return thumbnail, reader, err
+}
+
+func (s *thumbnailService) DeleteThumbnails(ctx context.Context, file models.File) error {
+	if !supportedContentTypes[file.ContentType] {
+		return nil
+	}
+
+	for size := range models.ThumbnailSizes {
+		err := s.fileStorage.DeleteFile(ctx, getThumbnail(file, size).Id)
+		if err != nil && err != models.ErrFileNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *thumbnailService) loadImage(ctx context.Context, file models.File) (image.Image, error) {
+	reader, err := s.fileStorage.LoadFile(ctx, file.Id)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	// Check the dimensions before decoding the entire image
+	var header bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(reader, &header))
+	if err != nil {
+		s.logger.WithContext(ctx).Warnf("Failed to read image '%s': %v", file.Id, err)
+		return nil, models.ErrThumbnailNotSupported
+	}
+
+	if config.Width*config.Height > maxPixels {
+		s.logger.WithContext(ctx).Warnf("Image '%s' is too large to make thumbnails for: %dx%d", file.Id, config.Width, config.Height)
+		return nil, models.ErrThumbnailNotSupported
+	}
+
+	img, _, err := image.Decode(io.MultiReader(&header, reader))
+	if err != nil {
+		s.logger.WithContext(ctx).Warnf("Failed to decode image '%s': %v", file.Id, err)
+		return nil, models.ErrThumbnailNotSupported
+	}
+
+	return img, nil
+}
+
+// Scales the image down, so it fits within maxDimension x maxDimension
+// Images that are already small enough keeps their size
+func resize(img image.Image, maxDimension int, contentType string) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if width > maxDimension || height > maxDimension {
+		if width > height {
+			height = max(1, height*maxDimension/width)
+			width = maxDimension
+		} else {
+			width = max(1, width*maxDimension/height)
+			height = maxDimension
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	op := draw.Src
+	if contentType == "image/jpeg" {
+		// Jpeg can't be transparent, so put transparent images on a white background
+		draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+		op = draw.Over
+	}
+
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, op, nil)
+
+	return dst
+}
+
+func encode(w io.Writer, img image.Image, contentType string) error {
+	if contentType == "image/png" {
+		return png.Encode(w, img)
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
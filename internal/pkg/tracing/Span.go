@@ -0,0 +1,223 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// What a span represents
+type SpanKind string
+
+const (
+	// Work inside welp
+	KindInternal SpanKind = "internal"
+	// A request welp handles
+	KindServer SpanKind = "server"
+	// A call welp makes to another service, like S3 or SendGrid
+	KindClient SpanKind = "client"
+)
+
+// A finished span, as it is exported
+type SpanData struct {
+	Name         string                 `json:"name"`
+	Kind         SpanKind               `json:"kind"`
+	TraceId      string                 `json:"traceId"`
+	SpanId       string                 `json:"spanId"`
+	ParentSpanId string                 `json:"parentSpanId,omitempty"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	// Why the work failed, if it did
+	Error string `json:"error,omitempty"`
+}
+
+// Times a piece of work. All the methods can be called on a nil span, which does nothing,
+// so code doesn't have to check if tracing is enabled
+type Span struct {
+	tracer  *Tracer
+	context SpanContext
+	parent  SpanContext
+
+	lock       sync.Mutex
+	name       string
+	kind       SpanKind
+	start      time.Time
+	attributes map[string]interface{}
+	err        string
+	ended      bool
+}
+
+type spanKey struct{}
+type remoteParentKey struct{}
+
+// Starts a span for work inside welp. The span is a child of the span in the context, if there is one.
+// The returned context has the new span, so the spans started from it become its children
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	return startSpan(ctx, name, KindInternal)
+}
+
+// Starts a span for a request welp handles. Use WithRemoteParent to continue the trace of the caller
+func StartServerSpan(ctx context.Context, name string) (context.Context, *Span) {
+	return startSpan(ctx, name, KindServer)
+}
+
+// Starts a span for a call to another service
+func StartClientSpan(ctx context.Context, name string) (context.Context, *Span) {
+	return startSpan(ctx, name, KindClient)
+}
+
+func startSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	tracer := getTracer()
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	parent, hasParent := GetSpanContext(ctx)
+
+	span := &Span{
+		tracer: tracer,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+	}
+
+	if hasParent {
+		span.parent = parent
+		span.context = SpanContext{
+			TraceId: parent.TraceId,
+			SpanId:  newSpanId(),
+			Sampled: parent.Sampled,
+		}
+	} else {
+		span.context = SpanContext{
+			TraceId: newTraceId(),
+			SpanId:  newSpanId(),
+			Sampled: tracer.shouldSample(),
+		}
+	}
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Puts the span context of the caller in the context, so the next span started continues its trace
+func WithRemoteParent(ctx context.Context, parent SpanContext) context.Context {
+	return context.WithValue(ctx, remoteParentKey{}, parent)
+}
+
+// Gets the context of the current span, or of the caller if no span has been started yet
+func GetSpanContext(ctx context.Context) (SpanContext, bool) {
+	if ctx == nil {
+		return SpanContext{}, false
+	}
+
+	if span, ok := ctx.Value(spanKey{}).(*Span); ok {
+		return span.context, true
+	}
+
+	if parent, ok := ctx.Value(remoteParentKey{}).(SpanContext); ok && parent.IsValid() {
+		return parent, true
+	}
+
+	return SpanContext{}, false
+}
+
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// Describes the work, e.g. with the id of the file that is saved
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.attributes == nil {
+		s.attributes = map[string]interface{}{}
+	}
+	s.attributes[key] = value
+}
+
+// Marks the work as failed. Nil errors are ignored, so the error of the work can always be passed
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.err = err.Error()
+}
+
+// Ends the span, which is then exported if the trace is sampled. Only the first call does anything
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	data := s.getData(time.Now())
+	s.lock.Unlock()
+
+	if s.context.Sampled {
+		s.tracer.export(data)
+	}
+}
+
+// Ends the span with the error, which makes it easy to end a span with the named error result of a function
+func (s *Span) EndWithError(err error) {
+	s.SetError(err)
+	s.End()
+}
+
+func (s *Span) getData(end time.Time) SpanData {
+	data := SpanData{
+		Name:       s.name,
+		Kind:       s.kind,
+		TraceId:    s.context.TraceIdString(),
+		SpanId:     s.context.SpanIdString(),
+		Start:      s.start,
+		End:        end,
+		Attributes: s.attributes,
+		Error:      s.err,
+	}
+
+	if s.parent.IsValid() {
+		data.ParentSpanId = s.parent.SpanIdString()
+	}
+
+	return data
+}
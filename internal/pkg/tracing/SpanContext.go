@@ -0,0 +1,121 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// The header W3C trace context is propagated in
+const TraceparentHeader = "traceparent"
+
+// Identifies a span, and the trace it is part of
+type SpanContext struct {
+	TraceId [16]byte
+	SpanId  [8]byte
+	// If the trace is recorded
+	Sampled bool
+}
+
+// True if the ids are set. All zero ids are not allowed by the W3C trace context
+func (c SpanContext) IsValid() bool {
+	return c.TraceId != [16]byte{} && c.SpanId != [8]byte{}
+}
+
+func (c SpanContext) TraceIdString() string {
+	return hex.EncodeToString(c.TraceId[:])
+}
+
+func (c SpanContext) SpanIdString() string {
+	return hex.EncodeToString(c.SpanId[:])
+}
+
+// Formats the span context as a traceparent header, e.g. 00-<trace id>-<span id>-01
+func (c SpanContext) Traceparent() string {
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", c.TraceIdString(), c.SpanIdString(), flags)
+}
+
+// Parses a traceparent header. Returns false if the header isn't a valid traceparent
+func ParseTraceparent(header string) (SpanContext, bool) {
+	var c SpanContext
+
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return c, false
+	}
+
+	version, err := hex.DecodeString(parts[0])
+	// Version ff is forbidden, and version 00 has exactly 4 parts. Later versions may add more
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(parts) != 4) {
+		return c, false
+	}
+
+	traceId, err := hex.DecodeString(parts[1])
+	if err != nil || strings.ToLower(parts[1]) != parts[1] {
+		return c, false
+	}
+	spanId, err := hex.DecodeString(parts[2])
+	if err != nil || strings.ToLower(parts[2]) != parts[2] {
+		return c, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return c, false
+	}
+
+	copy(c.TraceId[:], traceId)
+	copy(c.SpanId[:], spanId)
+	c.Sampled = flags[0]&1 == 1
+
+	return c, c.IsValid()
+}
+
+// Gets the span context the caller sent in the traceparent header, if there is a valid one
+func Extract(header http.Header) (SpanContext, bool) {
+	return ParseTraceparent(header.Get(TraceparentHeader))
+}
+
+// Sets the traceparent header to the span context, so the receiver can continue the trace
+func Inject(c SpanContext, header http.Header) {
+	if c.IsValid() {
+		header.Set(TraceparentHeader, c.Traceparent())
+	}
+}
+
+func newTraceId() (id [16]byte) {
+	rand.Read(id[:])
+	return id
+}
+
+func newSpanId() (id [8]byte) {
+	rand.Read(id[:])
+	return id
+}
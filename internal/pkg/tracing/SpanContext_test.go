@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	header := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	c, ok := ParseTraceparent(header)
+	if !ok {
+		t.Fatalf("Expected '%s' to be valid", header)
+	}
+	if !c.Sampled {
+		t.Errorf("Expected the trace to be sampled")
+	}
+	if c.Traceparent() != header {
+		t.Errorf("Expected '%s', got '%s'", header, c.Traceparent())
+	}
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	}
+	for _, header := range invalid {
+		if _, ok := ParseTraceparent(header); ok {
+			t.Errorf("Expected '%s' to be invalid", header)
+		}
+	}
+}
+
+type recordingExporter struct {
+	spans []SpanData
+}
+
+func (e *recordingExporter) Export(ctx context.Context, spans []SpanData) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *recordingExporter) Close() error {
+	return nil
+}
+
+func TestSpansContinueRemoteTrace(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := Start(TracerArgs{Exporter: exporter, SampleRatio: 0})
+
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, server := StartServerSpan(WithRemoteParent(context.Background(), parent), "server")
+	_, child := StartSpan(ctx, "child")
+	child.End()
+	server.End()
+
+	err := tracer.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(exporter.spans) != 2 {
+		t.Fatalf("Expected 2 spans to be exported, as the caller sampled the trace, got %d", len(exporter.spans))
+	}
+	for _, span := range exporter.spans {
+		if span.TraceId != parent.TraceIdString() {
+			t.Errorf("Expected span '%s' to be in trace '%s', got '%s'", span.Name, parent.TraceIdString(), span.TraceId)
+		}
+	}
+	if exporter.spans[0].ParentSpanId != exporter.spans[1].SpanId {
+		t.Errorf("Expected the child span to have the server span as parent")
+	}
+	if exporter.spans[1].ParentSpanId != parent.SpanIdString() {
+		t.Errorf("Expected the server span to have the caller as parent")
+	}
+}
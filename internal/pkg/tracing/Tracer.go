@@ -0,0 +1,191 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package tracing
+
+import (
+	"context"
+	"github.com/zlepper/welp/internal/pkg/models"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// How many spans can wait to be exported. Spans are dropped when it is full,
+	// so a slow collector can't make welp run out of memory
+	queueSize = 2048
+	// How many spans are exported at once
+	batchSize = 512
+	// How long spans wait at most before they are exported
+	exportInterval = 5 * time.Second
+)
+
+// Sends finished spans somewhere
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+	// Called when tracing stops, after the last spans have been exported
+	Close() error
+}
+
+var (
+	// The tracer the spans are recorded by. Nil when tracing is disabled
+	activeTracer     *Tracer
+	activeTracerLock sync.RWMutex
+)
+
+func getTracer() *Tracer {
+	activeTracerLock.RLock()
+	defer activeTracerLock.RUnlock()
+	return activeTracer
+}
+
+type TracerArgs struct {
+	Exporter Exporter
+	// The fraction of new traces that are recorded, between 0 and 1.
+	// Traces continued from a caller are recorded if the caller recorded them
+	SampleRatio float64
+	Logger      models.Logger
+}
+
+// Starts recording spans, which are exported in batches in the background until Shutdown is called
+func Start(args TracerArgs) *Tracer {
+	tracer := &Tracer{
+		TracerArgs: args,
+		queue:      make(chan SpanData, queueSize),
+		flush:      make(chan chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	go tracer.run()
+
+	activeTracerLock.Lock()
+	activeTracer = tracer
+	activeTracerLock.Unlock()
+
+	return tracer
+}
+
+type Tracer struct {
+	TracerArgs
+
+	queue chan SpanData
+	// Asks the exporting to export what has been queued, and stop
+	flush chan chan struct{}
+	done  chan struct{}
+
+	dropped     int
+	droppedLock sync.Mutex
+}
+
+func (t *Tracer) shouldSample() bool {
+	return t.SampleRatio >= 1 || rand.Float64() < t.SampleRatio
+}
+
+func (t *Tracer) export(span SpanData) {
+	select {
+	case t.queue <- span:
+	default:
+		t.droppedLock.Lock()
+		t.dropped++
+		t.droppedLock.Unlock()
+	}
+}
+
+// Stops recording spans, and exports the spans that are still waiting.
+// Gives up on exporting them when the context is cancelled
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	activeTracerLock.Lock()
+	if activeTracer == t {
+		activeTracer = nil
+	}
+	activeTracerLock.Unlock()
+
+	flushed := make(chan struct{})
+	select {
+	case t.flush <- flushed:
+	case <-t.done:
+		return nil
+	}
+
+	select {
+	case <-flushed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return t.Exporter.Close()
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, batchSize)
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				batch = t.exportBatch(batch)
+			}
+		case <-ticker.C:
+			batch = t.exportBatch(batch)
+		case flushed := <-t.flush:
+			// Spans that ended before shutting down are still in the queue
+			for len(t.queue) > 0 {
+				batch = append(batch, <-t.queue)
+			}
+			t.exportBatch(batch)
+			close(flushed)
+			return
+		}
+	}
+}
+
+// Exports the batch, and returns it emptied so it can be filled again
+func (t *Tracer) exportBatch(batch []SpanData) []SpanData {
+	t.droppedLock.Lock()
+	dropped := t.dropped
+	t.dropped = 0
+	t.droppedLock.Unlock()
+
+	if dropped > 0 {
+		t.Logger.Warnf("Dropped %d spans, as they were made faster than they could be exported", dropped)
+	}
+
+	if len(batch) == 0 {
+		return batch
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportInterval*2)
+	defer cancel()
+
+	err := t.Exporter.Export(ctx, batch)
+	if err != nil {
+		t.Logger.Warnf("Failed to export %d spans: %v", len(batch), err)
+	}
+
+	return batch[:0]
+}
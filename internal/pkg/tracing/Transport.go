@@ -0,0 +1,77 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package tracing
+
+import (
+	"net/http"
+)
+
+// Wraps the transport, so every outgoing request gets a span, and
+// the server it's sent to can continue the trace from the traceparent header
+func NewTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &transport{base: base}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+// The span ends when the response headers are received, so reading the body isn't included
+func (t *transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	ctx, span := StartClientSpan(request.Context(), "HTTP "+request.Method)
+	defer span.End()
+
+	span.SetAttribute("http.method", request.Method)
+	// Only the host and path are recorded, as the query can have tokens
+	span.SetAttribute("http.host", request.URL.Host)
+	span.SetAttribute("http.target", request.URL.Path)
+
+	if span != nil {
+		// The request must not be changed by a RoundTripper, so the headers are set on a copy
+		request = request.WithContext(ctx)
+		request.Header = cloneHeader(request.Header)
+		Inject(span.Context(), request.Header)
+	}
+
+	response, err := t.base.RoundTrip(request)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+
+	span.SetAttribute("http.status_code", response.StatusCode)
+
+	return response, nil
+}
+
+func cloneHeader(header http.Header) http.Header {
+	clone := make(http.Header, len(header)+1)
+	for key, values := range header {
+		clone[key] = append([]string(nil), values...)
+	}
+	return clone
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright © 2018 Rasmus Hansen
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ * THE SOFTWARE.
+ */
+
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// Writes the spans as a json object per line, e.g. to stdout or a file, for debugging locally
+func NewWriterExporter(writer io.WriteCloser) Exporter {
+	return &writerExporter{
+		writer: writer,
+	}
+}
+
+// Appends the spans to the file, which is created if it doesn't exist
+func NewFileExporter(filename string) (Exporter, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWriterExporter(file), nil
+}
+
+type writerExporter struct {
+	lock   sync.Mutex
+	writer io.WriteCloser
+}
+
+func (e *writerExporter) Export(ctx context.Context, spans []SpanData) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	encoder := json.NewEncoder(e.writer)
+	for _, span := range spans {
+		err := encoder.Encode(span)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *writerExporter) Close() error {
+	// Stdout is shared with everything else, so it is left open
+	if e.writer == os.Stdout {
+		return nil
+	}
+	return e.writer.Close()
+}
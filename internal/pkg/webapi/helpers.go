@@ -25,28 +25,61 @@ package webapi
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"github.com/labstack/echo"
 	"io"
+	"net"
 	"net/http"
 	"strings"
 )
 
 const (
-	HeaderAccept       = echo.HeaderAccept
-	HeaderCacheControl = "Cache-Control"
-	MIMEHTML           = "text/html"
-	MIMEJSON           = "application/json"
-	MIMEXML            = "application/xml"
+	HeaderAccept                = echo.HeaderAccept
+	HeaderCacheControl          = "Cache-Control"
+	HeaderContentDisposition    = echo.HeaderContentDisposition
+	HeaderContentLength         = echo.HeaderContentLength
+	HeaderContentSecurityPolicy = echo.HeaderContentSecurityPolicy
+	HeaderContentType           = echo.HeaderContentType
+	HeaderContentTypeOptions    = echo.HeaderXContentTypeOptions
+	HeaderETag                  = "ETag"
+	HeaderIfNoneMatch           = "If-None-Match"
+	HeaderRetryAfter            = "Retry-After"
+	HeaderXForwardedFor         = echo.HeaderXForwardedFor
+	HeaderXForwardedProto       = echo.HeaderXForwardedProto
+	MIMECSV                     = "text/csv"
+	MIMEHTML                    = "text/html"
+	MIMEJSON                    = "application/json"
+	MIMEMarkdown                = "text/markdown"
+	MIMENDJSON                  = "application/x-ndjson"
+	MIMEXML                     = "application/xml"
 )
 
+// The values of the format query parameter, which overrides the accept header,
+// so browser links can ask for a specific format
+var formats = map[string]string{
+	"csv":      MIMECSV,
+	"html":     MIMEHTML,
+	"json":     MIMEJSON,
+	"markdown": MIMEMarkdown,
+	"md":       MIMEMarkdown,
+	"ndjson":   MIMENDJSON,
+	"xml":      MIMEXML,
+}
+
 // Attempts to normalize the accept header
 // If no type is known, return MIMEHTML
 func GetResponseType(r *http.Request) string {
+	if format, exists := formats[strings.ToLower(r.URL.Query().Get("format"))]; exists {
+		return format
+	}
+
 	accept := r.Header.Get(HeaderAccept)
 
 	options := strings.Split(accept, ",")
 
 	for _, option := range options {
+		option = strings.TrimSpace(option)
 		switch {
 		case strings.HasPrefix(option, echo.MIMEApplicationJSON):
 			return MIMEJSON
@@ -54,6 +87,12 @@ func GetResponseType(r *http.Request) string {
 			return MIMEHTML
 		case strings.HasPrefix(option, echo.MIMEApplicationXML), strings.HasPrefix(option, echo.MIMETextXML):
 			return MIMEXML
+		case strings.HasPrefix(option, MIMECSV):
+			return MIMECSV
+		case strings.HasPrefix(option, MIMENDJSON):
+			return MIMENDJSON
+		case strings.HasPrefix(option, MIMEMarkdown):
+			return MIMEMarkdown
 		}
 	}
 
@@ -84,3 +123,156 @@ func DetectContentType(r io.Reader) (string, io.Reader, error) {
 
 	return contentType, newReader, nil
 }
+
+// Parses a list of ip addresses and cidr ranges, e.g. 10.0.0.1 or 10.0.0.0/8
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(proxies))
+
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy '%s'", proxy)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy '%s': %v", proxy, err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// Gets the ip address of the client that made the request.
+// X-Forwarded-For is only used if the request came from one of the trusted proxies,
+// in which case the right-most address that isn't a trusted proxy is used
+func GetClientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	remoteAddress, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteAddress = r.RemoteAddr
+	}
+
+	if !isTrustedProxy(remoteAddress, trustedProxies) {
+		return remoteAddress
+	}
+
+	forwardedFor := strings.Split(r.Header.Get(HeaderXForwardedFor), ",")
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwardedFor[i])
+		if address == "" {
+			continue
+		}
+
+		if !isTrustedProxy(address, trustedProxies) {
+			return address
+		}
+	}
+
+	return remoteAddress
+}
+
+// Gets the scheme the client used for the request, either http or https.
+// X-Forwarded-Proto is only used if the request came from one of the trusted proxies
+func GetScheme(r *http.Request, trustedProxies []*net.IPNet) string {
+	if r.TLS != nil {
+		return "https"
+	}
+
+	remoteAddress, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteAddress = r.RemoteAddr
+	}
+
+	if isTrustedProxy(remoteAddress, trustedProxies) {
+		// Each proxy can add its own value, the first one is from the proxy the client connected to
+		proto := strings.TrimSpace(strings.Split(r.Header.Get(HeaderXForwardedProto), ",")[0])
+		if strings.EqualFold(proto, "https") {
+			return "https"
+		}
+	}
+
+	return "http"
+}
+
+func isTrustedProxy(address string, trustedProxies []*net.IPNet) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Limits how much can be read from a request body.
+// Unlike http.MaxBytesReader it's possible to check afterwards if the limit was the reason reading failed
+type LimitedBody struct {
+	body      io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+var ErrRequestTooLarge = errors.New("request body too large")
+
+// Replaces the body of the request with one that fails once more than max bytes has been read
+func LimitRequestBody(r *http.Request, max int64) *LimitedBody {
+	body := &LimitedBody{
+		body:      r.Body,
+		remaining: max,
+	}
+	r.Body = body
+	return body
+}
+
+func (b *LimitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, ErrRequestTooLarge
+	}
+
+	// Read one byte more than allowed, to know if the body is too large
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.body.Read(p)
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.exceeded = true
+		b.remaining = 0
+		return n, ErrRequestTooLarge
+	}
+
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func (b *LimitedBody) Close() error {
+	return b.body.Close()
+}
+
+// Returns true if the body was larger than allowed
+func (b *LimitedBody) Exceeded() bool {
+	return b.exceeded
+}
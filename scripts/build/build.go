@@ -28,6 +28,8 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"strings"
+	"time"
 )
 
 type configuration struct {
@@ -78,6 +80,8 @@ func main() {
 		log.Panicln(err)
 	}
 
+	ldflags := getLdflags()
+
 	for _, conf := range configurations {
 		// Pull dependencies
 		log.Printf("fetching dependencies for '%s'\n", conf.Extension)
@@ -109,6 +113,8 @@ func main() {
 			Args: []string{
 				goBinary,
 				"build",
+				"-ldflags",
+				ldflags,
 				"-o",
 				fmt.Sprintf("build/welp-%s-%s", consts.Version, conf.Extension),
 				"github.com/zlepper/welp",
@@ -130,3 +136,20 @@ func main() {
 
 	log.Println("Finished building all configurations.")
 }
+
+// Stamps the commit and the build date into the binary, so /version can tell which build is running
+func getLdflags() string {
+	commit := "unknown"
+	output, err := exec.Command("git", "rev-parse", "--short", "HEAD").Output()
+	if err == nil {
+		commit = strings.TrimSpace(string(output))
+	} else {
+		log.Println("Could not get the git commit", err)
+	}
+
+	return fmt.Sprintf(
+		"-X github.com/zlepper/welp/internal/pkg/consts.Commit=%s -X github.com/zlepper/welp/internal/pkg/consts.BuildDate=%s",
+		commit,
+		time.Now().UTC().Format(time.RFC3339),
+	)
+}